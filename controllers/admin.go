@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// Admin is the controller for operator authentication
+type Admin struct {
+	// Token is the bearer token operators must send, if set
+	Token string
+}
+
+// Authenticate is a middleware requiring the operator token as a bearer token.
+// Requests pass through when no token is set.
+func (c *Admin) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Token != "" {
+			expected := []byte("Bearer " + c.Token)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="broker"`)
+				http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
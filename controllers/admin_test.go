@@ -0,0 +1,51 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+)
+
+func TestAdminAuthenticate(t *testing.T) {
+	tests := map[string]struct {
+		token              string // input controller token
+		authorization      string // input Authorization header
+		expectedBody       string // expected body
+		expectedStatusCode int    // expected status code
+	}{
+		"Valid":           {"secret", "Bearer secret", "OK.\n", http.StatusOK},
+		"Invalid":         {"secret", "Bearer guess", "Unauthorized.\n", http.StatusUnauthorized},
+		"Missing":         {"secret", "", "Unauthorized.\n", http.StatusUnauthorized},
+		"Basic":           {"secret", "Basic c2VjcmV0", "Unauthorized.\n", http.StatusUnauthorized},
+		"Without a token": {"", "", "OK.\n", http.StatusOK},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			controller := &controllers.Admin{Token: testCase.token}
+			handler := controller.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK.\n"))
+			}))
+			request := httptest.NewRequest("POST", "/broker/devices", nil)
+			if testCase.authorization != "" {
+				request.Header.Set("Authorization", testCase.authorization)
+			}
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, recorder.Code)
+			}
+			body, _ := ioutil.ReadAll(recorder.Body)
+			if string(body) != testCase.expectedBody {
+				t.Errorf("Expected %q, got %q", testCase.expectedBody, string(body))
+			}
+			if testCase.expectedStatusCode == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// Alert is the controller for alert rules and alerts
+type Alert struct {
+	Service services.Alert
+}
+
+// List lists the alerts, filtered by the "state" query parameter
+func (c *Alert) List(w http.ResponseWriter, r *http.Request) {
+	alerts, err := c.Service.Alerts(r.URL.Query().Get("state"))
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, alerts)
+}
+
+// Acknowledge acknowledges an alert
+func (c *Alert) Acknowledge(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	if err := c.Service.Acknowledge(id); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	w.Write([]byte("OK.\n"))
+}
+
+// CreateRule creates an alert rule
+func (c *Alert) CreateRule(w http.ResponseWriter, r *http.Request) {
+	var rule models.AlertRule
+	if !readJSON(w, r, &rule) {
+		return
+	}
+
+	if err := c.Service.CreateRule(&rule); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, &rule)
+}
+
+// ListRules lists the alert rules, filtered by the "plant" query parameter
+func (c *Alert) ListRules(w http.ResponseWriter, r *http.Request) {
+	var plantID uint64
+	if value := r.URL.Query().Get("plant"); value != "" {
+		var err error
+		if plantID, err = strconv.ParseUint(value, 10, 32); err != nil {
+			http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	rules, err := c.Service.Rules(uint(plantID))
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, rules)
+}
+
+// DeleteRule deletes an alert rule
+func (c *Alert) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	if err := c.Service.DeleteRule(id); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	w.Write([]byte("OK.\n"))
+}
+
+// writeError maps a service error to a response
+func (c *Alert) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case services.AlertInvalidID:
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+	case services.AlertInvalidPlant:
+		http.Error(w, "Invalid plant.", http.StatusBadRequest)
+	case services.AlertInvalidData:
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,150 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/gorilla/mux"
+)
+
+// Service mock: alert and rule IDs 1 to 4 exist, ID 5 fails
+type mockAlertService struct{}
+
+var _ services.Alert = (*mockAlertService)(nil)
+
+func (s *mockAlertService) CreateRule(rule *models.AlertRule) error {
+	switch {
+	case rule.Metric == "":
+		return services.AlertInvalidData
+	case rule.PlantID == 0:
+		return services.AlertInvalidPlant
+	}
+	rule.ID = 6
+
+	return nil
+}
+
+func (s *mockAlertService) Rules(plantID uint) ([]*models.AlertRule, error) {
+	return []*models.AlertRule{&models.AlertRule{ID: 1, PlantID: plantID, Metric: "humidity", Operator: "<", Threshold: 20}}, nil
+}
+
+func (s *mockAlertService) DeleteRule(id uint) error {
+	return s.Acknowledge(id)
+}
+
+func (s *mockAlertService) Alerts(state string) ([]*models.Alert, error) {
+	if state == "exploded" {
+		return nil, services.AlertInvalidData
+	}
+
+	return []*models.Alert{&models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertFiring, Value: 12, Since: 100, FiredAt: 200}}, nil
+}
+
+func (s *mockAlertService) Acknowledge(id uint) error {
+	switch {
+	case id > 0 && id < 5:
+		return nil
+	case id == 5:
+		return services.AlertDatabaseDriverError("mocked error")
+	}
+
+	return services.AlertInvalidID
+}
+
+func TestAlert(t *testing.T) {
+	// Setup
+	c := controllers.Alert{
+		Service: &mockAlertService{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/alerts", c.List).Methods("GET")
+	router.HandleFunc("/alerts/{id}/ack", c.Acknowledge).Methods("POST")
+	router.HandleFunc("/alerts/rules", c.CreateRule).Methods("POST")
+	router.HandleFunc("/alerts/rules", c.ListRules).Methods("GET")
+	router.HandleFunc("/alerts/rules/{id}", c.DeleteRule).Methods("DELETE")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := map[string]struct {
+		request            *http.Request // input
+		expectedBody       string        // expected body
+		expectedStatusCode int           // expected status code
+	}{
+		"List": {
+			request:            buildStatusRequest("GET", server.URL+"/alerts?state=firing", nil),
+			expectedBody:       `[{"id":1,"ruleId":1,"plantId":1,"metric":"humidity","state":"firing","value":12,"since":100,"firedAt":200,"acknowledged":false}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"List invalid state": {
+			request:            buildStatusRequest("GET", server.URL+"/alerts?state=exploded", nil),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Acknowledge": {
+			request:            buildStatusRequest("POST", server.URL+"/alerts/1/ack", nil),
+			expectedBody:       "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Acknowledge invalid ID": {
+			request:            buildStatusRequest("POST", server.URL+"/alerts/7/ack", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Acknowledge database error": {
+			request:            buildStatusRequest("POST", server.URL+"/alerts/5/ack", nil),
+			expectedBody:       "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		"Create rule": {
+			request:            buildStatusRequest("POST", server.URL+"/alerts/rules", []byte(`{"plantId":1,"metric":"humidity","operator":"<","threshold":20,"for":1800,"hysteresis":5}`)),
+			expectedBody:       `{"id":6,"plantId":1,"metric":"humidity","operator":"\u003c","threshold":20,"for":1800,"hysteresis":5}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		"Create rule invalid data": {
+			request:            buildStatusRequest("POST", server.URL+"/alerts/rules", []byte(`{"plantId":1}`)),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Create rule invalid plant": {
+			request:            buildStatusRequest("POST", server.URL+"/alerts/rules", []byte(`{"metric":"humidity"}`)),
+			expectedBody:       "Invalid plant.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"List rules": {
+			request:            buildStatusRequest("GET", server.URL+"/alerts/rules?plant=3", nil),
+			expectedBody:       `[{"id":1,"plantId":3,"metric":"humidity","operator":"\u003c","threshold":20,"for":0,"hysteresis":0}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"List rules invalid plant": {
+			request:            buildStatusRequest("GET", server.URL+"/alerts/rules?plant=basil", nil),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Delete rule": {
+			request:            buildStatusRequest("DELETE", server.URL+"/alerts/rules/2", nil),
+			expectedBody:       "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(testCase.request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedBody ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,547 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// Reading fields of CSV import columns, other than metric names
+const (
+	importPlant     = "plant"
+	importDevice    = "device"
+	importTimestamp = "timestamp"
+)
+
+// importFields are the reading fields of the default CSV import headers.
+// Other headers are metric names, if registered.
+var importFields = map[string]string{
+	"plant":     importPlant,
+	"id":        importPlant,
+	"device":    importDevice,
+	"deviceid":  importDevice,
+	"timestamp": importTimestamp,
+	"time":      importTimestamp,
+}
+
+// Archive is the controller for bulk imports and exports of status data
+type Archive struct {
+	Service     services.Status
+	History     services.StatusQuery
+	Aggregation services.StatusAggregation
+	// Metrics are the import and export metric columns, defaulting to DefaultMetrics
+	Metrics services.MetricRegistry
+	// MaxStreamSize is the size limit of decoded imports, defaulting to DefaultMaxStreamSize
+	MaxStreamSize int64
+}
+
+// ImportRejection is a row rejected from a CSV import or NDJSON stream
+type ImportRejection struct {
+	// Line is the line of the row in the file, starting at 1
+	Line int `json:"line"`
+	// Code is the HTTP status code the reading would get from Write
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// maxListedRejections is the number of rejected rows listed by an import or stream result,
+// so its size does not grow with the rejections
+const maxListedRejections = 1000
+
+// ImportResult is the response of a CSV import or NDJSON stream
+type ImportResult struct {
+	Accepted int `json:"accepted"`
+	// RejectedCount is the number of rejected rows, the first 1000 being listed in Rejected
+	RejectedCount int               `json:"rejectedCount"`
+	Rejected      []ImportRejection `json:"rejected"`
+}
+
+// add counts the result of the reading at a line
+func (response *ImportResult) add(line int, result statusResult) {
+	if result.Code == http.StatusOK {
+		response.Accepted++
+
+		return
+	}
+	response.RejectedCount++
+	if len(response.Rejected) >= maxListedRejections {
+		return
+	}
+	response.Rejected = append(response.Rejected, ImportRejection{
+		Line:    line,
+		Code:    result.Code,
+		Message: result.Message,
+	})
+}
+
+// importMapping maps the rows of a CSV import to readings
+type importMapping struct {
+	headers []string
+	// fields are the reading fields of the columns, empty for ignored columns
+	fields []string
+	// plant is the plant of rows without a plant or device
+	plant    uint
+	format   string
+	location *time.Location
+}
+
+// Import writes the readings of a CSV file, one per row after the header row, replying
+// with the rejected rows. Rows are read and written one at a time, so a rejection does
+// not stop the import. Query parameters:
+//   - column: "<header>:<field>" mappings, the field being plant, device, timestamp,
+//     a metric name or "-" to ignore the column
+//   - plant: the plant of rows without a plant or device
+//   - timeFormat: unix (default), unixms, rfc3339 or a Go time layout
+//   - timezone: the location of layouts without an offset, defaulting to UTC
+//   - delimiter: the field delimiter, defaulting to a comma
+//   - report: json (default) or csv, for a downloadable report
+func (c *Archive) Import(w http.ResponseWriter, r *http.Request) {
+	if value := r.Header.Get("Content-Type"); value != "" {
+		mediaType, _, err := mime.ParseMediaType(value)
+		if err != nil || mediaType != "text/csv" {
+			http.Error(w, "Unsupported media type.", http.StatusUnsupportedMediaType)
+
+			return
+		}
+	}
+	query := r.URL.Query()
+	report := query.Get("report")
+	if report != "" && report != "json" && report != "csv" {
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+		return
+	}
+	delimiter := ','
+	if value := query.Get("delimiter"); value != "" {
+		var size int
+		delimiter, size = utf8.DecodeRuneInString(value)
+		if size != len(value) || delimiter == '"' || delimiter == '\r' || delimiter == '\n' {
+			http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	body, ok := openBody(w, r, sizeLimit(c.MaxStreamSize, DefaultMaxStreamSize))
+	if !ok {
+		return
+	}
+	defer body.Close()
+	reader := csv.NewReader(body)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if tooLarge(err) {
+		http.Error(w, "Body too large.", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid body.", http.StatusBadRequest)
+
+		return
+	}
+	mapping, ok := c.importMapping(header, query)
+	if !ok {
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+		return
+	}
+
+	response := ImportResult{Rejected: []ImportRejection{}}
+	var delay time.Duration
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The reader cannot resynchronize after a malformed row, so the import stops
+			line++
+			if e, ok := err.(*csv.ParseError); ok {
+				line = e.Line
+			}
+			result := statusResult{Code: http.StatusBadRequest, Message: "Invalid CSV."}
+			if tooLarge(err) {
+				result = statusResult{Code: http.StatusRequestEntityTooLarge, Message: "Body too large."}
+			}
+			response.add(line, result)
+
+			break
+		}
+		line, _ = reader.FieldPos(0)
+
+		data, message := mapping.reading(record)
+		result := statusResult{Code: http.StatusBadRequest, Message: message}
+		if data != nil {
+			result = writeStatus(r.Context(), c.Service, data)
+		}
+		if result.Err != nil {
+			util.LogError(r, result.Err)
+		}
+		if result.RetryAfter > delay {
+			delay = result.RetryAfter
+		}
+		response.add(line, result)
+	}
+
+	if delay != 0 {
+		w.Header().Set("Retry-After", retryAfter(delay))
+	}
+	if report != "csv" {
+		writeJSON(w, r, http.StatusOK, response)
+
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="import-report.csv"`)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"line", "code", "message"})
+	for _, rejection := range response.Rejected {
+		writer.Write([]string{strconv.Itoa(rejection.Line), strconv.Itoa(rejection.Code), rejection.Message})
+	}
+	writer.Flush()
+}
+
+// importMapping maps the columns of a CSV header to reading fields.
+// Every reading needs a timestamp, and a plant or device.
+func (c *Archive) importMapping(header []string, query url.Values) (*importMapping, bool) {
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = services.DefaultMetrics
+	}
+	mapping := &importMapping{
+		headers:  make([]string, len(header)),
+		fields:   make([]string, len(header)),
+		format:   "unix",
+		location: time.UTC,
+	}
+	if value := query.Get("plant"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, false
+		}
+		mapping.plant = uint(id)
+	}
+	if value := query.Get("timeFormat"); value != "" {
+		mapping.format = value
+	}
+	if value := query.Get("timezone"); value != "" {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return nil, false
+		}
+		mapping.location = location
+	}
+
+	columns := map[string]string{}
+	for _, value := range query["column"] {
+		separator := strings.LastIndex(value, ":")
+		if separator < 0 {
+			return nil, false
+		}
+		field := value[separator+1:]
+		if field == "-" {
+			field = ""
+		}
+		columns[strings.ToLower(strings.TrimSpace(value[:separator]))] = field
+	}
+
+	counts := map[string]int{}
+	for index, name := range header {
+		if index == 0 {
+			// Spreadsheets often start UTF-8 files with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		field, ok := columns[key]
+		if ok {
+			delete(columns, key)
+		} else if field, ok = importFields[key]; !ok {
+			// Unmapped columns of unregistered metrics, such as notes, are ignored
+			if _, ok := metrics[key]; ok {
+				field = key
+			}
+		}
+		mapping.headers[index] = name
+		mapping.fields[index] = field
+		counts[field]++
+	}
+	// Every mapped header must exist, to catch typos
+	if len(columns) != 0 {
+		return nil, false
+	}
+	if counts[importTimestamp] != 1 || counts[importPlant] > 1 || counts[importDevice] > 1 {
+		return nil, false
+	}
+	if counts[importPlant] == 0 && counts[importDevice] == 0 && mapping.plant == 0 {
+		return nil, false
+	}
+
+	return mapping, true
+}
+
+// reading maps a CSV row to a reading, or to the message of its rejection.
+// Empty cells are not reported.
+func (m *importMapping) reading(record []string) (*models.StatusData, string) {
+	if len(record) > len(m.fields) {
+		return nil, "Invalid column count."
+	}
+
+	data := &models.StatusData{ID: m.plant}
+	hasTimestamp := false
+	for index, value := range record {
+		value = strings.TrimSpace(value)
+		field := m.fields[index]
+		if value == "" || field == "" {
+			continue
+		}
+		switch field {
+		case importPlant, importDevice:
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || id == 0 {
+				return nil, "Invalid ID."
+			}
+			if field == importPlant {
+				data.ID = uint(id)
+			} else {
+				data.DeviceID = uint(id)
+			}
+		case importTimestamp:
+			timestamp, err := m.timestamp(value)
+			if err != nil {
+				return nil, "Invalid timestamp."
+			}
+			data.Timestamp = timestamp
+			hasTimestamp = true
+		default:
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Sprintf("Invalid value in column %q.", m.headers[index])
+			}
+			data.SetValue(field, number)
+		}
+	}
+	switch {
+	case !hasTimestamp:
+		return nil, "Missing timestamp."
+	case data.ID == 0 && data.DeviceID == 0:
+		return nil, "Missing ID."
+	case len(data.Metrics) == 0:
+		return nil, "Missing values."
+	}
+
+	return data, ""
+}
+
+// timestamp parses a time in the format of the import, as a Unix timestamp
+func (m *importMapping) timestamp(value string) (int64, error) {
+	switch m.format {
+	case "unix":
+		return strconv.ParseInt(value, 10, 64)
+	case "unixms":
+		milliseconds, err := strconv.ParseInt(value, 10, 64)
+
+		return milliseconds / 1000, err
+	case "rfc3339":
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+
+		return parsed.Unix(), err
+	default:
+		parsed, err := time.ParseInLocation(m.format, value, m.location)
+
+		return parsed.Unix(), err
+	}
+}
+
+// Export streams the stored readings of a plant, in the range of the "from" (inclusive)
+// and "to" (exclusive) Unix timestamps, as CSV with a column per metric or as
+// newline-delimited JSON. Query parameters:
+//   - format: csv (default) or ndjson
+//   - metrics: comma-separated metric columns, defaulting to every registered metric
+func (c *Archive) Export(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	query := r.URL.Query()
+	var from, to int64
+	for name, bound := range map[string]*int64{"from": &from, "to": &to} {
+		if value := query.Get(name); value != "" {
+			var err error
+			if *bound, err = strconv.ParseInt(value, 10, 64); err != nil {
+				http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+				return
+			}
+		}
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+		return
+	}
+	metrics := c.exportMetrics(query.Get("metrics"))
+
+	// The response starts with the first reading, so query errors still get a status code
+	var writer *csv.Writer
+	var encoder *json.Encoder
+	started := false
+	start := func() error {
+		started = true
+		if format == "ndjson" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="plant-%d.ndjson"`, id))
+			w.WriteHeader(http.StatusOK)
+			encoder = json.NewEncoder(w)
+
+			return nil
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="plant-%d.csv"`, id))
+		w.WriteHeader(http.StatusOK)
+		writer = csv.NewWriter(w)
+
+		return writer.Write(append([]string{importPlant, importTimestamp}, metrics...))
+	}
+	err := c.History.Query(id, from, to, func(data *models.StatusData) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if encoder != nil {
+			return encoder.Encode(data)
+		}
+
+		row := []string{strconv.FormatUint(uint64(data.ID), 10), strconv.FormatInt(data.Timestamp, 10)}
+		for _, metric := range metrics {
+			value := ""
+			if number, ok := data.Value(metric); ok {
+				value = strconv.FormatFloat(number, 'f', -1, 64)
+			}
+			row = append(row, value)
+		}
+
+		// Rows are buffered by the writer and flushed as the buffer fills
+		return writer.Write(row)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil && writer != nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+
+	if err == nil {
+		return
+	}
+	if !started {
+		switch err.(type) {
+		case services.StatusInvalidDataError:
+			if err == services.StatusInvalidID {
+				http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+				return
+			}
+			http.Error(w, "Invalid data.", http.StatusBadRequest)
+		default:
+			util.LogError(r, err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+		}
+
+		return
+	}
+	if _, ok := err.(services.StatusDatabaseDriverError); ok {
+		util.LogError(r, err)
+	}
+	// Failures once the response started abort it, so clients do not mistake
+	// a truncated export for a complete one
+	panic(http.ErrAbortHandler)
+}
+
+// Aggregate summarizes the stored readings of a plant in windows of the "window" query parameter,
+// in seconds, between the "from" and "to" query parameters, for the metrics of the "metrics"
+// query parameter or every metric
+func (c *Archive) Aggregate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	query := r.URL.Query()
+	var from, to, window int64
+	for name, bound := range map[string]*int64{"from": &from, "to": &to, "window": &window} {
+		if value := query.Get(name); value != "" {
+			var err error
+			if *bound, err = strconv.ParseInt(value, 10, 64); err != nil {
+				http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+				return
+			}
+		}
+	}
+	var metrics []string
+	if value := query.Get("metrics"); value != "" {
+		metrics = strings.Split(value, ",")
+	}
+
+	aggregates, err := c.Aggregation.Aggregate(id, from, to, window, metrics)
+	switch err.(type) {
+	case nil:
+		writeJSON(w, r, http.StatusOK, aggregates)
+	case services.StatusInvalidDataError:
+		if err == services.StatusInvalidID {
+			http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+			return
+		}
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+}
+
+// exportMetrics lists the metric columns of an export, sorted by name unless requested
+func (c *Archive) exportMetrics(value string) []string {
+	if value != "" {
+		return strings.Split(value, ",")
+	}
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = services.DefaultMetrics
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,266 @@
+package controllers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/gorilla/mux"
+)
+
+func TestArchiveImport(t *testing.T) {
+	tests := map[string]struct {
+		query              string                    // input query
+		body               string                    // input body
+		expectedStatusCode int                       // expected status code
+		expectedResult     *controllers.ImportResult // expected result, nil for errors
+		expectedWritten    []*models.StatusData      // expected written readings
+	}{
+		"Default columns": {
+			"",
+			"\ufeffPlant,Timestamp,Temperature,Humidity,Notes\n1,1516472722,21.5,40,watered\n2,1516472723,,41,\n",
+			http.StatusOK,
+			&controllers.ImportResult{Accepted: 2, Rejected: []controllers.ImportRejection{}},
+			[]*models.StatusData{
+				{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 21.5, Unit: "°C"}, "humidity": {Value: 40, Unit: "%"}}},
+				{ID: 2, Timestamp: 1516472723, Metrics: map[string]models.Metric{"humidity": {Value: 41, Unit: "%"}}},
+			},
+		},
+		"Column mapping": {
+			"plant=3&column=Date:timestamp&column=Temp%20(C):temperature&column=pH:-&timeFormat=2006-01-02%2015:04&timezone=America/Mexico_City&delimiter=%3B",
+			"Date;Temp (C);pH\n2018-01-20 12:25;21.5;6.5\n",
+			http.StatusOK,
+			&controllers.ImportResult{Accepted: 1, Rejected: []controllers.ImportRejection{}},
+			[]*models.StatusData{
+				{ID: 3, Timestamp: 1516472700, Metrics: map[string]models.Metric{"temperature": {Value: 21.5, Unit: "°C"}}},
+			},
+		},
+		"Rejected rows": {
+			"timeFormat=rfc3339",
+			"device,time,light\n" +
+				"1,2018-01-20T18:25:22Z,12\n" +
+				"1,yesterday,12\n" +
+				"1,2018-01-20T18:25:22Z,1000\n" +
+				"7,2018-01-20T18:25:22Z,12\n" +
+				"1,2018-01-20T18:25:22Z,bright\n" +
+				"1,2018-01-20T18:25:22Z\n" +
+				"1,2018-01-20T18:25:22Z,12,extra\n" +
+				"1,\"2018-01-20T18:25:22Z,12\n",
+			http.StatusOK,
+			&controllers.ImportResult{Accepted: 1, RejectedCount: 7, Rejected: []controllers.ImportRejection{
+				{Line: 3, Code: http.StatusBadRequest, Message: "Invalid timestamp."},
+				{Line: 4, Code: http.StatusBadRequest, Message: "Invalid data."},
+				{Line: 5, Code: http.StatusInternalServerError, Message: "Internal server error."},
+				{Line: 6, Code: http.StatusBadRequest, Message: `Invalid value in column "light".`},
+				{Line: 7, Code: http.StatusBadRequest, Message: "Missing values."},
+				{Line: 8, Code: http.StatusBadRequest, Message: "Invalid column count."},
+				{Line: 9, Code: http.StatusBadRequest, Message: "Invalid CSV."},
+			}},
+			[]*models.StatusData{
+				{ID: 1, DeviceID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"light": {Value: 12, Unit: "klx"}}},
+			},
+		},
+		"Missing timestamp column": {"", "plant,temperature\n1,20\n", http.StatusBadRequest, nil, nil},
+		"Missing plant":            {"", "timestamp,temperature\n1516472722,20\n", http.StatusBadRequest, nil, nil},
+		"Unknown mapped column":    {"plant=1&column=Temp:temperature", "timestamp,temperature\n1516472722,20\n", http.StatusBadRequest, nil, nil},
+		"Invalid delimiter":        {"delimiter=%3B%3B", "plant;timestamp\n", http.StatusBadRequest, nil, nil},
+		"Empty body":               {"", "", http.StatusBadRequest, nil, nil},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := &mockRecordingStatusService{}
+			controller := controllers.Archive{Service: service}
+			server := httptest.NewServer(http.HandlerFunc(controller.Import))
+			defer server.Close()
+
+			request := buildStatusRequest("POST", server.URL+"?"+testCase.query, []byte(testCase.body))
+			request.Header.Set("Content-Type", "text/csv; charset=utf-8")
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+			if testCase.expectedResult != nil {
+				var result controllers.ImportResult
+				if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+					t.Fatalf("No error expected, got %+v", err)
+				}
+				if !reflect.DeepEqual(&result, testCase.expectedResult) {
+					t.Errorf("Expected %+v, got %+v", testCase.expectedResult, result)
+				}
+			}
+			if !reflect.DeepEqual(service.written, testCase.expectedWritten) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedWritten, service.written)
+			}
+		})
+	}
+}
+
+func TestArchiveImportReport(t *testing.T) {
+	controller := controllers.Archive{Service: &mockStatusService{}}
+	server := httptest.NewServer(http.HandlerFunc(controller.Import))
+	defer server.Close()
+
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	writer.Write([]byte("plant,timestamp,temperature\n1,1516472722,20\n9,1516472722,20\n"))
+	writer.Close()
+	request := buildStatusRequest("POST", server.URL+"?report=csv", body.Bytes())
+	request.Header.Set("Content-Encoding", "gzip")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	report, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	expected := "line,code,message\n3,503,Service unavailable.\n"
+	if string(report) != expected || response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200: %q, got %d: %q", expected, response.StatusCode, report)
+	}
+	if value := response.Header.Get("Retry-After"); value != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", value)
+	}
+	if value := response.Header.Get("Content-Disposition"); value != `attachment; filename="import-report.csv"` {
+		t.Errorf("Expected an attachment, got %q", value)
+	}
+}
+
+func TestArchiveExport(t *testing.T) {
+	controller := controllers.Archive{
+		History: &mockStatusQuery{},
+		Metrics: services.MetricRegistry{
+			"temperature": services.DefaultMetrics["temperature"],
+			"humidity":    services.DefaultMetrics["humidity"],
+		},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/status/{id}/export", controller.Export).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := map[string]struct {
+		path                string // input path
+		expectedBody        string // expected body
+		expectedContentType string // expected content type
+		expectedStatusCode  int    // expected status code
+	}{
+		"CSV": {
+			"/status/1/export",
+			"plant,timestamp,humidity,temperature\n1,100,40,\n1,200,,\n",
+			"text/csv", http.StatusOK,
+		},
+		"CSV metrics": {
+			"/status/1/export?format=csv&metrics=humidity&from=0&to=300",
+			"plant,timestamp,humidity\n1,100,40\n1,200,\n",
+			"text/csv", http.StatusOK,
+		},
+		"NDJSON": {
+			"/status/1/export?format=ndjson",
+			`{"id":1,"timestamp":100,"metrics":{"humidity":{"value":40,"unit":"%"}}}` + "\n" + `{"id":1,"timestamp":200}` + "\n",
+			"application/x-ndjson", http.StatusOK,
+		},
+		"Invalid format":  {"/status/1/export?format=xlsx", "Invalid data.\n", "text/plain; charset=utf-8", http.StatusBadRequest},
+		"Invalid range":   {"/status/1/export?from=300&to=100", "Invalid data.\n", "text/plain; charset=utf-8", http.StatusBadRequest},
+		"Malformed range": {"/status/1/export?from=yesterday", "Invalid data.\n", "text/plain; charset=utf-8", http.StatusBadRequest},
+		"Invalid ID":      {"/status/7/export", "Invalid ID.\n", "text/plain; charset=utf-8", http.StatusNotFound},
+		"Malformed ID":    {"/status/basil/export", "Invalid ID.\n", "text/plain; charset=utf-8", http.StatusNotFound},
+		"Database error":  {"/status/5/export", "Internal server error.\n", "text/plain; charset=utf-8", http.StatusInternalServerError},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(buildStatusRequest("GET", server.URL+testCase.path, nil))
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, _ := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if string(body) != testCase.expectedBody || response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, body)
+			}
+			if value := response.Header.Get("Content-Type"); value != testCase.expectedContentType {
+				t.Errorf("Expected %q, got %q", testCase.expectedContentType, value)
+			}
+		})
+	}
+}
+
+// Aggregation service mock: plant 1 exists, plant 5 fails
+type mockStatusAggregation struct{}
+
+var _ services.StatusAggregation = (*mockStatusAggregation)(nil)
+
+func (s *mockStatusAggregation) Aggregate(plantID uint, from, to, window int64, metrics []string) ([]*models.Aggregate, error) {
+	switch {
+	case window <= 0 || (to != 0 && to <= from):
+		return nil, services.StatusInvalidData
+	case plantID == 5:
+		return nil, services.StatusDatabaseDriverError("mocked error")
+	case plantID != 1:
+		return nil, services.StatusInvalidID
+	}
+
+	aggregate := &models.Aggregate{From: from, To: from + window, Metrics: map[string]*models.MetricSummary{}}
+	for _, name := range append([]string{"humidity"}, metrics...) {
+		aggregate.Metrics[name] = &models.MetricSummary{Count: 2, Min: 30, Max: 50, Mean: 40, Unit: "%"}
+	}
+
+	return []*models.Aggregate{aggregate}, nil
+}
+
+func TestArchiveAggregate(t *testing.T) {
+	controller := controllers.Archive{
+		Aggregation: &mockStatusAggregation{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/status/{id}/aggregate", controller.Aggregate).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := map[string]struct {
+		path               string // input path
+		expectedBody       string // expected body
+		expectedStatusCode int    // expected status code
+	}{
+		"Happy path": {
+			"/status/1/aggregate?from=3600&to=7200&window=3600",
+			`[{"from":3600,"to":7200,"metrics":{"humidity":{"count":2,"min":30,"max":50,"mean":40,"unit":"%"}}}]`,
+			http.StatusOK,
+		},
+		"Metrics": {
+			"/status/1/aggregate?window=60&metrics=vpd",
+			`[{"from":0,"to":60,"metrics":{"humidity":{"count":2,"min":30,"max":50,"mean":40,"unit":"%"},"vpd":{"count":2,"min":30,"max":50,"mean":40,"unit":"%"}}}]`,
+			http.StatusOK,
+		},
+		"Missing window":   {"/status/1/aggregate?from=0&to=100", "Invalid data.\n", http.StatusBadRequest},
+		"Malformed window": {"/status/1/aggregate?window=1h", "Invalid data.\n", http.StatusBadRequest},
+		"Invalid range":    {"/status/1/aggregate?from=300&to=100&window=60", "Invalid data.\n", http.StatusBadRequest},
+		"Invalid ID":       {"/status/7/aggregate?window=60", "Invalid ID.\n", http.StatusNotFound},
+		"Malformed ID":     {"/status/basil/aggregate?window=60", "Invalid ID.\n", http.StatusNotFound},
+		"Database error":   {"/status/5/aggregate?window=60", "Internal server error.\n", http.StatusInternalServerError},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.Get(server.URL + testCase.path)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, _ := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if string(body) != testCase.expectedBody || response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	"go.uber.org/zap"
+)
+
+// coapConflict is the 4.09 Conflict response code of RFC 8132, not defined by go-coap
+const coapConflict = codes.Code(4<<5 | 9)
+
+// coapCodes maps the HTTP codes of a status result to CoAP response codes
+var coapCodes = map[int]codes.Code{
+	http.StatusOK:                  codes.Changed,
+	http.StatusBadRequest:          codes.BadRequest,
+	http.StatusForbidden:           codes.Forbidden,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            coapConflict,
+	http.StatusServiceUnavailable:  codes.ServiceUnavailable,
+	http.StatusInternalServerError: codes.InternalServerError,
+}
+
+// coapMediaTypes maps CoAP content formats to the media types of their codecs
+var coapMediaTypes = map[message.MediaType]string{
+	message.AppJSON:      "application/json",
+	message.AppCBOR:      "application/cbor",
+	message.AppSenmlJSON: "application/senml+json",
+	message.AppSenmlCbor: "application/senml+cbor",
+}
+
+// CoAP is the controller for status data sent over CoAP
+type CoAP struct {
+	Service services.Status
+	// Codecs decodes payloads by content format, defaulting to DefaultCodecs
+	Codecs Codecs
+}
+
+// Write writes status data sent in a CBOR, JSON or SenML payload
+func (c *CoAP) Write(w mux.ResponseWriter, r *mux.Message) {
+	if r.Code() != codes.POST {
+		setCoAPResponse(w, codes.MethodNotAllowed, "Method not allowed.")
+
+		return
+	}
+
+	// Body extraction
+	body, err := r.ReadBody()
+	if err != nil {
+		logCoAPError(r, err)
+		setCoAPResponse(w, codes.InternalServerError, "Internal server error.")
+
+		return
+	}
+	format, err := r.ContentFormat()
+	if err != nil {
+		// Payloads without a content format are taken as JSON
+		format = message.AppJSON
+	}
+	codecs := c.Codecs
+	if codecs == nil {
+		codecs = DefaultCodecs
+	}
+	codec, ok := codecs[coapMediaTypes[format]]
+	if !ok {
+		setCoAPResponse(w, codes.UnsupportedMediaType, "Unsupported media type.")
+
+		return
+	}
+	temp, err := codec.Decode(body)
+	if err != nil {
+		setCoAPResponse(w, codes.BadRequest, "Invalid body.")
+
+		return
+	}
+
+	result := writeStatus(r.Context(), c.Service, temp)
+	if result.Err != nil {
+		logCoAPError(r, result.Err)
+	}
+	code, ok := coapCodes[result.Code]
+	if !ok {
+		code = codes.InternalServerError
+	}
+	setCoAPResponse(w, code, result.Message)
+	if result.RetryAfter != 0 {
+		// Max-Age tells clients how long the 5.03 response holds
+		seconds := (result.RetryAfter + time.Second - 1) / time.Second
+		w.Message().SetOptionUint32(message.MaxAge, uint32(seconds))
+	}
+}
+
+// CoAPLogger is a CoAP middleware adding the logger to the request context
+func CoAPLogger(logger *zap.Logger) mux.MiddlewareFunc {
+	return func(next mux.Handler) mux.Handler {
+		return mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+			r.SetContext(context.WithValue(r.Context(), "logger", logger))
+			next.ServeCOAP(w, r)
+		})
+	}
+}
+
+// setCoAPResponse sets a response with a diagnostic payload
+func setCoAPResponse(w mux.ResponseWriter, code codes.Code, diagnostic string) {
+	w.SetResponse(code, message.TextPlain, bytes.NewReader([]byte(diagnostic)))
+}
+
+// logCoAPError logs an error of the current CoAP request
+func logCoAPError(r *mux.Message, err error) {
+	path, _ := r.Path()
+	util.LogContextError(r.Context(), "COAP "+r.Code().String(), path, err)
+}
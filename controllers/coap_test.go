@@ -0,0 +1,139 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/fxamacker/cbor/v2"
+	piondtls "github.com/pion/dtls/v3"
+	"github.com/plgd-dev/go-coap/v3/dtls"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	coapnet "github.com/plgd-dev/go-coap/v3/net"
+	"github.com/plgd-dev/go-coap/v3/options"
+	"github.com/plgd-dev/go-coap/v3/udp"
+)
+
+// coapRouter routes the status resource to the CoAP controller
+func coapRouter(t *testing.T) *mux.Router {
+	router := mux.NewRouter()
+	if err := router.Handle("/status", mux.HandlerFunc((&controllers.CoAP{Service: &mockStatusService{}}).Write)); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	return router
+}
+
+func TestCoAPWrite(t *testing.T) {
+	// Setup
+	listener, err := coapnet.NewListenUDP("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer listener.Close()
+	server := udp.NewServer(options.WithMux(coapRouter(t)))
+	defer server.Stop()
+	go server.Serve(listener)
+
+	conn, err := udp.Dial(listener.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer conn.Close()
+
+	encode := func(value interface{}) []byte {
+		b, err := cbor.Marshal(value)
+		if err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+
+		return b
+	}
+
+	tests := map[string]struct {
+		format         message.MediaType // input content format
+		body           []byte            // input body
+		expectedCode   codes.Code        // expected code
+		expectedMaxAge uint32            // expected Max-Age option, 0 for none
+	}{
+		"Happy path JSON": {message.AppJSON, []byte(`{"id": 1, "timestamp": 1516478286, "temperature": 23}`), codes.Changed, 0},
+		"Happy path CBOR": {message.AppCBOR, encode(map[string]interface{}{"id": 1, "timestamp": 1516478286, "metrics": map[string]interface{}{"ph": 6.5}}), codes.Changed, 0},
+		"Device":          {message.AppCBOR, encode(map[string]interface{}{"deviceId": 2, "timestamp": 1516478286}), codes.Changed, 0},
+		"Invalid body":    {message.AppCBOR, []byte{0xff}, codes.BadRequest, 0},
+		"Invalid data":    {message.AppJSON, []byte(`{"id": 1, "timestamp": 1516478286, "temperature": -100}`), codes.BadRequest, 0},
+		"Invalid ID":      {message.AppCBOR, encode(map[string]interface{}{"id": 6, "timestamp": 1516478286}), codes.NotFound, 0},
+		"Database error":  {message.AppJSON, []byte(`{"id": 5, "timestamp": 1516478286}`), codes.InternalServerError, 0},
+		"Unavailable":     {message.AppJSON, []byte(`{"id": 9, "timestamp": 1516478286}`), codes.ServiceUnavailable, 2},
+		"Unsupported":     {message.TextPlain, []byte(`id=1`), codes.UnsupportedMediaType, 0},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			response, err := conn.Post(ctx, "/status", testCase.format, bytes.NewReader(testCase.body))
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if response.Code() != testCase.expectedCode {
+				t.Errorf("Expected %s, got %s", testCase.expectedCode, response.Code())
+			}
+			maxAge, _ := response.Options().GetUint32(message.MaxAge)
+			if maxAge != testCase.expectedMaxAge {
+				t.Errorf("Expected max age %d, got %d", testCase.expectedMaxAge, maxAge)
+			}
+		})
+	}
+
+	// Only POST writes readings
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := conn.Get(ctx, "/status")
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if response.Code() != codes.MethodNotAllowed {
+		t.Errorf("Expected %s, got %s", codes.MethodNotAllowed, response.Code())
+	}
+}
+
+func TestCoAPWriteDTLS(t *testing.T) {
+	// Setup
+	psk := []byte("secret")
+	listener, err := coapnet.NewDTLSListener("udp", "127.0.0.1:0", &piondtls.Config{
+		PSK:             func([]byte) ([]byte, error) { return psk, nil },
+		PSKIdentityHint: []byte("broker"),
+		CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+	})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer listener.Close()
+	server := dtls.NewServer(options.WithMux(coapRouter(t)))
+	defer server.Stop()
+	go server.Serve(listener)
+
+	conn, err := dtls.Dial(listener.Addr().String(), &piondtls.Config{
+		PSK:             func([]byte) ([]byte, error) { return psk, nil },
+		PSKIdentityHint: []byte("device"),
+		CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+	})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := conn.Post(ctx, "/status", message.AppJSON, bytes.NewReader([]byte(`{"id": 1, "timestamp": 1516478286}`)))
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if response.Code() != codes.Changed {
+		t.Errorf("Expected %s, got %s", codes.Changed, response.Code())
+	}
+}
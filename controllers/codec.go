@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/pb"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec decodes status data bodies of a media type
+type Codec interface {
+	// Decode decodes a single reading
+	Decode(body []byte) (*models.StatusData, error)
+	// DecodeBatch decodes a list of readings
+	DecodeBatch(body []byte) ([]*models.StatusData, error)
+}
+
+// Codecs is a codec registry, keyed by media type
+type Codecs map[string]Codec
+
+// DefaultCodecs is the registry used when none is configured
+var DefaultCodecs = Codecs{
+	"application/json":       JSONCodec{},
+	"application/cbor":       CBORCodec{},
+	"application/msgpack":    MsgpackCodec{},
+	"application/x-msgpack":  MsgpackCodec{},
+	"application/protobuf":   ProtobufCodec{},
+	"application/x-protobuf": ProtobufCodec{},
+	"application/senml+json": SenMLJSONCodec{},
+	"application/senml+cbor": SenMLCBORCodec{},
+}
+
+// JSONCodec decodes JSON bodies, including legacy flat fields
+type JSONCodec struct{}
+
+func (JSONCodec) Decode(body []byte) (*models.StatusData, error) {
+	var data models.StatusData
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (JSONCodec) DecodeBatch(body []byte) ([]*models.StatusData, error) {
+	var batch []*models.StatusData
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// cborDecoder decodes CBOR maps with string keys, so they can be re-encoded as JSON
+var cborDecoder, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+// CBORCodec decodes CBOR bodies, with the same fields as JSON bodies
+type CBORCodec struct{}
+
+func (CBORCodec) Decode(body []byte) (*models.StatusData, error) {
+	body, err := toJSON(cborDecoder.Unmarshal, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSONCodec{}.Decode(body)
+}
+
+func (CBORCodec) DecodeBatch(body []byte) ([]*models.StatusData, error) {
+	body, err := toJSON(cborDecoder.Unmarshal, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSONCodec{}.DecodeBatch(body)
+}
+
+// MsgpackCodec decodes MessagePack bodies, with the same fields as JSON bodies
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Decode(body []byte) (*models.StatusData, error) {
+	body, err := toJSON(msgpack.Unmarshal, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSONCodec{}.Decode(body)
+}
+
+func (MsgpackCodec) DecodeBatch(body []byte) ([]*models.StatusData, error) {
+	body, err := toJSON(msgpack.Unmarshal, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return JSONCodec{}.DecodeBatch(body)
+}
+
+// ProtobufCodec decodes pb.Reading bodies, and pb.ReadingBatch bodies for batches
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Decode(body []byte) (*models.StatusData, error) {
+	var reading pb.Reading
+	if err := proto.Unmarshal(body, &reading); err != nil {
+		return nil, err
+	}
+
+	return fromReading(&reading), nil
+}
+
+func (ProtobufCodec) DecodeBatch(body []byte) ([]*models.StatusData, error) {
+	var batch pb.ReadingBatch
+	if err := proto.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+	readings := make([]*models.StatusData, 0, len(batch.Readings))
+	for _, reading := range batch.Readings {
+		readings = append(readings, fromReading(reading))
+	}
+
+	return readings, nil
+}
+
+// toJSON decodes a body with a schemaless unmarshal and re-encodes it as JSON,
+// so every format shares the JSON field mapping
+func toJSON(unmarshal func([]byte, interface{}) error, body []byte) ([]byte, error) {
+	var value interface{}
+	if err := unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
@@ -0,0 +1,57 @@
+package controllers_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestCodecsDecode(t *testing.T) {
+	reading := map[string]interface{}{
+		"deviceId":  2,
+		"timestamp": 1516472722,
+		"humidity":  40,
+		"metrics":   map[string]interface{}{"ph": map[string]interface{}{"value": 6.5, "unit": "pH"}},
+	}
+	expected := &models.StatusData{
+		DeviceID:  2,
+		Timestamp: 1516472722,
+		Metrics: map[string]models.Metric{
+			"humidity": {Value: 40},
+			"ph":       {Value: 6.5, Unit: "pH"},
+		},
+	}
+
+	cborBody, err := cbor.Marshal(reading)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	msgpackBody, err := msgpack.Marshal(reading)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	tests := map[string]struct {
+		codec controllers.Codec // input codec
+		body  []byte            // input body
+	}{
+		"JSON":        {controllers.JSONCodec{}, []byte(`{"deviceId":2,"timestamp":1516472722,"humidity":40,"metrics":{"ph":{"value":6.5,"unit":"pH"}}}`)},
+		"CBOR":        {controllers.CBORCodec{}, cborBody},
+		"MessagePack": {controllers.MsgpackCodec{}, msgpackBody},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			data, err := testCase.codec.Decode(testCase.body)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if !reflect.DeepEqual(data, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, data)
+			}
+		})
+	}
+}
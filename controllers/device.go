@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// Device is the controller for device provisioning
+type Device struct {
+	Service services.Device
+}
+
+// credentials is the response body for issued credentials
+type credentials struct {
+	ID    uint   `json:"id"`
+	Token string `json:"token"`
+}
+
+// Create provisions a device, returning its credentials
+func (c *Device) Create(w http.ResponseWriter, r *http.Request) {
+	var device models.Device
+	if !readJSON(w, r, &device) {
+		return
+	}
+	device.Token = ""
+
+	if err := c.Service.Provision(&device); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, &device)
+}
+
+// List lists every device
+func (c *Device) List(w http.ResponseWriter, r *http.Request) {
+	devices, err := c.Service.ReadAll()
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, devices)
+}
+
+// Read reads a device
+func (c *Device) Read(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	device, err := c.Service.Read(id)
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, device)
+}
+
+// Update updates the name and expected reporting interval of a device
+func (c *Device) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	var device models.Device
+	if !readJSON(w, r, &device) {
+		return
+	}
+	device.ID = id
+
+	if err := c.Service.Update(&device); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &device)
+}
+
+// Delete deletes a device
+func (c *Device) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	if err := c.Service.Delete(id); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	w.Write([]byte("OK.\n"))
+}
+
+// IssueCredentials replaces the credentials of a device
+func (c *Device) IssueCredentials(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	token, err := c.Service.IssueCredentials(id)
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &credentials{ID: id, Token: token})
+}
+
+// Bind binds a device to a plant
+func (c *Device) Bind(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	var binding models.Binding
+	if !readJSON(w, r, &binding) {
+		return
+	}
+	binding.DeviceID = id
+
+	if err := c.Service.Bind(&binding); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, &binding)
+}
+
+// Unbind closes the current binding of a device, at the "at" query parameter or now
+func (c *Device) Unbind(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	var at int64
+	if value := r.URL.Query().Get("at"); value != "" {
+		var err error
+		if at, err = strconv.ParseInt(value, 10, 64); err != nil {
+			http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	if err := c.Service.Unbind(id, at); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	w.Write([]byte("OK.\n"))
+}
+
+// Bindings lists the binding history of a device
+func (c *Device) Bindings(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	bindings, err := c.Service.Bindings(id)
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, bindings)
+}
+
+// Calibrate adds a calibration profile to a device
+func (c *Device) Calibrate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	var calibration models.Calibration
+	if !readJSON(w, r, &calibration) {
+		return
+	}
+	calibration.DeviceID = id
+
+	if err := c.Service.Calibrate(&calibration); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, &calibration)
+}
+
+// Calibrations lists the calibration profile history of a device
+func (c *Device) Calibrations(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	calibrations, err := c.Service.Calibrations(id)
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, calibrations)
+}
+
+// Authenticate is a middleware requiring device credentials as HTTP basic auth,
+// with the device ID as username and its token as password
+func (c *Device) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, token, ok := r.BasicAuth()
+		id, err := strconv.ParseUint(username, 10, 32)
+		if !ok || err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="broker"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+
+			return
+		}
+
+		switch err := c.Service.Authenticate(uint(id), token); err {
+		case nil:
+			next.ServeHTTP(w, util.WithDevice(r, uint(id)))
+		case services.DeviceUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Basic realm="broker"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		default:
+			util.LogError(r, err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+		}
+	})
+}
+
+// writeError maps a service error to a response
+func (c *Device) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case services.DeviceInvalidID:
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+	case services.DeviceDuplicateID:
+		http.Error(w, "Duplicate ID.", http.StatusConflict)
+	case services.DeviceDuplicateEUI:
+		http.Error(w, "Duplicate DevEUI.", http.StatusConflict)
+	case services.DeviceInvalidPlant:
+		http.Error(w, "Invalid plant.", http.StatusBadRequest)
+	case services.DeviceInvalidBinding:
+		http.Error(w, "Invalid binding.", http.StatusConflict)
+	case services.DeviceInvalidCalibration:
+		http.Error(w, "Invalid calibration.", http.StatusConflict)
+	case services.DeviceInvalidData:
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,353 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/gorilla/mux"
+)
+
+// Service mock: IDs 1 to 4 exist with token "secret", ID 5 fails
+type mockDeviceService struct{}
+
+var _ services.Device = (*mockDeviceService)(nil)
+
+func (s *mockDeviceService) Provision(device *models.Device) error {
+	if device.ID > 0 && device.ID < 5 {
+		return services.DeviceDuplicateID
+	}
+	device.ID = 6
+	device.Token = "secret"
+
+	return nil
+}
+
+func (s *mockDeviceService) Read(id uint) (*models.Device, error) {
+	switch {
+	case id > 0 && id < 5:
+		return &models.Device{ID: id, Name: "probe", TokenHash: "hash"}, nil
+	case id == 5:
+		return nil, services.DeviceDatabaseDriverError("mocked error")
+	}
+
+	return nil, services.DeviceInvalidID
+}
+
+func (s *mockDeviceService) ReadAll() ([]*models.Device, error) {
+	return []*models.Device{&models.Device{ID: 1, Name: "probe"}}, nil
+}
+
+func (s *mockDeviceService) Update(device *models.Device) error {
+	if device.Interval < 0 {
+		return services.DeviceInvalidData
+	}
+	_, err := s.Read(device.ID)
+
+	return err
+}
+
+func (s *mockDeviceService) Delete(id uint) error {
+	_, err := s.Read(id)
+
+	return err
+}
+
+func (s *mockDeviceService) IssueCredentials(id uint) (string, error) {
+	if _, err := s.Read(id); err != nil {
+		return "", err
+	}
+
+	return "renewed", nil
+}
+
+func (s *mockDeviceService) Authenticate(id uint, token string) error {
+	if id == 5 {
+		return services.DeviceDatabaseDriverError("mocked error")
+	}
+	if _, err := s.Read(id); err != nil || token != "secret" {
+		return services.DeviceUnauthorized
+	}
+
+	return nil
+}
+
+func (s *mockDeviceService) Bind(binding *models.Binding) error {
+	if _, err := s.Read(binding.DeviceID); err != nil {
+		return err
+	}
+	switch {
+	case binding.PlantID == 0:
+		return services.DeviceInvalidPlant
+	case binding.From < 100:
+		return services.DeviceInvalidBinding
+	}
+
+	return nil
+}
+
+func (s *mockDeviceService) Unbind(deviceID uint, at int64) error {
+	if _, err := s.Read(deviceID); err != nil {
+		return err
+	}
+	if at != 0 && at < 100 {
+		return services.DeviceInvalidBinding
+	}
+
+	return nil
+}
+
+func (s *mockDeviceService) Bindings(deviceID uint) ([]*models.Binding, error) {
+	if _, err := s.Read(deviceID); err != nil {
+		return nil, err
+	}
+
+	return []*models.Binding{&models.Binding{DeviceID: deviceID, PlantID: 1, From: 100}}, nil
+}
+
+func (s *mockDeviceService) Calibrate(calibration *models.Calibration) error {
+	if _, err := s.Read(calibration.DeviceID); err != nil {
+		return err
+	}
+	switch {
+	case len(calibration.Metrics) == 0:
+		return services.DeviceInvalidData
+	case calibration.From == 100:
+		return services.DeviceInvalidCalibration
+	}
+
+	return nil
+}
+
+func (s *mockDeviceService) Calibrations(deviceID uint) ([]*models.Calibration, error) {
+	if _, err := s.Read(deviceID); err != nil {
+		return nil, err
+	}
+
+	return []*models.Calibration{&models.Calibration{DeviceID: deviceID, From: 100, Metrics: map[string]models.MetricCalibration{"humidity": {Offset: -2}}}}, nil
+}
+
+func TestDevice(t *testing.T) {
+	// Setup
+	c := controllers.Device{
+		Service: &mockDeviceService{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/devices", c.Create).Methods("POST")
+	router.HandleFunc("/devices", c.List).Methods("GET")
+	router.HandleFunc("/devices/{id}", c.Read).Methods("GET")
+	router.HandleFunc("/devices/{id}", c.Update).Methods("PUT")
+	router.HandleFunc("/devices/{id}", c.Delete).Methods("DELETE")
+	router.HandleFunc("/devices/{id}/credentials", c.IssueCredentials).Methods("POST")
+	router.HandleFunc("/devices/{id}/bindings", c.Bindings).Methods("GET")
+	router.HandleFunc("/devices/{id}/bindings", c.Bind).Methods("POST")
+	router.HandleFunc("/devices/{id}/bindings", c.Unbind).Methods("DELETE")
+	router.HandleFunc("/devices/{id}/calibrations", c.Calibrations).Methods("GET")
+	router.HandleFunc("/devices/{id}/calibrations", c.Calibrate).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := map[string]struct {
+		request            *http.Request // input
+		expectedBody       string        // expected body
+		expectedStatusCode int           // expected status code
+	}{
+		"Provision": {
+			request:            buildStatusRequest("POST", server.URL+"/devices", []byte(`{"name":"probe"}`)),
+			expectedBody:       `{"id":6,"name":"probe","token":"secret"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		"Provision duplicate ID": {
+			request:            buildStatusRequest("POST", server.URL+"/devices", []byte(`{"id":1}`)),
+			expectedBody:       "Duplicate ID.\n",
+			expectedStatusCode: http.StatusConflict,
+		},
+		"List": {
+			request:            buildStatusRequest("GET", server.URL+"/devices", nil),
+			expectedBody:       `[{"id":1,"name":"probe"}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Read hides credentials": {
+			request:            buildStatusRequest("GET", server.URL+"/devices/2", nil),
+			expectedBody:       `{"id":2,"name":"probe"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Read invalid ID": {
+			request:            buildStatusRequest("GET", server.URL+"/devices/7", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Update interval": {
+			request:            buildStatusRequest("PUT", server.URL+"/devices/2", []byte(`{"name":"probe","interval":600}`)),
+			expectedBody:       `{"id":2,"name":"probe","interval":600}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Update negative interval": {
+			request:            buildStatusRequest("PUT", server.URL+"/devices/2", []byte(`{"interval":-1}`)),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Update invalid ID": {
+			request:            buildStatusRequest("PUT", server.URL+"/devices/7", []byte(`{"interval":600}`)),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Delete database error": {
+			request:            buildStatusRequest("DELETE", server.URL+"/devices/5", nil),
+			expectedBody:       "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		"Issue credentials": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/credentials", nil),
+			expectedBody:       `{"id":1,"token":"renewed"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Bind": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/bindings", []byte(`{"plantId":3,"from":150}`)),
+			expectedBody:       `{"deviceId":1,"plantId":3,"from":150}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		"Bind invalid plant": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/bindings", []byte(`{"from":150}`)),
+			expectedBody:       "Invalid plant.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Bind overlapping": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/bindings", []byte(`{"plantId":3,"from":50}`)),
+			expectedBody:       "Invalid binding.\n",
+			expectedStatusCode: http.StatusConflict,
+		},
+		"Unbind": {
+			request:            buildStatusRequest("DELETE", server.URL+"/devices/1/bindings?at=150", nil),
+			expectedBody:       "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Unbind invalid time": {
+			request:            buildStatusRequest("DELETE", server.URL+"/devices/1/bindings?at=soon", nil),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Bindings": {
+			request:            buildStatusRequest("GET", server.URL+"/devices/1/bindings", nil),
+			expectedBody:       `[{"deviceId":1,"plantId":1,"from":100}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Calibrate": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/calibrations", []byte(`{"from":150,"metrics":{"humidity":{"table":[{"raw":0,"value":0},{"raw":80,"value":100}]}}}`)),
+			expectedBody:       `{"deviceId":1,"from":150,"metrics":{"humidity":{"table":[{"raw":0,"value":0},{"raw":80,"value":100}]}}}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		"Calibrate without metrics": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/calibrations", []byte(`{"from":150}`)),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Calibrate stored start": {
+			request:            buildStatusRequest("POST", server.URL+"/devices/1/calibrations", []byte(`{"from":100,"metrics":{"humidity":{"offset":-2}}}`)),
+			expectedBody:       "Invalid calibration.\n",
+			expectedStatusCode: http.StatusConflict,
+		},
+		"Calibrations": {
+			request:            buildStatusRequest("GET", server.URL+"/devices/1/calibrations", nil),
+			expectedBody:       `[{"deviceId":1,"from":100,"metrics":{"humidity":{"offset":-2}}}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Calibrations invalid ID": {
+			request:            buildStatusRequest("GET", server.URL+"/devices/7/calibrations", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(testCase.request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedBody ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, string(body))
+			}
+		})
+	}
+}
+
+func TestDeviceAuthenticate(t *testing.T) {
+	// Setup
+	devices := controllers.Device{
+		Service: &mockDeviceService{},
+	}
+	status := controllers.Status{
+		Service: &mockStatusService{},
+	}
+	server := httptest.NewServer(devices.Authenticate(http.HandlerFunc(status.Write)))
+	defer server.Close()
+
+	authenticated := func(username, password string, body []byte) *http.Request {
+		req := buildStatusRequest("POST", server.URL, body)
+		req.SetBasicAuth(username, password)
+
+		return req
+	}
+
+	tests := map[string]struct {
+		request            *http.Request // input
+		expectedStatus     string        // expected status
+		expectedStatusCode int           // expected status code
+	}{
+		"Happy path": {
+			request:            authenticated("1", "secret", []byte(`{"timestamp":1516472722}`)),
+			expectedStatus:     "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"No credentials": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722}`)),
+			expectedStatus:     "Unauthorized.\n",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		"Wrong token": {
+			request:            authenticated("1", "guess", []byte(`{"timestamp":1516472722}`)),
+			expectedStatus:     "Unauthorized.\n",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		"Malformed device ID": {
+			request:            authenticated("probe", "secret", []byte(`{"timestamp":1516472722}`)),
+			expectedStatus:     "Unauthorized.\n",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		"Other device": {
+			request:            authenticated("1", "secret", []byte(`{"deviceId":2,"timestamp":1516472722}`)),
+			expectedStatus:     "Forbidden.\n",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		"Driver error": {
+			request:            authenticated("5", "secret", []byte(`{"timestamp":1516472722}`)),
+			expectedStatus:     "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(testCase.request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedStatus ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedStatus, response.StatusCode, string(body))
+			}
+		})
+	}
+}
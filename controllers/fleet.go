@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// Fleet is the controller for device reporting states
+type Fleet struct {
+	Service services.Fleet
+}
+
+// Read reads the reporting state of devices and plants, filtered by the "state" query parameter
+func (c *Fleet) Read(w http.ResponseWriter, r *http.Request) {
+	fleet, err := c.Service.Fleet(r.URL.Query().Get("state"))
+	switch err {
+	case nil:
+		writeJSON(w, r, http.StatusOK, fleet)
+	case services.FleetInvalidState:
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,81 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// Service mock: the "stale" state fails
+type mockFleetService struct{}
+
+var _ services.Fleet = (*mockFleetService)(nil)
+
+func (s *mockFleetService) Fleet(state string) (*models.Fleet, error) {
+	switch state {
+	case "", models.ReportingOffline:
+	case models.ReportingStale:
+		return nil, services.FleetDatabaseDriverError("mocked error")
+	default:
+		return nil, services.FleetInvalidState
+	}
+
+	return &models.Fleet{
+		Devices: []*models.Heartbeat{&models.Heartbeat{DeviceID: 1, PlantID: 2, LastSeen: 100, Interval: 600, State: models.ReportingOffline}},
+		Plants:  []*models.Heartbeat{},
+		Counts:  map[string]int{"online": 0, "stale": 0, "offline": 1},
+		Checked: 700,
+	}, nil
+}
+
+func TestReadFleet(t *testing.T) {
+	// Setup
+	c := controllers.Fleet{
+		Service: &mockFleetService{},
+	}
+	server := httptest.NewServer(http.HandlerFunc(c.Read))
+	defer server.Close()
+
+	tests := map[string]struct {
+		query              string // input query
+		expectedBody       string // expected body
+		expectedStatusCode int    // expected status code
+	}{
+		"Offline": {
+			query:              "?state=offline",
+			expectedBody:       `{"devices":[{"deviceId":1,"plantId":2,"lastSeen":100,"interval":600,"state":"offline"}],"plants":[],"counts":{"offline":1,"online":0,"stale":0},"checked":700}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Invalid state": {
+			query:              "?state=asleep",
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Database error": {
+			query:              "?state=stale",
+			expectedBody:       "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.Get(server.URL + testCase.query)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedBody ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, string(body))
+			}
+		})
+	}
+}
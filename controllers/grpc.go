@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/pb"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authenticatedRPCs are the gRPC methods requiring device credentials, like POST /broker/status
+var authenticatedRPCs = map[string]bool{
+	pb.StatusService_Write_FullMethodName:       true,
+	pb.StatusService_WriteStream_FullMethodName: true,
+}
+
+// rpcCodes maps the HTTP status codes of status results to gRPC codes
+var rpcCodes = map[int]codes.Code{
+	http.StatusOK:                  codes.OK,
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// StatusRPC is the gRPC controller for status data
+type StatusRPC struct {
+	pb.UnimplementedStatusServiceServer
+	Service services.Status
+	History services.StatusQuery
+}
+
+var _ pb.StatusServiceServer = (*StatusRPC)(nil)
+
+// Write writes a reading
+func (c *StatusRPC) Write(ctx context.Context, reading *pb.Reading) (*pb.WriteReply, error) {
+	result := c.write(ctx, reading)
+	if result.RetryAfter != 0 {
+		grpc.SetTrailer(ctx, metadata.Pairs("retry-after", retryAfter(result.RetryAfter)))
+	}
+	if result.Code != http.StatusOK {
+		return nil, status.Error(rpcCodes[result.Code], result.Message)
+	}
+
+	return &pb.WriteReply{}, nil
+}
+
+// WriteStream writes a stream of readings, replying with the rejected ones
+func (c *StatusRPC) WriteStream(stream pb.StatusService_WriteStreamServer) error {
+	reply := &pb.WriteStreamReply{}
+	for index := uint32(0); ; index++ {
+		reading, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(reply)
+		}
+		if err != nil {
+			return err
+		}
+
+		result := c.write(stream.Context(), reading)
+		if result.Code == http.StatusOK {
+			reply.Accepted++
+
+			continue
+		}
+		reply.Rejected = append(reply.Rejected, &pb.Rejection{
+			Index:   index,
+			Code:    uint32(rpcCodes[result.Code]),
+			Message: result.Message,
+		})
+	}
+}
+
+// Query streams the stored readings of a plant in a time range
+func (c *StatusRPC) Query(request *pb.QueryRequest, stream pb.StatusService_QueryServer) error {
+	err := c.History.Query(uint(request.PlantId), request.From, request.To, func(data *models.StatusData) error {
+		return stream.Send(toReading(data))
+	})
+	switch err.(type) {
+	case nil:
+		return nil
+	case services.StatusInvalidDataError:
+		if err == services.StatusInvalidID {
+			return status.Error(codes.NotFound, "Invalid ID.")
+		}
+
+		return status.Error(codes.InvalidArgument, "Invalid data.")
+	case services.StatusDatabaseDriverError:
+		logRPCError(stream.Context(), err)
+
+		return status.Error(codes.Internal, "Internal server error.")
+	default:
+		// Failed sends
+		return err
+	}
+}
+
+// write writes a reading, logging unexpected errors
+func (c *StatusRPC) write(ctx context.Context, reading *pb.Reading) statusResult {
+	result := writeStatus(ctx, c.Service, fromReading(reading))
+	if result.Err != nil {
+		logRPCError(ctx, result.Err)
+	}
+
+	return result
+}
+
+// AuthenticateUnary is a unary interceptor requiring device credentials, as HTTP basic auth
+// in the "authorization" metadata, on write methods
+func (c *Device) AuthenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !authenticatedRPCs[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	ctx, err := c.authenticateRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// AuthenticateStream is the stream interceptor counterpart of AuthenticateUnary
+func (c *Device) AuthenticateStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !authenticatedRPCs[info.FullMethod] {
+		return handler(srv, ss)
+	}
+	ctx, err := c.authenticateRPC(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticateRPC checks the device credentials of a call, returning its context with the device
+func (c *Device) authenticateRPC(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var authorization string
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	username, token, ok := parseBasicAuth(authorization)
+	id, err := strconv.ParseUint(username, 10, 32)
+	if !ok || err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized.")
+	}
+
+	switch err := c.Service.Authenticate(uint(id), token); err {
+	case nil:
+		return util.ContextWithDevice(ctx, uint(id)), nil
+	case services.DeviceUnauthorized:
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized.")
+	default:
+		logRPCError(ctx, err)
+
+		return nil, status.Error(codes.Internal, "Internal server error.")
+	}
+}
+
+// UnaryLogger returns a unary interceptor carrying the logger in the call context,
+// as the HTTP router does for requests
+func UnaryLogger(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(context.WithValue(ctx, "logger", logger), req)
+	}
+}
+
+// StreamLogger is the stream interceptor counterpart of UnaryLogger
+func StreamLogger(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := context.WithValue(ss.Context(), "logger", logger)
+
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream is a server stream with a replaced context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// logRPCError logs an error of the current gRPC call
+func logRPCError(ctx context.Context, err error) {
+	method, _ := grpc.Method(ctx)
+	util.LogContextError(ctx, "GRPC", method, err)
+}
+
+// parseBasicAuth parses HTTP basic auth credentials
+func parseBasicAuth(authorization string) (string, string, bool) {
+	const prefix = "Basic "
+	if len(authorization) < len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(authorization[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	credentials := strings.SplitN(string(decoded), ":", 2)
+	if len(credentials) != 2 {
+		return "", "", false
+	}
+
+	return credentials[0], credentials[1], true
+}
+
+// fromReading converts a protobuf reading to status data
+func fromReading(reading *pb.Reading) *models.StatusData {
+	data := &models.StatusData{
+		ID:             uint(reading.Id),
+		DeviceID:       uint(reading.DeviceId),
+		Timestamp:      reading.Timestamp,
+		IdempotencyKey: reading.IdempotencyKey,
+	}
+	for name, metric := range reading.Metrics {
+		if metric == nil {
+			continue
+		}
+		if data.Metrics == nil {
+			data.Metrics = map[string]models.Metric{}
+		}
+		data.Metrics[name] = models.Metric{Value: metric.Value, Unit: metric.Unit}
+	}
+
+	return data
+}
+
+// toReading converts status data to a protobuf reading
+func toReading(data *models.StatusData) *pb.Reading {
+	reading := &pb.Reading{
+		Id:         uint32(data.ID),
+		DeviceId:   uint32(data.DeviceID),
+		Timestamp:  data.Timestamp,
+		ReceivedAt: data.ReceivedAt,
+	}
+	if len(data.Metrics) > 0 {
+		reading.Metrics = make(map[string]*pb.Metric, len(data.Metrics))
+		for name, metric := range data.Metrics {
+			reading.Metrics[name] = &pb.Metric{Value: metric.Value, Unit: metric.Unit}
+		}
+	}
+	if len(data.Raw) > 0 {
+		reading.Raw = make(map[string]float64, len(data.Raw))
+		for name, value := range data.Raw {
+			reading.Raw[name] = value
+		}
+	}
+	if len(data.Quality) > 0 {
+		reading.Quality = make(map[string]string, len(data.Quality))
+		for name, quality := range data.Quality {
+			reading.Quality[name] = quality
+		}
+	}
+
+	return reading
+}
@@ -0,0 +1,255 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/pb"
+	"github.com/berry-house/http_broker/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// Query mock: plant 1 has two readings, plant 5 fails
+type mockStatusQuery struct{}
+
+var _ services.StatusQuery = (*mockStatusQuery)(nil)
+
+func (s *mockStatusQuery) Query(plantID uint, from, to int64, fn func(data *models.StatusData) error) error {
+	switch {
+	case to != 0 && to <= from:
+		return services.StatusInvalidData
+	case plantID == 5:
+		return services.StatusDatabaseDriverError("mocked error")
+	case plantID != 1:
+		return services.StatusInvalidID
+	}
+
+	readings := []*models.StatusData{
+		&models.StatusData{ID: 1, Timestamp: 100, Metrics: map[string]models.Metric{"humidity": {Value: 40, Unit: "%"}}},
+		&models.StatusData{ID: 1, Timestamp: 200},
+	}
+	for _, data := range readings {
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dialStatusRPC serves the gRPC controller over an in-memory connection
+func dialStatusRPC(t *testing.T, options ...grpc.ServerOption) (pb.StatusServiceClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(options...)
+	pb.RegisterStatusServiceServer(server, &controllers.StatusRPC{
+		Service: &mockStatusService{},
+		History: &mockStatusQuery{},
+	})
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	return pb.NewStatusServiceClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestStatusRPCWrite(t *testing.T) {
+	// Setup
+	client, closeRPC := dialStatusRPC(t)
+	defer closeRPC()
+
+	tests := map[string]struct {
+		reading            *pb.Reading // input
+		expectedCode       codes.Code  // expected code
+		expectedRetryAfter string      // expected retry-after trailer
+	}{
+		"Happy path":     {&pb.Reading{Id: 1, Timestamp: 1516478286, Metrics: map[string]*pb.Metric{"temperature": {Value: 23}}}, codes.OK, ""},
+		"Device":         {&pb.Reading{DeviceId: 2, Timestamp: 1516478286}, codes.OK, ""},
+		"Invalid ID":     {&pb.Reading{Id: 6, Timestamp: 1516478286}, codes.NotFound, ""},
+		"Invalid data":   {&pb.Reading{Id: 1, Timestamp: 1516478286, Metrics: map[string]*pb.Metric{"temperature": {Value: -100}}}, codes.InvalidArgument, ""},
+		"Database error": {&pb.Reading{Id: 5, Timestamp: 1516478286}, codes.Internal, ""},
+		"Unavailable":    {&pb.Reading{Id: 9, Timestamp: 1516478286}, codes.Unavailable, "2"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var trailer metadata.MD
+			_, err := client.Write(context.Background(), testCase.reading, grpc.Trailer(&trailer))
+			if status.Code(err) != testCase.expectedCode {
+				t.Errorf("Expected %s, got %+v", testCase.expectedCode, err)
+			}
+			var retryAfter string
+			if values := trailer.Get("retry-after"); len(values) > 0 {
+				retryAfter = values[0]
+			}
+			if retryAfter != testCase.expectedRetryAfter {
+				t.Errorf("Expected retry after %q, got %q", testCase.expectedRetryAfter, retryAfter)
+			}
+		})
+	}
+}
+
+func TestStatusRPCWriteStream(t *testing.T) {
+	// Setup
+	client, closeRPC := dialStatusRPC(t)
+	defer closeRPC()
+
+	stream, err := client.WriteStream(context.Background())
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	readings := []*pb.Reading{
+		&pb.Reading{Id: 1, Timestamp: 1516478286},
+		&pb.Reading{Id: 6, Timestamp: 1516478286},
+		&pb.Reading{Id: 2, Timestamp: 1516478286},
+		&pb.Reading{Id: 1, Timestamp: 1516478286, Metrics: map[string]*pb.Metric{"radiation": {Value: 1}}},
+	}
+	for _, reading := range readings {
+		if err := stream.Send(reading); err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+	}
+	reply, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	expected := &pb.WriteStreamReply{
+		Accepted: 2,
+		Rejected: []*pb.Rejection{
+			&pb.Rejection{Index: 1, Code: uint32(codes.NotFound), Message: "Invalid ID."},
+			&pb.Rejection{Index: 3, Code: uint32(codes.InvalidArgument), Message: "Invalid data."},
+		},
+	}
+	if !proto.Equal(reply, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, reply)
+	}
+}
+
+func TestStatusRPCQuery(t *testing.T) {
+	// Setup
+	client, closeRPC := dialStatusRPC(t)
+	defer closeRPC()
+
+	tests := map[string]struct {
+		request      *pb.QueryRequest // input
+		expected     []int64          // expected timestamps
+		expectedCode codes.Code       // expected code
+	}{
+		"Happy path":     {&pb.QueryRequest{PlantId: 1}, []int64{100, 200}, codes.OK},
+		"Invalid range":  {&pb.QueryRequest{PlantId: 1, From: 200, To: 100}, []int64{}, codes.InvalidArgument},
+		"Invalid ID":     {&pb.QueryRequest{PlantId: 2}, []int64{}, codes.NotFound},
+		"Database error": {&pb.QueryRequest{PlantId: 5}, []int64{}, codes.Internal},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			stream, err := client.Query(context.Background(), testCase.request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			timestamps := []int64{}
+			for {
+				reading, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if status.Code(err) != testCase.expectedCode {
+						t.Errorf("Expected %s, got %+v", testCase.expectedCode, err)
+					}
+					break
+				}
+				timestamps = append(timestamps, reading.Timestamp)
+			}
+			if !reflect.DeepEqual(timestamps, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, timestamps)
+			}
+		})
+	}
+
+	// Metrics are converted
+	stream, _ := client.Query(context.Background(), &pb.QueryRequest{PlantId: 1})
+	reading, _ := stream.Recv()
+	expected := &pb.Reading{Id: 1, Timestamp: 100, Metrics: map[string]*pb.Metric{"humidity": {Value: 40, Unit: "%"}}}
+	if !proto.Equal(reading, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, reading)
+	}
+}
+
+func TestStatusRPCAuthenticate(t *testing.T) {
+	// Setup
+	device := controllers.Device{
+		Service: &mockDeviceService{},
+	}
+	client, closeRPC := dialStatusRPC(t,
+		grpc.UnaryInterceptor(device.AuthenticateUnary),
+		grpc.StreamInterceptor(device.AuthenticateStream),
+	)
+	defer closeRPC()
+
+	basic := func(credentials string) context.Context {
+		authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", authorization)
+	}
+
+	tests := map[string]struct {
+		ctx          context.Context // input
+		reading      *pb.Reading     // input
+		expectedCode codes.Code      // expected code
+	}{
+		"Happy path":          {basic("2:secret"), &pb.Reading{Timestamp: 1516478286}, codes.OK},
+		"Own device":          {basic("2:secret"), &pb.Reading{DeviceId: 2, Timestamp: 1516478286}, codes.OK},
+		"Other device":        {basic("2:secret"), &pb.Reading{DeviceId: 3, Timestamp: 1516478286}, codes.PermissionDenied},
+		"Missing credentials": {context.Background(), &pb.Reading{Timestamp: 1516478286}, codes.Unauthenticated},
+		"Invalid token":       {basic("2:guess"), &pb.Reading{Timestamp: 1516478286}, codes.Unauthenticated},
+		"Invalid username":    {basic("probe:secret"), &pb.Reading{Timestamp: 1516478286}, codes.Unauthenticated},
+		"Database error":      {basic("5:secret"), &pb.Reading{Timestamp: 1516478286}, codes.Internal},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := client.Write(testCase.ctx, testCase.reading)
+			if status.Code(err) != testCase.expectedCode {
+				t.Errorf("Expected %s, got %+v", testCase.expectedCode, err)
+			}
+		})
+	}
+
+	// Streams are authenticated too
+	stream, _ := client.WriteStream(context.Background())
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected %s, got %+v", codes.Unauthenticated, err)
+	}
+	stream, _ = client.WriteStream(basic("2:secret"))
+	stream.Send(&pb.Reading{Timestamp: 1516478286})
+	if reply, err := stream.CloseAndRecv(); err != nil || reply.Accepted != 1 {
+		t.Errorf("Expected an accepted reading, got %+v (%+v)", reply, err)
+	}
+
+	// Queries are not
+	query, _ := client.Query(context.Background(), &pb.QueryRequest{PlantId: 1})
+	if _, err := query.Recv(); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+}
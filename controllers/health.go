@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/berry-house/http_broker/services"
+)
+
+// Health is the controller for health data
+type Health struct {
+	Service services.Health
+}
+
+func (c *Health) Read(w http.ResponseWriter, r *http.Request) {
+	health := c.Service.Check()
+
+	statusCode := http.StatusOK
+	if health.Status == services.HealthUnavailable {
+		statusCode = http.StatusServiceUnavailable
+	}
+	writeJSON(w, r, statusCode, health)
+}
@@ -0,0 +1,70 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// Service mock
+type mockHealthService struct {
+	status string
+}
+
+var _ services.Health = (*mockHealthService)(nil)
+
+func (s *mockHealthService) Check() *models.Health {
+	return &models.Health{
+		Status:   s.status,
+		Breakers: map[string]string{"mysql": "closed"},
+	}
+}
+
+func TestReadHealth(t *testing.T) {
+	tests := map[string]struct {
+		status             string // service status
+		expectedBody       string // expected body
+		expectedStatusCode int    // expected status code
+	}{
+		"Healthy": {
+			status:             services.HealthOK,
+			expectedBody:       `{"status":"ok","breakers":{"mysql":"closed"}}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Degraded": {
+			status:             services.HealthDegraded,
+			expectedBody:       `{"status":"degraded","breakers":{"mysql":"closed"}}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Unavailable": {
+			status:             services.HealthUnavailable,
+			expectedBody:       `{"status":"unavailable","breakers":{"mysql":"closed"}}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			c := controllers.Health{Service: &mockHealthService{status: testCase.status}}
+			server := httptest.NewServer(http.HandlerFunc(c.Read))
+			defer server.Close()
+
+			response, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedBody ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, string(body))
+			}
+		})
+	}
+}
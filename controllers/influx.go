@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+	"github.com/influxdata/line-protocol/v2/lineprotocol"
+)
+
+// Line protocol tags identifying the plant or device of a reading
+const (
+	influxPlantTag  = "plant"
+	influxDeviceTag = "device"
+)
+
+// influxPrecisions maps the precision query parameter to timestamp precisions
+var influxPrecisions = map[string]lineprotocol.Precision{
+	"":   lineprotocol.Nanosecond,
+	"ns": lineprotocol.Nanosecond,
+	"us": lineprotocol.Microsecond,
+	"µs": lineprotocol.Microsecond,
+	"ms": lineprotocol.Millisecond,
+	"s":  lineprotocol.Second,
+}
+
+// influxCodes maps the HTTP codes of a failed write to InfluxDB error codes
+var influxCodes = map[int]string{
+	http.StatusBadRequest:            "invalid",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "request too large",
+	http.StatusUnsupportedMediaType:  "unsupported media type",
+	http.StatusInternalServerError:   "internal error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// influxError is an error response in the InfluxDB format
+type influxError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Line is the number of the first rejected line, starting at 1
+	Line int `json:"line,omitempty"`
+}
+
+// Influx is the controller for InfluxDB line protocol writes.
+// Every line needs a "plant" or "device" tag; its numeric fields are written as metrics,
+// named after the measurement for fields called "value". String and boolean fields are ignored.
+// Lines of the same plant, device and timestamp are written as a single reading.
+type Influx struct {
+	Service services.Status
+	// Clock returns the current time, for lines without a timestamp, defaulting to time.Now
+	Clock func() time.Time
+	// MaxBodySize is the size limit of decoded bodies, defaulting to DefaultMaxBodySize
+	MaxBodySize int64
+}
+
+// influxReading is a reading built from one or more lines
+type influxReading struct {
+	data *models.StatusData
+	// line is the number of the first line of the reading
+	line int
+	text string
+}
+
+// influxRejection is a line that was not written
+type influxRejection struct {
+	code    int
+	message string
+	line    int
+}
+
+func (c *Influx) Write(w http.ResponseWriter, r *http.Request) {
+	precision, ok := influxPrecisions[r.URL.Query().Get("precision")]
+	if !ok {
+		writeJSON(w, r, http.StatusBadRequest, influxError{Code: "invalid", Message: "invalid precision"})
+
+		return
+	}
+	body, code, err := decodedBody(w, r, sizeLimit(c.MaxBodySize, DefaultMaxBodySize))
+	if code != http.StatusOK {
+		if err != nil {
+			util.LogError(r, err)
+		}
+		writeJSON(w, r, code, influxError{Code: influxCodes[code], Message: strings.ToLower(http.StatusText(code))})
+
+		return
+	}
+
+	readings, rejections := c.parse(body, precision)
+	for _, reading := range readings {
+		result := writeStatus(r.Context(), c.Service, reading.data)
+		if result.Err != nil {
+			util.LogError(r, result.Err)
+		}
+		if result.RetryAfter != 0 {
+			w.Header().Set("Retry-After", retryAfter(result.RetryAfter))
+		}
+		if result.Code != http.StatusOK {
+			rejections = append(rejections, influxRejection{
+				code:    result.Code,
+				message: fmt.Sprintf("unable to write '%s': %s", reading.text, strings.TrimSuffix(result.Message, ".")),
+				line:    reading.line,
+			})
+		}
+	}
+	if len(rejections) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	// The most severe rejection sets the response code, the first one its message
+	first, worst := rejections[0], rejections[0]
+	for _, rejection := range rejections {
+		if rejection.line < first.line {
+			first = rejection
+		}
+		if influxSeverity(rejection.code) > influxSeverity(worst.code) {
+			worst = rejection
+		}
+	}
+	code = worst.code
+	if code == http.StatusNotFound {
+		code = http.StatusBadRequest
+	}
+	writeJSON(w, r, code, influxError{
+		Code:    influxCodes[code],
+		Message: fmt.Sprintf("partial write: %s; dropped=%d", first.message, len(rejections)),
+		Line:    first.line,
+	})
+}
+
+// AuthenticateInflux is the device authentication middleware of the InfluxDB write endpoint.
+// InfluxDB clients send their token as "Authorization: Token <token>", so devices send
+// "Token <id>:<token>"; HTTP basic auth is accepted as well. Failures are answered in the
+// InfluxDB error format.
+func (c *Device) AuthenticateInflux(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, token, ok := r.BasicAuth()
+		if scheme, credentials, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Token") {
+			username, token, ok = strings.Cut(credentials, ":")
+		}
+		id, err := strconv.ParseUint(username, 10, 32)
+		if !ok || err != nil {
+			writeJSON(w, r, http.StatusUnauthorized, influxError{Code: influxCodes[http.StatusUnauthorized], Message: "unauthorized access"})
+
+			return
+		}
+
+		switch err := c.Service.Authenticate(uint(id), token); err {
+		case nil:
+			next.ServeHTTP(w, util.WithDevice(r, uint(id)))
+		case services.DeviceUnauthorized:
+			writeJSON(w, r, http.StatusUnauthorized, influxError{Code: influxCodes[http.StatusUnauthorized], Message: "unauthorized access"})
+		default:
+			util.LogError(r, err)
+			writeJSON(w, r, http.StatusInternalServerError, influxError{Code: influxCodes[http.StatusInternalServerError], Message: "internal error"})
+		}
+	})
+}
+
+// parse parses a line protocol body into readings, rejecting invalid lines
+func (c *Influx) parse(body []byte, precision lineprotocol.Precision) ([]*influxReading, []influxRejection) {
+	now := time.Now
+	if c.Clock != nil {
+		now = c.Clock
+	}
+
+	var readings []*influxReading
+	var rejections []influxRejection
+	keys := map[string]*influxReading{}
+	for index, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		data, err := parseInfluxLine(line, precision, now())
+		if e, ok := err.(*lineprotocol.DecodeError); ok {
+			// Lines are decoded one by one, so decoder positions are meaningless
+			err = e.Err
+		}
+		if err != nil {
+			rejections = append(rejections, influxRejection{
+				code:    http.StatusBadRequest,
+				message: fmt.Sprintf("unable to parse '%s': %s", line, err),
+				line:    index + 1,
+			})
+
+			continue
+		}
+
+		key := fmt.Sprintf("%d/%d/%d", data.ID, data.DeviceID, data.Timestamp)
+		if reading, ok := keys[key]; ok {
+			for name, metric := range data.Metrics {
+				reading.data.Metrics[name] = metric
+			}
+
+			continue
+		}
+		reading := &influxReading{data: data, line: index + 1, text: string(line)}
+		keys[key] = reading
+		readings = append(readings, reading)
+	}
+
+	return readings, rejections
+}
+
+// parseInfluxLine parses a single line protocol entry
+func parseInfluxLine(line []byte, precision lineprotocol.Precision, now time.Time) (*models.StatusData, error) {
+	decoder := lineprotocol.NewDecoderWithBytes(line)
+	decoder.Next()
+	measurement, err := decoder.Measurement()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &models.StatusData{Metrics: map[string]models.Metric{}}
+	for {
+		key, value, err := decoder.NextTag()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+		var target *uint
+		switch string(key) {
+		case influxPlantTag:
+			target = &data.ID
+		case influxDeviceTag:
+			target = &data.DeviceID
+		default:
+			continue
+		}
+		id, err := strconv.ParseUint(string(value), 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid %s tag", key)
+		}
+		*target = uint(id)
+	}
+	if data.ID == 0 && data.DeviceID == 0 {
+		return nil, fmt.Errorf("missing %s or %s tag", influxPlantTag, influxDeviceTag)
+	}
+
+	for {
+		key, value, err := decoder.NextField()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+		name := string(key)
+		if name == "value" {
+			name = string(measurement)
+		}
+		switch value.Kind() {
+		case lineprotocol.Float:
+			data.SetValue(name, value.FloatV())
+		case lineprotocol.Int:
+			data.SetValue(name, float64(value.IntV()))
+		case lineprotocol.Uint:
+			data.SetValue(name, float64(value.UintV()))
+		}
+	}
+	if len(data.Metrics) == 0 {
+		return nil, fmt.Errorf("no numeric fields")
+	}
+
+	timestamp, err := decoder.Time(precision, now)
+	if err != nil {
+		return nil, err
+	}
+	data.Timestamp = timestamp.Unix()
+
+	return data, nil
+}
+
+// influxSeverity orders the codes of rejected lines, from client to server errors
+func influxSeverity(code int) int {
+	switch code {
+	case http.StatusServiceUnavailable:
+		return 4
+	case http.StatusInternalServerError:
+		return 3
+	case http.StatusForbidden:
+		return 2
+	default:
+		return 1
+	}
+}
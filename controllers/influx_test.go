@@ -0,0 +1,194 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// Service mock recording accepted readings
+type mockRecordingStatusService struct {
+	mockStatusService
+	written []*models.StatusData
+}
+
+var _ services.Status = (*mockRecordingStatusService)(nil)
+
+func (s *mockRecordingStatusService) Write(data *models.StatusData) error {
+	if err := s.mockStatusService.Write(data); err != nil {
+		return err
+	}
+	s.written = append(s.written, data)
+
+	return nil
+}
+
+func TestInfluxWrite(t *testing.T) {
+	now := time.Unix(1516472800, 0)
+
+	tests := map[string]struct {
+		query              string               // input query
+		body               string               // input body
+		expectedStatusCode int                  // expected status code
+		expectedError      map[string]string    // expected error code and message, nil for none
+		expectedLine       int                  // expected error line
+		expectedWritten    []*models.StatusData // expected written readings
+	}{
+		"Happy path": {
+			"precision=s",
+			"temperature,plant=1 value=21.5 1516472722\nhumidity,plant=1,room=a value=40i 1516472722\n\nenv,device=2 ph=6.5,co2=410u,label=\"x\",ok=true 1516472723\n",
+			http.StatusNoContent, nil, 0,
+			[]*models.StatusData{
+				{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 21.5, Unit: "°C"}, "humidity": {Value: 40, Unit: "%"}}},
+				{ID: 1, DeviceID: 2, Timestamp: 1516472723, Metrics: map[string]models.Metric{"ph": {Value: 6.5, Unit: "pH"}, "co2": {Value: 410, Unit: "ppm"}}},
+			},
+		},
+		"Default precision and time": {
+			"",
+			"temperature,plant=1 value=21.5 1516472722000000000\ntemperature,plant=2 value=20",
+			http.StatusNoContent, nil, 0,
+			[]*models.StatusData{
+				{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 21.5, Unit: "°C"}}},
+				{ID: 2, Timestamp: 1516472800, Metrics: map[string]models.Metric{"temperature": {Value: 20, Unit: "°C"}}},
+			},
+		},
+		"Partial write": {
+			"precision=ms",
+			"temperature,plant=1 value=21.5 1516472722000\ntemperature value=20\ntemperature,plant=7 value=20\ntemperature,plant=1 value=99",
+			http.StatusBadRequest, map[string]string{"code": "invalid", "message": "partial write: unable to parse 'temperature value=20': missing plant or device tag; dropped=3"}, 2,
+			[]*models.StatusData{
+				{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 21.5, Unit: "°C"}}},
+			},
+		},
+		"Syntax error": {
+			"precision=s",
+			"temperature,plant=1 value=",
+			http.StatusBadRequest, map[string]string{"code": "invalid", "message": "partial write: unable to parse 'temperature,plant=1 value=': expected field value, found end of input; dropped=1"}, 1,
+			nil,
+		},
+		"No numeric fields": {
+			"precision=s",
+			"status,plant=1 label=\"ok\" 1516472722",
+			http.StatusBadRequest, map[string]string{"code": "invalid", "message": "partial write: unable to parse 'status,plant=1 label=\"ok\" 1516472722': no numeric fields; dropped=1"}, 1,
+			nil,
+		},
+		"Unavailable": {
+			"precision=s",
+			"temperature,plant=7 value=20 1516472722\ntemperature,plant=9 value=20 1516472722",
+			http.StatusServiceUnavailable, map[string]string{"code": "unavailable", "message": "partial write: unable to write 'temperature,plant=7 value=20 1516472722': Invalid ID; dropped=2"}, 1,
+			nil,
+		},
+		"Invalid precision": {
+			"precision=h",
+			"temperature,plant=1 value=20",
+			http.StatusBadRequest, map[string]string{"code": "invalid", "message": "invalid precision"}, 0,
+			nil,
+		},
+		"Body too large": {
+			"precision=s",
+			strings.Repeat("temperature,plant=1 value=20 1516472722\n", 100),
+			http.StatusRequestEntityTooLarge, map[string]string{"code": "request too large", "message": "request entity too large"}, 0,
+			nil,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := &mockRecordingStatusService{}
+			controller := controllers.Influx{
+				Service:     service,
+				Clock:       func() time.Time { return now },
+				MaxBodySize: 1024,
+			}
+			server := httptest.NewServer(http.HandlerFunc(controller.Write))
+			defer server.Close()
+
+			response, err := http.DefaultClient.Do(buildStatusRequest("POST", server.URL+"/api/v2/write?"+testCase.query, []byte(testCase.body)))
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+			if testCase.expectedError != nil {
+				var body struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+					Line    int    `json:"line"`
+				}
+				if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+					t.Fatalf("No error expected, got %+v", err)
+				}
+				if body.Code != testCase.expectedError["code"] || body.Message != testCase.expectedError["message"] || body.Line != testCase.expectedLine {
+					t.Errorf("Expected %v at line %d, got %+v", testCase.expectedError, testCase.expectedLine, body)
+				}
+			}
+			if !reflect.DeepEqual(service.written, testCase.expectedWritten) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedWritten, service.written)
+			}
+		})
+	}
+}
+
+func TestDeviceAuthenticateInflux(t *testing.T) {
+	// Setup
+	devices := controllers.Device{
+		Service: &mockDeviceService{},
+	}
+	influx := controllers.Influx{
+		Service: &mockStatusService{},
+	}
+	server := httptest.NewServer(devices.AuthenticateInflux(http.HandlerFunc(influx.Write)))
+	defer server.Close()
+
+	tests := map[string]struct {
+		authorization      string            // input Authorization header
+		basic              []string          // input basic auth, if any
+		expectedStatusCode int               // expected status code
+		expectedError      map[string]string // expected error code and message, nil for none
+	}{
+		"Token":          {"Token 1:secret", nil, http.StatusNoContent, nil},
+		"Basic":          {"", []string{"1", "secret"}, http.StatusNoContent, nil},
+		"No credentials": {"", nil, http.StatusUnauthorized, map[string]string{"code": "unauthorized", "message": "unauthorized access"}},
+		"Wrong token":    {"Token 1:guess", nil, http.StatusUnauthorized, map[string]string{"code": "unauthorized", "message": "unauthorized access"}},
+		"No device ID":   {"Token secret", nil, http.StatusUnauthorized, map[string]string{"code": "unauthorized", "message": "unauthorized access"}},
+		"Bearer":         {"Bearer 1:secret", nil, http.StatusUnauthorized, map[string]string{"code": "unauthorized", "message": "unauthorized access"}},
+		"Database error": {"Token 5:secret", nil, http.StatusInternalServerError, map[string]string{"code": "internal error", "message": "internal error"}},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request := buildStatusRequest("POST", server.URL+"/api/v2/write?precision=s", []byte("temperature,device=1 value=20 1516472722"))
+			if testCase.authorization != "" {
+				request.Header.Set("Authorization", testCase.authorization)
+			}
+			if testCase.basic != nil {
+				request.SetBasicAuth(testCase.basic[0], testCase.basic[1])
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+			if testCase.expectedError != nil {
+				var body map[string]string
+				if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+					t.Fatalf("No error expected, got %+v", err)
+				}
+				if !reflect.DeepEqual(body, testCase.expectedError) {
+					t.Errorf("Expected %v, got %v", testCase.expectedError, body)
+				}
+			}
+		})
+	}
+}
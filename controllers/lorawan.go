@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// lorawanUplink is the body of an uplink webhook, from either
+// The Things Stack or ChirpStack v4 JSON integrations
+type lorawanUplink struct {
+	// The Things Stack
+	EndDeviceIDs *struct {
+		DevEUI string `json:"dev_eui"`
+	} `json:"end_device_ids"`
+	ReceivedAt    string `json:"received_at"`
+	UplinkMessage *struct {
+		FPort          int                    `json:"f_port"`
+		FRMPayload     []byte                 `json:"frm_payload"`
+		DecodedPayload map[string]interface{} `json:"decoded_payload"`
+		ReceivedAt     string                 `json:"received_at"`
+	} `json:"uplink_message"`
+
+	// ChirpStack
+	DeviceInfo *struct {
+		DevEUI string `json:"devEui"`
+	} `json:"deviceInfo"`
+	Time   string                 `json:"time"`
+	FPort  int                    `json:"fPort"`
+	Data   []byte                 `json:"data"`
+	Object map[string]interface{} `json:"object"`
+}
+
+// LoRaWAN is the controller for network server uplink webhooks
+type LoRaWAN struct {
+	Service services.LoRaWAN
+	Status  services.Status
+	// Token is the bearer token network servers must send, if set
+	Token string
+	// Clock returns the current time, for uplinks without a time, defaulting to time.Now
+	Clock func() time.Time
+}
+
+// Uplink writes the status data decoded from an uplink.
+// Other messages, such as joins, are acknowledged and ignored.
+func (c *LoRaWAN) Uplink(w http.ResponseWriter, r *http.Request) {
+	if c.Token != "" {
+		expected := []byte("Bearer " + c.Token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="broker"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+
+			return
+		}
+	}
+	// ChirpStack posts every event type to the same URL
+	if event := r.URL.Query().Get("event"); event != "" && event != "up" {
+		w.Write([]byte("OK.\n"))
+
+		return
+	}
+
+	var body lorawanUplink
+	if !readJSON(w, r, &body) {
+		return
+	}
+	var uplink models.Uplink
+	var receivedAt string
+	switch {
+	case body.EndDeviceIDs != nil && body.UplinkMessage != nil:
+		uplink = models.Uplink{
+			DevEUI:  body.EndDeviceIDs.DevEUI,
+			Port:    body.UplinkMessage.FPort,
+			Payload: body.UplinkMessage.FRMPayload,
+			Decoded: body.UplinkMessage.DecodedPayload,
+		}
+		receivedAt = body.UplinkMessage.ReceivedAt
+		if receivedAt == "" {
+			receivedAt = body.ReceivedAt
+		}
+	case body.EndDeviceIDs != nil:
+		w.Write([]byte("OK.\n"))
+
+		return
+	case body.DeviceInfo != nil:
+		uplink = models.Uplink{
+			DevEUI:  body.DeviceInfo.DevEUI,
+			Port:    body.FPort,
+			Payload: body.Data,
+			Decoded: body.Object,
+		}
+		receivedAt = body.Time
+	default:
+		http.Error(w, "Invalid body.", http.StatusBadRequest)
+
+		return
+	}
+	uplink.ReceivedAt = c.receivedAt(receivedAt)
+
+	data, err := c.Service.Decode(&uplink)
+	switch err.(type) {
+	case nil:
+	case services.LoRaWANInvalidDataError:
+		if err == services.LoRaWANInvalidID {
+			http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+			return
+		}
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+		return
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+
+		return
+	}
+
+	respondStatus(w, r, writeStatus(r.Context(), c.Status, data))
+}
+
+// receivedAt parses the time an uplink was received, defaulting to now
+func (c *LoRaWAN) receivedAt(value string) int64 {
+	if received, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return received.Unix()
+	}
+	if c.Clock == nil {
+		return time.Now().Unix()
+	}
+
+	return c.Clock().Unix()
+}
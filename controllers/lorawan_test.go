@@ -0,0 +1,110 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// LoRaWAN service mock: DevEUI 70B3D57ED005A1B2 is device 2, 0004A30B001C0530 fails
+type mockLoRaWANService struct {
+	uplinks []*models.Uplink
+}
+
+var _ services.LoRaWAN = (*mockLoRaWANService)(nil)
+
+func (s *mockLoRaWANService) Decode(uplink *models.Uplink) (*models.StatusData, error) {
+	s.uplinks = append(s.uplinks, uplink)
+	switch strings.ToUpper(uplink.DevEUI) {
+	case "70B3D57ED005A1B2":
+		if len(uplink.Payload) == 0 && len(uplink.Decoded) == 0 {
+			return nil, services.LoRaWANInvalidData
+		}
+
+		return &models.StatusData{DeviceID: 2, Timestamp: uplink.ReceivedAt}, nil
+	case "0004A30B001C0530":
+		return nil, services.LoRaWANDatabaseDriverError("mocked error")
+	default:
+		return nil, services.LoRaWANInvalidID
+	}
+}
+
+func TestLoRaWANUplink(t *testing.T) {
+	tests := map[string]struct {
+		query              string         // input query
+		authorization      string         // input Authorization header
+		body               string         // input body
+		expectedStatus     string         // expected status
+		expectedStatusCode int            // expected status code
+		expectedUplink     *models.Uplink // expected decoded uplink, nil for none
+	}{
+		"The Things Stack": {
+			"", "Bearer secret",
+			`{"end_device_ids":{"device_id":"bed-1","dev_eui":"70B3D57ED005A1B2"},"received_at":"2018-01-20T18:25:22.5Z",
+				"uplink_message":{"f_port":2,"frm_payload":"AWdQ","decoded_payload":{"temperature":8},"received_at":"2018-01-20T18:25:22.1Z"}}`,
+			"OK.\n", http.StatusOK,
+			&models.Uplink{DevEUI: "70B3D57ED005A1B2", Port: 2, Payload: []byte{0x01, 0x67, 0x50}, Decoded: map[string]interface{}{"temperature": float64(8)}, ReceivedAt: 1516472722},
+		},
+		"ChirpStack": {
+			"?event=up", "Bearer secret",
+			`{"deviceInfo":{"devEui":"70b3d57ed005a1b2"},"time":"2018-01-20T18:25:22Z","fPort":1,"data":"AWdQ"}`,
+			"OK.\n", http.StatusOK,
+			&models.Uplink{DevEUI: "70b3d57ed005a1b2", Port: 1, Payload: []byte{0x01, 0x67, 0x50}, ReceivedAt: 1516472722},
+		},
+		"No time": {
+			"", "Bearer secret",
+			`{"deviceInfo":{"devEui":"70B3D57ED005A1B2"},"object":{"ph":6.5}}`,
+			"OK.\n", http.StatusOK,
+			&models.Uplink{DevEUI: "70B3D57ED005A1B2", Decoded: map[string]interface{}{"ph": 6.5}, ReceivedAt: 1516472800},
+		},
+		"ChirpStack join": {"?event=join", "Bearer secret", `{"deviceInfo":{"devEui":"70B3D57ED005A1B2"}}`, "OK.\n", http.StatusOK, nil},
+		"TTS join":        {"", "Bearer secret", `{"end_device_ids":{"dev_eui":"70B3D57ED005A1B2"},"join_accept":{}}`, "OK.\n", http.StatusOK, nil},
+		"Unknown body":    {"", "Bearer secret", `{"devEUI":"70B3D57ED005A1B2"}`, "Invalid body.\n", http.StatusBadRequest, nil},
+		"Invalid JSON":    {"", "Bearer secret", `{`, "Invalid body.\n", http.StatusBadRequest, nil},
+		"Unauthorized":    {"", "Bearer guess", `{"deviceInfo":{"devEui":"70B3D57ED005A1B2"}}`, "Unauthorized.\n", http.StatusUnauthorized, nil},
+		"Unknown device":  {"", "Bearer secret", `{"deviceInfo":{"devEui":"0000000000000000"},"data":"AQ=="}`, "Invalid ID.\n", http.StatusNotFound, &models.Uplink{DevEUI: "0000000000000000", Payload: []byte{0x01}, ReceivedAt: 1516472800}},
+		"Undecodable":     {"", "Bearer secret", `{"deviceInfo":{"devEui":"70B3D57ED005A1B2"}}`, "Invalid data.\n", http.StatusBadRequest, &models.Uplink{DevEUI: "70B3D57ED005A1B2", ReceivedAt: 1516472800}},
+		"Database error":  {"", "Bearer secret", `{"deviceInfo":{"devEui":"0004A30B001C0530"}}`, "Internal server error.\n", http.StatusInternalServerError, &models.Uplink{DevEUI: "0004A30B001C0530", ReceivedAt: 1516472800}},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := &mockLoRaWANService{}
+			controller := controllers.LoRaWAN{
+				Service: service,
+				Status:  &mockStatusService{},
+				Token:   "secret",
+				Clock:   func() time.Time { return time.Unix(1516472800, 0) },
+			}
+			server := httptest.NewServer(http.HandlerFunc(controller.Uplink))
+			defer server.Close()
+
+			request := buildStatusRequest("POST", server.URL+testCase.query, []byte(testCase.body))
+			request.Header.Set("Authorization", testCase.authorization)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, _ := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if string(body) != testCase.expectedStatus || response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedStatus, response.StatusCode, body)
+			}
+
+			var expected []*models.Uplink
+			if testCase.expectedUplink != nil {
+				expected = []*models.Uplink{testCase.expectedUplink}
+			}
+			if !reflect.DeepEqual(service.uplinks, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, service.uplinks)
+			}
+		})
+	}
+}
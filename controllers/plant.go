@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// Plant is the controller for the plant registry
+type Plant struct {
+	Service services.Plant
+}
+
+// Create registers a plant
+func (c *Plant) Create(w http.ResponseWriter, r *http.Request) {
+	var plant models.Plant
+	if !readJSON(w, r, &plant) {
+		return
+	}
+
+	if err := c.Service.Create(&plant); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, &plant)
+}
+
+// List lists every plant
+func (c *Plant) List(w http.ResponseWriter, r *http.Request) {
+	plants, err := c.Service.ReadAll()
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, plants)
+}
+
+// Read reads a plant
+func (c *Plant) Read(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	plant, err := c.Service.Read(id)
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, plant)
+}
+
+// Update updates a plant
+func (c *Plant) Update(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+	var plant models.Plant
+	if !readJSON(w, r, &plant) {
+		return
+	}
+	plant.ID = id
+
+	if err := c.Service.Update(&plant); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, &plant)
+}
+
+// Delete deletes a plant
+func (c *Plant) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	if err := c.Service.Delete(id); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	w.Write([]byte("OK.\n"))
+}
+
+// writeError maps a service error to a response
+func (c *Plant) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case services.PlantInvalidID:
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+	case services.PlantDuplicateID:
+		http.Error(w, "Duplicate ID.", http.StatusConflict)
+	case services.PlantInvalidData:
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+}
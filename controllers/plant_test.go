@@ -0,0 +1,172 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/gorilla/mux"
+)
+
+// Service mock: IDs 1 to 4 exist, ID 5 fails
+type mockPlantService struct{}
+
+var _ services.Plant = (*mockPlantService)(nil)
+
+func (s *mockPlantService) Create(plant *models.Plant) error {
+	switch {
+	case plant.Name == "":
+		return services.PlantInvalidData
+	case plant.ID > 0 && plant.ID < 5:
+		return services.PlantDuplicateID
+	case plant.ID == 5:
+		return services.PlantDatabaseDriverError("mocked error")
+	case plant.ID == 0:
+		plant.ID = 6
+	}
+
+	return nil
+}
+
+func (s *mockPlantService) Read(id uint) (*models.Plant, error) {
+	switch {
+	case id > 0 && id < 5:
+		return &models.Plant{ID: id, Name: "Basil"}, nil
+	case id == 5:
+		return nil, services.PlantDatabaseDriverError("mocked error")
+	}
+
+	return nil, services.PlantInvalidID
+}
+
+func (s *mockPlantService) ReadAll() ([]*models.Plant, error) {
+	return []*models.Plant{&models.Plant{ID: 1, Name: "Basil"}}, nil
+}
+
+func (s *mockPlantService) Update(plant *models.Plant) error {
+	if plant.Name == "" {
+		return services.PlantInvalidData
+	}
+	_, err := s.Read(plant.ID)
+
+	return err
+}
+
+func (s *mockPlantService) Delete(id uint) error {
+	_, err := s.Read(id)
+
+	return err
+}
+
+func TestPlant(t *testing.T) {
+	// Setup
+	c := controllers.Plant{
+		Service: &mockPlantService{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/plants", c.Create).Methods("POST")
+	router.HandleFunc("/plants", c.List).Methods("GET")
+	router.HandleFunc("/plants/{id}", c.Read).Methods("GET")
+	router.HandleFunc("/plants/{id}", c.Update).Methods("PUT")
+	router.HandleFunc("/plants/{id}", c.Delete).Methods("DELETE")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := map[string]struct {
+		request            *http.Request // input
+		expectedBody       string        // expected body
+		expectedStatusCode int           // expected status code
+	}{
+		"Create": {
+			request:            buildStatusRequest("POST", server.URL+"/plants", []byte(`{"name":"Basil","owner":"lucia"}`)),
+			expectedBody:       `{"id":6,"name":"Basil","species":"","location":"","owner":"lucia"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		"Create invalid body": {
+			request:            buildStatusRequest("POST", server.URL+"/plants", []byte(`{"name":`)),
+			expectedBody:       "Invalid body.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Create too large": {
+			request:            buildStatusRequest("POST", server.URL+"/plants", []byte(`{"name":"`+strings.Repeat("a", controllers.DefaultMaxBodySize)+`"}`)),
+			expectedBody:       "Body too large.\n",
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		"Create invalid data": {
+			request:            buildStatusRequest("POST", server.URL+"/plants", []byte(`{}`)),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Create duplicate ID": {
+			request:            buildStatusRequest("POST", server.URL+"/plants", []byte(`{"id":1,"name":"Basil"}`)),
+			expectedBody:       "Duplicate ID.\n",
+			expectedStatusCode: http.StatusConflict,
+		},
+		"Create database error": {
+			request:            buildStatusRequest("POST", server.URL+"/plants", []byte(`{"id":5,"name":"Basil"}`)),
+			expectedBody:       "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		"List": {
+			request:            buildStatusRequest("GET", server.URL+"/plants", nil),
+			expectedBody:       `[{"id":1,"name":"Basil","species":"","location":"","owner":""}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Read": {
+			request:            buildStatusRequest("GET", server.URL+"/plants/2", nil),
+			expectedBody:       `{"id":2,"name":"Basil","species":"","location":"","owner":""}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Read invalid ID": {
+			request:            buildStatusRequest("GET", server.URL+"/plants/7", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Read malformed ID": {
+			request:            buildStatusRequest("GET", server.URL+"/plants/basil", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Update": {
+			request:            buildStatusRequest("PUT", server.URL+"/plants/3", []byte(`{"name":"Mint"}`)),
+			expectedBody:       `{"id":3,"name":"Mint","species":"","location":"","owner":""}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Update invalid ID": {
+			request:            buildStatusRequest("PUT", server.URL+"/plants/7", []byte(`{"name":"Mint"}`)),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Delete": {
+			request:            buildStatusRequest("DELETE", server.URL+"/plants/1", nil),
+			expectedBody:       "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Delete database error": {
+			request:            buildStatusRequest("DELETE", server.URL+"/plants/5", nil),
+			expectedBody:       "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(testCase.request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedBody ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, string(body))
+			}
+		})
+	}
+}
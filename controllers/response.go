@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/berry-house/http_broker/util"
+	"github.com/gorilla/mux"
+)
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// pathID extracts an unsigned ID from the route variable name
+func pathID(r *http.Request, name string) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)[name], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+// readJSON extracts a JSON request body of up to DefaultMaxBodySize bytes into v
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, DefaultMaxBodySize))
+	if tooLarge(err) {
+		http.Error(w, "Body too large.", http.StatusRequestEntityTooLarge)
+
+		return false
+	}
+	if err != nil {
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+
+		return false
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		http.Error(w, "Invalid body.", http.StatusBadRequest)
+
+		return false
+	}
+
+	return true
+}
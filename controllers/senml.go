@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// senmlRelativeTime is the limit below which SenML times are relative to now (RFC 8428, 4.5.3)
+const senmlRelativeTime = 1 << 28
+
+// senmlRecord is a SenML record, with its JSON labels and CBOR keys
+type senmlRecord struct {
+	BaseName  string   `json:"bn,omitempty" cbor:"-2,keyasint,omitempty"`
+	BaseTime  float64  `json:"bt,omitempty" cbor:"-3,keyasint,omitempty"`
+	BaseUnit  string   `json:"bu,omitempty" cbor:"-4,keyasint,omitempty"`
+	BaseValue float64  `json:"bv,omitempty" cbor:"-5,keyasint,omitempty"`
+	Name      string   `json:"n,omitempty" cbor:"0,keyasint,omitempty"`
+	Unit      string   `json:"u,omitempty" cbor:"1,keyasint,omitempty"`
+	Value     *float64 `json:"v,omitempty" cbor:"2,keyasint,omitempty"`
+	Time      float64  `json:"t,omitempty" cbor:"6,keyasint,omitempty"`
+}
+
+// senmlUnit is the registered unit of a SenML unit, and the factor converting values to it
+type senmlUnit struct {
+	unit   string
+	factor float64
+}
+
+// senmlUnits maps SenML units (RFC 8428, 12.1) to the units of the default metrics.
+// Other units are kept as sent.
+var senmlUnits = map[string]senmlUnit{
+	"Cel": {"°C", 1},
+	"%RH": {"%", 1},
+	"lx":  {"klx", 0.001},
+	"S/m": {"mS/cm", 10},
+	"ppm": {"ppm", 1},
+	"V":   {"V", 1},
+}
+
+// SenMLJSONCodec decodes SenML JSON packs.
+// Resolved names are "plant:<id>/<metric>" or "device:<id>/<metric>", usually sent as a
+// base name and metric names. Records of the same base and time form a single reading.
+type SenMLJSONCodec struct {
+	// Clock returns the current time, for relative times, defaulting to time.Now
+	Clock func() time.Time
+}
+
+func (c SenMLJSONCodec) Decode(body []byte) (*models.StatusData, error) {
+	var pack []senmlRecord
+	if err := json.Unmarshal(body, &pack); err != nil {
+		return nil, err
+	}
+
+	return singleReading(decodeSenML(pack, c.Clock))
+}
+
+func (c SenMLJSONCodec) DecodeBatch(body []byte) ([]*models.StatusData, error) {
+	var pack []senmlRecord
+	if err := json.Unmarshal(body, &pack); err != nil {
+		return nil, err
+	}
+
+	return decodeSenML(pack, c.Clock)
+}
+
+// SenMLCBORCodec decodes SenML CBOR packs, named like SenML JSON packs
+type SenMLCBORCodec struct {
+	// Clock returns the current time, for relative times, defaulting to time.Now
+	Clock func() time.Time
+}
+
+func (c SenMLCBORCodec) Decode(body []byte) (*models.StatusData, error) {
+	var pack []senmlRecord
+	if err := cbor.Unmarshal(body, &pack); err != nil {
+		return nil, err
+	}
+
+	return singleReading(decodeSenML(pack, c.Clock))
+}
+
+func (c SenMLCBORCodec) DecodeBatch(body []byte) ([]*models.StatusData, error) {
+	var pack []senmlRecord
+	if err := cbor.Unmarshal(body, &pack); err != nil {
+		return nil, err
+	}
+
+	return decodeSenML(pack, c.Clock)
+}
+
+// decodeSenML resolves the records of a pack into readings.
+// Records without a numeric value are ignored.
+func decodeSenML(pack []senmlRecord, clock func() time.Time) ([]*models.StatusData, error) {
+	if clock == nil {
+		clock = time.Now
+	}
+	now := clock()
+
+	var readings []*models.StatusData
+	keys := map[string]*models.StatusData{}
+	var base senmlRecord
+	for _, record := range pack {
+		// Base fields apply to the following records, until replaced
+		if record.BaseName != "" {
+			base.BaseName = record.BaseName
+		}
+		if record.BaseTime != 0 {
+			base.BaseTime = record.BaseTime
+		}
+		if record.BaseUnit != "" {
+			base.BaseUnit = record.BaseUnit
+		}
+		if record.BaseValue != 0 {
+			base.BaseValue = record.BaseValue
+		}
+		if record.Value == nil {
+			continue
+		}
+
+		name := base.BaseName + record.Name
+		separator := strings.LastIndex(name, "/")
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		data, err := senmlReading(name[:separator])
+		if err != nil {
+			return nil, err
+		}
+		metric := name[separator+1:]
+		if metric == "" {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+
+		timestamp := base.BaseTime + record.Time
+		if timestamp < senmlRelativeTime {
+			timestamp += float64(now.Unix())
+		}
+		data.Timestamp = int64(math.Floor(timestamp))
+
+		unit := record.Unit
+		if unit == "" {
+			unit = base.BaseUnit
+		}
+		value := base.BaseValue + *record.Value
+		if conversion, ok := senmlUnits[unit]; ok {
+			unit = conversion.unit
+			value *= conversion.factor
+		}
+
+		key := fmt.Sprintf("%d/%d/%d", data.ID, data.DeviceID, data.Timestamp)
+		reading, ok := keys[key]
+		if !ok {
+			reading = data
+			keys[key] = reading
+			readings = append(readings, reading)
+		}
+		reading.Metrics[metric] = models.Metric{Value: value, Unit: unit}
+	}
+	if len(readings) == 0 {
+		return nil, errors.New("no numeric records")
+	}
+
+	return readings, nil
+}
+
+// senmlReading creates a reading for the plant or device of a resolved name prefix
+func senmlReading(prefix string) (*models.StatusData, error) {
+	kind := strings.SplitN(prefix, ":", 2)
+	if len(kind) != 2 {
+		return nil, fmt.Errorf("invalid name prefix %q", prefix)
+	}
+	id, err := strconv.ParseUint(kind[1], 10, 32)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("invalid name prefix %q", prefix)
+	}
+
+	data := &models.StatusData{Metrics: map[string]models.Metric{}}
+	switch kind[0] {
+	case "plant":
+		data.ID = uint(id)
+	case "device":
+		data.DeviceID = uint(id)
+	default:
+		return nil, fmt.Errorf("invalid name prefix %q", prefix)
+	}
+
+	return data, nil
+}
+
+// singleReading checks a pack holds a single reading
+func singleReading(readings []*models.StatusData, err error) (*models.StatusData, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(readings) != 1 {
+		return nil, errors.New("pack holds several readings")
+	}
+
+	return readings[0], nil
+}
@@ -0,0 +1,104 @@
+package controllers_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestSenMLDecodeBatch(t *testing.T) {
+	clock := func() time.Time { return time.Unix(1516472800, 0) }
+
+	tests := map[string]struct {
+		body             string               // input SenML JSON pack
+		expectedReadings []*models.StatusData // expected readings, nil for an error
+	}{
+		"Happy path": {
+			`[{"bn":"plant:1/","bt":1516472722,"n":"temperature","u":"Cel","v":21.5},{"n":"humidity","u":"%RH","v":40},{"n":"light","u":"lx","v":1200}]`,
+			[]*models.StatusData{
+				{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{
+					"temperature": {Value: 21.5, Unit: "°C"},
+					"humidity":    {Value: 40, Unit: "%"},
+					"light":       {Value: 1.2, Unit: "klx"},
+				}},
+			},
+		},
+		"Several times": {
+			`[{"bn":"device:2/","bt":1516472722,"bu":"Cel","n":"temperature","v":21.5},{"n":"temperature","t":60,"v":22},{"n":"label","vs":"ok"}]`,
+			[]*models.StatusData{
+				{DeviceID: 2, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 21.5, Unit: "°C"}}},
+				{DeviceID: 2, Timestamp: 1516472782, Metrics: map[string]models.Metric{"temperature": {Value: 22, Unit: "°C"}}},
+			},
+		},
+		"Relative time": {
+			`[{"bn":"plant:3/","n":"ph","u":"pH","v":6.5,"t":-10},{"n":"co2","bv":400,"v":10,"t":-10}]`,
+			[]*models.StatusData{
+				{ID: 3, Timestamp: 1516472790, Metrics: map[string]models.Metric{
+					"ph":  {Value: 6.5, Unit: "pH"},
+					"co2": {Value: 410},
+				}},
+			},
+		},
+		"Full names": {
+			`[{"n":"plant:1/temperature","v":20,"t":1516472722},{"n":"plant:2/temperature","v":21,"t":1516472722}]`,
+			[]*models.StatusData{
+				{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 20}}},
+				{ID: 2, Timestamp: 1516472722, Metrics: map[string]models.Metric{"temperature": {Value: 21}}},
+			},
+		},
+		"Unknown prefix": {`[{"bn":"urn:dev:mac:0024befffe804ff1/","n":"temperature","v":20}]`, nil},
+		"Missing metric": {`[{"bn":"plant:1/","v":20}]`, nil},
+		"No values":      {`[{"bn":"plant:1/","n":"label","vs":"ok"}]`, nil},
+		"Not a pack":     {`{"bn":"plant:1/"}`, nil},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			readings, err := controllers.SenMLJSONCodec{Clock: clock}.DecodeBatch([]byte(testCase.body))
+			if testCase.expectedReadings == nil {
+				if err == nil {
+					t.Errorf("Error expected, got %+v", readings)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if !reflect.DeepEqual(readings, testCase.expectedReadings) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedReadings, readings)
+			}
+		})
+	}
+}
+
+func TestSenMLDecode(t *testing.T) {
+	// SenML CBOR labels are integers: bn -2, bt -3, n 0, u 1, v 2
+	body, err := cbor.Marshal([]map[int]interface{}{
+		{-2: "plant:1/", -3: 1516472722, 0: "temperature", 1: "Cel", 2: 21.5},
+		{0: "humidity", 1: "%RH", 2: 40},
+	})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	data, err := controllers.SenMLCBORCodec{}.Decode(body)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	expected := &models.StatusData{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{
+		"temperature": {Value: 21.5, Unit: "°C"},
+		"humidity":    {Value: 40, Unit: "%"},
+	}}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, data)
+	}
+
+	// Packs of several readings need a batch write
+	if _, err := (controllers.SenMLJSONCodec{}).Decode([]byte(`[{"bn":"plant:1/","bt":1516472722,"n":"temperature","v":20},{"n":"temperature","t":60,"v":21}]`)); err == nil {
+		t.Errorf("Error expected")
+	}
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket message types
+const (
+	socketPublish     = "publish"
+	socketSubscribe   = "subscribe"
+	socketUnsubscribe = "unsubscribe"
+	socketAck         = "ack"
+	socketReading     = "reading"
+	socketDropped     = "dropped"
+)
+
+// WebSocket connection limits
+const (
+	defaultSocketHeartbeat = 30 * time.Second
+	socketWriteTimeout     = 10 * time.Second
+	socketMaxMessage       = 64 * 1024
+)
+
+// socketRequest is a message sent by WebSocket clients
+type socketRequest struct {
+	Type   string             `json:"type"`
+	Ref    string             `json:"ref,omitempty"`
+	Data   *models.StatusData `json:"data,omitempty"`
+	Plants []uint             `json:"plants,omitempty"`
+	LastID uint64             `json:"lastId,omitempty"`
+}
+
+// socketResponse is a message sent to WebSocket clients.
+// Acks echo the request ref with the status code and message of the equivalent HTTP request.
+type socketResponse struct {
+	Type       string      `json:"type"`
+	Ref        string      `json:"ref,omitempty"`
+	Code       int         `json:"code,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	RetryAfter string      `json:"retryAfter,omitempty"`
+	ID         uint64      `json:"id,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Socket is the controller for the WebSocket channel, publishing and subscribing to readings
+type Socket struct {
+	Status services.Status
+	Events services.EventSource
+	// Heartbeat is the interval between pings, defaulting to 30 seconds.
+	// Connections not answering for two intervals are closed.
+	Heartbeat time.Duration
+
+	upgrader websocket.Upgrader
+}
+
+// socketConn is a WebSocket connection with at most one reading subscription
+type socketConn struct {
+	mu           sync.Mutex
+	conn         *websocket.Conn
+	subscription *services.HubSubscription
+}
+
+// Serve upgrades the request and serves the WebSocket channel until the client disconnects
+func (c *Socket) Serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded
+		return
+	}
+	client := &socketConn{conn: conn}
+	defer client.close()
+
+	heartbeat := c.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultSocketHeartbeat
+	}
+	conn.SetReadLimit(socketMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go client.ping(heartbeat, done)
+
+	for {
+		_, body, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+
+		var request socketRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			client.send(&socketResponse{Type: socketAck, Code: http.StatusBadRequest, Message: "Invalid body."})
+
+			continue
+		}
+		if err := client.send(c.handle(r, client, &request)); err != nil {
+			return
+		}
+	}
+}
+
+// handle serves a client message, returning its ack
+func (c *Socket) handle(r *http.Request, client *socketConn, request *socketRequest) *socketResponse {
+	ack := &socketResponse{Type: socketAck, Ref: request.Ref, Code: http.StatusOK, Message: "OK."}
+
+	switch request.Type {
+	case socketPublish:
+		if request.Data == nil {
+			ack.Code, ack.Message = http.StatusBadRequest, "Invalid body."
+
+			break
+		}
+		result := writeStatus(r.Context(), c.Status, request.Data)
+		if result.Err != nil {
+			util.LogError(r, result.Err)
+		}
+		ack.Code, ack.Message = result.Code, result.Message
+		if result.RetryAfter != 0 {
+			ack.RetryAfter = retryAfter(result.RetryAfter)
+		}
+	case socketSubscribe:
+		plants := map[uint]bool{}
+		for _, id := range request.Plants {
+			plants[id] = true
+		}
+		subscription := c.Events.Subscribe(request.LastID, func(event *models.Event) bool {
+			return event.Type == models.EventReadingAccepted && (len(plants) == 0 || plants[event.PlantID])
+		})
+		client.subscribe(subscription)
+	case socketUnsubscribe:
+		client.subscribe(nil)
+	default:
+		ack.Code, ack.Message = http.StatusBadRequest, "Invalid data."
+	}
+
+	return ack
+}
+
+// send writes a message to the client
+func (s *socketConn) send(response *socketResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+
+	return s.conn.WriteJSON(response)
+}
+
+// subscribe replaces the reading subscription, forwarding its readings to the client
+func (s *socketConn) subscribe(subscription *services.HubSubscription) {
+	s.mu.Lock()
+	previous := s.subscription
+	s.subscription = subscription
+	s.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	if subscription != nil {
+		go s.forward(subscription)
+	}
+}
+
+// forward sends the readings of a subscription until it is closed.
+// Subscriptions dropped for lagging behind are reported, so clients resume from their last reading.
+func (s *socketConn) forward(subscription *services.HubSubscription) {
+	for message := range subscription.C {
+		response := &socketResponse{Type: socketReading, ID: message.ID, Data: message.Event.Data}
+		if err := s.send(response); err != nil {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	dropped := s.subscription == subscription
+	if dropped {
+		s.subscription = nil
+	}
+	s.mu.Unlock()
+	if dropped {
+		s.send(&socketResponse{Type: socketDropped})
+	}
+}
+
+// ping pings the client every heartbeat until done is closed
+func (s *socketConn) ping(heartbeat time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close closes the subscription and the connection
+func (s *socketConn) close() {
+	s.subscribe(nil)
+	s.conn.Close()
+}
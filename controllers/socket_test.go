@@ -0,0 +1,189 @@
+package controllers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+	"github.com/gorilla/websocket"
+)
+
+// socketMessage is a message received from the WebSocket channel
+type socketMessage struct {
+	Type       string             `json:"type"`
+	Ref        string             `json:"ref"`
+	Code       int                `json:"code"`
+	Message    string             `json:"message"`
+	RetryAfter string             `json:"retryAfter"`
+	ID         uint64             `json:"id"`
+	Data       *models.StatusData `json:"data"`
+}
+
+// dialSocket connects to a Socket controller served by an httptest server
+func dialSocket(t *testing.T, handler http.Handler) (*websocket.Conn, func()) {
+	server := httptest.NewServer(handler)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+// readSocket reads the next message, failing after a second
+func readSocket(t *testing.T, conn *websocket.Conn) *socketMessage {
+	var message socketMessage
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	return &message
+}
+
+func TestSocketPublish(t *testing.T) {
+	// Setup
+	hub, _ := services.NewHub(16)
+	c := controllers.Socket{
+		Status: &mockStatusService{},
+		Events: hub,
+	}
+	conn, closeSocket := dialSocket(t, http.HandlerFunc(c.Serve))
+	defer closeSocket()
+
+	// Acks arrive in order, so steps run sequentially on one connection
+	steps := []struct {
+		name     string         // step name
+		request  string         // input
+		expected *socketMessage // expected ack
+	}{
+		{
+			name:     "Happy path",
+			request:  `{"type":"publish","ref":"a","data":{"id":1,"timestamp":1516478286,"temperature":23}}`,
+			expected: &socketMessage{Type: "ack", Ref: "a", Code: http.StatusOK, Message: "OK."},
+		},
+		{
+			name:     "Invalid ID",
+			request:  `{"type":"publish","ref":"b","data":{"id":6,"timestamp":1516478286}}`,
+			expected: &socketMessage{Type: "ack", Ref: "b", Code: http.StatusNotFound, Message: "Invalid ID."},
+		},
+		{
+			name:     "Invalid data",
+			request:  `{"type":"publish","ref":"c","data":{"id":1,"timestamp":1516478286,"temperature":-100}}`,
+			expected: &socketMessage{Type: "ack", Ref: "c", Code: http.StatusBadRequest, Message: "Invalid data."},
+		},
+		{
+			name:     "Database error",
+			request:  `{"type":"publish","ref":"d","data":{"id":5,"timestamp":1516478286}}`,
+			expected: &socketMessage{Type: "ack", Ref: "d", Code: http.StatusInternalServerError, Message: "Internal server error."},
+		},
+		{
+			name:     "Unavailable",
+			request:  `{"type":"publish","ref":"e","data":{"id":9,"timestamp":1516478286}}`,
+			expected: &socketMessage{Type: "ack", Ref: "e", Code: http.StatusServiceUnavailable, Message: "Service unavailable.", RetryAfter: "2"},
+		},
+		{
+			name:     "Missing data",
+			request:  `{"type":"publish","ref":"f"}`,
+			expected: &socketMessage{Type: "ack", Ref: "f", Code: http.StatusBadRequest, Message: "Invalid body."},
+		},
+		{
+			name:     "Invalid body",
+			request:  `{"type":`,
+			expected: &socketMessage{Type: "ack", Code: http.StatusBadRequest, Message: "Invalid body."},
+		},
+		{
+			name:     "Unknown type",
+			request:  `{"type":"water","ref":"g"}`,
+			expected: &socketMessage{Type: "ack", Ref: "g", Code: http.StatusBadRequest, Message: "Invalid data."},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(step.request)); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if ack := readSocket(t, conn); !reflect.DeepEqual(ack, step.expected) {
+				t.Errorf("Expected %+v, got %+v", step.expected, ack)
+			}
+		})
+	}
+}
+
+func TestSocketPublishDevice(t *testing.T) {
+	// Setup
+	c := controllers.Socket{
+		Status: &mockStatusService{},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Serve(w, util.WithDevice(r, 2))
+	})
+	conn, closeSocket := dialSocket(t, handler)
+	defer closeSocket()
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"publish","data":{"timestamp":1516478286}}`))
+	if ack := readSocket(t, conn); ack.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %+v", http.StatusOK, ack)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"publish","data":{"deviceId":3,"timestamp":1516478286}}`))
+	if ack := readSocket(t, conn); ack.Code != http.StatusForbidden || ack.Message != "Forbidden." {
+		t.Errorf("Expected %d, got %+v", http.StatusForbidden, ack)
+	}
+}
+
+func TestSocketSubscribe(t *testing.T) {
+	// Setup
+	hub, _ := services.NewHub(16)
+	c := controllers.Socket{
+		Status: &mockStatusService{},
+		Events: hub,
+	}
+	conn, closeSocket := dialSocket(t, http.HandlerFunc(c.Serve))
+	defer closeSocket()
+
+	publish := func(eventType string, plantID uint) {
+		hub.Publish(&models.Event{Type: eventType, PlantID: plantID, Data: &models.StatusData{ID: plantID, Timestamp: 100}})
+	}
+	publish(models.EventReadingAccepted, 2)
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","ref":"s","plants":[2,3],"lastId":0}`))
+	if ack := readSocket(t, conn); ack.Code != http.StatusOK || ack.Ref != "s" {
+		t.Fatalf("Expected a subscription ack, got %+v", ack)
+	}
+
+	publish(models.EventReadingAccepted, 1)
+	publish(models.EventAlertFiring, 2)
+	publish(models.EventReadingAccepted, 3)
+	expected := &socketMessage{Type: "reading", ID: 4, Data: &models.StatusData{ID: 3, Timestamp: 100}}
+	if reading := readSocket(t, conn); !reflect.DeepEqual(reading, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, reading)
+	}
+
+	// Resubscribing resumes after the given reading
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","lastId":3}`))
+	readSocket(t, conn)
+	if reading := readSocket(t, conn); !reflect.DeepEqual(reading, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, reading)
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"unsubscribe","ref":"u"}`))
+	if ack := readSocket(t, conn); ack.Code != http.StatusOK || ack.Ref != "u" {
+		t.Fatalf("Expected an unsubscription ack, got %+v", ack)
+	}
+	publish(models.EventReadingAccepted, 3)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"publish","ref":"p","data":{"id":1,"timestamp":1516478286}}`))
+	if ack := readSocket(t, conn); ack.Type != "ack" || ack.Ref != "p" {
+		t.Errorf("Expected no more readings, got %+v", ack)
+	}
+}
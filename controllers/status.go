@@ -5,46 +5,404 @@
 package controllers
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/berry-house/http_broker/models"
 	"github.com/berry-house/http_broker/services"
 	"github.com/berry-house/http_broker/util"
 )
 
+// Size limits of decoded request bodies
+const (
+	// DefaultMaxBodySize is the default size limit of bodies read whole
+	DefaultMaxBodySize = 4 << 20
+	// DefaultMaxStreamSize is the default size limit of streamed bodies, read one record at a time
+	DefaultMaxStreamSize = 256 << 20
+)
+
+// errBodyTooLarge is the error of reads past the size limit of a body
+var errBodyTooLarge = errors.New("body too large")
+
 // Status is the controller for status data
 type Status struct {
 	Service services.Status
+	// Codecs decodes bodies by Content-Type, defaulting to DefaultCodecs
+	Codecs Codecs
+	// MaxBodySize is the size limit of decoded bodies, defaulting to DefaultMaxBodySize
+	MaxBodySize int64
+	// MaxStreamSize is the size limit of decoded NDJSON streams, defaulting to DefaultMaxStreamSize
+	MaxStreamSize int64
 }
 
 func (c *Status) Write(w http.ResponseWriter, r *http.Request) {
 	// Body extraction
-	body, err := ioutil.ReadAll(r.Body)
+	body, codec, ok := c.readBody(w, r)
+	if !ok {
+		return
+	}
+	temp, err := codec.Decode(body)
 	if err != nil {
-		util.LogError(r, err)
-		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+		http.Error(w, "Invalid body.", http.StatusBadRequest)
+
+		return
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		temp.IdempotencyKey = key
+	}
+
+	respondStatus(w, r, writeStatus(r.Context(), c.Service, temp))
+}
+
+// BatchRejection is a reading rejected from a batch
+type BatchRejection struct {
+	// Index is the position of the reading in the batch, starting at 0
+	Index int `json:"index"`
+	// Code is the HTTP status code the reading would get from Write
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
 
+// BatchResult is the response of a batch write
+type BatchResult struct {
+	Accepted int              `json:"accepted"`
+	Rejected []BatchRejection `json:"rejected"`
+}
+
+// WriteBatch writes a list of readings, replying with the rejected ones.
+// Readings are written independently, so a rejection does not stop the batch.
+func (c *Status) WriteBatch(w http.ResponseWriter, r *http.Request) {
+	// Body extraction
+	body, codec, ok := c.readBody(w, r)
+	if !ok {
 		return
 	}
-	var temp models.StatusData
-	if err = json.Unmarshal(body, &temp); err != nil {
+	batch, err := codec.DecodeBatch(body)
+	if err != nil || len(batch) == 0 {
 		http.Error(w, "Invalid body.", http.StatusBadRequest)
 
 		return
 	}
 
+	response := BatchResult{Rejected: []BatchRejection{}}
+	var delay time.Duration
+	for index, data := range batch {
+		result := statusResult{Code: http.StatusBadRequest, Message: "Invalid data."}
+		if data != nil {
+			result = writeStatus(r.Context(), c.Service, data)
+		}
+		if result.Err != nil {
+			util.LogError(r, result.Err)
+		}
+		if result.RetryAfter > delay {
+			delay = result.RetryAfter
+		}
+		if result.Code == http.StatusOK {
+			response.Accepted++
+
+			continue
+		}
+		response.Rejected = append(response.Rejected, BatchRejection{
+			Index:   index,
+			Code:    result.Code,
+			Message: result.Message,
+		})
+	}
+
+	if delay != 0 {
+		w.Header().Set("Retry-After", retryAfter(delay))
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// maxStreamRecord is the size limit of a record of a streamed write
+const maxStreamRecord = 64 * 1024
+
+// WriteStream writes newline-delimited JSON readings as they are read, replying with a
+// summary once the stream ends. Records are decoded and written one at a time, so memory
+// does not grow with the stream, and a rejection does not stop it.
+func (c *Status) WriteStream(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-ndjson" {
+		http.Error(w, "Unsupported media type.", http.StatusUnsupportedMediaType)
+
+		return
+	}
+	body, ok := openBody(w, r, sizeLimit(c.MaxStreamSize, DefaultMaxStreamSize))
+	if !ok {
+		return
+	}
+	defer body.Close()
+
+	response := ImportResult{Rejected: []ImportRejection{}}
+	var delay time.Duration
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 4096), maxStreamRecord)
+	line := 0
+	for scanner.Scan() {
+		line++
+		record := bytes.TrimSpace(scanner.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+
+		result := statusResult{Code: http.StatusBadRequest, Message: "Invalid body."}
+		var data *models.StatusData
+		if err := json.Unmarshal(record, &data); err == nil {
+			result = statusResult{Code: http.StatusBadRequest, Message: "Invalid data."}
+			if data != nil {
+				result = writeStatus(r.Context(), c.Service, data)
+			}
+		}
+		if result.Err != nil {
+			util.LogError(r, result.Err)
+		}
+		if result.RetryAfter > delay {
+			delay = result.RetryAfter
+		}
+		response.add(line, result)
+	}
+	if err := scanner.Err(); err != nil {
+		// The rest of the stream cannot be split into records
+		result := statusResult{Code: http.StatusBadRequest, Message: "Invalid body."}
+		switch {
+		case err == bufio.ErrTooLong:
+			result = statusResult{Code: http.StatusRequestEntityTooLarge, Message: "Record too large."}
+		case tooLarge(err):
+			result = statusResult{Code: http.StatusRequestEntityTooLarge, Message: "Body too large."}
+		}
+		response.add(line+1, result)
+	}
+
+	if delay != 0 {
+		w.Header().Set("Retry-After", retryAfter(delay))
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// readBody reads a request body, undoing its Content-Encoding, and finds the codec
+// of its Content-Type. Bodies without a Content-Type are taken as JSON.
+func (c *Status) readBody(w http.ResponseWriter, r *http.Request) ([]byte, Codec, bool) {
+	codecs := c.Codecs
+	if codecs == nil {
+		codecs = DefaultCodecs
+	}
+	mediaType := "application/json"
+	if value := r.Header.Get("Content-Type"); value != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(value); err != nil {
+			http.Error(w, "Unsupported media type.", http.StatusUnsupportedMediaType)
+
+			return nil, nil, false
+		}
+	}
+	codec, ok := codecs[mediaType]
+	if !ok {
+		http.Error(w, "Unsupported media type.", http.StatusUnsupportedMediaType)
+
+		return nil, nil, false
+	}
+
+	body, code, err := decodedBody(w, r, sizeLimit(c.MaxBodySize, DefaultMaxBodySize))
+	switch code {
+	case http.StatusOK:
+		return body, codec, true
+	case http.StatusBadRequest:
+		http.Error(w, "Invalid body.", code)
+	case http.StatusRequestEntityTooLarge:
+		http.Error(w, "Body too large.", code)
+	case http.StatusUnsupportedMediaType:
+		http.Error(w, "Unsupported media type.", code)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+
+	return nil, nil, false
+}
+
+// openBody opens a request body for streaming, undoing its Content-Encoding.
+// Reads past limit bytes of decoded body fail with errBodyTooLarge.
+// On failure it responds to the request.
+func openBody(w http.ResponseWriter, r *http.Request, limit int64) (io.ReadCloser, bool) {
+	body, code := bodyReader(w, r, limit)
+	switch code {
+	case http.StatusOK:
+		return body, true
+	case http.StatusBadRequest:
+		http.Error(w, "Invalid body.", code)
+	default:
+		http.Error(w, "Unsupported media type.", code)
+	}
+
+	return nil, false
+}
+
+// decodedBody reads a request body of up to limit bytes, undoing its gzip or deflate Content-Encoding.
+// On failure it returns the HTTP status code to respond, with the error for internal errors.
+func decodedBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, int, error) {
+	reader, code := bodyReader(w, r, limit)
+	if code != http.StatusOK {
+		return nil, code, nil
+	}
+	defer reader.Close()
+
+	body, err := ioutil.ReadAll(reader)
+	switch {
+	case err == nil:
+		return body, http.StatusOK, nil
+	case tooLarge(err):
+		return nil, http.StatusRequestEntityTooLarge, nil
+	case r.Header.Get("Content-Encoding") != "" && !strings.EqualFold(r.Header.Get("Content-Encoding"), "identity"):
+		// Corrupt compressed streams fail while reading
+		return nil, http.StatusBadRequest, nil
+	default:
+		return nil, http.StatusInternalServerError, err
+	}
+}
+
+// bodyReader returns a reader of a request body undoing its gzip or deflate Content-Encoding,
+// for streaming bodies. Both the body and its decoded content are limited to limit bytes, so
+// small compressed bodies cannot expand without bound. On failure it returns the HTTP status
+// code to respond.
+func bodyReader(w http.ResponseWriter, r *http.Request, limit int64) (io.ReadCloser, int) {
+	body := http.MaxBytesReader(w, r.Body, limit)
+	var reader io.ReadCloser
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return body, http.StatusOK
+	case "gzip":
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		reader = gzipReader
+	case "deflate":
+		zlibReader, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		reader = zlibReader
+	default:
+		return nil, http.StatusUnsupportedMediaType
+	}
+
+	return &limitedBody{ReadCloser: reader, remaining: limit}, http.StatusOK
+}
+
+// limitedBody is a decoded body failing with errBodyTooLarge past its size limit
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	// Reading a byte past the limit tells bodies of the limit size from larger ones
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n - 1, errBodyTooLarge
+	}
+
+	return n, err
+}
+
+// tooLarge reports whether err comes from a body past its size limit
+func tooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+
+	return errors.Is(err, errBodyTooLarge) || errors.As(err, &maxBytesErr)
+}
+
+// sizeLimit returns limit, or defaultLimit if it is not set
+func sizeLimit(limit, defaultLimit int64) int64 {
+	if limit <= 0 {
+		return defaultLimit
+	}
+
+	return limit
+}
+
+// statusResult is the outcome of a status write, shared by every transport.
+// Codes are HTTP status codes; Err is set for unexpected errors, to be logged.
+type statusResult struct {
+	Code       int
+	Message    string
+	RetryAfter time.Duration
+	Err        error
+}
+
+// writeStatus writes status data on behalf of a caller, mapping the outcome to a result
+func writeStatus(ctx context.Context, service services.Status, data *models.StatusData) statusResult {
+	// Authenticated devices may only write their own readings
+	if deviceID, ok := util.ContextDevice(ctx); ok {
+		if data.DeviceID != 0 && data.DeviceID != deviceID {
+			return statusResult{Code: http.StatusForbidden, Message: "Forbidden."}
+		}
+		data.DeviceID = deviceID
+	}
+
 	// Using service
-	switch err = c.Service.Write(&temp); err {
+	err := service.Write(data)
+	if e, ok := err.(services.StatusUnavailableError); ok {
+		return statusResult{Code: http.StatusServiceUnavailable, Message: "Service unavailable.", RetryAfter: e.RetryAfter, Err: err}
+	}
+	switch err {
 	case nil:
-		w.Write([]byte("OK.\n"))
+		return statusResult{Code: http.StatusOK, Message: "OK."}
 	case services.StatusInvalidID:
-		http.Error(w, "Invalid ID.", http.StatusNotFound)
+		return statusResult{Code: http.StatusNotFound, Message: "Invalid ID."}
 	case services.StatusInvalidData:
-		http.Error(w, "Invalid data.", http.StatusBadRequest)
+		return statusResult{Code: http.StatusBadRequest, Message: "Invalid data."}
+	case services.StatusInvalidTimestamp:
+		return statusResult{Code: http.StatusBadRequest, Message: "Invalid timestamp."}
+	case services.StatusDuplicate:
+		return statusResult{Code: http.StatusConflict, Message: "Duplicate reading."}
 	default:
-		util.LogError(r, err)
-		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+		return statusResult{Code: http.StatusInternalServerError, Message: "Internal server error.", Err: err}
+	}
+}
+
+// respondStatus writes the result of a status write as a plain text response
+func respondStatus(w http.ResponseWriter, r *http.Request, result statusResult) {
+	if result.Err != nil {
+		util.LogError(r, result.Err)
+	}
+	if result.RetryAfter != 0 {
+		w.Header().Set("Retry-After", retryAfter(result.RetryAfter))
+	}
+	if result.Code != http.StatusOK {
+		http.Error(w, result.Message, result.Code)
+
+		return
+	}
+	w.Write([]byte(result.Message + "\n"))
+}
+
+// retryAfter formats a duration as a Retry-After header value in whole seconds
+func retryAfter(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
 	}
+
+	return strconv.Itoa(seconds)
 }
@@ -2,14 +2,25 @@ package controllers_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/drivers/database"
 	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/pb"
 	"github.com/berry-house/http_broker/services"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
 )
 
 // Handler mock
@@ -33,10 +44,13 @@ func (s *mockStatusService) Write(data *models.StatusData) error {
 	}
 
 	// Threshold values
-	if data.Temperature < -30 || data.Temperature > 50 ||
-		data.Light > 150 ||
-		data.Humidity > 100 {
-		return services.StatusInvalidData
+	if err := services.DefaultMetrics.Validate(data); err != nil {
+		return err
+	}
+
+	// Mocked device resolution
+	if data.DeviceID > 0 && data.DeviceID < 5 {
+		data.ID = 1
 	}
 
 	// Mocked valid IDs
@@ -47,6 +61,10 @@ func (s *mockStatusService) Write(data *models.StatusData) error {
 	if data.ID == 0 || data.ID == 5 {
 		return services.StatusDatabaseDriverError("mocked error")
 	}
+	// Mocked open breaker
+	if data.ID == 9 {
+		return services.StatusUnavailableError{Message: "mocked breaker", RetryAfter: 1500 * time.Millisecond}
+	}
 
 	return services.StatusInvalidID
 }
@@ -111,6 +129,36 @@ func TestWriteStatus(t *testing.T) {
 			expectedStatus:     "Invalid data.\n",
 			expectedStatusCode: http.StatusBadRequest,
 		},
+		"Fractional temperature": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"temperature":21.4}`)),
+			expectedStatus:     "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Null light": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"temperature":21.4,"light":null}`)),
+			expectedStatus:     "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Metrics object": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"metrics":{"ph":{"value":6.5,"unit":"pH"},"co2":410}}`)),
+			expectedStatus:     "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Unknown metric": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"metrics":{"radiation":1}}`)),
+			expectedStatus:     "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Metric without value": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"metrics":{"ph":{"unit":"pH"}}}`)),
+			expectedStatus:     "Invalid body.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Negative humidity": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"humidity":-3}`)),
+			expectedStatus:     "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
 		"Light too high": {
 			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":1,"timestamp":1516472722,"light":165}`)),
 			expectedStatus:     "Invalid data.\n",
@@ -121,6 +169,11 @@ func TestWriteStatus(t *testing.T) {
 			expectedStatus:     "Internal server error.\n",
 			expectedStatusCode: http.StatusInternalServerError,
 		},
+		"Database unavailable": {
+			request:            buildStatusRequest("POST", server.URL, []byte(`{"id":9,"timestamp":1516472722,"status":20}`)),
+			expectedStatus:     "Service unavailable.\n",
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
 	}
 	for testName, testCase := range tests {
 		t.Run(testName, func(t *testing.T) {
@@ -141,3 +194,375 @@ func TestWriteStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteStatusRetryAfter(t *testing.T) {
+	// Setup
+	handler := &mockHandlerStatus{
+		c: controllers.Status{
+			Service: &mockStatusService{},
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	response, err := http.DefaultClient.Do(buildStatusRequest("POST", server.URL, []byte(`{"id":9,"timestamp":1516472722}`)))
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if retryAfter := response.Header.Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Expected %q, got %q", "2", retryAfter)
+	}
+}
+
+func TestWriteStatusFormats(t *testing.T) {
+	// Setup
+	handler := &mockHandlerStatus{
+		c: controllers.Status{
+			Service: &mockStatusService{},
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	reading := map[string]interface{}{"id": 1, "timestamp": 1516472722, "metrics": map[string]interface{}{"ph": 6.5}}
+	cborBody, err := cbor.Marshal(reading)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	msgpackBody, err := msgpack.Marshal(reading)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	protobufBody, err := proto.Marshal(&pb.Reading{Id: 1, Timestamp: 1516472722, Metrics: map[string]*pb.Metric{"ph": {Value: 6.5}}})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	jsonBody := []byte(`{"id":1,"timestamp":1516472722,"temperature":21.4}`)
+	var gzipBody, deflateBody bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipBody)
+	gzipWriter.Write(cborBody)
+	gzipWriter.Close()
+	zlibWriter := zlib.NewWriter(&deflateBody)
+	zlibWriter.Write(jsonBody)
+	zlibWriter.Close()
+	// A few KB of gzip expanding past the size limit
+	var bombBody bytes.Buffer
+	bombWriter := gzip.NewWriter(&bombBody)
+	bombWriter.Write(bytes.Repeat([]byte(" "), controllers.DefaultMaxBodySize+1))
+	bombWriter.Close()
+	largeBody := append(bytes.Repeat([]byte(" "), controllers.DefaultMaxBodySize), jsonBody...)
+
+	tests := map[string]struct {
+		contentType        string // input Content-Type
+		contentEncoding    string // input Content-Encoding
+		body               []byte // input body
+		expectedStatusCode int    // expected status code
+	}{
+		"JSON":                 {"application/json; charset=utf-8", "", jsonBody, http.StatusOK},
+		"CBOR":                 {"application/cbor", "", cborBody, http.StatusOK},
+		"MessagePack":          {"application/msgpack", "", msgpackBody, http.StatusOK},
+		"MessagePack x-":       {"application/x-msgpack", "", msgpackBody, http.StatusOK},
+		"Protobuf":             {"application/x-protobuf", "", protobufBody, http.StatusOK},
+		"Gzip CBOR":            {"application/cbor", "gzip", gzipBody.Bytes(), http.StatusOK},
+		"Deflate JSON":         {"application/json", "deflate", deflateBody.Bytes(), http.StatusOK},
+		"Invalid CBOR":         {"application/cbor", "", []byte{0xff}, http.StatusBadRequest},
+		"JSON as CBOR":         {"application/cbor", "", jsonBody, http.StatusBadRequest},
+		"Corrupt gzip":         {"application/json", "gzip", jsonBody, http.StatusBadRequest},
+		"Gzip too large":       {"application/json", "gzip", bombBody.Bytes(), http.StatusRequestEntityTooLarge},
+		"Too large":            {"application/json", "", largeBody, http.StatusRequestEntityTooLarge},
+		"Unsupported type":     {"text/csv", "", []byte("1,1516472722"), http.StatusUnsupportedMediaType},
+		"Unsupported encoding": {"application/json", "br", jsonBody, http.StatusUnsupportedMediaType},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request := buildStatusRequest("POST", server.URL, testCase.body)
+			request.Header.Set("Content-Type", testCase.contentType)
+			if testCase.contentEncoding != "" {
+				request.Header.Set("Content-Encoding", testCase.contentEncoding)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			response.Body.Close()
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestWriteStatusBatch(t *testing.T) {
+	// Setup
+	controller := controllers.Status{
+		Service: &mockStatusService{},
+	}
+	server := httptest.NewServer(http.HandlerFunc(controller.WriteBatch))
+	defer server.Close()
+
+	protobufBody, err := proto.Marshal(&pb.ReadingBatch{Readings: []*pb.Reading{
+		{Id: 1, Timestamp: 1516472722},
+		{Id: 7, Timestamp: 1516472722},
+	}})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	tests := map[string]struct {
+		contentType        string                  // input Content-Type
+		body               []byte                  // input body
+		expectedStatusCode int                     // expected status code
+		expectedResult     controllers.BatchResult // expected result
+		expectedRetryAfter string                  // expected Retry-After header
+	}{
+		"Happy path": {
+			"application/json",
+			[]byte(`[{"id":1,"timestamp":1516472722},{"deviceId":2,"timestamp":1516472723,"temperature":21.4}]`),
+			http.StatusOK,
+			controllers.BatchResult{Accepted: 2, Rejected: []controllers.BatchRejection{}},
+			"",
+		},
+		"Partial": {
+			"application/json",
+			[]byte(`[{"id":1,"timestamp":1516472722},{"id":7,"timestamp":1516472722},null,{"id":1,"timestamp":1516472722,"temperature":163},{"id":9,"timestamp":1516472722}]`),
+			http.StatusOK,
+			controllers.BatchResult{Accepted: 1, Rejected: []controllers.BatchRejection{
+				{Index: 1, Code: http.StatusNotFound, Message: "Invalid ID."},
+				{Index: 2, Code: http.StatusBadRequest, Message: "Invalid data."},
+				{Index: 3, Code: http.StatusBadRequest, Message: "Invalid data."},
+				{Index: 4, Code: http.StatusServiceUnavailable, Message: "Service unavailable."},
+			}},
+			"2",
+		},
+		"Protobuf": {
+			"application/protobuf",
+			protobufBody,
+			http.StatusOK,
+			controllers.BatchResult{Accepted: 1, Rejected: []controllers.BatchRejection{
+				{Index: 1, Code: http.StatusNotFound, Message: "Invalid ID."},
+			}},
+			"",
+		},
+		"Single reading":     {"application/json", []byte(`{"id":1,"timestamp":1516472722}`), http.StatusBadRequest, controllers.BatchResult{}, ""},
+		"Empty batch":        {"application/json", []byte(`[]`), http.StatusBadRequest, controllers.BatchResult{}, ""},
+		"Unsupported format": {"application/xml", []byte(`<status/>`), http.StatusUnsupportedMediaType, controllers.BatchResult{}, ""},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request := buildStatusRequest("POST", server.URL, testCase.body)
+			request.Header.Set("Content-Type", testCase.contentType)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Fatalf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+			if response.StatusCode != http.StatusOK {
+				return
+			}
+			var result controllers.BatchResult
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if !reflect.DeepEqual(result, testCase.expectedResult) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedResult, result)
+			}
+			if retryAfter := response.Header.Get("Retry-After"); retryAfter != testCase.expectedRetryAfter {
+				t.Errorf("Expected retry after %q, got %q", testCase.expectedRetryAfter, retryAfter)
+			}
+		})
+	}
+}
+
+func TestWriteStatusStream(t *testing.T) {
+	// Setup
+	service := &mockRecordingStatusService{}
+	controller := controllers.Status{
+		Service: service,
+	}
+	server := httptest.NewServer(http.HandlerFunc(controller.WriteStream))
+	defer server.Close()
+
+	tests := map[string]struct {
+		contentType        string                   // input Content-Type
+		body               string                   // input body
+		expectedStatusCode int                      // expected status code
+		expectedResult     controllers.ImportResult // expected result
+		expectedRetryAfter string                   // expected Retry-After header
+	}{
+		"Happy path": {
+			"application/x-ndjson",
+			"{\"id\":1,\"timestamp\":1516472722}\n\n{\"deviceId\":2,\"timestamp\":1516472723,\"temperature\":21.4}",
+			http.StatusOK,
+			controllers.ImportResult{Accepted: 2, Rejected: []controllers.ImportRejection{}},
+			"",
+		},
+		"Partial": {
+			"application/x-ndjson; charset=utf-8",
+			"{\"id\":1,\"timestamp\":1516472722}\n{\"id\":7,\"timestamp\":1516472722}\r\nnull\n{\"id\":\n{\"id\":9,\"timestamp\":1516472722}\n",
+			http.StatusOK,
+			controllers.ImportResult{Accepted: 1, RejectedCount: 4, Rejected: []controllers.ImportRejection{
+				{Line: 2, Code: http.StatusNotFound, Message: "Invalid ID."},
+				{Line: 3, Code: http.StatusBadRequest, Message: "Invalid data."},
+				{Line: 4, Code: http.StatusBadRequest, Message: "Invalid body."},
+				{Line: 5, Code: http.StatusServiceUnavailable, Message: "Service unavailable."},
+			}},
+			"2",
+		},
+		"Record too large": {
+			"application/x-ndjson",
+			"{\"id\":1,\"timestamp\":1516472722}\n{\"id\":1,\"timestamp\":1516472722,\"pad\":\"" + strings.Repeat("x", 64*1024) + "\"}\n{\"id\":1,\"timestamp\":1516472722}\n",
+			http.StatusOK,
+			controllers.ImportResult{Accepted: 1, RejectedCount: 1, Rejected: []controllers.ImportRejection{
+				{Line: 2, Code: http.StatusRequestEntityTooLarge, Message: "Record too large."},
+			}},
+			"",
+		},
+		"Unsupported format": {"application/json", `{"id":1,"timestamp":1516472722}`, http.StatusUnsupportedMediaType, controllers.ImportResult{}, ""},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request := buildStatusRequest("POST", server.URL, []byte(testCase.body))
+			request.Header.Set("Content-Type", testCase.contentType)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Fatalf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+			if response.StatusCode != http.StatusOK {
+				return
+			}
+			var result controllers.ImportResult
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if !reflect.DeepEqual(result, testCase.expectedResult) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedResult, result)
+			}
+			if retryAfter := response.Header.Get("Retry-After"); retryAfter != testCase.expectedRetryAfter {
+				t.Errorf("Expected retry after %q, got %q", testCase.expectedRetryAfter, retryAfter)
+			}
+		})
+	}
+}
+
+func TestWriteStatusStreamLimit(t *testing.T) {
+	// Setup
+	record := "{\"id\":1,\"timestamp\":1516472722}\n"
+	controller := controllers.Status{
+		Service:       &mockRecordingStatusService{},
+		MaxStreamSize: int64(3 * len(record)),
+	}
+	server := httptest.NewServer(http.HandlerFunc(controller.WriteStream))
+	defer server.Close()
+
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	writer.Write([]byte(strings.Repeat(record, 10)))
+	writer.Close()
+	request := buildStatusRequest("POST", server.URL, body.Bytes())
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	request.Header.Set("Content-Encoding", "gzip")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer response.Body.Close()
+
+	// Records past the limit are not read
+	var result controllers.ImportResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	expected := controllers.ImportResult{Accepted: 3, RejectedCount: 1, Rejected: []controllers.ImportRejection{
+		{Line: 4, Code: http.StatusRequestEntityTooLarge, Message: "Body too large."},
+	}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestWriteStatusStreamRejections(t *testing.T) {
+	// Setup
+	controller := controllers.Status{Service: &mockRecordingStatusService{}}
+	server := httptest.NewServer(http.HandlerFunc(controller.WriteStream))
+	defer server.Close()
+
+	request := buildStatusRequest("POST", server.URL, []byte(strings.Repeat("null\n", 1500)))
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer response.Body.Close()
+
+	// Every rejection is counted, but only the first ones are listed
+	var result controllers.ImportResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if result.RejectedCount != 1500 {
+		t.Errorf("Expected %d, got %d", 1500, result.RejectedCount)
+	}
+	if len(result.Rejected) != 1000 {
+		t.Fatalf("Expected %d, got %d", 1000, len(result.Rejected))
+	}
+	if line := result.Rejected[999].Line; line != 1000 {
+		t.Errorf("Expected %d, got %d", 1000, line)
+	}
+}
+
+func TestWriteStatusIdempotencyKey(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	driver.Duplicates = database.DuplicateReject
+	controller := controllers.Status{
+		Service: &services.StatusDatabase{Driver: driver},
+	}
+	server := httptest.NewServer(http.HandlerFunc(controller.Write))
+	defer server.Close()
+
+	steps := []struct {
+		name               string // step name
+		idempotencyKey     string // input Idempotency-Key header
+		body               string // input body
+		expectedStatus     string // expected status
+		expectedStatusCode int    // expected status code
+	}{
+		{"Stored", "a", `{"id":1,"timestamp":100,"temperature":20}`, "OK.\n", http.StatusOK},
+		{"Replayed key", "a", `{"id":1,"timestamp":200,"temperature":25}`, "OK.\n", http.StatusOK},
+		{"Duplicate", "", `{"id":1,"timestamp":100,"temperature":25}`, "Duplicate reading.\n", http.StatusConflict},
+		{"Body key", "", `{"id":1,"timestamp":300,"temperature":25,"idempotencyKey":"b"}`, "OK.\n", http.StatusOK},
+		{"Replayed body key", "b", `{"id":1,"timestamp":400,"temperature":25}`, "OK.\n", http.StatusOK},
+	}
+	for _, step := range steps {
+		request := buildStatusRequest("POST", server.URL, []byte(step.body))
+		if step.idempotencyKey != "" {
+			request.Header.Set("Idempotency-Key", step.idempotencyKey)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if string(body) != step.expectedStatus || response.StatusCode != step.expectedStatusCode {
+			t.Errorf("%s: expected %d: %q, got %d: %q", step.name, step.expectedStatusCode, step.expectedStatus, response.StatusCode, body)
+		}
+	}
+
+	var timestamps []int64
+	driver.ReadStatus(1, 0, 0, func(data *models.StatusData) error {
+		timestamps = append(timestamps, data.Timestamp)
+
+		return nil
+	})
+	if expected := []int64{100, 300}; !reflect.DeepEqual(timestamps, expected) {
+		t.Errorf("Expected %v, got %v", expected, timestamps)
+	}
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// defaultHeartbeat is the interval between keep-alive comments on idle streams
+const defaultHeartbeat = 15 * time.Second
+
+// Stream is the controller for the Server-Sent Events stream of accepted readings
+type Stream struct {
+	Service services.EventSource
+	// Heartbeat is the interval between keep-alive comments, defaulting to 15 seconds
+	Heartbeat time.Duration
+}
+
+// Read streams accepted readings, filtered by the "plant" query parameters.
+// Clients resume after the reading in the Last-Event-ID header.
+func (c *Stream) Read(w http.ResponseWriter, r *http.Request) {
+	plants, ok := plantFilter(r)
+	if !ok {
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+		return
+	}
+	var lastID uint64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "Invalid data.", http.StatusBadRequest)
+
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		util.LogError(r, fmt.Errorf("streaming unsupported"))
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+
+		return
+	}
+
+	subscription := c.Service.Subscribe(lastID, func(event *models.Event) bool {
+		return event.Type == models.EventReadingAccepted && (plants == nil || plants[event.PlantID])
+	})
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := c.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case message, ok := <-subscription.C:
+			if !ok {
+				// Dropped for lagging behind, the client resumes from its last reading
+				return
+			}
+			data, err := json.Marshal(message.Event.Data)
+			if err != nil {
+				util.LogError(r, err)
+
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", message.ID, message.Event.Type, data)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// plantFilter parses the "plant" query parameters, each a comma-separated list of IDs.
+// A nil filter matches every plant.
+func plantFilter(r *http.Request) (map[uint]bool, bool) {
+	values := r.URL.Query()["plant"]
+	if len(values) == 0 {
+		return nil, true
+	}
+
+	plants := map[uint]bool{}
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, false
+			}
+			plants[uint(id)] = true
+		}
+	}
+
+	return plants, true
+}
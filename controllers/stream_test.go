@@ -0,0 +1,112 @@
+package controllers_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// readEvent reads the next event from a stream, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(lines) > 0:
+			return strings.Join(lines, "\n")
+		case line == "", strings.HasPrefix(line, ":"):
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	// Setup
+	hub, _ := services.NewHub(16)
+	c := controllers.Stream{
+		Service:   hub,
+		Heartbeat: 10 * time.Millisecond,
+	}
+	server := httptest.NewServer(http.HandlerFunc(c.Read))
+	defer server.Close()
+
+	publish := func(eventType string, plantID uint) {
+		hub.Publish(&models.Event{Type: eventType, PlantID: plantID, Timestamp: 100, Data: &models.StatusData{ID: plantID, Timestamp: 100}})
+	}
+	publish(models.EventReadingAccepted, 1)
+	publish(models.EventReadingAccepted, 2)
+
+	request := buildStatusRequest("GET", server.URL+"/?plant=2,3", nil)
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(response.Body)
+
+	// Resumed after event 1
+	expected := "id: 2\nevent: reading.accepted\ndata: {\"id\":2,\"timestamp\":100}"
+	if event := readEvent(t, reader); event != expected {
+		t.Errorf("Expected %q, got %q", expected, event)
+	}
+
+	// Live, skipping other plants and event types
+	publish(models.EventReadingAccepted, 1)
+	publish(models.EventAlertFiring, 3)
+	publish(models.EventReadingAccepted, 3)
+	expected = "id: 5\nevent: reading.accepted\ndata: {\"id\":3,\"timestamp\":100}"
+	if event := readEvent(t, reader); event != expected {
+		t.Errorf("Expected %q, got %q", expected, event)
+	}
+}
+
+func TestStreamInvalid(t *testing.T) {
+	// Setup
+	hub, _ := services.NewHub(16)
+	c := controllers.Stream{
+		Service: hub,
+	}
+	server := httptest.NewServer(http.HandlerFunc(c.Read))
+	defer server.Close()
+
+	tests := map[string]struct {
+		query       string // input
+		lastEventID string // input
+	}{
+		"Invalid plant":         {"?plant=basil", ""},
+		"Invalid plant list":    {"?plant=1,", ""},
+		"Invalid Last-Event-ID": {"", "abc"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request := buildStatusRequest("GET", server.URL+"/"+testCase.query, nil)
+			if testCase.lastEventID != "" {
+				request.Header.Set("Last-Event-ID", testCase.lastEventID)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected %d, got %d", http.StatusBadRequest, response.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/berry-house/http_broker/util"
+)
+
+// Webhook is the controller for webhook subscriptions
+type Webhook struct {
+	Service services.Webhook
+}
+
+// Create registers a webhook subscriber, returning its signing secret
+func (c *Webhook) Create(w http.ResponseWriter, r *http.Request) {
+	var subscription models.Subscription
+	if !readJSON(w, r, &subscription) {
+		return
+	}
+
+	if err := c.Service.Subscribe(&subscription); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, &subscription)
+}
+
+// List lists every webhook subscriber
+func (c *Webhook) List(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := c.Service.Subscriptions()
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, subscriptions)
+}
+
+// Delete deletes a webhook subscriber
+func (c *Webhook) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	if err := c.Service.Unsubscribe(id); err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	w.Write([]byte("OK.\n"))
+}
+
+// Deliveries lists the delivery log of a webhook subscriber
+func (c *Webhook) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+
+		return
+	}
+
+	deliveries, err := c.Service.Deliveries(id)
+	if err != nil {
+		c.writeError(w, r, err)
+
+		return
+	}
+	writeJSON(w, r, http.StatusOK, deliveries)
+}
+
+// writeError maps a service error to a response
+func (c *Webhook) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case services.WebhookInvalidID:
+		http.Error(w, "Invalid ID.", http.StatusNotFound)
+	case services.WebhookInvalidData:
+		http.Error(w, "Invalid data.", http.StatusBadRequest)
+	default:
+		util.LogError(r, err)
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+	}
+}
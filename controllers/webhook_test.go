@@ -0,0 +1,142 @@
+package controllers_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"github.com/gorilla/mux"
+)
+
+// Service mock: subscription IDs 1 to 4 exist, ID 5 fails
+type mockWebhookService struct{}
+
+var _ services.Webhook = (*mockWebhookService)(nil)
+
+func (s *mockWebhookService) Subscribe(subscription *models.Subscription) error {
+	if subscription.URL == "" {
+		return services.WebhookInvalidData
+	}
+	subscription.ID = 6
+	subscription.Secret = "s3cr3t"
+
+	return nil
+}
+
+func (s *mockWebhookService) Subscriptions() ([]*models.Subscription, error) {
+	return []*models.Subscription{&models.Subscription{ID: 1, URL: "https://irrigation.local/hook", Events: []string{"alert.firing"}}}, nil
+}
+
+func (s *mockWebhookService) Unsubscribe(id uint) error {
+	_, err := s.Deliveries(id)
+
+	return err
+}
+
+func (s *mockWebhookService) Deliveries(subscriptionID uint) ([]*models.Delivery, error) {
+	switch {
+	case subscriptionID > 0 && subscriptionID < 5:
+		return []*models.Delivery{&models.Delivery{
+			ID:             1,
+			SubscriptionID: subscriptionID,
+			EventType:      "alert.firing",
+			Payload:        []byte(`{"type":"alert.firing"}`),
+			State:          "pending",
+			Attempts:       1,
+			NextAttempt:    1010,
+			LastStatus:     500,
+			LastError:      "unexpected status 500",
+			CreatedAt:      1000,
+		}}, nil
+	case subscriptionID == 5:
+		return nil, services.WebhookDatabaseDriverError("mocked error")
+	}
+
+	return nil, services.WebhookInvalidID
+}
+
+func TestWebhook(t *testing.T) {
+	// Setup
+	c := controllers.Webhook{
+		Service: &mockWebhookService{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/webhooks", c.Create).Methods("POST")
+	router.HandleFunc("/webhooks", c.List).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", c.Delete).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", c.Deliveries).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tests := map[string]struct {
+		request            *http.Request // input
+		expectedBody       string        // expected body
+		expectedStatusCode int           // expected status code
+	}{
+		"Create": {
+			request:            buildStatusRequest("POST", server.URL+"/webhooks", []byte(`{"url":"https://irrigation.local/hook","events":["alert.firing"]}`)),
+			expectedBody:       `{"id":6,"url":"https://irrigation.local/hook","events":["alert.firing"],"secret":"s3cr3t"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		"Create invalid data": {
+			request:            buildStatusRequest("POST", server.URL+"/webhooks", []byte(`{"events":["alert.firing"]}`)),
+			expectedBody:       "Invalid data.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"Create invalid body": {
+			request:            buildStatusRequest("POST", server.URL+"/webhooks", []byte(`{"url":`)),
+			expectedBody:       "Invalid body.\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		"List": {
+			request:            buildStatusRequest("GET", server.URL+"/webhooks", nil),
+			expectedBody:       `[{"id":1,"url":"https://irrigation.local/hook","events":["alert.firing"]}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Delete": {
+			request:            buildStatusRequest("DELETE", server.URL+"/webhooks/1", nil),
+			expectedBody:       "OK.\n",
+			expectedStatusCode: http.StatusOK,
+		},
+		"Delete invalid ID": {
+			request:            buildStatusRequest("DELETE", server.URL+"/webhooks/7", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		"Deliveries": {
+			request:            buildStatusRequest("GET", server.URL+"/webhooks/2/deliveries", nil),
+			expectedBody:       `[{"id":1,"subscriptionId":2,"eventType":"alert.firing","payload":{"type":"alert.firing"},"state":"pending","attempts":1,"nextAttempt":1010,"lastStatus":500,"lastError":"unexpected status 500","createdAt":1000}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		"Deliveries database error": {
+			request:            buildStatusRequest("GET", server.URL+"/webhooks/5/deliveries", nil),
+			expectedBody:       "Internal server error.\n",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		"Deliveries invalid ID": {
+			request:            buildStatusRequest("GET", server.URL+"/webhooks/basil/deliveries", nil),
+			expectedBody:       "Invalid ID.\n",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			response, err := http.DefaultClient.Do(testCase.request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			if string(body) != testCase.expectedBody ||
+				response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d: %q, got %d: %q", testCase.expectedStatusCode, testCase.expectedBody, response.StatusCode, string(body))
+			}
+		})
+	}
+}
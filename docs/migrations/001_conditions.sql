@@ -0,0 +1,29 @@
+-- Moves the readings of the former flat conditions table to the reading and readingMetric tables.
+-- Run it once, after docs/schema.sql and before starting the upgraded broker. Readings already in
+-- the new tables are kept, so it may be run again after a failure.
+-- Former times were written in the local time zone of the broker, UTC in the Docker image; wrap
+-- c.time in CONVERT_TZ(c.time, '<zone>', '+00:00') if the broker ran in another zone.
+-- The conditions table is left as it is, to be dropped once the readings are checked.
+
+START TRANSACTION;
+
+INSERT IGNORE INTO reading(plantID, time)
+    SELECT DISTINCT c.plantID, c.time FROM conditions c
+    JOIN plant p ON p.id = c.plantID;
+
+INSERT IGNORE INTO readingMetric(readingID, metric, value, unit)
+    SELECT r.id, 'light', c.lightIntensity, 'klx' FROM conditions c
+    JOIN reading r ON r.plantID = c.plantID AND r.time = c.time
+    WHERE c.lightIntensity IS NOT NULL;
+
+INSERT IGNORE INTO readingMetric(readingID, metric, value, unit)
+    SELECT r.id, 'humidity', c.soilHumidity, '%' FROM conditions c
+    JOIN reading r ON r.plantID = c.plantID AND r.time = c.time
+    WHERE c.soilHumidity IS NOT NULL;
+
+INSERT IGNORE INTO readingMetric(readingID, metric, value, unit)
+    SELECT r.id, 'temperature', c.airTemperature, '°C' FROM conditions c
+    JOIN reading r ON r.plantID = c.plantID AND r.time = c.time
+    WHERE c.airTemperature IS NOT NULL;
+
+COMMIT;
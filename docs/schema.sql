@@ -0,0 +1,137 @@
+-- MySQL schema used by drivers/database/mysql.go
+
+CREATE TABLE IF NOT EXISTS plant (
+    id       INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name     VARCHAR(255) NOT NULL,
+    species  VARCHAR(255) NOT NULL DEFAULT '',
+    location VARCHAR(255) NOT NULL DEFAULT '',
+    owner    VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id)
+);
+
+-- A reading holds any number of named metrics, so new metrics need no schema change
+-- Times are UTC; receivedAt is the Unix time the broker received the reading
+CREATE TABLE IF NOT EXISTS reading (
+    id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    plantID    INT UNSIGNED    NOT NULL,
+    time       DATETIME        NOT NULL,
+    receivedAt BIGINT          NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    UNIQUE KEY (plantID, time),
+    FOREIGN KEY (plantID) REFERENCES plant(id) ON DELETE CASCADE
+);
+
+-- Idempotency keys of stored writes, so their retries are acknowledged without writing
+CREATE TABLE IF NOT EXISTS readingKey (
+    plantID        INT UNSIGNED NOT NULL,
+    idempotencyKey VARCHAR(64)  NOT NULL,
+    time           DATETIME     NOT NULL,
+    PRIMARY KEY (plantID, idempotencyKey),
+    FOREIGN KEY (plantID) REFERENCES plant(id) ON DELETE CASCADE
+);
+
+-- raw is the value the device reported, for calibrated metrics;
+-- quality is the code of suspect values ("spike", "rate" or "flat"), empty for good ones
+CREATE TABLE IF NOT EXISTS readingMetric (
+    readingID BIGINT UNSIGNED NOT NULL,
+    metric    VARCHAR(64)     NOT NULL,
+    value     DOUBLE          NOT NULL,
+    unit      VARCHAR(16)     NOT NULL DEFAULT '',
+    raw       DOUBLE          NULL,
+    quality   VARCHAR(16)     NOT NULL DEFAULT '',
+    PRIMARY KEY (readingID, metric),
+    FOREIGN KEY (readingID) REFERENCES reading(id) ON DELETE CASCADE
+);
+
+-- Deployments storing readings in the former flat conditions table move them with
+-- docs/migrations/001_conditions.sql
+
+-- LoRaWAN devices have a DevEUI, and the profile of their payload decoder;
+-- reportInterval is the expected number of seconds between reports, 0 using the broker default
+CREATE TABLE IF NOT EXISTS device (
+    id             INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name           VARCHAR(255) NOT NULL DEFAULT '',
+    tokenHash      CHAR(64)     NOT NULL DEFAULT '',
+    devEUI         CHAR(16)     NULL,
+    profile        VARCHAR(64)  NOT NULL DEFAULT '',
+    reportInterval BIGINT       NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    UNIQUE KEY (devEUI)
+);
+
+-- Times are Unix epochs; an open binding has a NULL toTime
+CREATE TABLE IF NOT EXISTS deviceBinding (
+    deviceID INT UNSIGNED NOT NULL,
+    plantID  INT UNSIGNED NOT NULL,
+    fromTime BIGINT       NOT NULL,
+    toTime   BIGINT,
+    PRIMARY KEY (deviceID, fromTime),
+    FOREIGN KEY (deviceID) REFERENCES device(id) ON DELETE CASCADE,
+    FOREIGN KEY (plantID) REFERENCES plant(id) ON DELETE CASCADE
+);
+
+-- Calibration profiles of a device, effective from fromTime (a Unix epoch) until the next one;
+-- metrics holds the corrections keyed by metric name, as JSON
+CREATE TABLE IF NOT EXISTS deviceCalibration (
+    deviceID INT UNSIGNED NOT NULL,
+    fromTime BIGINT       NOT NULL,
+    metrics  TEXT         NOT NULL,
+    PRIMARY KEY (deviceID, fromTime),
+    FOREIGN KEY (deviceID) REFERENCES device(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS alertRule (
+    id         INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    plantID    INT UNSIGNED NOT NULL,
+    metric     VARCHAR(64)  NOT NULL,
+    operator   CHAR(1)      NOT NULL,
+    threshold  DOUBLE       NOT NULL,
+    forSeconds BIGINT       NOT NULL DEFAULT 0,
+    hysteresis DOUBLE       NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    FOREIGN KEY (plantID) REFERENCES plant(id) ON DELETE CASCADE
+);
+
+-- Times are Unix epochs, 0 when not reached yet
+CREATE TABLE IF NOT EXISTS alert (
+    id           INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    ruleID       INT UNSIGNED NOT NULL,
+    plantID      INT UNSIGNED NOT NULL,
+    metric       VARCHAR(64)  NOT NULL,
+    state        VARCHAR(16)  NOT NULL,
+    value        DOUBLE       NOT NULL,
+    since        BIGINT       NOT NULL,
+    firedAt      BIGINT       NOT NULL DEFAULT 0,
+    resolvedAt   BIGINT       NOT NULL DEFAULT 0,
+    acknowledged BOOLEAN      NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (id),
+    KEY (ruleID, state),
+    FOREIGN KEY (ruleID) REFERENCES alertRule(id) ON DELETE CASCADE
+);
+
+-- Event types are stored comma-separated
+CREATE TABLE IF NOT EXISTS webhookSubscription (
+    id     INT UNSIGNED  NOT NULL AUTO_INCREMENT,
+    url    VARCHAR(2048) NOT NULL,
+    events VARCHAR(255)  NOT NULL,
+    secret VARCHAR(64)   NOT NULL,
+    PRIMARY KEY (id)
+);
+
+-- Webhook outbox and delivery log; times are Unix epochs, 0 when not reached yet
+CREATE TABLE IF NOT EXISTS webhookDelivery (
+    id             INT UNSIGNED  NOT NULL AUTO_INCREMENT,
+    subscriptionID INT UNSIGNED  NOT NULL,
+    eventType      VARCHAR(64)   NOT NULL,
+    payload        TEXT          NOT NULL,
+    state          VARCHAR(16)   NOT NULL,
+    attempts       INT           NOT NULL DEFAULT 0,
+    nextAttempt    BIGINT        NOT NULL DEFAULT 0,
+    lastStatus     INT           NOT NULL DEFAULT 0,
+    lastError      VARCHAR(1024) NOT NULL DEFAULT '',
+    createdAt      BIGINT        NOT NULL,
+    deliveredAt    BIGINT        NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    KEY (state, nextAttempt),
+    FOREIGN KEY (subscriptionID) REFERENCES webhookSubscription(id) ON DELETE CASCADE
+);
@@ -4,7 +4,11 @@
 // Error returning should be related only to the sources.
 package database
 
-import "github.com/berry-house/http_broker/models"
+import (
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+)
 
 // Database is an interface for database drivers
 type Database interface {
@@ -12,6 +16,75 @@ type Database interface {
 	WriteStatus(data *models.StatusData) error
 }
 
+// DuplicatePolicy is the handling of a reading at the time of a stored reading of its plant
+type DuplicatePolicy string
+
+const (
+	// DuplicateOverwrite replaces the stored reading
+	DuplicateOverwrite = DuplicatePolicy("overwrite")
+	// DuplicateIgnore keeps the stored reading, acknowledging the new one
+	DuplicateIgnore = DuplicatePolicy("ignore")
+	// DuplicateReject keeps the stored reading, rejecting the new one
+	DuplicateReject = DuplicatePolicy("reject")
+)
+
+// Valid reports whether p is a known policy
+func (p DuplicatePolicy) Valid() bool {
+	return p == DuplicateOverwrite || p == DuplicateIgnore || p == DuplicateReject
+}
+
+// StatusReader is an interface for drivers reading stored status data.
+// Readings of a plant from (inclusive) to (exclusive) are passed to fn in time order,
+// to being 0 for no upper bound.
+type StatusReader interface {
+	ReadStatus(id uint, from, to int64, fn func(data *models.StatusData) error) error
+}
+
+// PlantStore is an interface for plant registry drivers
+type PlantStore interface {
+	CreatePlant(plant *models.Plant) error
+	ReadPlant(id uint) (*models.Plant, error)
+	ReadPlants() ([]*models.Plant, error)
+	UpdatePlant(plant *models.Plant) error
+	DeletePlant(id uint) error
+}
+
+// DeviceStore is an interface for device registry drivers
+type DeviceStore interface {
+	CreateDevice(device *models.Device) error
+	ReadDevice(id uint) (*models.Device, error)
+	ReadDeviceByEUI(devEUI string) (*models.Device, error)
+	ReadDevices() ([]*models.Device, error)
+	UpdateDevice(device *models.Device) error
+	DeleteDevice(id uint) error
+	ReadBindings(deviceID uint) ([]*models.Binding, error)
+	WriteBinding(binding *models.Binding) error
+	CloseBinding(deviceID uint, to int64) error
+	ReadCalibrations(deviceID uint) ([]*models.Calibration, error)
+	WriteCalibration(calibration *models.Calibration) error
+}
+
+// AlertStore is an interface for alert rule and state drivers
+type AlertStore interface {
+	CreateAlertRule(rule *models.AlertRule) error
+	ReadAlertRules(plantID uint) ([]*models.AlertRule, error)
+	DeleteAlertRule(id uint) error
+	ReadActiveAlert(ruleID uint) (*models.Alert, error)
+	ReadAlerts(state string) ([]*models.Alert, error)
+	WriteAlert(alert *models.Alert) error
+	AcknowledgeAlert(id uint) error
+}
+
+// WebhookStore is an interface for webhook subscription and outbox drivers
+type WebhookStore interface {
+	CreateSubscription(subscription *models.Subscription) error
+	ReadSubscriptions() ([]*models.Subscription, error)
+	DeleteSubscription(id uint) error
+	WriteDelivery(delivery *models.Delivery) error
+	ReadDeliveries(subscriptionID uint) ([]*models.Delivery, error)
+	ReadDueDeliveries(before int64, limit int) ([]*models.Delivery, error)
+}
+
 // DatabaseInvalidDataError is an error type for invalid data errors
 type DatabaseInvalidDataError string
 
@@ -20,3 +93,44 @@ type DatabaseUnexpectedError string
 
 func (e DatabaseInvalidDataError) Error() string { return string(e) }
 func (e DatabaseUnexpectedError) Error() string  { return string(e) }
+
+// DatabaseQueryError is an error type for failed calls to a database client, keeping the error
+// of the client so its cause can be inspected
+type DatabaseQueryError struct {
+	Err error
+}
+
+func (e DatabaseQueryError) Error() string { return e.Err.Error() }
+func (e DatabaseQueryError) Unwrap() error { return e.Err }
+
+// DatabaseUnavailableError is an error type for temporarily unavailable drivers
+type DatabaseUnavailableError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e DatabaseUnavailableError) Error() string { return e.Message }
+
+// DatabaseDuplicateError is an error type for readings not stored for duplicating a stored one.
+// Ignored readings are acknowledged rather than rejected: retries of the stored reading, readings
+// with a stored idempotency key, and any duplicate under DuplicateIgnore.
+type DatabaseDuplicateError struct {
+	Message string
+	Ignored bool
+}
+
+func (e DatabaseDuplicateError) Error() string { return e.Message }
+
+// sameMetrics reports whether a reading repeats the metrics of a stored one, as retries do
+func sameMetrics(stored, metrics map[string]models.Metric) bool {
+	if len(stored) != len(metrics) {
+		return false
+	}
+	for name, metric := range metrics {
+		if storedMetric, ok := stored[name]; !ok || storedMetric != metric {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+)
+
+// FanoutMode is the write semantics of a Fanout driver
+type FanoutMode string
+
+const (
+	// FanoutAll acknowledges a reading once every backend stored it
+	FanoutAll = FanoutMode("all")
+	// FanoutPrimary acknowledges a reading once the primary stored it. Secondaries are written
+	// best effort by Run, which must be started, failed writes being retried.
+	FanoutPrimary = FanoutMode("primary")
+)
+
+const (
+	defaultFanoutQueueSize   = 10000
+	defaultFanoutMaxAttempts = 10
+)
+
+// FanoutConfig is the configuration for a Fanout driver
+type FanoutConfig struct {
+	Mode FanoutMode
+	// QueueSize is the number of readings waiting for their secondary writes, and of failed
+	// secondary writes kept for retry, defaulting to 10000. The oldest are dropped when full.
+	QueueSize int
+	// MaxAttempts is the number of attempts of a secondary write before it is dropped, defaulting to 10
+	MaxAttempts int
+}
+
+// fanoutWrite is a secondary write waiting for a retry
+type fanoutWrite struct {
+	secondary int
+	data      *models.StatusData
+	attempts  int
+}
+
+// Fanout is a database driver writing readings to a primary backend and to secondary ones,
+// such as a time-series store for analytics.
+// The primary is written first and decides whether a reading is valid or a duplicate, so its
+// rejections are returned as is and reach no secondary, and secondary failures are never
+// rejections of the reading. Secondaries must accept repeated writes
+// of a reading, as retries of a reading acknowledged as a duplicate by the primary are written
+// to them again in FanoutAll mode. Backends are not rolled back when another one fails.
+type Fanout struct {
+	primary     Database
+	secondaries []Database
+	config      FanoutConfig
+
+	mu       sync.Mutex
+	incoming []*models.StatusData
+	queue    []*fanoutWrite
+	dropped  int
+	wake     chan struct{}
+}
+
+var _ Database = (*Fanout)(nil)
+
+// NewFanout creates a new Fanout driver
+func NewFanout(primary Database, secondaries []Database, config FanoutConfig) (*Fanout, error) {
+	if primary == nil {
+		return nil, DatabaseInvalidDataError("nil driver")
+	}
+	for _, secondary := range secondaries {
+		if secondary == nil {
+			return nil, DatabaseInvalidDataError("nil driver")
+		}
+	}
+	if (config.Mode != FanoutAll && config.Mode != FanoutPrimary) || config.QueueSize < 0 || config.MaxAttempts < 0 {
+		return nil, DatabaseInvalidDataError("invalid config")
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = defaultFanoutQueueSize
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultFanoutMaxAttempts
+	}
+
+	return &Fanout{
+		primary:     primary,
+		secondaries: secondaries,
+		config:      config,
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+// Exists checks if current ID exists in the primary
+func (d *Fanout) Exists(id uint) (bool, error) {
+	return d.primary.Exists(id)
+}
+
+// WriteStatus writes status data to the primary, then to every secondary.
+// In FanoutAll mode, the errors of failed secondaries are aggregated into one unavailable error,
+// if any of them is, or unexpected error. In FanoutPrimary mode, the secondary writes are queued for Run.
+func (d *Fanout) WriteStatus(data *models.StatusData) error {
+	if data == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	err := d.primary.WriteStatus(data)
+	if err != nil && !ignoredDuplicate(err) {
+		return err
+	}
+
+	if d.config.Mode == FanoutPrimary {
+		// Secondaries got the reading along with the stored one
+		if err == nil && len(d.secondaries) > 0 {
+			d.mu.Lock()
+			d.incoming = append(d.incoming, copyStatus(data))
+			d.trim()
+			d.mu.Unlock()
+
+			select {
+			case d.wake <- struct{}{}:
+			default:
+			}
+		}
+
+		return err
+	}
+
+	// Retries acknowledged by the primary heal secondaries that failed the first write
+	var errs []error
+	for i, result := range d.fanout(data) {
+		if result != nil && !ignoredDuplicate(result) {
+			errs = append(errs, fanoutBackendError(i, result))
+		}
+	}
+	if len(errs) > 0 {
+		return aggregateErrors(errs)
+	}
+
+	return err
+}
+
+// Retry retries the queued secondary writes, returning the number still queued.
+// Writes rejected by a secondary are dropped, as retries would be rejected alike.
+func (d *Fanout) Retry() int {
+	d.mu.Lock()
+	queue := d.queue
+	d.queue = nil
+	d.mu.Unlock()
+
+	var failed []*fanoutWrite
+	for _, write := range queue {
+		write.attempts++
+		err := d.secondaries[write.secondary].WriteStatus(write.data)
+		switch {
+		case err == nil || ignoredDuplicate(err):
+		case transientWrite(err) && write.attempts < d.config.MaxAttempts:
+			failed = append(failed, write)
+		default:
+			d.mu.Lock()
+			d.dropped++
+			d.mu.Unlock()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Writes queued during the retries are newer
+	d.queue = append(failed, d.queue...)
+	d.trim()
+
+	return len(d.queue)
+}
+
+// Flush makes the first attempt of the secondary writes of the readings written in FanoutPrimary
+// mode, queueing failures for retry
+func (d *Fanout) Flush() {
+	d.mu.Lock()
+	incoming := d.incoming
+	d.incoming = nil
+	d.mu.Unlock()
+
+	for _, data := range incoming {
+		d.writeSecondaries(data)
+	}
+}
+
+// Run makes the secondary writes of written readings as they come, and retries the queued ones
+// every interval, until stop is closed
+func (d *Fanout) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.wake:
+			d.Flush()
+		case <-ticker.C:
+			d.Retry()
+		}
+	}
+}
+
+// Pending returns the number of secondary writes waiting for a first attempt or a retry
+func (d *Fanout) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.incoming)*len(d.secondaries) + len(d.queue)
+}
+
+// Dropped returns the number of secondary writes given up
+func (d *Fanout) Dropped() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.dropped
+}
+
+// fanout writes a reading to every secondary concurrently, returning their errors in order
+func (d *Fanout) fanout(data *models.StatusData) []error {
+	results := make([]error, len(d.secondaries))
+	var wg sync.WaitGroup
+	for i, secondary := range d.secondaries {
+		wg.Add(1)
+		go func(i int, secondary Database) {
+			defer wg.Done()
+			results[i] = secondary.WriteStatus(data)
+		}(i, secondary)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// writeSecondaries makes a first attempt of the secondary writes of a reading, queueing failures
+func (d *Fanout) writeSecondaries(data *models.StatusData) {
+	for i, result := range d.fanout(data) {
+		if result == nil || ignoredDuplicate(result) {
+			continue
+		}
+		if !transientWrite(result) {
+			d.mu.Lock()
+			d.dropped++
+			d.mu.Unlock()
+
+			continue
+		}
+
+		d.mu.Lock()
+		d.queue = append(d.queue, &fanoutWrite{secondary: i, data: data, attempts: 1})
+		d.trim()
+		d.mu.Unlock()
+	}
+}
+
+// trim drops the oldest readings and queued writes past the queue size
+func (d *Fanout) trim() {
+	if excess := len(d.incoming) - d.config.QueueSize; excess > 0 {
+		d.dropped += excess * len(d.secondaries)
+		d.incoming = d.incoming[excess:]
+	}
+	if excess := len(d.queue) - d.config.QueueSize; excess > 0 {
+		d.dropped += excess
+		d.queue = d.queue[excess:]
+	}
+}
+
+// ignoredDuplicate reports whether err acknowledges a reading already stored
+func ignoredDuplicate(err error) bool {
+	duplicate, ok := err.(DatabaseDuplicateError)
+
+	return ok && duplicate.Ignored
+}
+
+// transientWrite reports whether a failed write may succeed later
+func transientWrite(err error) bool {
+	switch err.(type) {
+	case DatabaseUnexpectedError, DatabaseUnavailableError:
+		return true
+	case DatabaseQueryError:
+		return transient(err)
+	}
+
+	return false
+}
+
+// fanoutBackendError prefixes the message of the error of a secondary with its position.
+// The primary accepted the reading, so secondary rejections are unexpected errors; only
+// unavailability keeps its type.
+func fanoutBackendError(secondary int, err error) error {
+	prefix := "secondary " + strconv.Itoa(secondary) + ": "
+	if e, ok := err.(DatabaseUnavailableError); ok {
+		e.Message = prefix + e.Message
+
+		return e
+	}
+
+	return DatabaseUnexpectedError(prefix + err.Error())
+}
+
+// aggregateErrors joins the messages of secondary errors into an unavailable error, retrying
+// after the longest delay, if any of them is, or an unexpected error
+func aggregateErrors(errs []error) error {
+	messages := make([]string, len(errs))
+	unavailable := false
+	var retryAfter time.Duration
+	for i, err := range errs {
+		messages[i] = err.Error()
+		if e, ok := err.(DatabaseUnavailableError); ok {
+			unavailable = true
+			if e.RetryAfter > retryAfter {
+				retryAfter = e.RetryAfter
+			}
+		}
+	}
+	message := strings.Join(messages, "; ")
+
+	if unavailable {
+		return DatabaseUnavailableError{Message: message, RetryAfter: retryAfter}
+	}
+
+	return DatabaseUnexpectedError(message)
+}
+
+// copyStatus copies a reading for later writes, as callers may change it
+func copyStatus(data *models.StatusData) *models.StatusData {
+	copied := *data
+	if data.Metrics != nil {
+		copied.Metrics = make(map[string]models.Metric, len(data.Metrics))
+		for name, metric := range data.Metrics {
+			copied.Metrics[name] = metric
+		}
+	}
+	if data.Raw != nil {
+		copied.Raw = make(map[string]float64, len(data.Raw))
+		for name, value := range data.Raw {
+			copied.Raw[name] = value
+		}
+	}
+	if data.Quality != nil {
+		copied.Quality = make(map[string]string, len(data.Quality))
+		for name, quality := range data.Quality {
+			copied.Quality[name] = quality
+		}
+	}
+
+	return &copied
+}
@@ -0,0 +1,203 @@
+package database_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// Sink mock, failing with err while set
+type mockSink struct {
+	mu     sync.Mutex
+	err    error
+	stored []int64
+}
+
+func (s *mockSink) Exists(id uint) (bool, error) { return true, nil }
+
+func (s *mockSink) WriteStatus(data *models.StatusData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.stored = append(s.stored, data.Timestamp)
+
+	return nil
+}
+
+func TestNewFanout(t *testing.T) {
+	primary, _ := database.NewMemory(map[uint][]*models.StatusData{})
+
+	tests := map[string]struct {
+		primary     database.Database     // input
+		secondaries []database.Database   // input
+		config      database.FanoutConfig // input
+		expected    error                 // expected error
+	}{
+		"All":               {primary, []database.Database{&mockSink{}}, database.FanoutConfig{Mode: database.FanoutAll}, nil},
+		"Primary":           {primary, nil, database.FanoutConfig{Mode: database.FanoutPrimary, QueueSize: 10}, nil},
+		"Nil primary":       {nil, nil, database.FanoutConfig{Mode: database.FanoutAll}, database.DatabaseInvalidDataError("nil driver")},
+		"Nil secondary":     {primary, []database.Database{nil}, database.FanoutConfig{Mode: database.FanoutAll}, database.DatabaseInvalidDataError("nil driver")},
+		"Unknown mode":      {primary, nil, database.FanoutConfig{Mode: "any"}, database.DatabaseInvalidDataError("invalid config")},
+		"Negative attempts": {primary, nil, database.FanoutConfig{Mode: database.FanoutAll, MaxAttempts: -1}, database.DatabaseInvalidDataError("invalid config")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := database.NewFanout(testCase.primary, testCase.secondaries, testCase.config)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestFanoutAll(t *testing.T) {
+	// Setup
+	primary, _ := database.NewMemory(map[uint][]*models.StatusData{1: {}})
+	analytics, _ := database.NewMemory(map[uint][]*models.StatusData{1: {}})
+	flaky := &mockSink{}
+	driver, _ := database.NewFanout(primary, []database.Database{analytics, flaky}, database.FanoutConfig{Mode: database.FanoutAll})
+	metrics := map[string]models.Metric{"humidity": {Value: 40, Unit: "%"}}
+
+	steps := []struct {
+		name        string             // step name
+		data        *models.StatusData // input
+		flakyErr    error              // error of the flaky sink
+		expected    error              // expected error
+		expectedLen int                // expected readings of the flaky sink
+	}{
+		{"Stored everywhere", &models.StatusData{ID: 1, Timestamp: 100, Metrics: metrics}, nil, nil, 1},
+		{"Invalid ID", &models.StatusData{ID: 2, Timestamp: 100, Metrics: metrics}, nil, database.DatabaseInvalidDataError("invalid ID"), 1},
+		{
+			"Secondary down", &models.StatusData{ID: 1, Timestamp: 200, Metrics: metrics},
+			database.DatabaseUnavailableError{Message: "circuit breaker open", RetryAfter: time.Second},
+			database.DatabaseUnavailableError{Message: "secondary 1: circuit breaker open", RetryAfter: time.Second}, 1,
+		},
+		{
+			"Retry heals", &models.StatusData{ID: 1, Timestamp: 200, Metrics: metrics}, nil,
+			database.DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}, 2,
+		},
+		{
+			"Secondary rejects", &models.StatusData{ID: 1, Timestamp: 300, Metrics: metrics},
+			database.DatabaseInvalidDataError("unknown measurement"),
+			database.DatabaseUnexpectedError("secondary 1: unknown measurement"), 2,
+		},
+		{
+			"Secondary duplicate", &models.StatusData{ID: 1, Timestamp: 400, Metrics: metrics},
+			database.DatabaseDuplicateError{Message: "duplicate reading"},
+			database.DatabaseUnexpectedError("secondary 1: duplicate reading"), 2,
+		},
+	}
+	for _, step := range steps {
+		flaky.err = step.flakyErr
+		if err := driver.WriteStatus(step.data); !reflect.DeepEqual(err, step.expected) {
+			t.Errorf("%s: expected %+v, got %+v", step.name, step.expected, err)
+		}
+		if len(flaky.stored) != step.expectedLen {
+			t.Errorf("%s: expected %d readings, got %d", step.name, step.expectedLen, len(flaky.stored))
+		}
+	}
+}
+
+func TestFanoutPrimary(t *testing.T) {
+	// Setup
+	primary, _ := database.NewMemory(map[uint][]*models.StatusData{1: {}})
+	sink := &mockSink{err: database.DatabaseUnexpectedError("connection refused")}
+	driver, _ := database.NewFanout(primary, []database.Database{sink}, database.FanoutConfig{
+		Mode:        database.FanoutPrimary,
+		QueueSize:   2,
+		MaxAttempts: 3,
+	})
+
+	steps := []struct {
+		name            string // step name
+		timestamp       int64  // input timestamp, 0 to retry the queue
+		recovered       bool   // whether the sink is back
+		expectedPending int    // expected queued writes
+		expectedDropped int    // expected dropped writes
+	}{
+		{"Sink down", 100, false, 1, 0},
+		{"Retry fails", 0, false, 1, 0},
+		{"Out of attempts", 0, false, 0, 1},
+		{"Queued", 200, false, 1, 1},
+		{"Queued again", 300, false, 2, 1},
+		{"Queue full", 400, false, 2, 2},
+		{"Recovered", 0, true, 0, 2},
+	}
+	for _, step := range steps {
+		if step.recovered {
+			sink.err = nil
+		}
+		if step.timestamp != 0 {
+			data := &models.StatusData{ID: 1, Timestamp: step.timestamp, Metrics: map[string]models.Metric{"light": {Value: 10}}}
+			if err := driver.WriteStatus(data); err != nil {
+				t.Fatalf("%s: no error expected, got %+v", step.name, err)
+			}
+			// Queued writes are copies of the reading
+			data.Timestamp = 0
+			driver.Flush()
+		} else {
+			driver.Retry()
+		}
+		if pending, dropped := driver.Pending(), driver.Dropped(); pending != step.expectedPending || dropped != step.expectedDropped {
+			t.Errorf("%s: expected %d pending and %d dropped, got %d and %d", step.name, step.expectedPending, step.expectedDropped, pending, dropped)
+		}
+	}
+
+	// The sink got the writes kept in the queue
+	if expected := []int64{300, 400}; !reflect.DeepEqual(sink.stored, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, sink.stored)
+	}
+
+	// Primary rejections reach no secondary
+	err := driver.WriteStatus(&models.StatusData{ID: 2, Timestamp: 500})
+	if expected := database.DatabaseInvalidDataError("invalid ID"); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+	if len(sink.stored) != 2 {
+		t.Errorf("Expected 2 readings, got %d", len(sink.stored))
+	}
+}
+
+func TestFanoutPrimaryRun(t *testing.T) {
+	// Setup
+	primary, _ := database.NewMemory(map[uint][]*models.StatusData{1: {}})
+	sink := &mockSink{}
+	driver, _ := database.NewFanout(primary, []database.Database{sink}, database.FanoutConfig{Mode: database.FanoutPrimary})
+
+	// Writes return before secondaries are written
+	if err := driver.WriteStatus(&models.StatusData{ID: 1, Timestamp: 100}); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if pending := driver.Pending(); pending != 1 {
+		t.Errorf("Expected 1 pending write, got %d", pending)
+	}
+	if len(sink.stored) != 0 {
+		t.Errorf("Expected no readings, got %d", len(sink.stored))
+	}
+
+	// Run writes them
+	stop := make(chan struct{})
+	defer close(stop)
+	go driver.Run(time.Hour, stop)
+	stored := func() int {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+
+		return len(sink.stored)
+	}
+	for deadline := time.Now().Add(time.Second); stored() == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if expected := []int64{100}; !reflect.DeepEqual(sink.stored, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, sink.stored)
+	}
+}
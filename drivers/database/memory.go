@@ -1,23 +1,68 @@
 package database
 
-import "github.com/berry-house/http_broker/models"
+import (
+	"sort"
+	"sync"
+
+	"github.com/berry-house/http_broker/models"
+)
 
 // Memory is an in-memory database driver
 type Memory struct {
-	data map[uint][]*models.StatusData
+	// Duplicates is the policy for readings at the time of a stored one, defaulting to DuplicateOverwrite
+	Duplicates DuplicatePolicy
+
+	mu            sync.RWMutex
+	data          map[uint][]*models.StatusData
+	keys          map[uint]map[string]bool
+	plants        map[uint]*models.Plant
+	devices       map[uint]*models.Device
+	bindings      map[uint][]*models.Binding
+	calibrations  map[uint][]*models.Calibration
+	rules         map[uint]*models.AlertRule
+	alerts        map[uint]*models.Alert
+	subscriptions map[uint]*models.Subscription
+	deliveries    map[uint]*models.Delivery
 }
 
-// NewMemory creates a new DatabaseMemory driver
+var _ Database = (*Memory)(nil)
+var _ StatusReader = (*Memory)(nil)
+var _ PlantStore = (*Memory)(nil)
+var _ DeviceStore = (*Memory)(nil)
+var _ AlertStore = (*Memory)(nil)
+var _ WebhookStore = (*Memory)(nil)
+
+// NewMemory creates a new DatabaseMemory driver.
+// Every ID in data is registered as an unnamed plant.
 func NewMemory(data map[uint][]*models.StatusData) (*Memory, error) {
 	if data == nil {
 		return nil, DatabaseInvalidDataError("nil data")
 	}
 
-	return &Memory{data: data}, nil
+	plants := map[uint]*models.Plant{}
+	for id := range data {
+		plants[id] = &models.Plant{ID: id}
+	}
+
+	return &Memory{
+		data:          data,
+		keys:          map[uint]map[string]bool{},
+		plants:        plants,
+		devices:       map[uint]*models.Device{},
+		bindings:      map[uint][]*models.Binding{},
+		calibrations:  map[uint][]*models.Calibration{},
+		rules:         map[uint]*models.AlertRule{},
+		alerts:        map[uint]*models.Alert{},
+		subscriptions: map[uint]*models.Subscription{},
+		deliveries:    map[uint]*models.Delivery{},
+	}, nil
 }
 
 // Exists checks if current ID exists
 func (d *Memory) Exists(id uint) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	_, ok := d.data[id]
 	if !ok {
 		return false, nil
@@ -26,7 +71,8 @@ func (d *Memory) Exists(id uint) (bool, error) {
 	return true, nil
 }
 
-// WriteStatus writes status data into memory
+// WriteStatus writes status data into memory.
+// A reading at the time of a stored one of its plant is handled by the duplicate policy.
 func (d *Memory) WriteStatus(temp *models.StatusData) error {
 	if d == nil {
 		return DatabaseUnexpectedError("nil driver")
@@ -35,6 +81,9 @@ func (d *Memory) WriteStatus(temp *models.StatusData) error {
 		return DatabaseInvalidDataError("nil data")
 	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	list, ok := d.data[temp.ID]
 	if !ok {
 		return DatabaseInvalidDataError("invalid ID")
@@ -42,7 +91,658 @@ func (d *Memory) WriteStatus(temp *models.StatusData) error {
 	if list == nil {
 		return DatabaseUnexpectedError("nil list")
 	}
-	d.data[temp.ID] = append(list, temp)
+	if temp.IdempotencyKey != "" && d.keys[temp.ID][temp.IdempotencyKey] {
+		return DatabaseDuplicateError{Message: "duplicate idempotency key", Ignored: true}
+	}
+
+	// Idempotency keys are not part of the stored reading
+	stored := *temp
+	stored.IdempotencyKey = ""
+	index := -1
+	for i, data := range list {
+		if data.Timestamp == temp.Timestamp {
+			index = i
+
+			break
+		}
+	}
+
+	var err error
+	switch {
+	case index < 0:
+		d.data[temp.ID] = append(list, &stored)
+	case sameMetrics(list[index].Metrics, stored.Metrics):
+		err = DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}
+	case d.Duplicates == DuplicateIgnore:
+		err = DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}
+	case d.Duplicates == DuplicateReject:
+		return DatabaseDuplicateError{Message: "duplicate reading"}
+	default:
+		list[index] = &stored
+	}
+	if temp.IdempotencyKey != "" {
+		if d.keys[temp.ID] == nil {
+			d.keys[temp.ID] = map[string]bool{}
+		}
+		d.keys[temp.ID][temp.IdempotencyKey] = true
+	}
+
+	return err
+}
+
+// ReadStatus reads the status data of a plant in a time range from memory, in time order
+func (d *Memory) ReadStatus(id uint, from, to int64, fn func(data *models.StatusData) error) error {
+	d.mu.RLock()
+	list, ok := d.data[id]
+	readings := make([]*models.StatusData, 0, len(list))
+	for _, data := range list {
+		if data.Timestamp >= from && (to == 0 || data.Timestamp < to) {
+			readings = append(readings, data)
+		}
+	}
+	d.mu.RUnlock()
+
+	if !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].Timestamp < readings[j].Timestamp })
+	for _, data := range readings {
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreatePlant registers a plant in memory, assigning an ID if none is given
+func (d *Memory) CreatePlant(plant *models.Plant) error {
+	if plant == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if plant.ID == 0 {
+		for id := range d.plants {
+			if id > plant.ID {
+				plant.ID = id
+			}
+		}
+		plant.ID++
+	}
+	if _, ok := d.plants[plant.ID]; ok {
+		return DatabaseInvalidDataError("duplicate ID")
+	}
+
+	stored := *plant
+	d.plants[plant.ID] = &stored
+	d.data[plant.ID] = []*models.StatusData{}
+
+	return nil
+}
+
+// ReadPlant reads a plant from memory
+func (d *Memory) ReadPlant(id uint) (*models.Plant, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	plant, ok := d.plants[id]
+	if !ok {
+		return nil, DatabaseInvalidDataError("invalid ID")
+	}
+	result := *plant
+
+	return &result, nil
+}
+
+// ReadPlants reads every plant from memory, ordered by ID
+func (d *Memory) ReadPlants() ([]*models.Plant, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	plants := make([]*models.Plant, 0, len(d.plants))
+	for _, plant := range d.plants {
+		result := *plant
+		plants = append(plants, &result)
+	}
+	sort.Slice(plants, func(i, j int) bool { return plants[i].ID < plants[j].ID })
+
+	return plants, nil
+}
+
+// UpdatePlant updates a plant in memory
+func (d *Memory) UpdatePlant(plant *models.Plant) error {
+	if plant == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.plants[plant.ID]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	stored := *plant
+	d.plants[plant.ID] = &stored
+
+	return nil
+}
+
+// DeletePlant deletes a plant from memory, along with its status data, device bindings,
+// alert rules and their alerts
+func (d *Memory) DeletePlant(id uint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.plants[id]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	delete(d.plants, id)
+	delete(d.data, id)
+	delete(d.keys, id)
+
+	for deviceID, bindings := range d.bindings {
+		kept := bindings[:0]
+		for _, binding := range bindings {
+			if binding.PlantID != id {
+				kept = append(kept, binding)
+			}
+		}
+		d.bindings[deviceID] = kept
+	}
+	for ruleID, rule := range d.rules {
+		if rule.PlantID == id {
+			delete(d.rules, ruleID)
+		}
+	}
+	for alertID, alert := range d.alerts {
+		if _, ok := d.rules[alert.RuleID]; !ok {
+			delete(d.alerts, alertID)
+		}
+	}
+
+	return nil
+}
+
+// CreateDevice registers a device in memory, assigning an ID if none is given
+func (d *Memory) CreateDevice(device *models.Device) error {
+	if device == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if device.ID == 0 {
+		for id := range d.devices {
+			if id > device.ID {
+				device.ID = id
+			}
+		}
+		device.ID++
+	}
+	if _, ok := d.devices[device.ID]; ok {
+		return DatabaseInvalidDataError("duplicate ID")
+	}
+	if d.duplicateEUI(device) {
+		return DatabaseInvalidDataError("duplicate DevEUI")
+	}
+
+	stored := *device
+	stored.Token = ""
+	d.devices[device.ID] = &stored
+
+	return nil
+}
+
+// ReadDevice reads a device from memory
+func (d *Memory) ReadDevice(id uint) (*models.Device, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	device, ok := d.devices[id]
+	if !ok {
+		return nil, DatabaseInvalidDataError("invalid ID")
+	}
+	result := *device
+
+	return &result, nil
+}
+
+// ReadDeviceByEUI reads the device with a LoRaWAN DevEUI from memory
+func (d *Memory) ReadDeviceByEUI(devEUI string) (*models.Device, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, device := range d.devices {
+		if devEUI != "" && device.DevEUI == devEUI {
+			result := *device
+
+			return &result, nil
+		}
+	}
+
+	return nil, DatabaseInvalidDataError("invalid ID")
+}
+
+// duplicateEUI checks if another device has the DevEUI of a device
+func (d *Memory) duplicateEUI(device *models.Device) bool {
+	if device.DevEUI == "" {
+		return false
+	}
+	for id, stored := range d.devices {
+		if id != device.ID && stored.DevEUI == device.DevEUI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReadDevices reads every device from memory, ordered by ID
+func (d *Memory) ReadDevices() ([]*models.Device, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	devices := make([]*models.Device, 0, len(d.devices))
+	for _, device := range d.devices {
+		result := *device
+		devices = append(devices, &result)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+	return devices, nil
+}
+
+// UpdateDevice updates a device in memory
+func (d *Memory) UpdateDevice(device *models.Device) error {
+	if device == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.devices[device.ID]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	if d.duplicateEUI(device) {
+		return DatabaseInvalidDataError("duplicate DevEUI")
+	}
+	stored := *device
+	stored.Token = ""
+	d.devices[device.ID] = &stored
+
+	return nil
+}
+
+// DeleteDevice deletes a device, its bindings and its calibrations from memory
+func (d *Memory) DeleteDevice(id uint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.devices[id]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	delete(d.devices, id)
+	delete(d.bindings, id)
+	delete(d.calibrations, id)
+
+	return nil
+}
+
+// ReadBindings reads the bindings of a device from memory, ordered by start time
+func (d *Memory) ReadBindings(deviceID uint) ([]*models.Binding, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, ok := d.devices[deviceID]; !ok {
+		return nil, DatabaseInvalidDataError("invalid ID")
+	}
+	bindings := make([]*models.Binding, 0, len(d.bindings[deviceID]))
+	for _, binding := range d.bindings[deviceID] {
+		result := *binding
+		bindings = append(bindings, &result)
+	}
+
+	return bindings, nil
+}
+
+// WriteBinding adds a binding to memory
+func (d *Memory) WriteBinding(binding *models.Binding) error {
+	if binding == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.devices[binding.DeviceID]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	stored := *binding
+	bindings := append(d.bindings[binding.DeviceID], &stored)
+	sort.SliceStable(bindings, func(i, j int) bool { return bindings[i].From < bindings[j].From })
+	d.bindings[binding.DeviceID] = bindings
+
+	return nil
+}
+
+// CloseBinding closes the open binding of a device in memory
+func (d *Memory) CloseBinding(deviceID uint, to int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, binding := range d.bindings[deviceID] {
+		if binding.To == 0 {
+			binding.To = to
+
+			return nil
+		}
+	}
+
+	return DatabaseInvalidDataError("no open binding")
+}
+
+// ReadCalibrations reads the calibration profiles of a device from memory, ordered by start time
+func (d *Memory) ReadCalibrations(deviceID uint) ([]*models.Calibration, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, ok := d.devices[deviceID]; !ok {
+		return nil, DatabaseInvalidDataError("invalid ID")
+	}
+	calibrations := make([]*models.Calibration, 0, len(d.calibrations[deviceID]))
+	for _, calibration := range d.calibrations[deviceID] {
+		result := *calibration
+		calibrations = append(calibrations, &result)
+	}
+
+	return calibrations, nil
+}
+
+// WriteCalibration adds a calibration profile to memory
+func (d *Memory) WriteCalibration(calibration *models.Calibration) error {
+	if calibration == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.devices[calibration.DeviceID]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	stored := *calibration
+	calibrations := append(d.calibrations[calibration.DeviceID], &stored)
+	sort.SliceStable(calibrations, func(i, j int) bool { return calibrations[i].From < calibrations[j].From })
+	d.calibrations[calibration.DeviceID] = calibrations
+
+	return nil
+}
+
+// CreateAlertRule stores an alert rule in memory, assigning its ID
+func (d *Memory) CreateAlertRule(rule *models.AlertRule) error {
+	if rule == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.plants[rule.PlantID]; !ok {
+		return DatabaseInvalidDataError("invalid plant")
+	}
+	rule.ID = 0
+	for id := range d.rules {
+		if id > rule.ID {
+			rule.ID = id
+		}
+	}
+	rule.ID++
+	stored := *rule
+	d.rules[rule.ID] = &stored
+
+	return nil
+}
+
+// ReadAlertRules reads the alert rules of a plant from memory, or every rule for plant 0
+func (d *Memory) ReadAlertRules(plantID uint) ([]*models.AlertRule, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	rules := []*models.AlertRule{}
+	for _, rule := range d.rules {
+		if plantID == 0 || rule.PlantID == plantID {
+			result := *rule
+			rules = append(rules, &result)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	return rules, nil
+}
+
+// DeleteAlertRule deletes an alert rule and its alerts from memory
+func (d *Memory) DeleteAlertRule(id uint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.rules[id]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	delete(d.rules, id)
+	for alertID, alert := range d.alerts {
+		if alert.RuleID == id {
+			delete(d.alerts, alertID)
+		}
+	}
 
 	return nil
 }
+
+// ReadActiveAlert reads the pending or firing alert of a rule from memory, if any
+func (d *Memory) ReadActiveAlert(ruleID uint) (*models.Alert, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, alert := range d.alerts {
+		if alert.RuleID == ruleID && alert.State != models.AlertResolved {
+			result := *alert
+
+			return &result, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ReadAlerts reads the alerts in a state from memory, or every alert for an empty state
+func (d *Memory) ReadAlerts(state string) ([]*models.Alert, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	alerts := []*models.Alert{}
+	for _, alert := range d.alerts {
+		if state == "" || alert.State == state {
+			result := *alert
+			alerts = append(alerts, &result)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+
+	return alerts, nil
+}
+
+// WriteAlert stores an alert in memory, assigning an ID to new alerts
+func (d *Memory) WriteAlert(alert *models.Alert) error {
+	if alert == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if alert.ID == 0 {
+		for id := range d.alerts {
+			if id > alert.ID {
+				alert.ID = id
+			}
+		}
+		alert.ID++
+	} else if _, ok := d.alerts[alert.ID]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	stored := *alert
+	d.alerts[alert.ID] = &stored
+
+	return nil
+}
+
+// AcknowledgeAlert acknowledges an alert in memory
+func (d *Memory) AcknowledgeAlert(id uint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	alert, ok := d.alerts[id]
+	if !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	alert.Acknowledged = true
+
+	return nil
+}
+
+// CreateSubscription stores a webhook subscription in memory, assigning its ID
+func (d *Memory) CreateSubscription(subscription *models.Subscription) error {
+	if subscription == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscription.ID = 0
+	for id := range d.subscriptions {
+		if id > subscription.ID {
+			subscription.ID = id
+		}
+	}
+	subscription.ID++
+	stored := *subscription
+	stored.Events = append([]string(nil), subscription.Events...)
+	d.subscriptions[subscription.ID] = &stored
+
+	return nil
+}
+
+// ReadSubscriptions reads every webhook subscription from memory, ordered by ID
+func (d *Memory) ReadSubscriptions() ([]*models.Subscription, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	subscriptions := make([]*models.Subscription, 0, len(d.subscriptions))
+	for _, subscription := range d.subscriptions {
+		result := *subscription
+		result.Events = append([]string(nil), subscription.Events...)
+		subscriptions = append(subscriptions, &result)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+
+	return subscriptions, nil
+}
+
+// DeleteSubscription deletes a webhook subscription and its deliveries from memory
+func (d *Memory) DeleteSubscription(id uint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subscriptions[id]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	delete(d.subscriptions, id)
+	for deliveryID, delivery := range d.deliveries {
+		if delivery.SubscriptionID == id {
+			delete(d.deliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+// WriteDelivery stores a webhook delivery in memory, assigning an ID to new deliveries
+func (d *Memory) WriteDelivery(delivery *models.Delivery) error {
+	if delivery == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subscriptions[delivery.SubscriptionID]; !ok {
+		return DatabaseInvalidDataError("invalid subscription")
+	}
+	if delivery.ID == 0 {
+		for id := range d.deliveries {
+			if id > delivery.ID {
+				delivery.ID = id
+			}
+		}
+		delivery.ID++
+	} else if _, ok := d.deliveries[delivery.ID]; !ok {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	stored := *delivery
+	d.deliveries[delivery.ID] = &stored
+
+	return nil
+}
+
+// ReadDeliveries reads the deliveries of a webhook subscription from memory, ordered by ID
+func (d *Memory) ReadDeliveries(subscriptionID uint) ([]*models.Delivery, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, ok := d.subscriptions[subscriptionID]; !ok {
+		return nil, DatabaseInvalidDataError("invalid ID")
+	}
+	deliveries := []*models.Delivery{}
+	for _, delivery := range d.deliveries {
+		if delivery.SubscriptionID == subscriptionID {
+			result := *delivery
+			deliveries = append(deliveries, &result)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+
+	return deliveries, nil
+}
+
+// ReadDueDeliveries reads up to limit pending deliveries due before a time from memory,
+// oldest first
+func (d *Memory) ReadDueDeliveries(before int64, limit int) ([]*models.Delivery, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	deliveries := []*models.Delivery{}
+	for _, delivery := range d.deliveries {
+		if delivery.State == models.DeliveryPending && delivery.NextAttempt <= before {
+			result := *delivery
+			deliveries = append(deliveries, &result)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].NextAttempt != deliveries[j].NextAttempt {
+			return deliveries[i].NextAttempt < deliveries[j].NextAttempt
+		}
+
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
@@ -20,6 +20,17 @@ func TestNewMemory(t *testing.T) {
 				data: map[uint][]*models.StatusData{
 					1: []*models.StatusData{},
 				},
+				keys: map[uint]map[string]bool{},
+				plants: map[uint]*models.Plant{
+					1: &models.Plant{ID: 1},
+				},
+				devices:       map[uint]*models.Device{},
+				bindings:      map[uint][]*models.Binding{},
+				calibrations:  map[uint][]*models.Calibration{},
+				rules:         map[uint]*models.AlertRule{},
+				alerts:        map[uint]*models.Alert{},
+				subscriptions: map[uint]*models.Subscription{},
+				deliveries:    map[uint]*models.Delivery{},
 			},
 		},
 		"nil list": {
@@ -30,6 +41,17 @@ func TestNewMemory(t *testing.T) {
 				data: map[uint][]*models.StatusData{
 					1: nil,
 				},
+				keys: map[uint]map[string]bool{},
+				plants: map[uint]*models.Plant{
+					1: &models.Plant{ID: 1},
+				},
+				devices:       map[uint]*models.Device{},
+				bindings:      map[uint][]*models.Binding{},
+				calibrations:  map[uint][]*models.Calibration{},
+				rules:         map[uint]*models.AlertRule{},
+				alerts:        map[uint]*models.Alert{},
+				subscriptions: map[uint]*models.Subscription{},
+				deliveries:    map[uint]*models.Delivery{},
 			},
 		},
 	}
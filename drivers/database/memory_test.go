@@ -42,6 +42,23 @@ func TestUnexpectedError(t *testing.T) {
 	}
 }
 
+func TestUnavailableError(t *testing.T) {
+	tests := map[string]struct {
+		err      database.DatabaseUnavailableError // error
+		expected string                            // expected message
+	}{
+		"General test": {database.DatabaseUnavailableError{Message: "some message"}, "some message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
 func TestMemoryExists(t *testing.T) {
 	// Setup
 	driver, _ := database.NewMemory(
@@ -102,3 +119,553 @@ func TestMemoryWriteStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryCreatePlant(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(
+		map[uint][]*models.StatusData{
+			1: []*models.StatusData{},
+			4: []*models.StatusData{},
+		},
+	)
+
+	tests := map[string]struct {
+		plant      *models.Plant // input
+		expected   error         // expected error
+		expectedID uint          // expected assigned ID
+	}{
+		"Happy path":   {&models.Plant{Name: "Basil"}, nil, 5},
+		"Given ID":     {&models.Plant{ID: 9, Name: "Mint"}, nil, 9},
+		"Duplicate ID": {&models.Plant{ID: 1, Name: "Mint"}, database.DatabaseInvalidDataError("duplicate ID"), 1},
+		"nil data":     {nil, database.DatabaseInvalidDataError("nil data"), 0},
+	}
+	for _, testName := range []string{"Happy path", "Given ID", "Duplicate ID", "nil data"} {
+		testCase := tests[testName]
+		t.Run(testName, func(t *testing.T) {
+			err := driver.CreatePlant(testCase.plant)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if testCase.plant != nil && testCase.plant.ID != testCase.expectedID {
+				t.Errorf("Expected ID %d, got %d", testCase.expectedID, testCase.plant.ID)
+			}
+		})
+	}
+
+	// Created plants accept status data
+	if err := driver.WriteStatus(&models.StatusData{ID: 5, Timestamp: 1516478286}); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+}
+
+func TestMemoryReadPlants(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+	driver.CreatePlant(&models.Plant{ID: 2, Name: "Basil", Species: "Ocimum basilicum"})
+	driver.CreatePlant(&models.Plant{ID: 1, Name: "Mint", Owner: "lucia"})
+
+	plant, err := driver.ReadPlant(2)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := &models.Plant{ID: 2, Name: "Basil", Species: "Ocimum basilicum"}
+	if !reflect.DeepEqual(plant, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, plant)
+	}
+
+	_, err = driver.ReadPlant(3)
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+
+	plants, err := driver.ReadPlants()
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expectedPlants := []*models.Plant{
+		&models.Plant{ID: 1, Name: "Mint", Owner: "lucia"},
+		&models.Plant{ID: 2, Name: "Basil", Species: "Ocimum basilicum"},
+	}
+	if !reflect.DeepEqual(plants, expectedPlants) {
+		t.Errorf("Expected %+v, got %+v", expectedPlants, plants)
+	}
+}
+
+func TestMemoryUpdatePlant(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+
+	tests := map[string]struct {
+		plant    *models.Plant // input
+		expected error         // expected error
+	}{
+		"Happy path": {&models.Plant{ID: 1, Name: "Basil", Location: "Kitchen"}, nil},
+		"Invalid ID": {&models.Plant{ID: 2, Name: "Basil"}, database.DatabaseInvalidDataError("invalid ID")},
+		"nil data":   {nil, database.DatabaseInvalidDataError("nil data")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := driver.UpdatePlant(testCase.plant)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+
+	plant, _ := driver.ReadPlant(1)
+	if plant.Location != "Kitchen" {
+		t.Errorf("Expected %q, got %q", "Kitchen", plant.Location)
+	}
+}
+
+func TestMemoryDeletePlant(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}, 2: []*models.StatusData{}})
+	driver.CreateDevice(&models.Device{ID: 1})
+	driver.WriteBinding(&models.Binding{DeviceID: 1, PlantID: 1, From: 100, To: 200})
+	driver.WriteBinding(&models.Binding{DeviceID: 1, PlantID: 2, From: 200})
+	driver.CreateAlertRule(&models.AlertRule{PlantID: 1, Metric: "humidity"})
+	driver.CreateAlertRule(&models.AlertRule{PlantID: 2, Metric: "humidity"})
+	driver.WriteAlert(&models.Alert{RuleID: 1, PlantID: 1})
+	driver.WriteAlert(&models.Alert{RuleID: 2, PlantID: 2})
+
+	tests := map[string]struct {
+		id       uint  // input
+		expected error // expected error
+	}{
+		"Happy path": {1, nil},
+		"Invalid ID": {3, database.DatabaseInvalidDataError("invalid ID")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := driver.DeletePlant(testCase.id)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+
+	if exists, _ := driver.Exists(1); exists {
+		t.Error("Deleted plant should not exist")
+	}
+
+	// Bindings, rules and alerts of the plant are deleted with it
+	bindings, _ := driver.ReadBindings(1)
+	if expected := []*models.Binding{&models.Binding{DeviceID: 1, PlantID: 2, From: 200}}; !reflect.DeepEqual(bindings, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, bindings)
+	}
+	rules, _ := driver.ReadAlertRules(0)
+	if len(rules) != 1 || rules[0].PlantID != 2 {
+		t.Errorf("Expected the rule of plant 2, got %+v", rules)
+	}
+	alerts, _ := driver.ReadAlerts("")
+	if len(alerts) != 1 || alerts[0].PlantID != 2 {
+		t.Errorf("Expected the alert of plant 2, got %+v", alerts)
+	}
+}
+
+func TestMemoryDevices(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+
+	device := &models.Device{Name: "probe", Token: "secret", TokenHash: "hash"}
+	if err := driver.CreateDevice(device); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if device.ID != 1 {
+		t.Errorf("Expected ID 1, got %d", device.ID)
+	}
+	err := driver.CreateDevice(&models.Device{ID: 1})
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("duplicate ID")) {
+		t.Errorf("Expected duplicate ID, got %+v", err)
+	}
+
+	// Tokens are never stored
+	stored, err := driver.ReadDevice(1)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := &models.Device{ID: 1, Name: "probe", TokenHash: "hash"}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, stored)
+	}
+
+	stored.TokenHash = "other"
+	if err := driver.UpdateDevice(stored); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	devices, _ := driver.ReadDevices()
+	if len(devices) != 1 || devices[0].TokenHash != "other" {
+		t.Errorf("Expected updated device, got %+v", devices)
+	}
+
+	if err := driver.DeleteDevice(1); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	_, err = driver.ReadDevice(1)
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+}
+
+func TestMemoryDeviceEUI(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+	driver.CreateDevice(&models.Device{ID: 1, DevEUI: "70B3D57ED005A1B2", Profile: "cayenne-lpp"})
+	driver.CreateDevice(&models.Device{ID: 2})
+
+	device, err := driver.ReadDeviceByEUI("70B3D57ED005A1B2")
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := &models.Device{ID: 1, DevEUI: "70B3D57ED005A1B2", Profile: "cayenne-lpp"}
+	if !reflect.DeepEqual(device, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, device)
+	}
+	for _, devEUI := range []string{"0000000000000000", ""} {
+		_, err = driver.ReadDeviceByEUI(devEUI)
+		if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+			t.Errorf("Expected invalid ID for %q, got %+v", devEUI, err)
+		}
+	}
+
+	// DevEUIs are unique
+	err = driver.CreateDevice(&models.Device{ID: 3, DevEUI: "70B3D57ED005A1B2"})
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("duplicate DevEUI")) {
+		t.Errorf("Expected duplicate DevEUI, got %+v", err)
+	}
+	err = driver.UpdateDevice(&models.Device{ID: 2, DevEUI: "70B3D57ED005A1B2"})
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("duplicate DevEUI")) {
+		t.Errorf("Expected duplicate DevEUI, got %+v", err)
+	}
+	if err := driver.UpdateDevice(expected); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+}
+
+func TestMemoryBindings(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+	driver.CreateDevice(&models.Device{ID: 1})
+
+	tests := map[string]struct {
+		binding  *models.Binding // input
+		expected error           // expected error
+	}{
+		"Happy path": {&models.Binding{DeviceID: 1, PlantID: 2, From: 100}, nil},
+		"Invalid ID": {&models.Binding{DeviceID: 2, PlantID: 2, From: 100}, database.DatabaseInvalidDataError("invalid ID")},
+		"nil data":   {nil, database.DatabaseInvalidDataError("nil data")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := driver.WriteBinding(testCase.binding)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+
+	if err := driver.CloseBinding(1, 200); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	err := driver.CloseBinding(1, 300)
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("no open binding")) {
+		t.Errorf("Expected no open binding, got %+v", err)
+	}
+
+	bindings, err := driver.ReadBindings(1)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := []*models.Binding{&models.Binding{DeviceID: 1, PlantID: 2, From: 100, To: 200}}
+	if !reflect.DeepEqual(bindings, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, bindings)
+	}
+}
+
+func TestMemoryCalibrations(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+	driver.CreateDevice(&models.Device{ID: 1})
+	later := &models.Calibration{DeviceID: 1, From: 200, Metrics: map[string]models.MetricCalibration{"humidity": {Offset: -2}}}
+	earlier := &models.Calibration{DeviceID: 1, From: 100, Metrics: map[string]models.MetricCalibration{"humidity": {Scale: 1.1}}}
+
+	steps := []struct {
+		name        string              // step name
+		calibration *models.Calibration // input
+		expected    error               // expected error
+	}{
+		{"Later", later, nil},
+		{"Earlier", earlier, nil},
+		{"Invalid ID", &models.Calibration{DeviceID: 2, From: 100}, database.DatabaseInvalidDataError("invalid ID")},
+		{"nil data", nil, database.DatabaseInvalidDataError("nil data")},
+	}
+	for _, step := range steps {
+		if err := driver.WriteCalibration(step.calibration); !reflect.DeepEqual(err, step.expected) {
+			t.Errorf("%s: expected %+v, got %+v", step.name, step.expected, err)
+		}
+	}
+
+	// Profiles are ordered by start time
+	calibrations, err := driver.ReadCalibrations(1)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := []*models.Calibration{earlier, later}
+	if !reflect.DeepEqual(calibrations, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, calibrations)
+	}
+	if _, err := driver.ReadCalibrations(2); !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+}
+
+func TestMemoryAlertRules(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}, 2: []*models.StatusData{}})
+
+	tests := map[string]struct {
+		rule     *models.AlertRule // input
+		expected error             // expected error
+	}{
+		"Happy path":    {&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "<", Threshold: 20}, nil},
+		"Invalid plant": {&models.AlertRule{PlantID: 3, Metric: "humidity", Operator: "<", Threshold: 20}, database.DatabaseInvalidDataError("invalid plant")},
+		"nil data":      {nil, database.DatabaseInvalidDataError("nil data")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := driver.CreateAlertRule(testCase.rule)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+	driver.CreateAlertRule(&models.AlertRule{PlantID: 2, Metric: "light", Operator: ">", Threshold: 100})
+
+	rules, _ := driver.ReadAlertRules(2)
+	expected := []*models.AlertRule{&models.AlertRule{ID: 2, PlantID: 2, Metric: "light", Operator: ">", Threshold: 100}}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, rules)
+	}
+	if rules, _ := driver.ReadAlertRules(0); len(rules) != 2 {
+		t.Errorf("Expected 2 rules, got %d", len(rules))
+	}
+
+	// Deleting a rule deletes its alerts
+	driver.WriteAlert(&models.Alert{RuleID: 2, State: models.AlertFiring})
+	if err := driver.DeleteAlertRule(2); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if alerts, _ := driver.ReadAlerts(""); len(alerts) != 0 {
+		t.Errorf("Expected no alerts, got %+v", alerts)
+	}
+	err := driver.DeleteAlertRule(2)
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+}
+
+func TestMemoryAlerts(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+
+	alert := &models.Alert{RuleID: 1, State: models.AlertPending, Since: 100}
+	if err := driver.WriteAlert(alert); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	driver.WriteAlert(&models.Alert{RuleID: 2, State: models.AlertResolved})
+
+	active, err := driver.ReadActiveAlert(1)
+	if err != nil || !reflect.DeepEqual(active, alert) {
+		t.Errorf("Expected %+v, got %+v (%+v)", alert, active, err)
+	}
+	if active, _ := driver.ReadActiveAlert(2); active != nil {
+		t.Errorf("Expected no active alert, got %+v", active)
+	}
+
+	alert.State = models.AlertFiring
+	if err := driver.WriteAlert(alert); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if err := driver.AcknowledgeAlert(alert.ID); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	firing, _ := driver.ReadAlerts(models.AlertFiring)
+	expected := []*models.Alert{&models.Alert{ID: 1, RuleID: 1, State: models.AlertFiring, Since: 100, Acknowledged: true}}
+	if !reflect.DeepEqual(firing, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, firing)
+	}
+
+	err = driver.WriteAlert(&models.Alert{ID: 9})
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+	err = driver.AcknowledgeAlert(9)
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+}
+
+func TestMemoryWebhooks(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+
+	subscription := &models.Subscription{URL: "http://irrigation.local/hook", Events: []string{"alert.firing"}, Secret: "s3cr3t"}
+	if err := driver.CreateSubscription(subscription); err != nil || subscription.ID != 1 {
+		t.Errorf("Expected ID 1, got %d (%+v)", subscription.ID, err)
+	}
+	driver.CreateSubscription(&models.Subscription{URL: "http://slack.local/hook", Events: []string{"reading.accepted"}})
+
+	deliveries := []*models.Delivery{
+		&models.Delivery{SubscriptionID: 1, State: models.DeliveryPending, NextAttempt: 200},
+		&models.Delivery{SubscriptionID: 1, State: models.DeliveryPending, NextAttempt: 100},
+		&models.Delivery{SubscriptionID: 2, State: models.DeliveryDelivered, NextAttempt: 0},
+		&models.Delivery{SubscriptionID: 2, State: models.DeliveryPending, NextAttempt: 300},
+	}
+	for _, delivery := range deliveries {
+		if err := driver.WriteDelivery(delivery); err != nil {
+			t.Errorf("No error expected, got %+v", err)
+		}
+	}
+
+	tests := map[string]struct {
+		before   int64  // input
+		limit    int    // input
+		expected []uint // expected delivery IDs
+	}{
+		"Oldest first": {250, 10, []uint{2, 1}},
+		"Limited":      {400, 2, []uint{2, 1}},
+		"All due":      {400, 10, []uint{2, 1, 4}},
+		"None due":     {50, 10, []uint{}},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			due, err := driver.ReadDueDeliveries(testCase.before, testCase.limit)
+			if err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+			ids := []uint{}
+			for _, delivery := range due {
+				ids = append(ids, delivery.ID)
+			}
+			if !reflect.DeepEqual(ids, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, ids)
+			}
+		})
+	}
+
+	err := driver.WriteDelivery(&models.Delivery{SubscriptionID: 3})
+	if !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid subscription")) {
+		t.Errorf("Expected invalid subscription, got %+v", err)
+	}
+
+	// Deleting a subscription deletes its deliveries
+	if err := driver.DeleteSubscription(1); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if subscriptions, _ := driver.ReadSubscriptions(); len(subscriptions) != 1 || subscriptions[0].ID != 2 {
+		t.Errorf("Expected subscription 2, got %+v", subscriptions)
+	}
+	if due, _ := driver.ReadDueDeliveries(400, 10); len(due) != 1 || due[0].ID != 4 {
+		t.Errorf("Expected delivery 4, got %+v", due)
+	}
+	if _, err := driver.ReadDeliveries(1); !reflect.DeepEqual(err, database.DatabaseInvalidDataError("invalid ID")) {
+		t.Errorf("Expected invalid ID, got %+v", err)
+	}
+}
+
+func TestMemoryReadStatus(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{
+		1: []*models.StatusData{
+			&models.StatusData{ID: 1, Timestamp: 300},
+			&models.StatusData{ID: 1, Timestamp: 100},
+			&models.StatusData{ID: 1, Timestamp: 200},
+		},
+	})
+
+	tests := map[string]struct {
+		id       uint    // input
+		from     int64   // input
+		to       int64   // input
+		expected []int64 // expected timestamps
+		err      error   // expected error
+	}{
+		"Every reading": {1, 0, 0, []int64{100, 200, 300}, nil},
+		"Range":         {1, 100, 300, []int64{100, 200}, nil},
+		"Empty range":   {1, 400, 0, []int64{}, nil},
+		"Invalid ID":    {2, 0, 0, []int64{}, database.DatabaseInvalidDataError("invalid ID")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			timestamps := []int64{}
+			err := driver.ReadStatus(testCase.id, testCase.from, testCase.to, func(data *models.StatusData) error {
+				timestamps = append(timestamps, data.Timestamp)
+
+				return nil
+			})
+			if !reflect.DeepEqual(err, testCase.err) || !reflect.DeepEqual(timestamps, testCase.expected) {
+				t.Errorf("Expected %+v (%+v), got %+v (%+v)", testCase.expected, testCase.err, timestamps, err)
+			}
+		})
+	}
+}
+
+func TestMemoryDuplicates(t *testing.T) {
+	first := map[string]models.Metric{"temperature": {Value: 20, Unit: "°C"}}
+	second := map[string]models.Metric{"temperature": {Value: 35, Unit: "°C"}}
+	duplicate := database.DatabaseDuplicateError{Message: "duplicate reading"}
+	ignored := database.DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}
+	replayed := database.DatabaseDuplicateError{Message: "duplicate idempotency key", Ignored: true}
+
+	// Every policy writes the same steps, on a reading stored at time 100 with key "a"
+	steps := []struct {
+		name   string             // step name
+		data   *models.StatusData // input
+		errors map[database.DuplicatePolicy]error
+	}{
+		{"Retry", &models.StatusData{ID: 1, Timestamp: 100, Metrics: first}, map[database.DuplicatePolicy]error{
+			database.DuplicateOverwrite: ignored, database.DuplicateIgnore: ignored, database.DuplicateReject: ignored,
+		}},
+		{"Replayed key", &models.StatusData{ID: 1, Timestamp: 200, Metrics: second, IdempotencyKey: "a"}, map[database.DuplicatePolicy]error{
+			database.DuplicateOverwrite: replayed, database.DuplicateIgnore: replayed, database.DuplicateReject: replayed,
+		}},
+		{"Key of another plant", &models.StatusData{ID: 2, Timestamp: 100, Metrics: first, IdempotencyKey: "a"}, map[database.DuplicatePolicy]error{}},
+		{"Duplicate", &models.StatusData{ID: 1, Timestamp: 100, Metrics: second, IdempotencyKey: "b"}, map[database.DuplicatePolicy]error{
+			database.DuplicateIgnore: ignored, database.DuplicateReject: duplicate,
+		}},
+		{"Rejected key", &models.StatusData{ID: 1, Timestamp: 300, Metrics: first, IdempotencyKey: "b"}, map[database.DuplicatePolicy]error{
+			database.DuplicateOverwrite: replayed, database.DuplicateIgnore: replayed,
+		}},
+	}
+	expected := map[database.DuplicatePolicy][]*models.StatusData{
+		database.DuplicateOverwrite: {{ID: 1, Timestamp: 100, Metrics: second}},
+		database.DuplicateIgnore:    {{ID: 1, Timestamp: 100, Metrics: first}},
+		database.DuplicateReject:    {{ID: 1, Timestamp: 100, Metrics: first}, {ID: 1, Timestamp: 300, Metrics: first}},
+	}
+
+	for policy, expectedData := range expected {
+		t.Run(string(policy), func(t *testing.T) {
+			driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: {}, 2: {}})
+			driver.Duplicates = policy
+			if err := driver.WriteStatus(&models.StatusData{ID: 1, Timestamp: 100, Metrics: first, IdempotencyKey: "a"}); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			for _, step := range steps {
+				if err := driver.WriteStatus(step.data); !reflect.DeepEqual(err, step.errors[policy]) {
+					t.Errorf("%s: expected %+v, got %+v", step.name, step.errors[policy], err)
+				}
+			}
+
+			var stored []*models.StatusData
+			driver.ReadStatus(1, 0, 0, func(data *models.StatusData) error {
+				stored = append(stored, data)
+
+				return nil
+			})
+			if !reflect.DeepEqual(stored, expectedData) {
+				t.Errorf("Expected %+v, got %+v", expectedData, stored)
+			}
+		})
+	}
+}
@@ -2,35 +2,116 @@ package database
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/berry-house/http_broker/models"
-	_ "github.com/go-sql-driver/mysql" // MySQL
+	"github.com/go-sql-driver/mysql"
 )
 
 const (
-	plantQuery   = `SELECT COUNT(*) FROM plant WHERE id = ?;`
-	statusInsert = `REPLACE INTO
-						conditions(plantID, time, lightIntensity, soilHumidity, airTemperature)
-					VALUES(?, ?, ?, ?, ?);`
+	plantQuery            = `SELECT COUNT(*) FROM plant WHERE id = ?;`
+	readingInsert         = `INSERT IGNORE INTO reading(plantID, time, receivedAt) VALUES(?, ?, ?);`
+	readingSelect         = `SELECT id FROM reading WHERE plantID = ? AND time = ? FOR UPDATE;`
+	readingReceivedUpdate = `UPDATE reading SET receivedAt = ? WHERE id = ?;`
+	readingKeyInsert      = `INSERT IGNORE INTO readingKey(plantID, idempotencyKey, time) VALUES(?, ?, ?);`
+	readingMetricsSelect  = `SELECT metric, value, unit FROM readingMetric WHERE readingID = ?;`
+	readingMetricsDelete  = `DELETE FROM readingMetric WHERE readingID = ?;`
+	readingMetricInsert   = `INSERT INTO readingMetric(readingID, metric, value, unit, raw, quality) VALUES(?, ?, ?, ?, ?, ?);`
+	readingsSelect        = `SELECT r.id, UNIX_TIMESTAMP(r.time), r.receivedAt, m.metric, m.value, m.unit, m.raw, m.quality
+					FROM reading r
+					LEFT JOIN readingMetric m ON m.readingID = r.id
+					WHERE r.plantID = ? AND r.time >= FROM_UNIXTIME(?) AND (? = 0 OR r.time < FROM_UNIXTIME(?))
+					ORDER BY r.time, r.id;`
+	plantInsert        = `INSERT INTO plant(name, species, location, owner) VALUES(?, ?, ?, ?);`
+	plantInsertWithID  = `INSERT INTO plant(id, name, species, location, owner) VALUES(?, ?, ?, ?, ?);`
+	plantSelect        = `SELECT id, name, species, location, owner FROM plant WHERE id = ?;`
+	plantsSelect       = `SELECT id, name, species, location, owner FROM plant ORDER BY id;`
+	plantUpdate        = `UPDATE plant SET name = ?, species = ?, location = ?, owner = ? WHERE id = ?;`
+	plantDelete        = `DELETE FROM plant WHERE id = ?;`
+	deviceColumns      = `id, name, tokenHash, COALESCE(devEUI, ''), profile, reportInterval`
+	deviceInsert       = `INSERT INTO device(name, tokenHash, devEUI, profile, reportInterval) VALUES(?, ?, NULLIF(?, ''), ?, ?);`
+	deviceInsertWithID = `INSERT INTO device(id, name, tokenHash, devEUI, profile, reportInterval) VALUES(?, ?, ?, NULLIF(?, ''), ?, ?);`
+	deviceSelect       = `SELECT ` + deviceColumns + ` FROM device WHERE id = ?;`
+	deviceEUISelect    = `SELECT ` + deviceColumns + ` FROM device WHERE devEUI = ?;`
+	devicesSelect      = `SELECT ` + deviceColumns + ` FROM device ORDER BY id;`
+	deviceUpdate       = `UPDATE device SET name = ?, tokenHash = ?, devEUI = NULLIF(?, ''), profile = ?, reportInterval = ? WHERE id = ?;`
+	deviceDelete       = `DELETE FROM device WHERE id = ?;`
+	bindingsSelect     = `SELECT deviceID, plantID, fromTime, COALESCE(toTime, 0) FROM deviceBinding
+					WHERE deviceID = ? ORDER BY fromTime;`
+	bindingInsert      = `INSERT INTO deviceBinding(deviceID, plantID, fromTime, toTime) VALUES(?, ?, ?, NULLIF(?, 0));`
+	bindingClose       = `UPDATE deviceBinding SET toTime = ? WHERE deviceID = ? AND toTime IS NULL;`
+	calibrationsSelect = `SELECT deviceID, fromTime, metrics FROM deviceCalibration WHERE deviceID = ? ORDER BY fromTime;`
+	calibrationInsert  = `INSERT INTO deviceCalibration(deviceID, fromTime, metrics) VALUES(?, ?, ?);`
+	alertRuleInsert    = `INSERT INTO alertRule(plantID, metric, operator, threshold, forSeconds, hysteresis)
+					VALUES(?, ?, ?, ?, ?, ?);`
+	alertRulesSelect = `SELECT id, plantID, metric, operator, threshold, forSeconds, hysteresis FROM alertRule
+					WHERE ? = 0 OR plantID = ? ORDER BY id;`
+	alertRuleDelete   = `DELETE FROM alertRule WHERE id = ?;`
+	alertColumns      = `id, ruleID, plantID, metric, state, value, since, firedAt, resolvedAt, acknowledged`
+	alertActiveSelect = `SELECT ` + alertColumns + ` FROM alert WHERE ruleID = ? AND state <> 'resolved' LIMIT 1;`
+	alertsSelect      = `SELECT ` + alertColumns + ` FROM alert WHERE ? = '' OR state = ? ORDER BY id;`
+	alertInsert       = `INSERT INTO alert(ruleID, plantID, metric, state, value, since, firedAt, resolvedAt, acknowledged)
+					VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	alertUpdate = `UPDATE alert SET state = ?, value = ?, since = ?, firedAt = ?, resolvedAt = ?, acknowledged = ?
+					WHERE id = ?;`
+	alertAcknowledge    = `UPDATE alert SET acknowledged = TRUE WHERE id = ?;`
+	alertQuery          = `SELECT COUNT(*) FROM alert WHERE id = ?;`
+	subscriptionInsert  = `INSERT INTO webhookSubscription(url, events, secret) VALUES(?, ?, ?);`
+	subscriptionsSelect = `SELECT id, url, events, secret FROM webhookSubscription ORDER BY id;`
+	subscriptionDelete  = `DELETE FROM webhookSubscription WHERE id = ?;`
+	subscriptionQuery   = `SELECT COUNT(*) FROM webhookSubscription WHERE id = ?;`
+	deliveryColumns     = `id, subscriptionID, eventType, payload, state, attempts, nextAttempt, lastStatus, lastError,
+					createdAt, deliveredAt`
+	deliveryInsert = `INSERT INTO webhookDelivery(subscriptionID, eventType, payload, state, attempts, nextAttempt,
+					lastStatus, lastError, createdAt, deliveredAt) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	deliveryUpdate = `UPDATE webhookDelivery SET state = ?, attempts = ?, nextAttempt = ?, lastStatus = ?, lastError = ?,
+					deliveredAt = ? WHERE id = ?;`
+	deliveryQuery       = `SELECT COUNT(*) FROM webhookDelivery WHERE id = ?;`
+	deliveriesSelect    = `SELECT ` + deliveryColumns + ` FROM webhookDelivery WHERE subscriptionID = ? ORDER BY id;`
+	deliveriesDueSelect = `SELECT ` + deliveryColumns + ` FROM webhookDelivery
+					WHERE state = 'pending' AND nextAttempt <= ? ORDER BY nextAttempt, id LIMIT ?;`
 )
 
 // MySQL is a MySQL database driver
 type MySQL struct {
+	// Duplicates is the policy for readings at the time of a stored one, defaulting to DuplicateOverwrite
+	Duplicates DuplicatePolicy
+
 	database *sql.DB
 }
 
-// NewMySQL creates a new MySQL driver
+var _ Database = (*MySQL)(nil)
+var _ StatusReader = (*MySQL)(nil)
+var _ PlantStore = (*MySQL)(nil)
+var _ DeviceStore = (*MySQL)(nil)
+var _ AlertStore = (*MySQL)(nil)
+var _ WebhookStore = (*MySQL)(nil)
+
+// mysqlDatetime is the layout of DATETIME values, which are stored in UTC
+const mysqlDatetime = "2006-01-02 15:04:05"
+
+// NewMySQL creates a new MySQL driver.
+// Sessions use UTC, so times do not depend on the time zone of the server.
 func NewMySQL(conn string) (*MySQL, error) {
-	db, err := sql.Open("mysql", conn)
+	config, err := mysql.ParseDSN(conn)
 	if err != nil {
-		fmt.Println(err)
-		return nil, DatabaseUnexpectedError(err.Error())
+		return nil, DatabaseInvalidDataError(err.Error())
+	}
+	if config.Params == nil {
+		config.Params = map[string]string{}
+	}
+	config.Params["time_zone"] = "'+00:00'"
+	config.Loc = time.UTC
+
+	db, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
 	}
 	err = db.Ping()
 	if err != nil {
-		return nil, DatabaseUnexpectedError(err.Error())
+		return nil, DatabaseQueryError{Err: err}
 	}
 
 	return &MySQL{database: db}, nil
@@ -41,13 +122,14 @@ func (d *MySQL) Exists(id uint) (bool, error) {
 	var rowsNumber int
 	err := d.database.QueryRow(plantQuery, id).Scan(&rowsNumber)
 	if err != nil {
-		return false, DatabaseUnexpectedError(err.Error())
+		return false, DatabaseQueryError{Err: err}
 	}
 
 	return rowsNumber != 0, nil
 }
 
-// WriteStatus writes status data into memory
+// WriteStatus writes status data into the database.
+// A reading at the time of a stored one of its plant is handled by the duplicate policy.
 func (d *MySQL) WriteStatus(temp *models.StatusData) error {
 	if d == nil {
 		return DatabaseUnexpectedError("nil driver")
@@ -59,24 +141,859 @@ func (d *MySQL) WriteStatus(temp *models.StatusData) error {
 	// Check if ID is valid
 	exists, err := d.Exists(temp.ID)
 	if err != nil {
-		return DatabaseUnexpectedError(err.Error())
+		return err
 	}
 	if !exists {
 		return DatabaseInvalidDataError("non-existent ID")
 	}
 
 	// Insert
-	timestamp := time.Unix(temp.Timestamp, 0)
-	timestampString := fmt.Sprintf("%d-%02d-%02d %02d:%02d:%02d",
-		timestamp.Year(), int(timestamp.Month()), timestamp.Day(), timestamp.Hour(), timestamp.Minute(), timestamp.Second())
-	insert, err := d.database.Prepare(statusInsert)
+	timestampString := time.Unix(temp.Timestamp, 0).UTC().Format(mysqlDatetime)
+	tx, err := d.database.Begin()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	defer tx.Rollback()
+
+	// Retries of a write with a stored idempotency key are acknowledged without writing
+	if temp.IdempotencyKey != "" {
+		result, err := tx.Exec(readingKeyInsert, temp.ID, temp.IdempotencyKey, timestampString)
+		if err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+		if inserted == 0 {
+			return DatabaseDuplicateError{Message: "duplicate idempotency key", Ignored: true}
+		}
+	}
+
+	// The insert is ignored when a reading of the plant is stored at that time, which is then
+	// looked up and locked. Ignored inserts affect no rows whatever the clientFoundRows setting.
+	result, err := tx.Exec(readingInsert, temp.ID, timestampString, temp.ReceivedAt)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	var readingID int64
+	if inserted != 0 {
+		readingID, err = result.LastInsertId()
+	} else {
+		err = tx.QueryRow(readingSelect, temp.ID, timestampString).Scan(&readingID)
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		// Ignored for another reason, such as the plant being deleted meanwhile
+		return DatabaseInvalidDataError("non-existent ID")
+	case err != nil:
+		return DatabaseQueryError{Err: err}
+	}
+	var duplicate error
+	if inserted == 0 {
+		stored, err := readingMetrics(tx, readingID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sameMetrics(stored, temp.Metrics), d.Duplicates == DuplicateIgnore:
+			duplicate = DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}
+		case d.Duplicates == DuplicateReject:
+			return DatabaseDuplicateError{Message: "duplicate reading"}
+		}
+	}
+	if duplicate == nil {
+		// A reading replaces every metric of a previous one with the same timestamp
+		if inserted == 0 {
+			if _, err = tx.Exec(readingReceivedUpdate, temp.ReceivedAt, readingID); err != nil {
+				return DatabaseQueryError{Err: err}
+			}
+		}
+		if _, err = tx.Exec(readingMetricsDelete, readingID); err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+		for name, metric := range temp.Metrics {
+			raw, calibrated := temp.Raw[name]
+			_, err = tx.Exec(readingMetricInsert, readingID, name, metric.Value, metric.Unit,
+				sql.NullFloat64{Float64: raw, Valid: calibrated}, temp.Quality[name])
+			if err != nil {
+				return DatabaseQueryError{Err: err}
+			}
+		}
+	}
+	// Ignored duplicates still commit their idempotency key
+	if err = tx.Commit(); err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+
+	return duplicate
+}
+
+// readingMetrics reads the metrics of a stored reading within a transaction
+func readingMetrics(tx *sql.Tx, readingID int64) (map[string]models.Metric, error) {
+	rows, err := tx.Query(readingMetricsSelect, readingID)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	metrics := map[string]models.Metric{}
+	for rows.Next() {
+		var name string
+		var metric models.Metric
+		if err := rows.Scan(&name, &metric.Value, &metric.Unit); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		metrics[name] = metric
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return metrics, nil
+}
+
+// ReadStatus reads the status data of a plant in a time range, in time order.
+// Rows hold one metric each, so consecutive rows of a reading are merged.
+func (d *MySQL) ReadStatus(id uint, from, to int64, fn func(data *models.StatusData) error) error {
+	exists, err := d.Exists(id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+
+	rows, err := d.database.Query(readingsSelect, id, from, to, to)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	var current *models.StatusData
+	var currentID uint64
+	for rows.Next() {
+		var readingID uint64
+		var timestamp, receivedAt int64
+		var metric, unit, quality sql.NullString
+		var value, raw sql.NullFloat64
+		if err := rows.Scan(&readingID, &timestamp, &receivedAt, &metric, &value, &unit, &raw, &quality); err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+		if current == nil || readingID != currentID {
+			if current != nil {
+				if err := fn(current); err != nil {
+					return err
+				}
+			}
+			current = &models.StatusData{ID: id, Timestamp: timestamp, ReceivedAt: receivedAt}
+			currentID = readingID
+		}
+		if metric.Valid {
+			if current.Metrics == nil {
+				current.Metrics = map[string]models.Metric{}
+			}
+			current.Metrics[metric.String] = models.Metric{Value: value.Float64, Unit: unit.String}
+		}
+		if raw.Valid {
+			if current.Raw == nil {
+				current.Raw = map[string]float64{}
+			}
+			current.Raw[metric.String] = raw.Float64
+		}
+		if quality.String != "" {
+			if current.Quality == nil {
+				current.Quality = map[string]string{}
+			}
+			current.Quality[metric.String] = quality.String
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if current != nil {
+		return fn(current)
+	}
+
+	return nil
+}
+
+// CreatePlant inserts a plant, assigning an ID if none is given
+func (d *MySQL) CreatePlant(plant *models.Plant) error {
+	if plant == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if plant.ID != 0 {
+		exists, err := d.Exists(plant.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return DatabaseInvalidDataError("duplicate ID")
+		}
+		_, err = d.database.Exec(plantInsertWithID, plant.ID, plant.Name, plant.Species, plant.Location, plant.Owner)
+		if err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+
+		return nil
+	}
+
+	result, err := d.database.Exec(plantInsert, plant.Name, plant.Species, plant.Location, plant.Owner)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	plant.ID = uint(id)
+
+	return nil
+}
+
+// ReadPlant reads a plant
+func (d *MySQL) ReadPlant(id uint) (*models.Plant, error) {
+	var plant models.Plant
+	err := d.database.QueryRow(plantSelect, id).Scan(&plant.ID, &plant.Name, &plant.Species, &plant.Location, &plant.Owner)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, DatabaseInvalidDataError("invalid ID")
+	case err != nil:
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return &plant, nil
+}
+
+// ReadPlants reads every plant, ordered by ID
+func (d *MySQL) ReadPlants() ([]*models.Plant, error) {
+	rows, err := d.database.Query(plantsSelect)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	plants := []*models.Plant{}
+	for rows.Next() {
+		var plant models.Plant
+		if err := rows.Scan(&plant.ID, &plant.Name, &plant.Species, &plant.Location, &plant.Owner); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		plants = append(plants, &plant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return plants, nil
+}
+
+// UpdatePlant updates a plant
+func (d *MySQL) UpdatePlant(plant *models.Plant) error {
+	if plant == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	exists, err := d.Exists(plant.ID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+	_, err = d.database.Exec(plantUpdate, plant.Name, plant.Species, plant.Location, plant.Owner, plant.ID)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+
+	return nil
+}
+
+// DeletePlant deletes a plant
+func (d *MySQL) DeletePlant(id uint) error {
+	result, err := d.database.Exec(plantDelete, id)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if affected == 0 {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+
+	return nil
+}
+
+// CreateDevice inserts a device, assigning an ID if none is given
+func (d *MySQL) CreateDevice(device *models.Device) error {
+	if device == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if device.ID != 0 {
+		if _, err := d.ReadDevice(device.ID); err == nil {
+			return DatabaseInvalidDataError("duplicate ID")
+		} else if _, ok := err.(DatabaseInvalidDataError); !ok {
+			return err
+		}
+		_, err := d.database.Exec(deviceInsertWithID, device.ID, device.Name, device.TokenHash, device.DevEUI, device.Profile, device.Interval)
+		if err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+
+		return nil
+	}
+
+	result, err := d.database.Exec(deviceInsert, device.Name, device.TokenHash, device.DevEUI, device.Profile, device.Interval)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	device.ID = uint(id)
+
+	return nil
+}
+
+// ReadDevice reads a device
+func (d *MySQL) ReadDevice(id uint) (*models.Device, error) {
+	return d.queryDevice(deviceSelect, id)
+}
+
+// ReadDeviceByEUI reads the device with a LoRaWAN DevEUI
+func (d *MySQL) ReadDeviceByEUI(devEUI string) (*models.Device, error) {
+	return d.queryDevice(deviceEUISelect, devEUI)
+}
+
+// ReadDevices reads every device, ordered by ID
+func (d *MySQL) ReadDevices() ([]*models.Device, error) {
+	rows, err := d.database.Query(devicesSelect)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	devices := []*models.Device{}
+	for rows.Next() {
+		var device models.Device
+		if err := rows.Scan(&device.ID, &device.Name, &device.TokenHash, &device.DevEUI, &device.Profile, &device.Interval); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		devices = append(devices, &device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return devices, nil
+}
+
+// UpdateDevice updates a device
+func (d *MySQL) UpdateDevice(device *models.Device) error {
+	if device == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if _, err := d.ReadDevice(device.ID); err != nil {
+		return err
+	}
+	_, err := d.database.Exec(deviceUpdate, device.Name, device.TokenHash, device.DevEUI, device.Profile, device.Interval, device.ID)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+
+	return nil
+}
+
+// queryDevice reads the device selected by a query
+func (d *MySQL) queryDevice(query string, args ...interface{}) (*models.Device, error) {
+	var device models.Device
+	err := d.database.QueryRow(query, args...).Scan(&device.ID, &device.Name, &device.TokenHash, &device.DevEUI, &device.Profile, &device.Interval)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, DatabaseInvalidDataError("invalid ID")
+	case err != nil:
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return &device, nil
+}
+
+// DeleteDevice deletes a device
+func (d *MySQL) DeleteDevice(id uint) error {
+	result, err := d.database.Exec(deviceDelete, id)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if affected == 0 {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+
+	return nil
+}
+
+// ReadBindings reads the bindings of a device, ordered by start time
+func (d *MySQL) ReadBindings(deviceID uint) ([]*models.Binding, error) {
+	if _, err := d.ReadDevice(deviceID); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.database.Query(bindingsSelect, deviceID)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	bindings := []*models.Binding{}
+	for rows.Next() {
+		var binding models.Binding
+		if err := rows.Scan(&binding.DeviceID, &binding.PlantID, &binding.From, &binding.To); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		bindings = append(bindings, &binding)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return bindings, nil
+}
+
+// WriteBinding inserts a binding
+func (d *MySQL) WriteBinding(binding *models.Binding) error {
+	if binding == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if _, err := d.ReadDevice(binding.DeviceID); err != nil {
+		return err
+	}
+	_, err := d.database.Exec(bindingInsert, binding.DeviceID, binding.PlantID, binding.From, binding.To)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+
+	return nil
+}
+
+// CloseBinding closes the open binding of a device
+func (d *MySQL) CloseBinding(deviceID uint, to int64) error {
+	result, err := d.database.Exec(bindingClose, to, deviceID)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if affected == 0 {
+		return DatabaseInvalidDataError("no open binding")
+	}
+
+	return nil
+}
+
+// ReadCalibrations reads the calibration profiles of a device, ordered by start time
+func (d *MySQL) ReadCalibrations(deviceID uint) ([]*models.Calibration, error) {
+	if _, err := d.ReadDevice(deviceID); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.database.Query(calibrationsSelect, deviceID)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	calibrations := []*models.Calibration{}
+	for rows.Next() {
+		var calibration models.Calibration
+		var metrics []byte
+		if err := rows.Scan(&calibration.DeviceID, &calibration.From, &metrics); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		if err := json.Unmarshal(metrics, &calibration.Metrics); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		calibrations = append(calibrations, &calibration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return calibrations, nil
+}
+
+// WriteCalibration inserts a calibration profile, its metric corrections stored as JSON
+func (d *MySQL) WriteCalibration(calibration *models.Calibration) error {
+	if calibration == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if _, err := d.ReadDevice(calibration.DeviceID); err != nil {
+		return err
+	}
+	metrics, err := json.Marshal(calibration.Metrics)
+	if err != nil {
+		return DatabaseInvalidDataError(err.Error())
+	}
+	if _, err := d.database.Exec(calibrationInsert, calibration.DeviceID, calibration.From, metrics); err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+
+	return nil
+}
+
+// CreateAlertRule inserts an alert rule, assigning its ID
+func (d *MySQL) CreateAlertRule(rule *models.AlertRule) error {
+	if rule == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	exists, err := d.Exists(rule.PlantID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return DatabaseInvalidDataError("invalid plant")
+	}
+	result, err := d.database.Exec(alertRuleInsert,
+		rule.PlantID, rule.Metric, rule.Operator, rule.Threshold, rule.For, rule.Hysteresis)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	rule.ID = uint(id)
+
+	return nil
+}
+
+// ReadAlertRules reads the alert rules of a plant, or every rule for plant 0
+func (d *MySQL) ReadAlertRules(plantID uint) ([]*models.AlertRule, error) {
+	rows, err := d.database.Query(alertRulesSelect, plantID, plantID)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	rules := []*models.AlertRule{}
+	for rows.Next() {
+		var rule models.AlertRule
+		err := rows.Scan(&rule.ID, &rule.PlantID, &rule.Metric, &rule.Operator, &rule.Threshold, &rule.For, &rule.Hysteresis)
+		if err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		rules = append(rules, &rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return rules, nil
+}
+
+// DeleteAlertRule deletes an alert rule and, by cascade, its alerts
+func (d *MySQL) DeleteAlertRule(id uint) error {
+	result, err := d.database.Exec(alertRuleDelete, id)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if affected == 0 {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+
+	return nil
+}
+
+// ReadActiveAlert reads the pending or firing alert of a rule, if any
+func (d *MySQL) ReadActiveAlert(ruleID uint) (*models.Alert, error) {
+	alert, err := scanAlert(d.database.QueryRow(alertActiveSelect, ruleID))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return alert, nil
+}
+
+// ReadAlerts reads the alerts in a state, or every alert for an empty state
+func (d *MySQL) ReadAlerts(state string) ([]*models.Alert, error) {
+	rows, err := d.database.Query(alertsSelect, state, state)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	alerts := []*models.Alert{}
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		alerts = append(alerts, alert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return alerts, nil
+}
+
+// WriteAlert inserts a new alert, assigning its ID, or updates an existing one
+func (d *MySQL) WriteAlert(alert *models.Alert) error {
+	if alert == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if alert.ID != 0 {
+		result, err := d.database.Exec(alertUpdate, alert.State, alert.Value, alert.Since,
+			alert.FiredAt, alert.ResolvedAt, alert.Acknowledged, alert.ID)
+		if err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+
+		return d.checkUpdate(result, alertQuery, alert.ID)
+	}
+
+	result, err := d.database.Exec(alertInsert, alert.RuleID, alert.PlantID, alert.Metric, alert.State,
+		alert.Value, alert.Since, alert.FiredAt, alert.ResolvedAt, alert.Acknowledged)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	alert.ID = uint(id)
+
+	return nil
+}
+
+// AcknowledgeAlert acknowledges an alert
+func (d *MySQL) AcknowledgeAlert(id uint) error {
+	result, err := d.database.Exec(alertAcknowledge, id)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+
+	return d.checkUpdate(result, alertQuery, id)
+}
+
+// checkUpdate checks that an updated row exists, looking it up with a COUNT query.
+// Unchanged rows are not affected, so they are looked up.
+func (d *MySQL) checkUpdate(result sql.Result, query string, id uint) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if affected != 0 {
+		return nil
+	}
+
+	exists, err := d.count(query, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+
+	return nil
+}
+
+// CreateSubscription inserts a webhook subscription, assigning its ID.
+// Event types are stored comma-separated.
+func (d *MySQL) CreateSubscription(subscription *models.Subscription) error {
+	if subscription == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	result, err := d.database.Exec(subscriptionInsert,
+		subscription.URL, strings.Join(subscription.Events, ","), subscription.Secret)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	subscription.ID = uint(id)
+
+	return nil
+}
+
+// ReadSubscriptions reads every webhook subscription, ordered by ID
+func (d *MySQL) ReadSubscriptions() ([]*models.Subscription, error) {
+	rows, err := d.database.Query(subscriptionsSelect)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	subscriptions := []*models.Subscription{}
+	for rows.Next() {
+		var subscription models.Subscription
+		var events string
+		if err := rows.Scan(&subscription.ID, &subscription.URL, &events, &subscription.Secret); err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		subscription.Events = strings.Split(events, ",")
+		subscriptions = append(subscriptions, &subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return subscriptions, nil
+}
+
+// DeleteSubscription deletes a webhook subscription and, by cascade, its deliveries
+func (d *MySQL) DeleteSubscription(id uint) error {
+	result, err := d.database.Exec(subscriptionDelete, id)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	if affected == 0 {
+		return DatabaseInvalidDataError("invalid ID")
+	}
+
+	return nil
+}
+
+// WriteDelivery inserts a new webhook delivery, assigning its ID, or updates an existing one
+func (d *MySQL) WriteDelivery(delivery *models.Delivery) error {
+	if delivery == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+
+	if delivery.ID != 0 {
+		result, err := d.database.Exec(deliveryUpdate, delivery.State, delivery.Attempts, delivery.NextAttempt,
+			delivery.LastStatus, delivery.LastError, delivery.DeliveredAt, delivery.ID)
+		if err != nil {
+			return DatabaseQueryError{Err: err}
+		}
+
+		return d.checkUpdate(result, deliveryQuery, delivery.ID)
+	}
+
+	exists, err := d.count(subscriptionQuery, delivery.SubscriptionID)
 	if err != nil {
-		return DatabaseUnexpectedError(err.Error())
+		return err
 	}
-	_, err = insert.Exec(temp.ID, timestampString, temp.Light, temp.Humidity, temp.Temperature)
+	if !exists {
+		return DatabaseInvalidDataError("invalid subscription")
+	}
+	result, err := d.database.Exec(deliveryInsert, delivery.SubscriptionID, delivery.EventType,
+		[]byte(delivery.Payload), delivery.State, delivery.Attempts, delivery.NextAttempt,
+		delivery.LastStatus, delivery.LastError, delivery.CreatedAt, delivery.DeliveredAt)
+	if err != nil {
+		return DatabaseQueryError{Err: err}
+	}
+	id, err := result.LastInsertId()
 	if err != nil {
-		return DatabaseUnexpectedError(err.Error())
+		return DatabaseQueryError{Err: err}
 	}
+	delivery.ID = uint(id)
 
 	return nil
 }
+
+// ReadDeliveries reads the deliveries of a webhook subscription, ordered by ID
+func (d *MySQL) ReadDeliveries(subscriptionID uint) ([]*models.Delivery, error) {
+	exists, err := d.count(subscriptionQuery, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, DatabaseInvalidDataError("invalid ID")
+	}
+
+	return d.queryDeliveries(deliveriesSelect, subscriptionID)
+}
+
+// ReadDueDeliveries reads up to limit pending deliveries due before a time, oldest first
+func (d *MySQL) ReadDueDeliveries(before int64, limit int) ([]*models.Delivery, error) {
+	return d.queryDeliveries(deliveriesDueSelect, before, limit)
+}
+
+// queryDeliveries reads the deliveries selected with deliveryColumns
+func (d *MySQL) queryDeliveries(query string, args ...interface{}) ([]*models.Delivery, error) {
+	rows, err := d.database.Query(query, args...)
+	if err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+	defer rows.Close()
+
+	deliveries := []*models.Delivery{}
+	for rows.Next() {
+		var delivery models.Delivery
+		var payload []byte
+		err := rows.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventType, &payload, &delivery.State,
+			&delivery.Attempts, &delivery.NextAttempt, &delivery.LastStatus, &delivery.LastError,
+			&delivery.CreatedAt, &delivery.DeliveredAt)
+		if err != nil {
+			return nil, DatabaseQueryError{Err: err}
+		}
+		delivery.Payload = payload
+		deliveries = append(deliveries, &delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, DatabaseQueryError{Err: err}
+	}
+
+	return deliveries, nil
+}
+
+// count checks if a COUNT query on an ID matches any row
+func (d *MySQL) count(query string, id uint) (bool, error) {
+	var count int
+	if err := d.database.QueryRow(query, id).Scan(&count); err != nil {
+		return false, DatabaseQueryError{Err: err}
+	}
+
+	return count != 0, nil
+}
+
+// scanner is implemented by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAlert scans an alert row selected with alertColumns
+func scanAlert(row scanner) (*models.Alert, error) {
+	var alert models.Alert
+	err := row.Scan(&alert.ID, &alert.RuleID, &alert.PlantID, &alert.Metric, &alert.State, &alert.Value,
+		&alert.Since, &alert.FiredAt, &alert.ResolvedAt, &alert.Acknowledged)
+	if err != nil {
+		return nil, err
+	}
+
+	return &alert, nil
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/models"
+)
+
+// Connector mock, whose connections fail every statement with err
+type mockFailingConnector struct {
+	err error
+}
+
+func (c *mockFailingConnector) Connect(context.Context) (driver.Conn, error) {
+	return &mockFailingConn{c.err}, nil
+}
+func (c *mockFailingConnector) Driver() driver.Driver { return nil }
+
+type mockFailingConn struct {
+	err error
+}
+
+func (c *mockFailingConn) Prepare(query string) (driver.Stmt, error) { return nil, c.err }
+func (c *mockFailingConn) Close() error                              { return nil }
+func (c *mockFailingConn) Begin() (driver.Tx, error)                 { return nil, c.err }
+
+func TestMySQLWriteStatusExistsError(t *testing.T) {
+	d := &MySQL{database: sql.OpenDB(&mockFailingConnector{err: driver.ErrBadConn})}
+	defer d.database.Close()
+
+	// Errors of the plant check are returned as is, so dropped connections are retried
+	err := d.WriteStatus(&models.StatusData{ID: 1, Timestamp: 100})
+	if expected := (DatabaseQueryError{Err: driver.ErrBadConn}); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+	if !transient(err) {
+		t.Errorf("Expected %+v to be transient", err)
+	}
+}
+
+// Connector mock, answering statements with a script keyed by query
+type mockScriptConnector struct {
+	script   map[string]mockScriptAnswer
+	executed []string
+}
+
+// mockScriptAnswer is the rows affected or rows returned by a scripted statement
+type mockScriptAnswer struct {
+	affected int64
+	rows     [][]driver.Value
+}
+
+func (c *mockScriptConnector) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *mockScriptConnector) Driver() driver.Driver                        { return nil }
+func (c *mockScriptConnector) Close() error                                 { return nil }
+func (c *mockScriptConnector) Begin() (driver.Tx, error)                    { return c, nil }
+func (c *mockScriptConnector) Commit() error                                { return nil }
+func (c *mockScriptConnector) Rollback() error                              { return nil }
+
+func (c *mockScriptConnector) Prepare(query string) (driver.Stmt, error) {
+	return &mockScriptStmt{connector: c, query: query}, nil
+}
+
+type mockScriptStmt struct {
+	connector *mockScriptConnector
+	query     string
+}
+
+func (s *mockScriptStmt) Close() error  { return nil }
+func (s *mockScriptStmt) NumInput() int { return -1 }
+
+func (s *mockScriptStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.connector.executed = append(s.connector.executed, s.query)
+
+	return mockScriptResult(s.connector.script[s.query].affected), nil
+}
+
+// mockScriptResult is the result of a scripted statement, inserting rows from ID 1
+type mockScriptResult int64
+
+func (r mockScriptResult) LastInsertId() (int64, error) { return 1, nil }
+func (r mockScriptResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func (s *mockScriptStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.connector.executed = append(s.connector.executed, s.query)
+
+	return &mockScriptRows{rows: s.connector.script[s.query].rows}, nil
+}
+
+type mockScriptRows struct {
+	rows [][]driver.Value
+}
+
+func (r *mockScriptRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"id"}
+	}
+
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *mockScriptRows) Close() error { return nil }
+
+func (r *mockScriptRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func TestMySQLWriteStatusDuplicates(t *testing.T) {
+	stored := map[string]mockScriptAnswer{
+		plantQuery:           {rows: [][]driver.Value{{int64(1)}}},
+		readingInsert:        {affected: 0},
+		readingSelect:        {rows: [][]driver.Value{{int64(5)}}},
+		readingMetricsSelect: {rows: [][]driver.Value{{"light", 10.0, "klx"}}},
+	}
+
+	tests := map[string]struct {
+		policy   DuplicatePolicy // duplicate policy
+		light    float64         // input light
+		expected error           // expected error
+	}{
+		"Retry":     {DuplicateReject, 10, DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}},
+		"Rejected":  {DuplicateReject, 20, DatabaseDuplicateError{Message: "duplicate reading"}},
+		"Ignored":   {DuplicateIgnore, 20, DatabaseDuplicateError{Message: "duplicate reading", Ignored: true}},
+		"Overwrite": {DuplicateOverwrite, 20, nil},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			connector := &mockScriptConnector{script: stored}
+			d := &MySQL{Duplicates: testCase.policy, database: sql.OpenDB(connector)}
+			defer d.database.Close()
+
+			// Ignored inserts are duplicates, however rows are counted
+			data := &models.StatusData{ID: 1, Timestamp: 100, Metrics: map[string]models.Metric{"light": {Value: testCase.light, Unit: "klx"}}}
+			if err := d.WriteStatus(data); !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			overwritten := false
+			for _, query := range connector.executed {
+				overwritten = overwritten || query == readingMetricInsert
+			}
+			if overwritten != (testCase.expected == nil) {
+				t.Errorf("Expected overwritten %t, got %t", testCase.expected == nil, overwritten)
+			}
+		})
+	}
+}
+
+func TestMySQLWriteStatusInserted(t *testing.T) {
+	connector := &mockScriptConnector{script: map[string]mockScriptAnswer{
+		plantQuery:    {rows: [][]driver.Value{{int64(1)}}},
+		readingInsert: {affected: 1},
+	}}
+	d := &MySQL{Duplicates: DuplicateReject, database: sql.OpenDB(connector)}
+	defer d.database.Close()
+
+	// New readings are not looked up
+	data := &models.StatusData{ID: 1, Timestamp: 100}
+	data.SetValue("light", 10)
+	if err := d.WriteStatus(data); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := []string{plantQuery, readingInsert, readingMetricsDelete, readingMetricInsert}
+	if !reflect.DeepEqual(connector.executed, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, connector.executed)
+	}
+}
@@ -0,0 +1,729 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/go-sql-driver/mysql"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed = BreakerState("closed")
+	// BreakerOpen rejects every call until the open timeout expires
+	BreakerOpen = BreakerState("open")
+	// BreakerHalfOpen lets a single trial call through
+	BreakerHalfOpen = BreakerState("half-open")
+)
+
+// Breaker is an interface for drivers guarded by a circuit breaker
+type Breaker interface {
+	State() BreakerState
+}
+
+// ResilientConfig is the configuration for a Resilient driver
+type ResilientConfig struct {
+	// MaxAttempts is the number of attempts for each idempotent operation
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry
+	BaseDelay time.Duration
+	// MaxDelay caps the exponential backoff
+	MaxDelay time.Duration
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int
+	// OpenTimeout is the time the breaker stays open before a trial call
+	OpenTimeout time.Duration
+	// IdempotentWrites enables retries for WriteStatus
+	IdempotentWrites bool
+}
+
+// Resilient is a database driver decorator that retries transient errors
+// and fails fast with a circuit breaker after repeated failures
+type Resilient struct {
+	driver Database
+	config ResilientConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+var _ Database = (*Resilient)(nil)
+var _ StatusReader = (*Resilient)(nil)
+var _ PlantStore = (*Resilient)(nil)
+var _ DeviceStore = (*Resilient)(nil)
+var _ AlertStore = (*Resilient)(nil)
+var _ WebhookStore = (*Resilient)(nil)
+var _ Breaker = (*Resilient)(nil)
+
+// NewResilient creates a new Resilient driver
+func NewResilient(driver Database, config ResilientConfig) (*Resilient, error) {
+	if driver == nil {
+		return nil, DatabaseInvalidDataError("nil driver")
+	}
+	if config.MaxAttempts < 1 || config.FailureThreshold < 1 ||
+		config.BaseDelay < 0 || config.MaxDelay < config.BaseDelay || config.OpenTimeout <= 0 {
+		return nil, DatabaseInvalidDataError("invalid config")
+	}
+
+	return &Resilient{
+		driver: driver,
+		config: config,
+		state:  BreakerClosed,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}, nil
+}
+
+// State returns the current breaker state
+func (d *Resilient) State() BreakerState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state == BreakerOpen && d.now().Sub(d.openedAt) >= d.config.OpenTimeout {
+		return BreakerHalfOpen
+	}
+
+	return d.state
+}
+
+// Exists checks if current ID exists
+func (d *Resilient) Exists(id uint) (bool, error) {
+	var exists bool
+	err := d.call(true, func() error {
+		var err error
+		exists, err = d.driver.Exists(id)
+
+		return err
+	})
+
+	return exists, err
+}
+
+// WriteStatus writes status data through the wrapped driver
+func (d *Resilient) WriteStatus(data *models.StatusData) error {
+	return d.call(d.config.IdempotentWrites, func() error {
+		return d.driver.WriteStatus(data)
+	})
+}
+
+// ReadStatus reads stored status data through the wrapped driver, retrying only until a reading
+// is passed to fn
+func (d *Resilient) ReadStatus(id uint, from, to int64, fn func(data *models.StatusData) error) error {
+	reader, ok := d.driver.(StatusReader)
+	if !ok {
+		return DatabaseUnexpectedError("driver is not a status reader")
+	}
+
+	passed := false
+	return d.callRetrying(func() bool { return !passed }, func() error {
+		return reader.ReadStatus(id, from, to, func(data *models.StatusData) error {
+			passed = true
+
+			return fn(data)
+		})
+	})
+}
+
+// CreatePlant registers a plant through the wrapped driver, without retries as IDs may be assigned
+func (d *Resilient) CreatePlant(plant *models.Plant) error {
+	store, err := d.plants()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.CreatePlant(plant)
+	})
+}
+
+// ReadPlant reads a plant through the wrapped driver
+func (d *Resilient) ReadPlant(id uint) (*models.Plant, error) {
+	store, err := d.plants()
+	if err != nil {
+		return nil, err
+	}
+
+	var plant *models.Plant
+	err = d.call(true, func() error {
+		var err error
+		plant, err = store.ReadPlant(id)
+
+		return err
+	})
+
+	return plant, err
+}
+
+// ReadPlants reads every plant through the wrapped driver
+func (d *Resilient) ReadPlants() ([]*models.Plant, error) {
+	store, err := d.plants()
+	if err != nil {
+		return nil, err
+	}
+
+	var plants []*models.Plant
+	err = d.call(true, func() error {
+		var err error
+		plants, err = store.ReadPlants()
+
+		return err
+	})
+
+	return plants, err
+}
+
+// UpdatePlant updates a plant through the wrapped driver
+func (d *Resilient) UpdatePlant(plant *models.Plant) error {
+	store, err := d.plants()
+	if err != nil {
+		return err
+	}
+
+	return d.call(true, func() error {
+		return store.UpdatePlant(plant)
+	})
+}
+
+// DeletePlant deletes a plant through the wrapped driver, without retries as a retry of a
+// committed delete would fail with an invalid ID
+func (d *Resilient) DeletePlant(id uint) error {
+	store, err := d.plants()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.DeletePlant(id)
+	})
+}
+
+// CreateDevice registers a device through the wrapped driver, without retries as IDs may be assigned
+func (d *Resilient) CreateDevice(device *models.Device) error {
+	store, err := d.devices()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.CreateDevice(device)
+	})
+}
+
+// ReadDevice reads a device through the wrapped driver
+func (d *Resilient) ReadDevice(id uint) (*models.Device, error) {
+	store, err := d.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var device *models.Device
+	err = d.call(true, func() error {
+		var err error
+		device, err = store.ReadDevice(id)
+
+		return err
+	})
+
+	return device, err
+}
+
+// ReadDeviceByEUI reads a device by its LoRaWAN DevEUI through the wrapped driver
+func (d *Resilient) ReadDeviceByEUI(devEUI string) (*models.Device, error) {
+	store, err := d.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var device *models.Device
+	err = d.call(true, func() error {
+		var err error
+		device, err = store.ReadDeviceByEUI(devEUI)
+
+		return err
+	})
+
+	return device, err
+}
+
+// ReadDevices reads every device through the wrapped driver
+func (d *Resilient) ReadDevices() ([]*models.Device, error) {
+	store, err := d.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []*models.Device
+	err = d.call(true, func() error {
+		var err error
+		devices, err = store.ReadDevices()
+
+		return err
+	})
+
+	return devices, err
+}
+
+// UpdateDevice updates a device through the wrapped driver
+func (d *Resilient) UpdateDevice(device *models.Device) error {
+	store, err := d.devices()
+	if err != nil {
+		return err
+	}
+
+	return d.call(true, func() error {
+		return store.UpdateDevice(device)
+	})
+}
+
+// DeleteDevice deletes a device through the wrapped driver, without retries
+func (d *Resilient) DeleteDevice(id uint) error {
+	store, err := d.devices()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.DeleteDevice(id)
+	})
+}
+
+// ReadBindings reads the bindings of a device through the wrapped driver
+func (d *Resilient) ReadBindings(deviceID uint) ([]*models.Binding, error) {
+	store, err := d.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var bindings []*models.Binding
+	err = d.call(true, func() error {
+		var err error
+		bindings, err = store.ReadBindings(deviceID)
+
+		return err
+	})
+
+	return bindings, err
+}
+
+// WriteBinding inserts a binding through the wrapped driver, without retries
+func (d *Resilient) WriteBinding(binding *models.Binding) error {
+	store, err := d.devices()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.WriteBinding(binding)
+	})
+}
+
+// CloseBinding closes the open binding of a device through the wrapped driver, without retries
+// as a retry of a committed close would find no open binding
+func (d *Resilient) CloseBinding(deviceID uint, to int64) error {
+	store, err := d.devices()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.CloseBinding(deviceID, to)
+	})
+}
+
+// ReadCalibrations reads the calibration profiles of a device through the wrapped driver
+func (d *Resilient) ReadCalibrations(deviceID uint) ([]*models.Calibration, error) {
+	store, err := d.devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var calibrations []*models.Calibration
+	err = d.call(true, func() error {
+		var err error
+		calibrations, err = store.ReadCalibrations(deviceID)
+
+		return err
+	})
+
+	return calibrations, err
+}
+
+// WriteCalibration inserts a calibration profile through the wrapped driver, without retries
+func (d *Resilient) WriteCalibration(calibration *models.Calibration) error {
+	store, err := d.devices()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.WriteCalibration(calibration)
+	})
+}
+
+// CreateAlertRule inserts an alert rule through the wrapped driver, without retries as IDs are assigned
+func (d *Resilient) CreateAlertRule(rule *models.AlertRule) error {
+	store, err := d.alerts()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.CreateAlertRule(rule)
+	})
+}
+
+// ReadAlertRules reads the alert rules of a plant through the wrapped driver
+func (d *Resilient) ReadAlertRules(plantID uint) ([]*models.AlertRule, error) {
+	store, err := d.alerts()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*models.AlertRule
+	err = d.call(true, func() error {
+		var err error
+		rules, err = store.ReadAlertRules(plantID)
+
+		return err
+	})
+
+	return rules, err
+}
+
+// DeleteAlertRule deletes an alert rule through the wrapped driver, without retries
+func (d *Resilient) DeleteAlertRule(id uint) error {
+	store, err := d.alerts()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.DeleteAlertRule(id)
+	})
+}
+
+// ReadActiveAlert reads the active alert of a rule through the wrapped driver
+func (d *Resilient) ReadActiveAlert(ruleID uint) (*models.Alert, error) {
+	store, err := d.alerts()
+	if err != nil {
+		return nil, err
+	}
+
+	var alert *models.Alert
+	err = d.call(true, func() error {
+		var err error
+		alert, err = store.ReadActiveAlert(ruleID)
+
+		return err
+	})
+
+	return alert, err
+}
+
+// ReadAlerts reads the alerts in a state through the wrapped driver
+func (d *Resilient) ReadAlerts(state string) ([]*models.Alert, error) {
+	store, err := d.alerts()
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []*models.Alert
+	err = d.call(true, func() error {
+		var err error
+		alerts, err = store.ReadAlerts(state)
+
+		return err
+	})
+
+	return alerts, err
+}
+
+// WriteAlert writes an alert through the wrapped driver. Only updates are retried, as inserts assign IDs.
+func (d *Resilient) WriteAlert(alert *models.Alert) error {
+	store, err := d.alerts()
+	if err != nil {
+		return err
+	}
+
+	return d.call(alert != nil && alert.ID != 0, func() error {
+		return store.WriteAlert(alert)
+	})
+}
+
+// AcknowledgeAlert acknowledges an alert through the wrapped driver
+func (d *Resilient) AcknowledgeAlert(id uint) error {
+	store, err := d.alerts()
+	if err != nil {
+		return err
+	}
+
+	return d.call(true, func() error {
+		return store.AcknowledgeAlert(id)
+	})
+}
+
+// CreateSubscription inserts a webhook subscription through the wrapped driver, without retries
+// as IDs are assigned
+func (d *Resilient) CreateSubscription(subscription *models.Subscription) error {
+	store, err := d.webhooks()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.CreateSubscription(subscription)
+	})
+}
+
+// ReadSubscriptions reads every webhook subscription through the wrapped driver
+func (d *Resilient) ReadSubscriptions() ([]*models.Subscription, error) {
+	store, err := d.webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []*models.Subscription
+	err = d.call(true, func() error {
+		var err error
+		subscriptions, err = store.ReadSubscriptions()
+
+		return err
+	})
+
+	return subscriptions, err
+}
+
+// DeleteSubscription deletes a webhook subscription through the wrapped driver, without retries
+func (d *Resilient) DeleteSubscription(id uint) error {
+	store, err := d.webhooks()
+	if err != nil {
+		return err
+	}
+
+	return d.call(false, func() error {
+		return store.DeleteSubscription(id)
+	})
+}
+
+// WriteDelivery writes a webhook delivery through the wrapped driver. Only updates are retried,
+// as inserts assign IDs.
+func (d *Resilient) WriteDelivery(delivery *models.Delivery) error {
+	store, err := d.webhooks()
+	if err != nil {
+		return err
+	}
+
+	return d.call(delivery != nil && delivery.ID != 0, func() error {
+		return store.WriteDelivery(delivery)
+	})
+}
+
+// ReadDeliveries reads the deliveries of a webhook subscription through the wrapped driver
+func (d *Resilient) ReadDeliveries(subscriptionID uint) ([]*models.Delivery, error) {
+	store, err := d.webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*models.Delivery
+	err = d.call(true, func() error {
+		var err error
+		deliveries, err = store.ReadDeliveries(subscriptionID)
+
+		return err
+	})
+
+	return deliveries, err
+}
+
+// ReadDueDeliveries reads the pending deliveries due before a time through the wrapped driver
+func (d *Resilient) ReadDueDeliveries(before int64, limit int) ([]*models.Delivery, error) {
+	store, err := d.webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*models.Delivery
+	err = d.call(true, func() error {
+		var err error
+		deliveries, err = store.ReadDueDeliveries(before, limit)
+
+		return err
+	})
+
+	return deliveries, err
+}
+
+// plants returns the wrapped driver as a plant store
+func (d *Resilient) plants() (PlantStore, error) {
+	store, ok := d.driver.(PlantStore)
+	if !ok {
+		return nil, DatabaseUnexpectedError("driver is not a plant store")
+	}
+
+	return store, nil
+}
+
+// devices returns the wrapped driver as a device store
+func (d *Resilient) devices() (DeviceStore, error) {
+	store, ok := d.driver.(DeviceStore)
+	if !ok {
+		return nil, DatabaseUnexpectedError("driver is not a device store")
+	}
+
+	return store, nil
+}
+
+// alerts returns the wrapped driver as an alert store
+func (d *Resilient) alerts() (AlertStore, error) {
+	store, ok := d.driver.(AlertStore)
+	if !ok {
+		return nil, DatabaseUnexpectedError("driver is not an alert store")
+	}
+
+	return store, nil
+}
+
+// webhooks returns the wrapped driver as a webhook store
+func (d *Resilient) webhooks() (WebhookStore, error) {
+	store, ok := d.driver.(WebhookStore)
+	if !ok {
+		return nil, DatabaseUnexpectedError("driver is not a webhook store")
+	}
+
+	return store, nil
+}
+
+// call runs op through the breaker, retrying it when idempotent
+func (d *Resilient) call(idempotent bool, op func() error) error {
+	return d.callRetrying(func() bool { return idempotent }, op)
+}
+
+// callRetrying runs op through the breaker, retrying transient failures while retryable allows it
+func (d *Resilient) callRetrying(retryable func() bool, op func() error) (err error) {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	// A panicking op counts as a failure, so a trial call cannot leave the breaker half-open
+	failed := true
+	defer func() {
+		d.release(failed)
+	}()
+
+	for attempt := 0; attempt < d.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			d.sleep(d.backoff(attempt))
+		}
+		err = op()
+		if !transient(err) || !retryable() {
+			break
+		}
+	}
+	failed = transient(err)
+
+	return err
+}
+
+// acquire checks whether a call may go through the breaker
+func (d *Resilient) acquire() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch d.state {
+	case BreakerOpen:
+		elapsed := d.now().Sub(d.openedAt)
+		if elapsed < d.config.OpenTimeout {
+			return DatabaseUnavailableError{
+				Message:    "circuit breaker open",
+				RetryAfter: d.config.OpenTimeout - elapsed,
+			}
+		}
+		d.state = BreakerHalfOpen
+		d.trial = true
+	case BreakerHalfOpen:
+		if d.trial {
+			return DatabaseUnavailableError{
+				Message:    "circuit breaker half-open",
+				RetryAfter: d.config.OpenTimeout,
+			}
+		}
+		d.trial = true
+	}
+
+	return nil
+}
+
+// release records the outcome of a call
+func (d *Resilient) release(failed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.trial = false
+	if !failed {
+		d.state = BreakerClosed
+		d.failures = 0
+
+		return
+	}
+
+	d.failures++
+	if d.state == BreakerHalfOpen || d.failures >= d.config.FailureThreshold {
+		d.state = BreakerOpen
+		d.openedAt = d.now()
+	}
+}
+
+// backoff returns a jittered exponential delay for the given retry
+func (d *Resilient) backoff(attempt int) time.Duration {
+	delay := d.config.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > d.config.MaxDelay {
+		delay = d.config.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// MySQL errors of transactions that may succeed when run again
+const (
+	mysqlLockWaitTimeout = 1205
+	mysqlDeadlock        = 1213
+)
+
+// transient reports whether err is worth retrying: dropped connections, network errors, lock
+// wait timeouts and deadlocks. Other failures, such as constraint violations, fail alike on retry.
+func transient(err error) bool {
+	queryErr, ok := err.(DatabaseQueryError)
+	if !ok {
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	var netErr net.Error
+	switch {
+	case errors.Is(queryErr.Err, driver.ErrBadConn), errors.Is(queryErr.Err, mysql.ErrInvalidConn):
+		return true
+	case errors.As(queryErr.Err, &mysqlErr):
+		return mysqlErr.Number == mysqlLockWaitTimeout || mysqlErr.Number == mysqlDeadlock
+	case errors.As(queryErr.Err, &netErr):
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,422 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/go-sql-driver/mysql"
+)
+
+var errConnectionReset = DatabaseQueryError{Err: driver.ErrBadConn}
+
+// Flaky driver mock, failing a fixed number of times before succeeding
+type mockFlakyDriver struct {
+	failures int
+	calls    int
+}
+
+func (d *mockFlakyDriver) Exists(id uint) (bool, error) {
+	d.calls++
+	if d.calls <= d.failures {
+		return false, errConnectionReset
+	}
+
+	return id == 1, nil
+}
+
+func (d *mockFlakyDriver) WriteStatus(data *models.StatusData) error {
+	d.calls++
+	if data == nil {
+		return DatabaseInvalidDataError("nil data")
+	}
+	if d.calls <= d.failures {
+		return errConnectionReset
+	}
+
+	return nil
+}
+
+// Failing driver mock, failing every call with err
+type mockFailingDriver struct {
+	err   error
+	calls int
+}
+
+func (d *mockFailingDriver) Exists(id uint) (bool, error) {
+	d.calls++
+
+	return false, d.err
+}
+
+func (d *mockFailingDriver) WriteStatus(data *models.StatusData) error {
+	d.calls++
+
+	return d.err
+}
+
+var testResilientConfig = ResilientConfig{
+	MaxAttempts:      3,
+	BaseDelay:        10 * time.Millisecond,
+	MaxDelay:         100 * time.Millisecond,
+	FailureThreshold: 2,
+	OpenTimeout:      time.Minute,
+}
+
+func newTestResilient(driver Database, config ResilientConfig) (*Resilient, *time.Time, *[]time.Duration) {
+	d, err := NewResilient(driver, config)
+	if err != nil {
+		panic(err.Error())
+	}
+	now := time.Unix(1516478286, 0)
+	sleeps := []time.Duration{}
+	d.now = func() time.Time { return now }
+	d.sleep = func(delay time.Duration) { sleeps = append(sleeps, delay) }
+
+	return d, &now, &sleeps
+}
+
+func TestNewResilient(t *testing.T) {
+	tests := map[string]struct {
+		driver   Database        // input driver
+		config   ResilientConfig // input config
+		expected error           // expected error
+	}{
+		"Happy path":      {&mockFlakyDriver{}, testResilientConfig, nil},
+		"nil driver":      {nil, testResilientConfig, DatabaseInvalidDataError("nil driver")},
+		"No attempts":     {&mockFlakyDriver{}, ResilientConfig{FailureThreshold: 1, OpenTimeout: time.Second}, DatabaseInvalidDataError("invalid config")},
+		"No threshold":    {&mockFlakyDriver{}, ResilientConfig{MaxAttempts: 1, OpenTimeout: time.Second}, DatabaseInvalidDataError("invalid config")},
+		"No open timeout": {&mockFlakyDriver{}, ResilientConfig{MaxAttempts: 1, FailureThreshold: 1}, DatabaseInvalidDataError("invalid config")},
+		"Max below base":  {&mockFlakyDriver{}, ResilientConfig{MaxAttempts: 1, FailureThreshold: 1, OpenTimeout: time.Second, BaseDelay: time.Second}, DatabaseInvalidDataError("invalid config")},
+		"Negative delay":  {&mockFlakyDriver{}, ResilientConfig{MaxAttempts: 1, FailureThreshold: 1, OpenTimeout: time.Second, BaseDelay: -time.Second}, DatabaseInvalidDataError("invalid config")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := NewResilient(testCase.driver, testCase.config)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestResilientExists(t *testing.T) {
+	tests := map[string]struct {
+		failures      int   // failures before success
+		expected      bool  // expected result
+		expectedErr   error // expected error
+		expectedCalls int   // expected driver calls
+	}{
+		"Happy path":        {0, true, nil, 1},
+		"Transient failure": {2, true, nil, 3},
+		"Retries exhausted": {3, false, errConnectionReset, 3},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			driver := &mockFlakyDriver{failures: testCase.failures}
+			d, _, sleeps := newTestResilient(driver, testResilientConfig)
+
+			exists, err := d.Exists(1)
+			if !reflect.DeepEqual(err, testCase.expectedErr) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedErr, err)
+			}
+			if exists != testCase.expected {
+				t.Errorf("Expected %t, got %t", testCase.expected, exists)
+			}
+			if driver.calls != testCase.expectedCalls {
+				t.Errorf("Expected %d calls, got %d", testCase.expectedCalls, driver.calls)
+			}
+			for i, delay := range *sleeps {
+				if delay < 0 || delay > testResilientConfig.BaseDelay<<uint(i) {
+					t.Errorf("Delay %d out of range: %s", i, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestResilientWriteStatus(t *testing.T) {
+	idempotent := testResilientConfig
+	idempotent.IdempotentWrites = true
+
+	tests := map[string]struct {
+		config        ResilientConfig    // driver config
+		data          *models.StatusData // input
+		failures      int                // failures before success
+		expected      error              // expected error
+		expectedCalls int                // expected driver calls
+	}{
+		"Happy path":           {testResilientConfig, &models.StatusData{ID: 1}, 0, nil, 1},
+		"Non-idempotent write": {testResilientConfig, &models.StatusData{ID: 1}, 1, errConnectionReset, 1},
+		"Idempotent write":     {idempotent, &models.StatusData{ID: 1}, 1, nil, 2},
+		"Invalid data":         {idempotent, nil, 0, DatabaseInvalidDataError("nil data"), 1},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			driver := &mockFlakyDriver{failures: testCase.failures}
+			d, _, _ := newTestResilient(driver, testCase.config)
+
+			err := d.WriteStatus(testCase.data)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if driver.calls != testCase.expectedCalls {
+				t.Errorf("Expected %d calls, got %d", testCase.expectedCalls, driver.calls)
+			}
+		})
+	}
+}
+
+func TestResilientPlants(t *testing.T) {
+	// Setup
+	memory, _ := NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	d, now, _ := newTestResilient(memory, testResilientConfig)
+
+	if err := d.UpdatePlant(&models.Plant{ID: 1, Name: "Basil"}); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	plant, err := d.ReadPlant(1)
+	if err != nil || plant.Name != "Basil" {
+		t.Errorf("Expected Basil, got %+v and %+v", plant, err)
+	}
+
+	// Plant calls go through the breaker
+	d.state, d.openedAt = BreakerOpen, *now
+	_, err = d.ReadPlants()
+	if expected := (DatabaseUnavailableError{Message: "circuit breaker open", RetryAfter: time.Minute}); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+
+	// Drivers without a plant registry
+	d, _, _ = newTestResilient(&mockFlakyDriver{}, testResilientConfig)
+	if err := d.DeletePlant(1); !reflect.DeepEqual(err, DatabaseUnexpectedError("driver is not a plant store")) {
+		t.Errorf("Expected not a plant store, got %+v", err)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := map[string]struct {
+		err      error // input
+		expected bool  // expected result
+	}{
+		"Bad connection":     {DatabaseQueryError{Err: driver.ErrBadConn}, true},
+		"Invalid connection": {DatabaseQueryError{Err: mysql.ErrInvalidConn}, true},
+		"Network error":      {DatabaseQueryError{Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		"Lock wait timeout":  {DatabaseQueryError{Err: &mysql.MySQLError{Number: 1205}}, true},
+		"Deadlock":           {DatabaseQueryError{Err: &mysql.MySQLError{Number: 1213}}, true},
+		"Constraint":         {DatabaseQueryError{Err: &mysql.MySQLError{Number: 1452}}, false},
+		"Syntax error":       {DatabaseQueryError{Err: &mysql.MySQLError{Number: 1064}}, false},
+		"Unexpected":         {DatabaseUnexpectedError("nil driver"), false},
+		"Invalid data":       {DatabaseInvalidDataError("invalid ID"), false},
+		"nil":                {nil, false},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if result := transient(testCase.err); result != testCase.expected {
+				t.Errorf("Expected %t, got %t", testCase.expected, result)
+			}
+		})
+	}
+}
+
+func TestResilientPermanentFailure(t *testing.T) {
+	driver := &mockFailingDriver{err: DatabaseQueryError{Err: &mysql.MySQLError{Number: 1452, Message: "foreign key"}}}
+	d, _, _ := newTestResilient(driver, testResilientConfig)
+
+	// Failures that would fail alike are neither retried nor counted by the breaker
+	for i := 0; i < testResilientConfig.FailureThreshold+1; i++ {
+		d.Exists(1)
+	}
+	if driver.calls != testResilientConfig.FailureThreshold+1 {
+		t.Errorf("Expected %d calls, got %d", testResilientConfig.FailureThreshold+1, driver.calls)
+	}
+	if state := d.State(); state != BreakerClosed {
+		t.Errorf("Expected %s, got %s", BreakerClosed, state)
+	}
+}
+
+func TestResilientBreaker(t *testing.T) {
+	driver := &mockFlakyDriver{failures: 6}
+	d, now, _ := newTestResilient(driver, testResilientConfig)
+
+	// Two failed operations open the breaker
+	d.Exists(1)
+	d.Exists(1)
+	if state := d.State(); state != BreakerOpen {
+		t.Errorf("Expected %s, got %s", BreakerOpen, state)
+	}
+
+	// Open breaker fails fast
+	_, err := d.Exists(1)
+	expected := DatabaseUnavailableError{Message: "circuit breaker open", RetryAfter: time.Minute}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+	if driver.calls != 6 {
+		t.Errorf("Expected 6 calls, got %d", driver.calls)
+	}
+
+	// Timeout moves the breaker to half-open, and a successful trial closes it
+	*now = now.Add(time.Minute)
+	if state := d.State(); state != BreakerHalfOpen {
+		t.Errorf("Expected %s, got %s", BreakerHalfOpen, state)
+	}
+	if _, err := d.Exists(1); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if state := d.State(); state != BreakerClosed {
+		t.Errorf("Expected %s, got %s", BreakerClosed, state)
+	}
+}
+
+func TestResilientBreakerFailedTrial(t *testing.T) {
+	driver := &mockFlakyDriver{failures: 100}
+	d, now, _ := newTestResilient(driver, testResilientConfig)
+
+	d.Exists(1)
+	d.Exists(1)
+	*now = now.Add(time.Minute)
+
+	// A failed trial reopens the breaker immediately
+	d.Exists(1)
+	if state := d.State(); state != BreakerOpen {
+		t.Errorf("Expected %s, got %s", BreakerOpen, state)
+	}
+}
+
+// Panicking driver mock, panicking while panics is set
+type mockPanickingDriver struct {
+	mockFlakyDriver
+	panics bool
+}
+
+func (d *mockPanickingDriver) Exists(id uint) (bool, error) {
+	if d.panics {
+		panic("driver panic")
+	}
+
+	return d.mockFlakyDriver.Exists(id)
+}
+
+func TestResilientBreakerPanickingTrial(t *testing.T) {
+	driver := &mockPanickingDriver{mockFlakyDriver: mockFlakyDriver{failures: 6}}
+	d, now, _ := newTestResilient(driver, testResilientConfig)
+
+	d.Exists(1)
+	d.Exists(1)
+	*now = now.Add(time.Minute)
+
+	// A panicking trial reopens the breaker
+	driver.panics = true
+	func() {
+		defer func() { recover() }()
+		d.Exists(1)
+	}()
+	if state := d.State(); state != BreakerOpen {
+		t.Errorf("Expected %s, got %s", BreakerOpen, state)
+	}
+
+	// The next trial goes through
+	driver.panics = false
+	*now = now.Add(time.Minute)
+	if _, err := d.Exists(1); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if state := d.State(); state != BreakerClosed {
+		t.Errorf("Expected %s, got %s", BreakerClosed, state)
+	}
+}
+
+func TestResilientStores(t *testing.T) {
+	// Setup
+	memory, _ := NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	d, now, _ := newTestResilient(memory, testResilientConfig)
+
+	if err := d.CreateDevice(&models.Device{ID: 7}); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if err := d.WriteBinding(&models.Binding{DeviceID: 7, PlantID: 1, From: 100}); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	bindings, err := d.ReadBindings(7)
+	if expected := []*models.Binding{{DeviceID: 7, PlantID: 1, From: 100}}; err != nil || !reflect.DeepEqual(bindings, expected) {
+		t.Errorf("Expected %+v, got %+v and %+v", expected, bindings, err)
+	}
+	rule := &models.AlertRule{PlantID: 1, Metric: "temperature", Operator: ">", Threshold: 30}
+	if err := d.CreateAlertRule(rule); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if rules, err := d.ReadAlertRules(1); err != nil || len(rules) != 1 {
+		t.Errorf("Expected 1 rule, got %+v and %+v", rules, err)
+	}
+	if subscriptions, err := d.ReadSubscriptions(); err != nil || len(subscriptions) != 0 {
+		t.Errorf("Expected no subscriptions, got %+v and %+v", subscriptions, err)
+	}
+
+	// Every store goes through the breaker
+	d.state, d.openedAt = BreakerOpen, *now
+	expected := DatabaseUnavailableError{Message: "circuit breaker open", RetryAfter: time.Minute}
+	if _, err := d.ReadDevice(7); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+	if _, err := d.ReadActiveAlert(rule.ID); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+	if _, err := d.ReadDueDeliveries(0, 10); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+	if err := d.ReadStatus(1, 0, 0, func(*models.StatusData) error { return nil }); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, err)
+	}
+}
+
+// Reader mock, passing readings before failing with a dropped connection
+type mockBrokenReader struct {
+	mockFlakyDriver
+	readings int
+}
+
+func (d *mockBrokenReader) ReadStatus(id uint, from, to int64, fn func(data *models.StatusData) error) error {
+	d.calls++
+	for i := 0; i < d.readings; i++ {
+		if err := fn(&models.StatusData{ID: id, Timestamp: int64(i)}); err != nil {
+			return err
+		}
+	}
+
+	return errConnectionReset
+}
+
+func TestResilientReadStatus(t *testing.T) {
+	tests := map[string]struct {
+		readings      int // readings passed before failing
+		expectedCalls int // expected reads
+	}{
+		"Failed before passing readings": {0, 3},
+		"Failed after passing readings":  {2, 1},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			driver := &mockBrokenReader{readings: testCase.readings}
+			d, _, _ := newTestResilient(driver, testResilientConfig)
+
+			// Readings are never passed twice
+			passed := 0
+			err := d.ReadStatus(1, 0, 0, func(*models.StatusData) error {
+				passed++
+
+				return nil
+			})
+			if err != errConnectionReset {
+				t.Errorf("Expected %+v, got %+v", errConnectionReset, err)
+			}
+			if driver.calls != testCase.expectedCalls || passed != testCase.readings {
+				t.Errorf("Expected %d calls and %d readings, got %d and %d", testCase.expectedCalls, testCase.readings, driver.calls, passed)
+			}
+		})
+	}
+}
@@ -2,32 +2,80 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/berry-house/http_broker/controllers"
 	"github.com/berry-house/http_broker/drivers/database"
 	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/pb"
 	"github.com/berry-house/http_broker/services"
-	"github.com/gorilla/mux"
+	piondtls "github.com/pion/dtls/v3"
+	coap "github.com/plgd-dev/go-coap/v3"
+	coapmux "github.com/plgd-dev/go-coap/v3/mux"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
-	port             int
-	httpsEnabled     bool
-	httpsCert        string
-	httpsKey         string
-	runningMode      string
-	loggerConfigFile string
-	databaseAddress  string
-	databaseName     string
-	databaseUsername string
-	databasePassword string
+	port              int
+	httpsEnabled      bool
+	httpsCert         string
+	httpsKey          string
+	runningMode       string
+	loggerConfigFile  string
+	databaseAddress   string
+	databaseName      string
+	databaseUsername  string
+	databasePassword  string
+	retryAttempts     int
+	retryBaseDelay    time.Duration
+	retryMaxDelay     time.Duration
+	breakerThreshold  int
+	breakerTimeout    time.Duration
+	deviceAuth        bool
+	adminToken        string
+	metricsConfigFile string
+	webhookInterval   time.Duration
+	webhookAttempts   int
+	webhookPrivate    bool
+	streamBuffer      int
+	grpcPort          int
+	coapPort          int
+	coapPSK           string
+	coapPSKIdentity   string
+	loraLayoutsFile   string
+	loraToken         string
+	duplicatePolicy   string
+	timestampPast     time.Duration
+	timestampFuture   time.Duration
+	timestampCorrect  bool
+	timestampStamp    bool
+	anomalyWindow     int
+	anomalySpike      float64
+	fleetInterval     time.Duration
+	fleetOffline      int
+	fleetCheck        time.Duration
+	pipelineStages    string
+	maxBodySize       int64
+	maxStreamSize     int64
+	fanoutMode        string
+	fanoutSecondaries string
+	fanoutQueueSize   int
+	fanoutRetry       time.Duration
+	shutdownTimeout   time.Duration
 )
 
 func init() {
@@ -41,54 +89,279 @@ func init() {
 	flag.StringVar(&databaseName, "databaseName", "", "Name of the database")
 	flag.StringVar(&databaseUsername, "databaseUsername", "", "Username for the database")
 	flag.StringVar(&databasePassword, "databasePassword", "", "Password for the database")
+	flag.StringVar(&duplicatePolicy, "duplicatePolicy", "overwrite", "Handling of readings at the time of a stored one of their plant (\"overwrite\", \"ignore\" or \"reject\")")
+	flag.DurationVar(&timestampPast, "timestampMaxPast", 0, "How far in the past reading timestamps may be (0 for no limit)")
+	flag.DurationVar(&timestampFuture, "timestampMaxFuture", 10*time.Minute, "How far in the future reading timestamps may be (0 for no limit)")
+	flag.BoolVar(&timestampCorrect, "timestampCorrect", false, "Replace timestamps out of range with the receive time instead of rejecting readings")
+	flag.BoolVar(&timestampStamp, "timestampStamp", false, "Use the receive time as the timestamp of readings without one")
+	flag.IntVar(&anomalyWindow, "anomalyWindow", 30, "Recent values of a plant metric spikes are compared to")
+	flag.Float64Var(&anomalySpike, "anomalySpikeScore", 3.5, "Modified z-score past which values are flagged as spikes")
+	flag.DurationVar(&fleetInterval, "fleetInterval", 15*time.Minute, "Expected reporting interval of plants and of devices without one")
+	flag.IntVar(&fleetOffline, "fleetOfflineAfter", 3, "Missed reporting intervals after which devices are offline")
+	flag.DurationVar(&fleetCheck, "fleetCheckInterval", time.Minute, "Interval between fleet reporting checks")
+	flag.StringVar(&pipelineStages, "pipeline", strings.Join(services.DefaultStages, ","), "Comma separated stages of the ingestion pipeline (the defaults, and \"convert\" for unit conversion)")
+	flag.IntVar(&retryAttempts, "retryAttempts", 3, "Attempts for idempotent database operations")
+	flag.DurationVar(&retryBaseDelay, "retryBaseDelay", 50*time.Millisecond, "Delay before the first database retry")
+	flag.DurationVar(&retryMaxDelay, "retryMaxDelay", time.Second, "Maximum delay between database retries")
+	flag.IntVar(&breakerThreshold, "breakerThreshold", 5, "Consecutive database failures that open the circuit breaker")
+	flag.DurationVar(&breakerTimeout, "breakerTimeout", 30*time.Second, "Time the circuit breaker stays open")
+	flag.StringVar(&fanoutMode, "fanoutMode", "", "Write readings to -fanoutSecondaries as well, acknowledging them once every backend (\"all\") or the primary (\"primary\") stored them")
+	flag.StringVar(&fanoutSecondaries, "fanoutSecondaries", "", "Comma separated MySQL DSNs of the secondary backends of -fanoutMode")
+	flag.IntVar(&fanoutQueueSize, "fanoutQueueSize", 10000, "Secondary writes waiting or queued for retry with -fanoutMode primary")
+	flag.DurationVar(&fanoutRetry, "fanoutRetryInterval", 10*time.Second, "Interval between retries of failed secondary writes")
+	flag.DurationVar(&shutdownTimeout, "shutdownTimeout", 30*time.Second, "Time requests in flight have to finish on shutdown")
+	flag.Int64Var(&maxBodySize, "maxBodySize", controllers.DefaultMaxBodySize, "Size limit in bytes of decoded status bodies")
+	flag.Int64Var(&maxStreamSize, "maxStreamSize", controllers.DefaultMaxStreamSize, "Size limit in bytes of decoded NDJSON streams and CSV imports")
+	flag.BoolVar(&deviceAuth, "deviceAuth", false, "Require device credentials for status ingestion")
+	flag.StringVar(&adminToken, "adminToken", "", "Bearer token operators must send to provision devices and manage webhooks (required with -deviceAuth)")
+	flag.DurationVar(&webhookInterval, "webhookInterval", 5*time.Second, "Interval between webhook outbox dispatches")
+	flag.IntVar(&webhookAttempts, "webhookAttempts", 8, "Attempts for a webhook delivery before it fails")
+	flag.BoolVar(&webhookPrivate, "webhookAllowPrivate", false, "Allow webhook subscribers at loopback, link-local and private addresses")
+	flag.IntVar(&grpcPort, "grpcPort", 9000, "Port in which the gRPC service listens (0 disables it)")
+	flag.IntVar(&coapPort, "coapPort", 5683, "Port in which the CoAP service listens (0 disables it)")
+	flag.StringVar(&coapPSK, "coapPSK", "", "Hex pre-shared key enabling DTLS for CoAP")
+	flag.StringVar(&coapPSKIdentity, "coapPSKIdentity", "broker", "DTLS identity hint sent to CoAP clients")
+	flag.IntVar(&streamBuffer, "streamBuffer", 1024, "Events kept for resuming live streams")
+	flag.StringVar(&loraLayoutsFile, "loraLayoutsFile", "", "Path of JSON file with byte-layout LoRaWAN payload decoders")
+	flag.StringVar(&loraToken, "loraToken", "", "Bearer token LoRaWAN network servers must send (empty accepts any, and disables uplinks with -deviceAuth)")
+	flag.StringVar(&metricsConfigFile, "metricsConfigFile", "", "Path of JSON file with the accepted metric definitions (defaults to built-in metrics)")
 }
 
 func main() {
 	flag.Parse()
 
-	var statusController controllers.Status
+	// Drivers
+	var statusDriver database.Database
+	var statusReader database.StatusReader
+	var plantDriver database.PlantStore
+	var deviceDriver database.DeviceStore
+	var alertDriver database.AlertStore
+	var webhookDriver database.WebhookStore
+	breakers := map[string]database.Breaker{}
+
+	// Device credentials are worthless if anyone may issue them
+	if deviceAuth && adminToken == "" {
+		panic("adminToken must not be empty with deviceAuth")
+	}
+
+	duplicates := database.DuplicatePolicy(duplicatePolicy)
+	if !duplicates.Valid() {
+		panic("Invalid duplicate policy. Use http_broker -h.")
+	}
 
 	switch runningMode {
 	case "prod":
-		// Drivers
-		databaseDriver, err := database.NewMySQL(
+		resilientDriver, err := newMySQL(
 			fmt.Sprintf("%s:%s@tcp(%s)/%s", databaseUsername, databasePassword, databaseAddress, databaseName),
+			duplicates,
 		)
 		if err != nil {
 			panic(err.Error())
 		}
-		// Services
-		statusService := services.StatusDatabase{
-			Driver: databaseDriver,
-		}
 
-		// Controllers
-		statusController = controllers.Status{
-			Service: &statusService,
-		}
+		// Every store shares the breaker, so its state reflects every call to the database
+		statusDriver = resilientDriver
+		statusReader = resilientDriver
+		plantDriver = resilientDriver
+		deviceDriver = resilientDriver
+		alertDriver = resilientDriver
+		webhookDriver = resilientDriver
+		breakers["mysql"] = resilientDriver
 	case "test":
-		// Drivers
-		databaseDriver, _ := database.NewMemory(
-			map[uint][]*models.StatusData{
-				1: []*models.StatusData{},
-				2: []*models.StatusData{},
-				3: []*models.StatusData{},
-				4: []*models.StatusData{},
-				5: []*models.StatusData{},
-			},
-		)
+		memoryDriver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+		memoryDriver.Duplicates = duplicates
+
+		statusDriver = memoryDriver
+		statusReader = memoryDriver
+		plantDriver = memoryDriver
+		deviceDriver = memoryDriver
+		alertDriver = memoryDriver
+		webhookDriver = memoryDriver
+	default:
+		panic("Invalid running mode. Use http_broker -h.")
+	}
 
-		// Services
-		statusService := services.StatusDatabase{
-			Driver: databaseDriver,
+	// Secondary backends, written after the primary decides whether readings are valid
+	var fanout *database.Fanout
+	if fanoutMode != "" {
+		var secondaries []database.Database
+		for i, dsn := range strings.Split(fanoutSecondaries, ",") {
+			if dsn == "" {
+				continue
+			}
+			secondary, err := newMySQL(dsn, duplicates)
+			if err != nil {
+				panic(err.Error())
+			}
+			secondaries = append(secondaries, secondary)
+			breakers[fmt.Sprintf("mysql-secondary-%d", i+1)] = secondary
+		}
+		if len(secondaries) == 0 {
+			panic("fanoutSecondaries must not be empty with fanoutMode")
 		}
+		var err error
+		fanout, err = database.NewFanout(statusDriver, secondaries, database.FanoutConfig{
+			Mode:      database.FanoutMode(fanoutMode),
+			QueueSize: fanoutQueueSize,
+		})
+		if err != nil {
+			panic(err.Error())
+		}
+		statusDriver = fanout
+	}
 
-		// Controllers
-		statusController = controllers.Status{
-			Service: &statusService,
+	// Metrics
+	metrics := services.DefaultMetrics
+	if metricsConfigFile != "" {
+		metricsJSON, err := ioutil.ReadFile(metricsConfigFile)
+		if err != nil {
+			panic(err)
 		}
-	default:
-		panic("Invalid running mode. Use http_broker -h.")
+		var definitions []models.MetricDefinition
+		if err = json.Unmarshal(metricsJSON, &definitions); err != nil {
+			panic(err)
+		}
+		if metrics, err = services.NewMetricRegistry(definitions); err != nil {
+			panic(err)
+		}
+	}
+
+	// LoRaWAN payload decoders
+	decoders := services.DefaultDecoders
+	if loraLayoutsFile != "" {
+		layoutsJSON, err := ioutil.ReadFile(loraLayoutsFile)
+		if err != nil {
+			panic(err)
+		}
+		var layouts []models.PayloadLayout
+		if err = json.Unmarshal(layoutsJSON, &layouts); err != nil {
+			panic(err)
+		}
+		if decoders, err = services.NewPayloadDecoders(layouts); err != nil {
+			panic(err)
+		}
+	}
+
+	// Services
+	webhookService := services.WebhookDatabase{
+		Driver:       webhookDriver,
+		MaxAttempts:  webhookAttempts,
+		AllowPrivate: webhookPrivate,
+	}
+	hub, err := services.NewHub(streamBuffer)
+	if err != nil {
+		panic(err)
+	}
+	events := services.Publishers{&webhookService, hub}
+	alertService := services.AlertDatabase{
+		Driver:  alertDriver,
+		Metrics: metrics,
+		Events:  events,
+	}
+	anomalyDetector := services.AnomalyDetector{
+		Window:     anomalyWindow,
+		SpikeScore: anomalySpike,
+		Metrics:    metrics,
+		Events:     events,
+	}
+	fleetMonitor := services.FleetMonitor{
+		Devices:      deviceDriver,
+		Plants:       plantDriver,
+		Interval:     fleetInterval,
+		OfflineAfter: fleetOffline,
+		Events:       events,
+	}
+	statusService := services.StatusDatabase{
+		Driver:  statusDriver,
+		Devices: deviceDriver,
+		Metrics: metrics,
+		Alerts:  &alertService,
+		Events:  events,
+		Reader:  statusReader,
+		Timestamps: services.TimestampPolicy{
+			MaxPast:      timestampPast,
+			MaxFuture:    timestampFuture,
+			Correct:      timestampCorrect,
+			StampMissing: timestampStamp,
+		},
+		Quality:    &anomalyDetector,
+		Heartbeats: &fleetMonitor,
+	}
+	stages := statusService.Stages()
+	stages[services.StageConvert] = &services.UnitConverter{Metrics: metrics}
+	if statusService.Pipeline, err = services.NewPipeline(strings.Split(pipelineStages, ","), stages); err != nil {
+		panic(err)
+	}
+	plantService := services.PlantDatabase{
+		Driver: plantDriver,
+	}
+	deviceService := services.DeviceDatabase{
+		Driver:  deviceDriver,
+		Plants:  statusDriver,
+		Metrics: metrics,
+	}
+	loraService := services.LoRaWANDatabase{
+		Devices:  deviceDriver,
+		Decoders: decoders,
+	}
+	healthService := services.HealthDatabase{
+		Breakers: breakers,
+		Fleet:    &fleetMonitor,
+	}
+
+	// Controllers
+	statusController := controllers.Status{
+		Service:       &statusService,
+		MaxBodySize:   maxBodySize,
+		MaxStreamSize: maxStreamSize,
+	}
+	plantController := controllers.Plant{
+		Service: &plantService,
+	}
+	deviceController := controllers.Device{
+		Service: &deviceService,
+	}
+	alertController := controllers.Alert{
+		Service: &alertService,
+	}
+	webhookController := controllers.Webhook{
+		Service: &webhookService,
+	}
+	socketController := controllers.Socket{
+		Status: &statusService,
+		Events: hub,
+	}
+	loraController := controllers.LoRaWAN{
+		Service: &loraService,
+		Status:  &statusService,
+		Token:   loraToken,
+	}
+	archiveController := controllers.Archive{
+		Service:       &statusService,
+		History:       &statusService,
+		Aggregation:   &statusService,
+		Metrics:       metrics,
+		MaxStreamSize: maxStreamSize,
+	}
+	influxController := controllers.Influx{
+		Service:     &statusService,
+		MaxBodySize: maxBodySize,
+	}
+	coapController := controllers.CoAP{
+		Service: &statusService,
+	}
+	statusRPCController := controllers.StatusRPC{
+		Service: &statusService,
+		History: &statusService,
+	}
+	streamController := controllers.Stream{
+		Service: hub,
+	}
+	fleetController := controllers.Fleet{
+		Service: &fleetMonitor,
+	}
+	healthController := controllers.Health{
+		Service: &healthService,
 	}
 
 	// Logging
@@ -107,20 +380,47 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// Services log background failures through the global logger
+	zap.ReplaceGlobals(logger)
+
+	// Background loops run until shutdown
+	stop := make(chan struct{})
+
+	// Webhook outbox
+	go webhookService.Run(webhookInterval, stop)
+
+	// Fleet reporting checks
+	go fleetMonitor.Run(fleetCheck, stop)
+
+	// Secondary writes
+	if fanout != nil {
+		go fanout.Run(fanoutRetry, stop)
+	}
 
-	// Context
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, "logger", logger)
+	// LoRaWAN uplinks carry no device credentials, so with device authentication the network
+	// server must authenticate with a token
+	uplinkController := &loraController
+	if deviceAuth && loraToken == "" {
+		logger.Warn("LoRaWAN uplinks disabled, device authentication requires -loraToken")
+		uplinkController = nil
+	}
 
 	// Router
-	router := mux.NewRouter()
-	router.HandleFunc("/broker/status", statusController.Write).Methods("POST")
-	router.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			rctx := r.WithContext(ctx)
-			next.ServeHTTP(w, rctx)
-		})
-	})
+	router := newRouter(routes{
+		status:  &statusController,
+		archive: &archiveController,
+		influx:  &influxController,
+		socket:  &socketController,
+		plant:   &plantController,
+		device:  &deviceController,
+		lora:    uplinkController,
+		alert:   &alertController,
+		webhook: &webhookController,
+		stream:  &streamController,
+		fleet:   &fleetController,
+		health:  &healthController,
+		admin:   &controllers.Admin{Token: adminToken},
+	}, deviceAuth, logger)
 
 	// Server
 	server := &http.Server{
@@ -128,12 +428,131 @@ func main() {
 		Addr:    "0.0.0.0:8000",
 	}
 
-	if httpsEnabled {
-		if httpsCert == "" || httpsKey == "" {
-			panic("httpsCert and httpsKey must not be empty")
+	if httpsEnabled && (httpsCert == "" || httpsKey == "") {
+		panic("httpsCert and httpsKey must not be empty")
+	}
+
+	// gRPC server, sharing logging and device authentication with the router
+	var grpcServer *grpc.Server
+	if grpcPort != 0 {
+		unaryInterceptors := []grpc.UnaryServerInterceptor{controllers.UnaryLogger(logger)}
+		streamInterceptors := []grpc.StreamServerInterceptor{controllers.StreamLogger(logger)}
+		if deviceAuth {
+			unaryInterceptors = append(unaryInterceptors, deviceController.AuthenticateUnary)
+			streamInterceptors = append(streamInterceptors, deviceController.AuthenticateStream)
+		}
+		options := []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(unaryInterceptors...),
+			grpc.ChainStreamInterceptor(streamInterceptors...),
+		}
+		if httpsEnabled {
+			tlsCredentials, err := credentials.NewServerTLSFromFile(httpsCert, httpsKey)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, grpc.Creds(tlsCredentials))
+		}
+		grpcServer = grpc.NewServer(options...)
+		pb.RegisterStatusServiceServer(grpcServer, &statusRPCController)
+
+		listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", grpcPort))
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			// Serve returns nil once stopped
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// CoAP server, over DTLS when a pre-shared key is given.
+	// Its shared key cannot tell devices apart, so it is left off with device authentication.
+	if coapPort != 0 && deviceAuth {
+		logger.Warn("CoAP disabled, it does not support device authentication")
+	} else if coapPort != 0 {
+		coapRouter := coapmux.NewRouter()
+		coapRouter.Use(controllers.CoAPLogger(logger))
+		if err := coapRouter.Handle("/status", coapmux.HandlerFunc(coapController.Write)); err != nil {
+			panic(err)
+		}
+
+		address := fmt.Sprintf("0.0.0.0:%d", coapPort)
+		if coapPSK == "" {
+			go func() {
+				log.Fatal(coap.ListenAndServe("udp", address, coapRouter))
+			}()
+		} else {
+			psk, err := hex.DecodeString(coapPSK)
+			if err != nil {
+				panic(err)
+			}
+			config := &piondtls.Config{
+				PSK:             func([]byte) ([]byte, error) { return psk, nil },
+				PSKIdentityHint: []byte(coapPSKIdentity),
+				CipherSuites:    []piondtls.CipherSuiteID{piondtls.TLS_PSK_WITH_AES_128_CCM_8},
+			}
+			go func() {
+				log.Fatal(coap.ListenAndServeDTLS("udp", address, config, coapRouter))
+			}()
+		}
+	}
+
+	// Shutdown on interrupt, letting requests in flight finish
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		logger.Info("Shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error(err.Error())
+		}
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
 		}
-		log.Fatal(server.ListenAndServeTLS(httpsCert, httpsKey))
+		close(done)
+	}()
+
+	if httpsEnabled {
+		err = server.ListenAndServeTLS(httpsCert, httpsKey)
 	} else {
-		log.Fatal(server.ListenAndServe())
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	close(stop)
+
+	// Secondary writes of acknowledged readings are made before exiting
+	if fanout != nil {
+		fanout.Flush()
+		fanout.Retry()
+		if pending, dropped := fanout.Pending(), fanout.Dropped(); pending > 0 || dropped > 0 {
+			logger.Warn("Secondary writes lost", zap.Int("pending", pending), zap.Int("dropped", dropped))
+		}
 	}
 }
+
+// newMySQL creates a MySQL driver behind retries and a circuit breaker
+func newMySQL(dsn string, duplicates database.DuplicatePolicy) (*database.Resilient, error) {
+	mysqlDriver, err := database.NewMySQL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	mysqlDriver.Duplicates = duplicates
+
+	return database.NewResilient(mysqlDriver, database.ResilientConfig{
+		MaxAttempts:      retryAttempts,
+		BaseDelay:        retryBaseDelay,
+		MaxDelay:         retryMaxDelay,
+		FailureThreshold: breakerThreshold,
+		OpenTimeout:      breakerTimeout,
+		IdempotentWrites: true, // retries of a committed write are acknowledged as duplicates
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+	"go.uber.org/zap"
+)
+
+func TestRouter(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	driver.CreateDevice(&models.Device{ID: 7})
+	router := newRouter(routes{
+		status:  &controllers.Status{},
+		archive: &controllers.Archive{},
+		influx:  &controllers.Influx{},
+		socket:  &controllers.Socket{},
+		plant:   &controllers.Plant{Service: &services.PlantDatabase{Driver: driver}},
+		device:  &controllers.Device{Service: &services.DeviceDatabase{Driver: driver, Plants: driver}},
+		lora:    &controllers.LoRaWAN{},
+		alert:   &controllers.Alert{},
+		webhook: &controllers.Webhook{},
+		stream:  &controllers.Stream{},
+		fleet:   &controllers.Fleet{},
+		health:  &controllers.Health{},
+	}, false, zap.NewNop())
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Routes with an ID reach their controllers with it
+	tests := map[string]struct {
+		method             string // input method
+		path               string // input path
+		body               string // input body
+		expectedBody       string // expected body, if any
+		expectedStatusCode int    // expected status code
+	}{
+		"Plant":           {"GET", "/broker/plants/1", "", `{"id":1,"name":"","species":"","location":"","owner":""}`, http.StatusOK},
+		"Unknown plant":   {"GET", "/broker/plants/2", "", "Invalid ID.\n", http.StatusNotFound},
+		"Device":          {"GET", "/broker/devices/7", "", "", http.StatusOK},
+		"Device bindings": {"GET", "/broker/devices/7/bindings", "", "", http.StatusOK},
+		"Unknown device":  {"GET", "/broker/devices/8", "", "Invalid ID.\n", http.StatusNotFound},
+		"Uplink":          {"POST", "/broker/lorawan/uplink", "{}", "", http.StatusBadRequest},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request, _ := http.NewRequest(testCase.method, server.URL+testCase.path, strings.NewReader(testCase.body))
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, response.StatusCode)
+			}
+			body, _ := ioutil.ReadAll(response.Body)
+			if testCase.expectedBody != "" && string(body) != testCase.expectedBody {
+				t.Errorf("Expected %q, got %q", testCase.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestRouterWithoutUplinks(t *testing.T) {
+	router := newRouter(routes{
+		status:  &controllers.Status{},
+		archive: &controllers.Archive{},
+		influx:  &controllers.Influx{},
+		socket:  &controllers.Socket{},
+		plant:   &controllers.Plant{},
+		device:  &controllers.Device{},
+		alert:   &controllers.Alert{},
+		webhook: &controllers.Webhook{},
+		stream:  &controllers.Stream{},
+		fleet:   &controllers.Fleet{},
+		health:  &controllers.Health{},
+	}, true, zap.NewNop())
+
+	// Uplinks are not routed without a LoRaWAN controller
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("POST", "/broker/lorawan/uplink", strings.NewReader("{}")))
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
+
+func TestRouterAdmin(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	driver.CreateDevice(&models.Device{ID: 7})
+	router := newRouter(routes{
+		status:  &controllers.Status{},
+		archive: &controllers.Archive{},
+		influx:  &controllers.Influx{},
+		socket:  &controllers.Socket{},
+		plant:   &controllers.Plant{Service: &services.PlantDatabase{Driver: driver}},
+		device:  &controllers.Device{Service: &services.DeviceDatabase{Driver: driver, Plants: driver}},
+		alert:   &controllers.Alert{},
+		webhook: &controllers.Webhook{},
+		stream:  &controllers.Stream{},
+		fleet:   &controllers.Fleet{},
+		health:  &controllers.Health{},
+		admin:   &controllers.Admin{Token: "secret"},
+	}, true, zap.NewNop())
+
+	// Operator routes require the admin token
+	tests := map[string]struct {
+		method             string // input method
+		path               string // input path
+		authorization      string // input Authorization header
+		expectedStatusCode int    // expected status code
+	}{
+		"Credentials":               {"POST", "/broker/devices/7/credentials", "", http.StatusUnauthorized},
+		"Credentials with a device": {"POST", "/broker/devices/7/credentials", "Basic Nzp0b2tlbg==", http.StatusUnauthorized},
+		"Credentials as operator":   {"POST", "/broker/devices/7/credentials", "Bearer secret", http.StatusOK},
+		"Provisioning":              {"POST", "/broker/devices", "Bearer guess", http.StatusUnauthorized},
+		"Device":                    {"GET", "/broker/devices/7", "", http.StatusUnauthorized},
+		"Bindings":                  {"POST", "/broker/devices/7/bindings", "", http.StatusUnauthorized},
+		"Calibrations":              {"POST", "/broker/devices/7/calibrations", "", http.StatusUnauthorized},
+		"Plant":                     {"GET", "/broker/plants/1", "", http.StatusOK},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			request := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader("{}"))
+			if testCase.authorization != "" {
+				request.Header.Set("Authorization", testCase.authorization)
+			}
+			response := httptest.NewRecorder()
+
+			router.ServeHTTP(response, request)
+
+			if response.Code != testCase.expectedStatusCode {
+				t.Errorf("Expected %d, got %d", testCase.expectedStatusCode, response.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+// Alert rule operators
+const (
+	AlertBelow = "<"
+	AlertAbove = ">"
+)
+
+// Alert states
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertRule is a model for a plant metric threshold rule.
+// The rule fires once the metric stays past the threshold for For seconds,
+// and resolves once it gets back past the threshold by Hysteresis.
+type AlertRule struct {
+	ID         uint    `json:"id"`
+	PlantID    uint    `json:"plantId"`
+	Metric     string  `json:"metric"`
+	Operator   string  `json:"operator"`
+	Threshold  float64 `json:"threshold"`
+	For        int64   `json:"for"`
+	Hysteresis float64 `json:"hysteresis"`
+}
+
+// Alert is a model for the state of an alert rule
+type Alert struct {
+	ID           uint    `json:"id"`
+	RuleID       uint    `json:"ruleId"`
+	PlantID      uint    `json:"plantId"`
+	Metric       string  `json:"metric"`
+	State        string  `json:"state"`
+	Value        float64 `json:"value"`
+	Since        int64   `json:"since"`
+	FiredAt      int64   `json:"firedAt,omitempty"`
+	ResolvedAt   int64   `json:"resolvedAt,omitempty"`
+	Acknowledged bool    `json:"acknowledged"`
+}
@@ -0,0 +1,87 @@
+package models
+
+// Device is a model for sensor device information
+type Device struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"` // only set when credentials are issued
+	TokenHash string `json:"-"`
+	// DevEUI identifies LoRaWAN devices, as 16 upper case hex digits
+	DevEUI string `json:"devEui,omitempty"`
+	// Profile names the payload decoder of LoRaWAN devices
+	Profile string `json:"profile,omitempty"`
+	// Interval is the expected number of seconds between reports, 0 using the broker default
+	Interval int64 `json:"interval,omitempty"`
+}
+
+// Binding is a model for a device to plant binding.
+// A binding is effective from From (inclusive) to To (exclusive), To being 0 while open.
+type Binding struct {
+	DeviceID uint  `json:"deviceId"`
+	PlantID  uint  `json:"plantId"`
+	From     int64 `json:"from"`
+	To       int64 `json:"to,omitempty"`
+}
+
+// Calibration is a model for the calibration profile of a device.
+// A profile is effective from From (inclusive) until the next profile of the device.
+// Profiles are kept as a history, so stored readings can be recomputed from their raw values.
+type Calibration struct {
+	DeviceID uint  `json:"deviceId"`
+	From     int64 `json:"from"`
+	// Metrics are the corrections keyed by metric name; other metrics are stored as reported
+	Metrics map[string]MetricCalibration `json:"metrics"`
+}
+
+// MetricCalibration is a model for the correction of the raw values of a metric.
+// With a lookup table, values are interpolated between its points, and extrapolated
+// from its first and last segments; otherwise they are scaled, then offset.
+type MetricCalibration struct {
+	Offset float64 `json:"offset,omitempty"`
+	// Scale multiplies raw values, 0 standing for 1
+	Scale float64            `json:"scale,omitempty"`
+	Table []CalibrationPoint `json:"table,omitempty"`
+}
+
+// CalibrationPoint is a model for a point of a calibration lookup table
+type CalibrationPoint struct {
+	Raw   float64 `json:"raw"`
+	Value float64 `json:"value"`
+}
+
+// Valid reports whether the correction is usable: a table needs two points or more, in increasing raw order
+func (c MetricCalibration) Valid() bool {
+	if len(c.Table) == 0 {
+		return true
+	}
+	if len(c.Table) < 2 {
+		return false
+	}
+	for i := 1; i < len(c.Table); i++ {
+		if c.Table[i].Raw <= c.Table[i-1].Raw {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Apply corrects a raw value
+func (c MetricCalibration) Apply(raw float64) float64 {
+	if len(c.Table) >= 2 {
+		i := 1
+		for i < len(c.Table)-1 && raw > c.Table[i].Raw {
+			i++
+		}
+		low, high := c.Table[i-1], c.Table[i]
+
+		return low.Value + (raw-low.Raw)*(high.Value-low.Value)/(high.Raw-low.Raw)
+	}
+
+	scale := c.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	return raw*scale + c.Offset
+}
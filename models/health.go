@@ -0,0 +1,34 @@
+package models
+
+// Health is a model for service health information
+type Health struct {
+	Status   string            `json:"status"`
+	Breakers map[string]string `json:"breakers"`
+	// Fleet counts the devices per reporting state, if tracked
+	Fleet map[string]int `json:"fleet,omitempty"`
+}
+
+// Reporting states of devices and plants
+const (
+	ReportingOnline  = "online"
+	ReportingStale   = "stale"
+	ReportingOffline = "offline"
+)
+
+// Heartbeat is a model for the reporting state of a device or plant.
+// Device heartbeats hold the plant of their last reading.
+type Heartbeat struct {
+	DeviceID uint   `json:"deviceId,omitempty"`
+	PlantID  uint   `json:"plantId,omitempty"`
+	LastSeen int64  `json:"lastSeen,omitempty"` // receive time of the last accepted reading, 0 if none since startup
+	Interval int64  `json:"interval"`           // expected seconds between reports
+	State    string `json:"state"`
+}
+
+// Fleet is a model for the reporting state of every device and plant
+type Fleet struct {
+	Devices []*Heartbeat   `json:"devices"`
+	Plants  []*Heartbeat   `json:"plants"`
+	Counts  map[string]int `json:"counts"`  // devices per state
+	Checked int64          `json:"checked"` // time of the check the states are from, 0 if none yet
+}
@@ -0,0 +1,31 @@
+package models
+
+// Uplink is a model for a LoRaWAN uplink, as forwarded by a network server
+type Uplink struct {
+	DevEUI  string
+	Port    int
+	Payload []byte
+	// Decoded is the payload decoded by the network server, if any
+	Decoded    map[string]interface{}
+	ReceivedAt int64
+}
+
+// PayloadLayout is a model for a configurable byte-layout payload decoder
+type PayloadLayout struct {
+	// Profile is the device profile decoded with the layout
+	Profile string `json:"profile"`
+	// Port restricts the layout to uplinks on a port, 0 for any
+	Port   int           `json:"port,omitempty"`
+	Fields []LayoutField `json:"fields"`
+}
+
+// LayoutField is a model for a metric read from a payload.
+// The value is the integer at Offset, of Size bytes, multiplied by Scale.
+type LayoutField struct {
+	Metric       string  `json:"metric"`
+	Offset       int     `json:"offset"`
+	Size         int     `json:"size"`
+	Signed       bool    `json:"signed,omitempty"`
+	LittleEndian bool    `json:"littleEndian,omitempty"`
+	Scale        float64 `json:"scale,omitempty"` // defaults to 1
+}
@@ -0,0 +1,10 @@
+package models
+
+// Plant is a model for plant registry information
+type Plant struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Species  string `json:"species"`
+	Location string `json:"location"`
+	Owner    string `json:"owner"`
+}
@@ -1,10 +1,174 @@
 package models
 
-// StatusData is a model for status information
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Legacy metric names, sent as flat fields by old firmware
+const (
+	MetricTemperature = "temperature"
+	MetricHumidity    = "humidity"
+	MetricLight       = "light"
+)
+
+// Metric is a model for a single measurement
+type Metric struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// UnmarshalJSON accepts either a bare number or a {"value", "unit"} object
+func (m *Metric) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var value float64
+	if err := json.Unmarshal(b, &value); err == nil {
+		*m = Metric{Value: value}
+
+		return nil
+	}
+
+	var metric struct {
+		Value *float64 `json:"value"`
+		Unit  string   `json:"unit"`
+	}
+	if err := json.Unmarshal(b, &metric); err != nil {
+		return err
+	}
+	if metric.Value == nil {
+		return errors.New("missing metric value")
+	}
+	*m = Metric{Value: *metric.Value, Unit: metric.Unit}
+
+	return nil
+}
+
+// MetricDefinition is a model for a registered metric and its valid range
+type MetricDefinition struct {
+	Name string  `json:"name"`
+	Unit string  `json:"unit"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	// Formula computes a derived metric from the other metrics of a reading, if set
+	Formula string `json:"formula,omitempty"`
+	// Window makes a derived metric cumulative: the integral of its formula over the readings
+	// of the last Window seconds, in days, such as growing degree days
+	Window int64 `json:"window,omitempty"`
+	// MaxRate is the largest change per minute of reported values, if set; faster changes are flagged
+	MaxRate float64 `json:"maxRate,omitempty"`
+	// FlatCount is the number of equal consecutive values flagged as a flat line, if set
+	FlatCount int `json:"flatCount,omitempty"`
+}
+
+// Quality codes of suspect metrics
+const (
+	QualitySpike = "spike"
+	QualityRate  = "rate"
+	QualityFlat  = "flat"
+)
+
+// StatusData is a model for status information.
+// Metrics are keyed by name; metrics the sensor did not report are absent.
 type StatusData struct {
-	ID          uint  `json:"id"`
-	Timestamp   int64 `json:"timestamp"`
-	Temperature int   `json:"temperature"`
-	Humidity    uint  `json:"humidity"`
-	Light       uint  `json:"light"`
+	ID        uint              `json:"id"`
+	DeviceID  uint              `json:"deviceId,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+	Metrics   map[string]Metric `json:"metrics,omitempty"`
+	// IdempotencyKey identifies a write, so retries of it are not stored twice
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// ReceivedAt is the Unix time the broker received the reading, set by the broker
+	ReceivedAt int64 `json:"receivedAt,omitempty"`
+	// Raw are the values of calibrated metrics as the device reported them, set by the broker
+	Raw map[string]float64 `json:"raw,omitempty"`
+	// Quality flags suspect metrics with a quality code, keyed by name, set by the broker
+	Quality map[string]string `json:"quality,omitempty"`
+}
+
+// statusJSON is the wire format of StatusData, including legacy flat fields
+type statusJSON struct {
+	ID             uint               `json:"id"`
+	DeviceID       uint               `json:"deviceId"`
+	Timestamp      int64              `json:"timestamp"`
+	Metrics        map[string]*Metric `json:"metrics"`
+	Temperature    *float64           `json:"temperature"`
+	Humidity       *float64           `json:"humidity"`
+	Light          *float64           `json:"light"`
+	IdempotencyKey string             `json:"idempotencyKey"`
+}
+
+// UnmarshalJSON decodes status data, mapping legacy flat fields to metrics
+func (d *StatusData) UnmarshalJSON(b []byte) error {
+	var data statusJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	*d = StatusData{
+		ID:             data.ID,
+		DeviceID:       data.DeviceID,
+		Timestamp:      data.Timestamp,
+		IdempotencyKey: data.IdempotencyKey,
+	}
+	for name, metric := range data.Metrics {
+		// null metrics were not reported
+		if metric == nil {
+			continue
+		}
+		if d.Metrics == nil {
+			d.Metrics = map[string]Metric{}
+		}
+		d.Metrics[name] = *metric
+	}
+	d.setLegacy(MetricTemperature, data.Temperature)
+	d.setLegacy(MetricHumidity, data.Humidity)
+	d.setLegacy(MetricLight, data.Light)
+
+	return nil
+}
+
+// setLegacy sets a metric from a legacy flat field, unless already reported
+func (d *StatusData) setLegacy(name string, value *float64) {
+	if value == nil {
+		return
+	}
+	if _, ok := d.Metrics[name]; ok {
+		return
+	}
+	d.SetValue(name, *value)
+}
+
+// Value returns the value of a metric, and whether it was reported
+func (d *StatusData) Value(name string) (float64, bool) {
+	metric, ok := d.Metrics[name]
+
+	return metric.Value, ok
+}
+
+// SetValue sets the value of a metric, keeping its unit
+func (d *StatusData) SetValue(name string, value float64) {
+	if d.Metrics == nil {
+		d.Metrics = map[string]Metric{}
+	}
+	metric := d.Metrics[name]
+	metric.Value = value
+	d.Metrics[name] = metric
+}
+
+// Aggregate is a model for the metrics of the readings of a plant in a time window
+type Aggregate struct {
+	From    int64                     `json:"from"` // start of the window
+	To      int64                     `json:"to"`   // end of the window, excluded
+	Metrics map[string]*MetricSummary `json:"metrics"`
+}
+
+// MetricSummary is a model for the values of a metric in a time window
+type MetricSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	Unit  string  `json:"unit,omitempty"`
 }
@@ -0,0 +1,88 @@
+package models_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/models"
+)
+
+func TestStatusDataUnmarshalJSON(t *testing.T) {
+	tests := map[string]struct {
+		body     string             // input
+		expected *models.StatusData // expected data
+	}{
+		"Legacy flat fields": {
+			body: `{"id":1,"timestamp":1516472722,"temperature":21.4,"humidity":55,"light":null}`,
+			expected: &models.StatusData{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{
+				"temperature": {Value: 21.4},
+				"humidity":    {Value: 55},
+			}},
+		},
+		"Metrics": {
+			body: `{"id":1,"deviceId":3,"timestamp":1516472722,"metrics":{"ph":{"value":6.5,"unit":"pH"},"co2":410,"ec":null}}`,
+			expected: &models.StatusData{ID: 1, DeviceID: 3, Timestamp: 1516472722, Metrics: map[string]models.Metric{
+				"ph":  {Value: 6.5, Unit: "pH"},
+				"co2": {Value: 410},
+			}},
+		},
+		"Metrics take precedence": {
+			body: `{"id":1,"timestamp":1516472722,"temperature":21.4,"metrics":{"temperature":{"value":20,"unit":"°C"}}}`,
+			expected: &models.StatusData{ID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{
+				"temperature": {Value: 20, Unit: "°C"},
+			}},
+		},
+		"No metrics": {
+			body:     `{"id":1,"timestamp":1516472722}`,
+			expected: &models.StatusData{ID: 1, Timestamp: 1516472722},
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var data models.StatusData
+			if err := json.Unmarshal([]byte(testCase.body), &data); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			if !reflect.DeepEqual(&data, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, &data)
+			}
+		})
+	}
+
+	failures := map[string]string{
+		"Negative ID":          `{"id":-1,"timestamp":1516472722}`,
+		"Metric without value": `{"id":1,"metrics":{"ph":{"unit":"pH"}}}`,
+		"Invalid metric":       `{"id":1,"metrics":{"ph":"acidic"}}`,
+	}
+	for testName, body := range failures {
+		t.Run(testName, func(t *testing.T) {
+			var data models.StatusData
+			if err := json.Unmarshal([]byte(body), &data); err == nil {
+				t.Error("Error expected")
+			}
+		})
+	}
+}
+
+func TestStatusDataRoundTrip(t *testing.T) {
+	data := &models.StatusData{ID: 1, Timestamp: 1516472722}
+	data.SetValue("ph", 6.5)
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	expectedBody := `{"id":1,"timestamp":1516472722,"metrics":{"ph":{"value":6.5}}}`
+	if string(body) != expectedBody {
+		t.Errorf("Expected %s, got %s", expectedBody, body)
+	}
+
+	var decoded models.StatusData
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if value, ok := decoded.Value("ph"); !ok || value != 6.5 {
+		t.Errorf("Expected 6.5, got %v (%t)", value, ok)
+	}
+}
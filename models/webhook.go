@@ -0,0 +1,67 @@
+package models
+
+import "encoding/json"
+
+// Event types
+const (
+	EventReadingAccepted = "reading.accepted"
+	EventReadingRejected = "reading.rejected"
+	EventAlertFiring     = "alert.firing"
+	EventDeviceHealth    = "device.health"
+	EventDeviceOffline   = "device.offline"
+	EventDeviceOnline    = "device.online"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Event is a model for a broker event sent to subscribers
+type Event struct {
+	Type      string      `json:"type"`
+	PlantID   uint        `json:"plantId"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// RejectedReading is the data of a reading.rejected event
+type RejectedReading struct {
+	Reading *StatusData `json:"reading"`
+	Error   string      `json:"error"`
+}
+
+// DeviceHealth is the data of a device.health event, sent when the quality of a metric changes
+type DeviceHealth struct {
+	DeviceID uint    `json:"deviceId,omitempty"`
+	Metric   string  `json:"metric"`
+	Quality  string  `json:"quality"` // empty once the metric is no longer suspect
+	Value    float64 `json:"value"`
+	Time     int64   `json:"time"`
+}
+
+// Subscription is a model for a webhook subscriber
+type Subscription struct {
+	ID     uint     `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"` // only returned on subscription
+}
+
+// Delivery is a model for a webhook delivery attempt log.
+// Pending deliveries are retried from NextAttempt on.
+type Delivery struct {
+	ID             uint            `json:"id"`
+	SubscriptionID uint            `json:"subscriptionId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	State          string          `json:"state"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    int64           `json:"nextAttempt,omitempty"`
+	LastStatus     int             `json:"lastStatus,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	CreatedAt      int64           `json:"createdAt"`
+	DeliveredAt    int64           `json:"deliveredAt,omitempty"`
+}
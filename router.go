@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/berry-house/http_broker/controllers"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// routes are the controllers served by the HTTP router. Routes of a nil lora are left out,
+// and operator routes are open with a nil admin.
+type routes struct {
+	status  *controllers.Status
+	archive *controllers.Archive
+	influx  *controllers.Influx
+	socket  *controllers.Socket
+	plant   *controllers.Plant
+	device  *controllers.Device
+	lora    *controllers.LoRaWAN
+	alert   *controllers.Alert
+	webhook *controllers.Webhook
+	stream  *controllers.Stream
+	fleet   *controllers.Fleet
+	health  *controllers.Health
+	admin   *controllers.Admin
+}
+
+// newRouter builds the HTTP router of the broker, requiring device credentials for
+// ingestion if deviceAuth is set
+func newRouter(c routes, deviceAuth bool, logger *zap.Logger) *mux.Router {
+	router := mux.NewRouter()
+	// Provisioning, binding, calibration and webhook routes require the operator token
+	operator := func(handler http.HandlerFunc) http.Handler {
+		if c.admin == nil {
+			return handler
+		}
+
+		return c.admin.Authenticate(handler)
+	}
+	var statusHandler http.Handler = http.HandlerFunc(c.status.Write)
+	var statusBatchHandler http.Handler = http.HandlerFunc(c.status.WriteBatch)
+	var statusStreamHandler http.Handler = http.HandlerFunc(c.status.WriteStream)
+	var statusImportHandler http.Handler = http.HandlerFunc(c.archive.Import)
+	var influxHandler http.Handler = http.HandlerFunc(c.influx.Write)
+	var socketHandler http.Handler = http.HandlerFunc(c.socket.Serve)
+	if deviceAuth {
+		statusHandler = c.device.Authenticate(statusHandler)
+		statusBatchHandler = c.device.Authenticate(statusBatchHandler)
+		statusStreamHandler = c.device.Authenticate(statusStreamHandler)
+		statusImportHandler = c.device.Authenticate(statusImportHandler)
+		influxHandler = c.device.AuthenticateInflux(influxHandler)
+		socketHandler = c.device.Authenticate(socketHandler)
+	}
+	router.Handle("/broker/status", statusHandler).Methods("POST")
+	router.Handle("/broker/status/batch", statusBatchHandler).Methods("POST")
+	router.Handle("/broker/status/stream", statusStreamHandler).Methods("POST")
+	router.Handle("/broker/status/import", statusImportHandler).Methods("POST")
+	router.HandleFunc("/broker/status/{id}/export", c.archive.Export).Methods("GET")
+	router.HandleFunc("/broker/status/{id}/aggregate", c.archive.Aggregate).Methods("GET")
+	router.Handle("/broker/socket", socketHandler).Methods("GET")
+	// InfluxDB v2 compatible write endpoint, for firmware that only speaks line protocol
+	router.Handle("/api/v2/write", influxHandler).Methods("POST")
+	router.HandleFunc("/broker/plants", c.plant.Create).Methods("POST")
+	router.HandleFunc("/broker/plants", c.plant.List).Methods("GET")
+	router.HandleFunc("/broker/plants/{id}", c.plant.Read).Methods("GET")
+	router.HandleFunc("/broker/plants/{id}", c.plant.Update).Methods("PUT")
+	router.HandleFunc("/broker/plants/{id}", c.plant.Delete).Methods("DELETE")
+	router.Handle("/broker/devices", operator(c.device.Create)).Methods("POST")
+	router.Handle("/broker/devices", operator(c.device.List)).Methods("GET")
+	router.Handle("/broker/devices/{id}", operator(c.device.Read)).Methods("GET")
+	router.Handle("/broker/devices/{id}", operator(c.device.Update)).Methods("PUT")
+	router.Handle("/broker/devices/{id}", operator(c.device.Delete)).Methods("DELETE")
+	router.Handle("/broker/devices/{id}/credentials", operator(c.device.IssueCredentials)).Methods("POST")
+	router.Handle("/broker/devices/{id}/bindings", operator(c.device.Bindings)).Methods("GET")
+	router.Handle("/broker/devices/{id}/bindings", operator(c.device.Bind)).Methods("POST")
+	router.Handle("/broker/devices/{id}/bindings", operator(c.device.Unbind)).Methods("DELETE")
+	router.Handle("/broker/devices/{id}/calibrations", operator(c.device.Calibrations)).Methods("GET")
+	router.Handle("/broker/devices/{id}/calibrations", operator(c.device.Calibrate)).Methods("POST")
+	if c.lora != nil {
+		router.HandleFunc("/broker/lorawan/uplink", c.lora.Uplink).Methods("POST")
+	}
+	router.HandleFunc("/broker/alerts", c.alert.List).Methods("GET")
+	router.HandleFunc("/broker/alerts/{id}/ack", c.alert.Acknowledge).Methods("POST")
+	router.HandleFunc("/broker/alerts/rules", c.alert.CreateRule).Methods("POST")
+	router.HandleFunc("/broker/alerts/rules", c.alert.ListRules).Methods("GET")
+	router.HandleFunc("/broker/alerts/rules/{id}", c.alert.DeleteRule).Methods("DELETE")
+	router.Handle("/broker/webhooks", operator(c.webhook.Create)).Methods("POST")
+	router.Handle("/broker/webhooks", operator(c.webhook.List)).Methods("GET")
+	router.Handle("/broker/webhooks/{id}", operator(c.webhook.Delete)).Methods("DELETE")
+	router.Handle("/broker/webhooks/{id}/deliveries", operator(c.webhook.Deliveries)).Methods("GET")
+	router.HandleFunc("/broker/stream", c.stream.Read).Methods("GET")
+	router.HandleFunc("/broker/fleet", c.fleet.Read).Methods("GET")
+	router.HandleFunc("/broker/health", c.health.Read).Methods("GET")
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Keep the request context, which holds the route variables
+			rctx := r.WithContext(context.WithValue(r.Context(), "logger", logger))
+			next.ServeHTTP(w, rctx)
+		})
+	})
+
+	return router
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"go.uber.org/zap"
+)
+
+// AlertInvalidDataError is an error type for invalid alert data errors
+type AlertInvalidDataError string
+
+// AlertDatabaseDriverError is an error type for alert database driver errors
+type AlertDatabaseDriverError string
+
+func (e AlertInvalidDataError) Error() string    { return string(e) }
+func (e AlertDatabaseDriverError) Error() string { return string(e) }
+
+const (
+	// AlertInvalidData is the default error for invalid alert rules
+	AlertInvalidData = AlertInvalidDataError("invalid data")
+	// AlertInvalidID is the default error for non-existent rules and alerts
+	AlertInvalidID = AlertInvalidDataError("invalid ID")
+	// AlertInvalidPlant is the default error for rules on non-existent plants
+	AlertInvalidPlant = AlertInvalidDataError("invalid plant")
+)
+
+// AlertDatabase is a service for evaluating alert rules and managing alerts
+type AlertDatabase struct {
+	Driver database.AlertStore
+	// Metrics describes the metrics rules may watch, defaulting to DefaultMetrics
+	Metrics MetricRegistry
+	// Events is notified of firing alerts, if set
+	Events Publisher
+
+	mu    sync.Mutex
+	rules map[uint]*sync.Mutex
+}
+
+// CreateRule creates an alert rule
+func (s *AlertDatabase) CreateRule(rule *models.AlertRule) error {
+	if rule == nil {
+		return AlertInvalidDataError("nil data")
+	}
+	metrics := s.Metrics
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
+	if _, ok := metrics[rule.Metric]; !ok ||
+		(rule.Operator != models.AlertBelow && rule.Operator != models.AlertAbove) ||
+		rule.For < 0 || rule.Hysteresis < 0 {
+		return AlertInvalidData
+	}
+
+	return alertError(s.Driver.CreateAlertRule(rule), AlertInvalidPlant)
+}
+
+// Rules reads the alert rules of a plant, or every rule for plant 0
+func (s *AlertDatabase) Rules(plantID uint) ([]*models.AlertRule, error) {
+	rules, err := s.Driver.ReadAlertRules(plantID)
+	if err != nil {
+		return nil, alertError(err, AlertInvalidID)
+	}
+
+	return rules, nil
+}
+
+// DeleteRule deletes an alert rule and its alerts
+func (s *AlertDatabase) DeleteRule(id uint) error {
+	if err := s.Driver.DeleteAlertRule(id); err != nil {
+		return alertError(err, AlertInvalidID)
+	}
+
+	s.mu.Lock()
+	delete(s.rules, id)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Alerts reads the alerts in a state, or every alert for an empty state
+func (s *AlertDatabase) Alerts(state string) ([]*models.Alert, error) {
+	switch state {
+	case "", models.AlertPending, models.AlertFiring, models.AlertResolved:
+	default:
+		return nil, AlertInvalidData
+	}
+
+	alerts, err := s.Driver.ReadAlerts(state)
+	if err != nil {
+		return nil, alertError(err, AlertInvalidData)
+	}
+
+	return alerts, nil
+}
+
+// Acknowledge acknowledges an alert
+func (s *AlertDatabase) Acknowledge(id uint) error {
+	return alertError(s.Driver.AcknowledgeAlert(id), AlertInvalidID)
+}
+
+// Evaluate updates the alerts of every rule watching an accepted reading
+func (s *AlertDatabase) Evaluate(data *models.StatusData) error {
+	rules, err := s.Driver.ReadAlertRules(data.ID)
+	if err != nil {
+		return AlertDatabaseDriverError(err.Error())
+	}
+
+	for _, rule := range rules {
+		value, ok := data.Value(rule.Metric)
+		if !ok {
+			continue
+		}
+		if err := s.evaluateRule(rule, value, data.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// evaluateRule moves the alert of a rule through pending, firing and resolved.
+// Evaluations of a rule are serialized, so concurrent readings cannot both open an alert.
+func (s *AlertDatabase) evaluateRule(rule *models.AlertRule, value float64, timestamp int64) error {
+	lock := s.ruleLock(rule.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	alert, err := s.Driver.ReadActiveAlert(rule.ID)
+	if err != nil {
+		return AlertDatabaseDriverError(err.Error())
+	}
+
+	var firing bool
+	switch {
+	case alert == nil:
+		if !breached(rule, value) {
+			return nil
+		}
+		alert = &models.Alert{
+			RuleID:  rule.ID,
+			PlantID: rule.PlantID,
+			Metric:  rule.Metric,
+			State:   models.AlertPending,
+			Since:   timestamp,
+		}
+	case timestamp < alert.Since:
+		// Readings older than the alert do not change it
+		return nil
+	case alert.State == models.AlertPending && !breached(rule, value):
+		alert.State = models.AlertResolved
+		alert.ResolvedAt = timestamp
+	case alert.State == models.AlertFiring && cleared(rule, value):
+		alert.State = models.AlertResolved
+		alert.ResolvedAt = timestamp
+	}
+
+	if alert.State == models.AlertPending && timestamp-alert.Since >= rule.For {
+		alert.State = models.AlertFiring
+		alert.FiredAt = timestamp
+		firing = true
+	}
+	alert.Value = value
+
+	if err := s.Driver.WriteAlert(alert); err != nil {
+		return AlertDatabaseDriverError(err.Error())
+	}
+	if firing && s.Events != nil {
+		event := &models.Event{Type: models.EventAlertFiring, PlantID: alert.PlantID, Data: alert}
+		if err := s.Events.Publish(event); err != nil {
+			zap.L().Error(err.Error(), zap.String("service", "events"), zap.Uint("plant", alert.PlantID))
+		}
+	}
+
+	return nil
+}
+
+// ruleLock returns the lock of the evaluations of a rule
+func (s *AlertDatabase) ruleLock(id uint) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rules == nil {
+		s.rules = map[uint]*sync.Mutex{}
+	}
+	lock, ok := s.rules[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.rules[id] = lock
+	}
+
+	return lock
+}
+
+// breached checks if a value is past the rule threshold
+func breached(rule *models.AlertRule, value float64) bool {
+	if rule.Operator == models.AlertBelow {
+		return value < rule.Threshold
+	}
+
+	return value > rule.Threshold
+}
+
+// cleared checks if a value is back past the rule threshold by its hysteresis
+func cleared(rule *models.AlertRule, value float64) bool {
+	if rule.Operator == models.AlertBelow {
+		return value >= rule.Threshold+rule.Hysteresis
+	}
+
+	return value <= rule.Threshold-rule.Hysteresis
+}
+
+// alertError maps a driver error, using invalid for invalid data errors
+func alertError(err error, invalid AlertInvalidDataError) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case database.DatabaseInvalidDataError:
+		return invalid
+	default:
+		return AlertDatabaseDriverError(err.Error())
+	}
+}
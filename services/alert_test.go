@@ -0,0 +1,218 @@
+package services_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestAlertInvalidDataError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.AlertInvalidDataError // error
+		expected string                         // expected message
+	}{
+		"General test": {services.AlertInvalidDataError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+func TestAlertDatabaseDriverError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.AlertDatabaseDriverError // error
+		expected string                            // expected message
+	}{
+		"General test": {services.AlertDatabaseDriverError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+func newAlertService() (*services.AlertDatabase, *database.Memory) {
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+
+	return &services.AlertDatabase{Driver: driver}, driver
+}
+
+func TestAlertCreateRule(t *testing.T) {
+	service, _ := newAlertService()
+
+	tests := map[string]struct {
+		rule     *models.AlertRule // input
+		expected error             // expected error
+	}{
+		"Happy path":          {&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "<", Threshold: 20, For: 1800}, nil},
+		"nil data":            {nil, services.AlertInvalidDataError("nil data")},
+		"Unknown metric":      {&models.AlertRule{PlantID: 1, Metric: "radiation", Operator: ">"}, services.AlertInvalidData},
+		"Invalid operator":    {&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "="}, services.AlertInvalidData},
+		"Negative duration":   {&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "<", For: -1}, services.AlertInvalidData},
+		"Negative hysteresis": {&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "<", Hysteresis: -1}, services.AlertInvalidData},
+		"Invalid plant":       {&models.AlertRule{PlantID: 2, Metric: "humidity", Operator: "<"}, services.AlertInvalidPlant},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.CreateRule(testCase.rule)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestAlertEvaluate(t *testing.T) {
+	service, _ := newAlertService()
+	// humidity < 20 for 30m, resolving at 25
+	service.CreateRule(&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "<", Threshold: 20, For: 1800, Hysteresis: 5})
+
+	reading := func(timestamp int64, humidity float64) *models.StatusData {
+		data := &models.StatusData{ID: 1, Timestamp: timestamp}
+		data.SetValue("humidity", humidity)
+
+		return data
+	}
+
+	steps := []struct {
+		name     string             // step name
+		data     *models.StatusData // input
+		expected *models.Alert      // expected alert, nil for none
+	}{
+		{"Within threshold", reading(0, 30), nil},
+		{"Other metric", &models.StatusData{ID: 1, Timestamp: 100}, nil},
+		{"Breach", reading(600, 15), &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertPending, Value: 15, Since: 600}},
+		{"Still pending", reading(1800, 12), &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertPending, Value: 12, Since: 600}},
+		{"Fires after duration", reading(2400, 14), &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertFiring, Value: 14, Since: 600, FiredAt: 2400}},
+		{"Hysteresis keeps firing", reading(3000, 22), &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertFiring, Value: 22, Since: 600, FiredAt: 2400}},
+		{"Older reading ignored", reading(300, 40), &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertFiring, Value: 22, Since: 600, FiredAt: 2400}},
+		{"Resolves past hysteresis", reading(3600, 25), &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertResolved, Value: 25, Since: 600, FiredAt: 2400, ResolvedAt: 3600}},
+		{"New breach", reading(4200, 10), &models.Alert{ID: 2, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertPending, Value: 10, Since: 4200}},
+		{"Pending cancelled", reading(4800, 21), &models.Alert{ID: 2, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertResolved, Value: 21, Since: 4200, ResolvedAt: 4800}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err := service.Evaluate(step.data); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			alerts, _ := service.Alerts("")
+			var last *models.Alert
+			if len(alerts) > 0 {
+				last = alerts[len(alerts)-1]
+			}
+			if !reflect.DeepEqual(last, step.expected) {
+				t.Errorf("Expected %+v, got %+v", step.expected, last)
+			}
+		})
+	}
+}
+
+func TestAlertEvaluateImmediate(t *testing.T) {
+	service, _ := newAlertService()
+	service.CreateRule(&models.AlertRule{PlantID: 1, Metric: "temperature", Operator: ">", Threshold: 35})
+
+	data := &models.StatusData{ID: 1, Timestamp: 100}
+	data.SetValue("temperature", 40)
+	if err := service.Evaluate(data); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	firing, _ := service.Alerts(models.AlertFiring)
+	expected := []*models.Alert{&models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "temperature", State: models.AlertFiring, Value: 40, Since: 100, FiredAt: 100}}
+	if !reflect.DeepEqual(firing, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, firing)
+	}
+
+	if err := service.Acknowledge(1); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if err := service.Acknowledge(2); err != services.AlertInvalidID {
+		t.Errorf("Expected %+v, got %+v", services.AlertInvalidID, err)
+	}
+	if _, err := service.Alerts("exploded"); err != services.AlertInvalidData {
+		t.Errorf("Expected %+v, got %+v", services.AlertInvalidData, err)
+	}
+}
+
+// Alert store mock, reading active alerts slowly so concurrent evaluations overlap
+type mockSlowAlertStore struct {
+	*database.Memory
+}
+
+func (d *mockSlowAlertStore) ReadActiveAlert(ruleID uint) (*models.Alert, error) {
+	alert, err := d.Memory.ReadActiveAlert(ruleID)
+	time.Sleep(10 * time.Millisecond)
+
+	return alert, err
+}
+
+func TestAlertEvaluateConcurrent(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	service := &services.AlertDatabase{Driver: &mockSlowAlertStore{driver}}
+	service.CreateRule(&models.AlertRule{PlantID: 1, Metric: "temperature", Operator: ">", Threshold: 35, For: 600})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(timestamp int64) {
+			defer wg.Done()
+			data := &models.StatusData{ID: 1, Timestamp: timestamp}
+			data.SetValue("temperature", 40)
+			if err := service.Evaluate(data); err != nil {
+				t.Errorf("No error expected, got %+v", err)
+			}
+		}(int64(100 + i))
+	}
+	wg.Wait()
+
+	// Every reading updates the same alert
+	alerts, _ := service.Alerts("")
+	if len(alerts) != 1 || alerts[0].State != models.AlertPending || alerts[0].Since < 100 {
+		t.Errorf("Expected a single pending alert, got %+v", alerts)
+	}
+}
+
+// Alert evaluator mock, recording evaluated readings
+type mockAlertEvaluator struct {
+	evaluated []*models.StatusData
+}
+
+func (e *mockAlertEvaluator) Evaluate(data *models.StatusData) error {
+	e.evaluated = append(e.evaluated, data)
+
+	return services.AlertDatabaseDriverError("mocked error")
+}
+
+func TestStatusWriteEvaluatesAlerts(t *testing.T) {
+	alerts := &mockAlertEvaluator{}
+	service := services.StatusDatabase{
+		Driver: &mockDatabaseDriver{},
+		Alerts: alerts,
+	}
+
+	accepted := &models.StatusData{ID: 1, Timestamp: 1516478286}
+	if err := service.Write(accepted); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	service.Write(&models.StatusData{ID: 7, Timestamp: 1516478286})
+
+	expected := []*models.StatusData{accepted}
+	if !reflect.DeepEqual(alerts.evaluated, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, alerts.evaluated)
+	}
+}
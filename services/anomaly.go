@@ -0,0 +1,212 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/berry-house/http_broker/models"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAnomalyWindow     = 30
+	defaultAnomalySpikeScore = 3.5
+	// anomalyMinSamples is the number of values needed before spikes are detected
+	anomalyMinSamples = 8
+	// madScale makes the median absolute deviation comparable to a standard deviation
+	madScale = 0.6745
+)
+
+// anomalySeries identifies the values of a metric of a plant
+type anomalySeries struct {
+	plantID uint
+	metric  string
+}
+
+// anomalyState is the rolling statistics of a series
+type anomalyState struct {
+	values    []float64 // recent values, oldest first
+	timestamp int64     // time of the last value
+	equal     int       // consecutive values equal to the last one
+	quality   string    // quality of the last value
+}
+
+// AnomalyDetector is a service flagging suspect metrics from the rolling statistics of each plant:
+// spikes by their modified z-score, changes faster than the metric rate limit, and flat lines.
+// Flagged readings are stored with quality codes; changes of quality of stored readings raise
+// device.health events.
+// Statistics are kept in memory, so they restart empty with the broker.
+type AnomalyDetector struct {
+	// Window is the number of recent values of a metric spikes are compared to, defaulting to 30
+	Window int
+	// SpikeScore is the modified z-score past which values are spikes, defaulting to 3.5
+	SpikeScore float64
+	// Metrics gives the rate limits and flat line lengths of metrics, defaulting to DefaultMetrics
+	Metrics MetricRegistry
+	// Events is notified of quality changes, if set
+	Events Publisher
+
+	mu     sync.Mutex
+	series map[anomalySeries]*anomalyState
+}
+
+// Inspect flags the suspect metrics of a reading from the statistics of its plant, which are left
+// as they are until the reading is recorded. Derived metrics, and readings not newer than the last
+// one of a metric, such as retries, are not inspected.
+func (d *AnomalyDetector) Inspect(data *models.StatusData) {
+	metrics := d.metrics()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, metric := range data.Metrics {
+		definition, ok := metrics[name]
+		if !ok || definition.Formula != "" {
+			continue
+		}
+		state := d.series[anomalySeries{plantID: data.ID, metric: name}]
+		if state == nil {
+			state = &anomalyState{}
+		}
+		if len(state.values) > 0 && data.Timestamp <= state.timestamp {
+			continue
+		}
+
+		if quality := d.check(state, definition, metric.Value, data.Timestamp); quality != "" {
+			if data.Quality == nil {
+				data.Quality = map[string]string{}
+			}
+			data.Quality[name] = quality
+		}
+	}
+}
+
+// Record adds a stored reading to the statistics of its plant, publishing the changes of quality
+// of its inspected metrics
+func (d *AnomalyDetector) Record(data *models.StatusData) {
+	metrics := d.metrics()
+
+	var events []*models.Event
+	d.mu.Lock()
+	if d.series == nil {
+		d.series = map[anomalySeries]*anomalyState{}
+	}
+	for name, metric := range data.Metrics {
+		definition, ok := metrics[name]
+		if !ok || definition.Formula != "" {
+			continue
+		}
+		key := anomalySeries{plantID: data.ID, metric: name}
+		state := d.series[key]
+		if state == nil {
+			state = &anomalyState{}
+			d.series[key] = state
+		}
+		if len(state.values) > 0 && data.Timestamp <= state.timestamp {
+			continue
+		}
+
+		d.add(state, metric.Value, data.Timestamp)
+		if quality := data.Quality[name]; quality != state.quality {
+			state.quality = quality
+			events = append(events, &models.Event{
+				Type:    models.EventDeviceHealth,
+				PlantID: data.ID,
+				Data: &models.DeviceHealth{
+					DeviceID: data.DeviceID,
+					Metric:   name,
+					Quality:  quality,
+					Value:    metric.Value,
+					Time:     data.Timestamp,
+				},
+			})
+		}
+	}
+	d.mu.Unlock()
+
+	if d.Events == nil {
+		return
+	}
+	for _, event := range events {
+		if err := d.Events.Publish(event); err != nil {
+			zap.L().Error(err.Error(), zap.String("service", "events"), zap.Uint("plant", data.ID))
+		}
+	}
+}
+
+func (d *AnomalyDetector) metrics() MetricRegistry {
+	if d.Metrics == nil {
+		return DefaultMetrics
+	}
+
+	return d.Metrics
+}
+
+// check returns the quality code of a value, or "" for good values
+func (d *AnomalyDetector) check(state *anomalyState, definition models.MetricDefinition, value float64, timestamp int64) string {
+	score := d.SpikeScore
+	if score == 0 {
+		score = defaultAnomalySpikeScore
+	}
+	if len(state.values) >= anomalyMinSamples {
+		median, deviation := medianDeviation(state.values)
+		if deviation > 0 && madScale*math.Abs(value-median)/deviation > score {
+			return models.QualitySpike
+		}
+	}
+
+	if len(state.values) > 0 {
+		last := state.values[len(state.values)-1]
+		minutes := float64(timestamp-state.timestamp) / 60
+		if definition.MaxRate > 0 && math.Abs(value-last)/minutes > definition.MaxRate {
+			return models.QualityRate
+		}
+		if definition.FlatCount > 0 && value == last && state.equal+1 >= definition.FlatCount {
+			return models.QualityFlat
+		}
+	}
+
+	return ""
+}
+
+// add adds a value to the statistics of a series
+func (d *AnomalyDetector) add(state *anomalyState, value float64, timestamp int64) {
+	window := d.Window
+	if window <= 0 {
+		window = defaultAnomalyWindow
+	}
+	if len(state.values) > 0 && state.values[len(state.values)-1] == value {
+		state.equal++
+	} else {
+		state.equal = 1
+	}
+	state.values = append(state.values, value)
+	if len(state.values) > window {
+		state.values = state.values[len(state.values)-window:]
+	}
+	state.timestamp = timestamp
+}
+
+// medianDeviation returns the median of values and their median absolute deviation from it
+func medianDeviation(values []float64) (float64, float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := middle(sorted)
+	for i, value := range sorted {
+		sorted[i] = math.Abs(value - median)
+	}
+	sort.Float64s(sorted)
+
+	return median, middle(sorted)
+}
+
+// middle returns the median of sorted values
+func middle(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
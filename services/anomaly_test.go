@@ -0,0 +1,124 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestStatusWriteQuality(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}, 2: []*models.StatusData{}})
+	metrics := services.MetricRegistry{
+		"temperature": {Name: "temperature", Unit: "°C", Min: -30, Max: 50, MaxRate: 2, FlatCount: 3},
+		"light":       {Name: "light", Unit: "klx", Min: 0, Max: 150},
+	}
+	events := &mockPublisher{}
+	service := services.StatusDatabase{
+		Driver:  driver,
+		Metrics: metrics,
+		Quality: &services.AnomalyDetector{Window: 10, Metrics: metrics, Events: events},
+	}
+
+	// Eight steady values, so spikes can be detected
+	for i, value := range []float64{20, 21, 20, 21, 20, 21, 20, 21} {
+		data := &models.StatusData{ID: 1, Timestamp: int64(60 * (i + 1))}
+		data.SetValue("temperature", value)
+		if err := service.Write(data); err != nil || data.Quality != nil {
+			t.Fatalf("Expected a good reading, got %+v: %+v", data.Quality, err)
+		}
+	}
+
+	steps := []struct {
+		name      string  // step name
+		plantID   uint    // input plant
+		timestamp int64   // input timestamp
+		value     float64 // input temperature
+		expected  string  // expected quality
+	}{
+		{"Spike", 1, 540, 30, models.QualitySpike},
+		{"Fast change", 1, 600, 21, models.QualityRate},
+		{"Slow change", 1, 900, 21, ""},
+		{"Flat line", 1, 960, 21, models.QualityFlat},
+		{"Retry", 1, 960, 21, ""},
+		{"Other plant", 2, 960, 35, ""},
+	}
+	for _, step := range steps {
+		data := &models.StatusData{ID: step.plantID, Timestamp: step.timestamp}
+		data.SetValue("temperature", step.value)
+		data.SetValue("light", 0)
+		if err := service.Write(data); err != nil {
+			t.Fatalf("%s: no error expected, got %+v", step.name, err)
+		}
+		if quality := data.Quality["temperature"]; quality != step.expected {
+			t.Errorf("%s: expected %q, got %q", step.name, step.expected, quality)
+		}
+		if _, ok := data.Quality["light"]; ok {
+			t.Errorf("%s: expected light not to be flagged", step.name)
+		}
+	}
+
+	// Every change of quality is an event
+	var expected []*models.Event
+	for _, health := range []*models.DeviceHealth{
+		{Metric: "temperature", Quality: models.QualitySpike, Value: 30, Time: 540},
+		{Metric: "temperature", Quality: models.QualityRate, Value: 21, Time: 600},
+		{Metric: "temperature", Quality: "", Value: 21, Time: 900},
+		{Metric: "temperature", Quality: models.QualityFlat, Value: 21, Time: 960},
+	} {
+		expected = append(expected, &models.Event{Type: models.EventDeviceHealth, PlantID: 1, Data: health})
+	}
+	if !reflect.DeepEqual(events.events, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, events.events)
+	}
+
+	// Quality codes are stored with the reading
+	var stored map[string]string
+	driver.ReadStatus(1, 540, 541, func(data *models.StatusData) error {
+		stored = data.Quality
+
+		return nil
+	})
+	if expectedQuality := map[string]string{"temperature": models.QualitySpike}; !reflect.DeepEqual(stored, expectedQuality) {
+		t.Errorf("Expected %+v, got %+v", expectedQuality, stored)
+	}
+}
+
+func TestStatusWriteQualityRejected(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	driver.Duplicates = database.DuplicateReject
+	metrics := services.MetricRegistry{
+		"temperature": {Name: "temperature", Unit: "°C", Min: -30, Max: 50, MaxRate: 2},
+	}
+	events := &mockPublisher{}
+	service := services.StatusDatabase{
+		Driver:  driver,
+		Metrics: metrics,
+		Quality: &services.AnomalyDetector{Metrics: metrics, Events: events},
+	}
+	stored := &models.StatusData{ID: 1, Timestamp: 60}
+	stored.SetValue("temperature", 20)
+	driver.WriteStatus(stored)
+
+	// A reading rejected by the database is not part of the statistics
+	rejected := &models.StatusData{ID: 1, Timestamp: 60}
+	rejected.SetValue("temperature", 40)
+	if err := service.Write(rejected); err != services.StatusDuplicate {
+		t.Fatalf("Expected %+v, got %+v", services.StatusDuplicate, err)
+	}
+	data := &models.StatusData{ID: 1, Timestamp: 120}
+	data.SetValue("temperature", 20)
+	if err := service.Write(data); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if data.Quality != nil {
+		t.Errorf("Expected a good reading, got %+v", data.Quality)
+	}
+	if events.events != nil {
+		t.Errorf("Expected no events, got %+v", events.events)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// Calibrate adds a calibration profile to a device, effective from its start time, or now.
+// Profiles are never replaced, so readings can be recomputed from the profile history.
+func (s *DeviceDatabase) Calibrate(calibration *models.Calibration) error {
+	if calibration == nil {
+		return DeviceInvalidDataError("nil data")
+	}
+	if calibration.From == 0 {
+		calibration.From = s.now().Unix()
+	}
+	metrics := s.Metrics
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
+	if len(calibration.Metrics) == 0 {
+		return DeviceInvalidData
+	}
+	for name, correction := range calibration.Metrics {
+		if _, ok := metrics[name]; !ok || !correction.Valid() {
+			return DeviceInvalidData
+		}
+	}
+
+	calibrations, err := s.Driver.ReadCalibrations(calibration.DeviceID)
+	if err != nil {
+		return deviceError(err, DeviceInvalidID)
+	}
+	for _, stored := range calibrations {
+		if stored.From == calibration.From {
+			return DeviceInvalidCalibration
+		}
+	}
+
+	return deviceError(s.Driver.WriteCalibration(calibration), DeviceInvalidID)
+}
+
+// Calibrations reads the calibration profile history of a device
+func (s *DeviceDatabase) Calibrations(deviceID uint) ([]*models.Calibration, error) {
+	calibrations, err := s.Driver.ReadCalibrations(deviceID)
+	if err != nil {
+		return nil, deviceError(err, DeviceInvalidID)
+	}
+
+	return calibrations, nil
+}
+
+// calibrate corrects the metrics of a reading with the profile of its device effective
+// at its timestamp, keeping the reported values as raw values
+func calibrate(driver database.DeviceStore, data *models.StatusData) error {
+	calibrations, err := driver.ReadCalibrations(data.DeviceID)
+	if err != nil {
+		return err
+	}
+	var profile *models.Calibration
+	for _, calibration := range calibrations {
+		if calibration.From <= data.Timestamp {
+			profile = calibration
+		}
+	}
+	if profile == nil {
+		return nil
+	}
+
+	for name, correction := range profile.Metrics {
+		metric, ok := data.Metrics[name]
+		if !ok {
+			continue
+		}
+		if data.Raw == nil {
+			data.Raw = map[string]float64{}
+		}
+		data.Raw[name] = metric.Value
+		metric.Value = correction.Apply(metric.Value)
+		data.Metrics[name] = metric
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestDeviceCalibrate(t *testing.T) {
+	service, driver := newDeviceService()
+	driver.CreateDevice(&models.Device{ID: 1})
+	offset := map[string]models.MetricCalibration{"humidity": {Offset: -2}}
+
+	steps := []struct {
+		name        string              // step name
+		calibration *models.Calibration // input
+		expected    error               // expected error
+	}{
+		{"First profile", &models.Calibration{DeviceID: 1, From: 100, Metrics: offset}, nil},
+		{"Invalid device", &models.Calibration{DeviceID: 9, From: 200, Metrics: offset}, services.DeviceInvalidID},
+		{"No metrics", &models.Calibration{DeviceID: 1, From: 200}, services.DeviceInvalidData},
+		{"Unknown metric", &models.Calibration{DeviceID: 1, From: 200, Metrics: map[string]models.MetricCalibration{"radiation": {Scale: 2}}}, services.DeviceInvalidData},
+		{"Short table", &models.Calibration{DeviceID: 1, From: 200, Metrics: map[string]models.MetricCalibration{"humidity": {Table: []models.CalibrationPoint{{Raw: 0, Value: 0}}}}}, services.DeviceInvalidData},
+		{"Unordered table", &models.Calibration{DeviceID: 1, From: 200, Metrics: map[string]models.MetricCalibration{"humidity": {Table: []models.CalibrationPoint{{Raw: 50, Value: 60}, {Raw: 10, Value: 0}}}}}, services.DeviceInvalidData},
+		{"Stored start", &models.Calibration{DeviceID: 1, From: 100, Metrics: offset}, services.DeviceInvalidCalibration},
+		{"Default start", &models.Calibration{DeviceID: 1, Metrics: offset}, nil},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := service.Calibrate(step.calibration)
+			if !reflect.DeepEqual(err, step.expected) {
+				t.Errorf("Expected %+v, got %+v", step.expected, err)
+			}
+		})
+	}
+
+	calibrations, err := service.Calibrations(1)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := []*models.Calibration{
+		&models.Calibration{DeviceID: 1, From: 100, Metrics: offset},
+		&models.Calibration{DeviceID: 1, From: 1000, Metrics: offset},
+	}
+	if !reflect.DeepEqual(calibrations, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, calibrations)
+	}
+}
+
+func TestStatusWriteCalibrated(t *testing.T) {
+	deviceService, driver := newDeviceService()
+	driver.CreateDevice(&models.Device{ID: 7})
+	deviceService.Bind(&models.Binding{DeviceID: 7, PlantID: 1, From: 100})
+	deviceService.Calibrate(&models.Calibration{DeviceID: 7, From: 200, Metrics: map[string]models.MetricCalibration{
+		"temperature": {Scale: 0.5, Offset: 1},
+	}})
+	deviceService.Calibrate(&models.Calibration{DeviceID: 7, From: 300, Metrics: map[string]models.MetricCalibration{
+		"humidity": {Table: []models.CalibrationPoint{{Raw: 20, Value: 0}, {Raw: 40, Value: 50}, {Raw: 60, Value: 100}}},
+	}})
+
+	service := services.StatusDatabase{
+		Driver:  driver,
+		Devices: driver,
+		Metrics: services.MetricRegistry{
+			"temperature": services.DefaultMetrics["temperature"],
+			"humidity":    services.DefaultMetrics["humidity"],
+		},
+	}
+
+	tests := map[string]struct {
+		data            *models.StatusData // input
+		expected        error              // expected error
+		expectedMetrics map[string]float64 // expected metric values
+		expectedRaw     map[string]float64 // expected raw values
+	}{
+		"Before any profile": {
+			&models.StatusData{DeviceID: 7, Timestamp: 150, Metrics: map[string]models.Metric{"temperature": {Value: 20}}},
+			nil, map[string]float64{"temperature": 20}, nil,
+		},
+		"Linear": {
+			&models.StatusData{DeviceID: 7, Timestamp: 250, Metrics: map[string]models.Metric{"temperature": {Value: 40}, "humidity": {Value: 30}}},
+			nil, map[string]float64{"temperature": 21, "humidity": 30}, map[string]float64{"temperature": 40},
+		},
+		"Interpolated": {
+			&models.StatusData{DeviceID: 7, Timestamp: 350, Metrics: map[string]models.Metric{"temperature": {Value: 20}, "humidity": {Value: 50}}},
+			nil, map[string]float64{"temperature": 20, "humidity": 75}, map[string]float64{"humidity": 50},
+		},
+		"Extrapolated": {
+			&models.StatusData{DeviceID: 7, Timestamp: 360, Metrics: map[string]models.Metric{"humidity": {Value: 24}}},
+			nil, map[string]float64{"humidity": 10}, map[string]float64{"humidity": 24},
+		},
+		"Calibrated out of range": {
+			&models.StatusData{DeviceID: 7, Timestamp: 370, Metrics: map[string]models.Metric{"humidity": {Value: 62}}},
+			services.StatusInvalidData, map[string]float64{"humidity": 105}, map[string]float64{"humidity": 62},
+		},
+		"Plant IDs are not calibrated": {
+			&models.StatusData{ID: 1, Timestamp: 380, Metrics: map[string]models.Metric{"humidity": {Value: 50}}},
+			nil, map[string]float64{"humidity": 50}, nil,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Write(testCase.data)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			metrics := map[string]float64{}
+			for name, metric := range testCase.data.Metrics {
+				metrics[name] = metric.Value
+			}
+			if !reflect.DeepEqual(metrics, testCase.expectedMetrics) {
+				t.Errorf("Expected metrics %+v, got %+v", testCase.expectedMetrics, metrics)
+			}
+			if !reflect.DeepEqual(testCase.data.Raw, testCase.expectedRaw) {
+				t.Errorf("Expected raw values %+v, got %+v", testCase.expectedRaw, testCase.data.Raw)
+			}
+		})
+	}
+}
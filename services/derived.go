@@ -0,0 +1,202 @@
+package services
+
+import (
+	"math"
+	"sync"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// secondsPerDay converts integrals of cumulative derived metrics to days
+const secondsPerDay = 24 * 60 * 60
+
+// derive computes the derived metrics of a reading, replacing reported values.
+// A derived metric whose inputs were not reported, or whose value is out of its range,
+// is left as reported.
+func (s *StatusDatabase) derive(data *models.StatusData, metrics MetricRegistry) error {
+	inputs := metricValues(data)
+	for name, definition := range metrics {
+		if definition.Formula == "" {
+			continue
+		}
+		parsed, err := parseFormula(definition.Formula)
+		if err != nil {
+			return err
+		}
+
+		value, ok := parsed.eval(inputs)
+		if ok && definition.Window > 0 {
+			value, ok, err = s.integrate(data, name, parsed, value, definition.Window)
+			if err != nil {
+				return err
+			}
+		}
+		if !ok || math.IsNaN(value) || math.IsInf(value, 0) || value < definition.Min || value > definition.Max {
+			continue
+		}
+		if data.Metrics == nil {
+			data.Metrics = map[string]models.Metric{}
+		}
+		data.Metrics[name] = models.Metric{Value: value, Unit: definition.Unit}
+	}
+
+	return nil
+}
+
+// integrate integrates a formula over the stored readings of a plant in the window ending at a
+// reading, whose formula value is given, with the trapezoidal rule.
+// Stored readings missing the inputs of the formula are skipped. The window of the plant is read
+// once and then kept up to date by recordIntegrals; readings older than its last one read it again.
+func (s *StatusDatabase) integrate(data *models.StatusData, name string, parsed formula, value float64, window int64) (float64, bool, error) {
+	if s.Reader == nil {
+		return 0, false, nil
+	}
+
+	key := integralSeries{plantID: data.ID, metric: name}
+	s.integrals.mu.Lock()
+	current := s.integrals.windows[key]
+	if current != nil && data.Timestamp > current.last() {
+		integral := current.integral(data.Timestamp, value, window)
+		s.integrals.mu.Unlock()
+
+		return integral / secondsPerDay, true, nil
+	}
+	s.integrals.mu.Unlock()
+
+	read := &integralWindow{}
+	err := s.Reader.ReadStatus(data.ID, data.Timestamp-window, data.Timestamp, func(stored *models.StatusData) error {
+		if value, ok := parsed.eval(metricValues(stored)); ok {
+			read.add(stored.Timestamp, value, window)
+		}
+
+		return nil
+	})
+	switch err.(type) {
+	case nil:
+	case database.DatabaseInvalidDataError:
+		return 0, false, StatusInvalidID
+	default:
+		return 0, false, StatusDatabaseDriverError(err.Error())
+	}
+
+	s.integrals.mu.Lock()
+	if s.integrals.windows == nil {
+		s.integrals.windows = map[integralSeries]*integralWindow{}
+	}
+	if current == nil {
+		s.integrals.windows[key] = read
+	}
+	s.integrals.mu.Unlock()
+
+	return read.integral(data.Timestamp, value, window) / secondsPerDay, true, nil
+}
+
+// recordIntegrals adds a stored reading to the windows of the cumulative derived metrics of its plant.
+// A window is dropped when the reading is not newer than its last one, to be read again.
+func (s *StatusDatabase) recordIntegrals(data *models.StatusData, metrics MetricRegistry) {
+	s.integrals.mu.Lock()
+	defer s.integrals.mu.Unlock()
+
+	for name, definition := range metrics {
+		if definition.Formula == "" || definition.Window <= 0 {
+			continue
+		}
+		key := integralSeries{plantID: data.ID, metric: name}
+		current := s.integrals.windows[key]
+		if current == nil {
+			continue
+		}
+		parsed, err := parseFormula(definition.Formula)
+		if err != nil {
+			continue
+		}
+		value, ok := parsed.eval(metricValues(data))
+		if !ok {
+			continue
+		}
+		if data.Timestamp <= current.last() {
+			delete(s.integrals.windows, key)
+
+			continue
+		}
+		current.add(data.Timestamp, value, definition.Window)
+	}
+}
+
+// integralSeries identifies the values of a cumulative derived metric of a plant
+type integralSeries struct {
+	plantID uint
+	metric  string
+}
+
+// integralWindows holds the windows of the cumulative derived metrics of each plant
+type integralWindows struct {
+	mu      sync.Mutex
+	windows map[integralSeries]*integralWindow
+}
+
+// integralWindow is the formula values of the stored readings in a window, oldest first,
+// with the integral between them
+type integralWindow struct {
+	times  []int64
+	values []float64
+	sum    float64
+}
+
+// last returns the time of the newest value, or 0 if there are none
+func (w *integralWindow) last() int64 {
+	if len(w.times) == 0 {
+		return 0
+	}
+
+	return w.times[len(w.times)-1]
+}
+
+// integral returns the integral up to a newer value over the window ending at it, leaving the
+// window as it is
+func (w *integralWindow) integral(timestamp int64, value float64, window int64) float64 {
+	sum := w.sum
+	first := 0
+	for first < len(w.times) && w.times[first] < timestamp-window {
+		if first+1 < len(w.times) {
+			sum -= trapezoid(w.times[first], w.values[first], w.times[first+1], w.values[first+1])
+		}
+		first++
+	}
+	if first >= len(w.times)-1 {
+		// Nothing left to add to, rounding errors aside
+		sum = 0
+	}
+	if first < len(w.times) {
+		sum += trapezoid(w.last(), w.values[len(w.values)-1], timestamp, value)
+	}
+
+	return sum
+}
+
+// add adds a newer value to the window, dropping the values out of the window ending at it
+func (w *integralWindow) add(timestamp int64, value float64, window int64) {
+	w.sum = w.integral(timestamp, value, window)
+	first := 0
+	for first < len(w.times) && w.times[first] < timestamp-window {
+		first++
+	}
+	w.times = append(w.times[first:], timestamp)
+	w.values = append(w.values[first:], value)
+}
+
+// trapezoid returns the integral between two values with the trapezoidal rule
+func trapezoid(fromTime int64, fromValue float64, toTime int64, toValue float64) float64 {
+	return (fromValue + toValue) / 2 * float64(toTime-fromTime)
+}
+
+// metricValues returns the values of the metrics of a reading, keyed by name
+func metricValues(data *models.StatusData) map[string]float64 {
+	values := make(map[string]float64, len(data.Metrics))
+	for name, metric := range data.Metrics {
+		values[name] = metric.Value
+	}
+
+	return values
+}
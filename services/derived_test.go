@@ -0,0 +1,123 @@
+package services_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestStatusWriteDerived(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	gdd := services.DefaultMetrics["gdd"]
+	gdd.Window = 24 * 60 * 60
+	service := services.StatusDatabase{
+		Driver: driver,
+		Reader: driver,
+		Metrics: services.MetricRegistry{
+			"temperature": services.DefaultMetrics["temperature"],
+			"humidity":    services.DefaultMetrics["humidity"],
+			"dew_point":   services.DefaultMetrics["dew_point"],
+			"vpd":         services.DefaultMetrics["vpd"],
+			"gdd":         gdd,
+			"power":       {Name: "power", Unit: "", Min: -1000, Max: 1000, Formula: "-2 ^ 2 + (temperature - 10) * 2 ^ 3 ^ 0"},
+		},
+	}
+
+	steps := []struct {
+		name     string             // step name
+		data     *models.StatusData // input
+		expected map[string]float64 // expected derived metrics
+	}{
+		{
+			"First reading",
+			&models.StatusData{ID: 1, Timestamp: 100000, Metrics: map[string]models.Metric{"temperature": {Value: 20}, "humidity": {Value: 50}}},
+			map[string]float64{"dew_point": 9.255, "vpd": 1.169, "gdd": 0, "power": 16},
+		},
+		{
+			"Half a day later",
+			&models.StatusData{ID: 1, Timestamp: 143200, Metrics: map[string]models.Metric{"temperature": {Value: 30}, "humidity": {Value: 100}}},
+			map[string]float64{"dew_point": 30, "vpd": 0, "gdd": 7.5, "power": 36},
+		},
+		{
+			"Missing inputs",
+			&models.StatusData{ID: 1, Timestamp: 186400, Metrics: map[string]models.Metric{"humidity": {Value: 40}}},
+			map[string]float64{},
+		},
+		{
+			// The first reading is out of the window, and the previous one is skipped
+			"Out of range",
+			&models.StatusData{ID: 1, Timestamp: 200000, Metrics: map[string]models.Metric{"temperature": {Value: 10}, "humidity": {Value: 0}}},
+			map[string]float64{"vpd": 1.228, "gdd": 6.574, "power": -4},
+		},
+	}
+	for _, step := range steps {
+		if err := service.Write(step.data); err != nil {
+			t.Fatalf("%s: no error expected, got %+v", step.name, err)
+		}
+		for _, name := range []string{"dew_point", "vpd", "gdd", "power"} {
+			metric, ok := step.data.Metrics[name]
+			expected, expectedOK := step.expected[name]
+			if ok != expectedOK || math.Abs(metric.Value-expected) > 0.001 {
+				t.Errorf("%s: expected %s %v (%t), got %v (%t)", step.name, name, expected, expectedOK, metric.Value, ok)
+			}
+		}
+	}
+}
+
+// countingReader counts the reads of stored readings
+type countingReader struct {
+	*database.Memory
+	reads int
+}
+
+func (r *countingReader) ReadStatus(plantID uint, from, to int64, fn func(data *models.StatusData) error) error {
+	r.reads++
+
+	return r.Memory.ReadStatus(plantID, from, to, fn)
+}
+
+func TestStatusWriteDerivedWindow(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	reader := &countingReader{Memory: driver}
+	gdd := services.DefaultMetrics["gdd"]
+	gdd.Window = 24 * 60 * 60
+	metrics := services.MetricRegistry{"temperature": services.DefaultMetrics["temperature"], "gdd": gdd}
+	service := services.StatusDatabase{Driver: driver, Reader: reader, Metrics: metrics}
+
+	// The window is read once, and integrals match those over the stored readings
+	for i, temperature := range []float64{20, 30, 25, 15, 10, 35, 20, 22} {
+		data := &models.StatusData{ID: 1, Timestamp: int64(100000 + 20000*i)}
+		data.SetValue("temperature", temperature)
+		if err := service.Write(data); err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+
+		fresh := services.StatusDatabase{Driver: driver, Reader: driver, Metrics: metrics}
+		read := &models.StatusData{ID: 1, Timestamp: data.Timestamp}
+		read.SetValue("temperature", temperature)
+		if err := fresh.Write(read); err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+		if expected, value := read.Metrics["gdd"].Value, data.Metrics["gdd"].Value; math.Abs(value-expected) > 0.001 {
+			t.Errorf("Reading %d: expected %v, got %v", i, expected, value)
+		}
+	}
+	if reader.reads != 1 {
+		t.Errorf("Expected 1 read, got %d", reader.reads)
+	}
+
+	// Older readings read the window again
+	data := &models.StatusData{ID: 1, Timestamp: 150000}
+	data.SetValue("temperature", 20)
+	if err := service.Write(data); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if reader.reads != 2 {
+		t.Errorf("Expected 2 reads, got %d", reader.reads)
+	}
+}
@@ -0,0 +1,297 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// DeviceInvalidDataError is an error type for invalid device data errors
+type DeviceInvalidDataError string
+
+// DeviceDatabaseDriverError is an error type for device database driver errors
+type DeviceDatabaseDriverError string
+
+func (e DeviceInvalidDataError) Error() string    { return string(e) }
+func (e DeviceDatabaseDriverError) Error() string { return string(e) }
+
+const (
+	// DeviceInvalidData is the default error for invalid device data
+	DeviceInvalidData = DeviceInvalidDataError("invalid data")
+	// DeviceInvalidID is the default error for non-existent device IDs
+	DeviceInvalidID = DeviceInvalidDataError("invalid ID")
+	// DeviceDuplicateID is the default error for already registered device IDs
+	DeviceDuplicateID = DeviceInvalidDataError("duplicate ID")
+	// DeviceInvalidPlant is the default error for bindings to non-existent plants
+	DeviceInvalidPlant = DeviceInvalidDataError("invalid plant")
+	// DeviceInvalidBinding is the default error for overlapping or missing bindings
+	DeviceInvalidBinding = DeviceInvalidDataError("invalid binding")
+	// DeviceUnauthorized is the default error for invalid device credentials
+	DeviceUnauthorized = DeviceInvalidDataError("unauthorized")
+	// DeviceDuplicateEUI is the default error for already registered LoRaWAN DevEUIs
+	DeviceDuplicateEUI = DeviceInvalidDataError("duplicate DevEUI")
+	// DeviceInvalidCalibration is the default error for profiles starting at the time of a stored one
+	DeviceInvalidCalibration = DeviceInvalidDataError("invalid calibration")
+)
+
+// tokenBytes is the number of random bytes in a device token
+const tokenBytes = 32
+
+// DeviceDatabase is a service for provisioning devices and binding them to plants
+type DeviceDatabase struct {
+	Driver database.DeviceStore
+	Plants database.Database
+	// Metrics describes the metrics calibration profiles may correct, defaulting to DefaultMetrics
+	Metrics MetricRegistry
+	// Clock returns the current time, defaulting to time.Now
+	Clock func() time.Time
+}
+
+// Provision registers a device and issues its credentials
+func (s *DeviceDatabase) Provision(device *models.Device) error {
+	if device == nil {
+		return DeviceInvalidDataError("nil data")
+	}
+	if device.Interval < 0 {
+		return DeviceInvalidData
+	}
+	if device.DevEUI != "" {
+		devEUI, ok := normalizeEUI(device.DevEUI)
+		if !ok {
+			return DeviceInvalidData
+		}
+		device.DevEUI = devEUI
+		_, err := s.Driver.ReadDeviceByEUI(devEUI)
+		if err == nil {
+			return DeviceDuplicateEUI
+		}
+		if _, ok := err.(database.DatabaseInvalidDataError); !ok {
+			return DeviceDatabaseDriverError(err.Error())
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return DeviceDatabaseDriverError(err.Error())
+	}
+	device.TokenHash = hashToken(token)
+	if err := deviceError(s.Driver.CreateDevice(device), DeviceDuplicateID); err != nil {
+		return err
+	}
+	device.Token = token
+
+	return nil
+}
+
+// Read reads a device
+func (s *DeviceDatabase) Read(id uint) (*models.Device, error) {
+	device, err := s.Driver.ReadDevice(id)
+	if err != nil {
+		return nil, deviceError(err, DeviceInvalidID)
+	}
+
+	return device, nil
+}
+
+// ReadAll reads every device
+func (s *DeviceDatabase) ReadAll() ([]*models.Device, error) {
+	devices, err := s.Driver.ReadDevices()
+	if err != nil {
+		return nil, deviceError(err, DeviceInvalidData)
+	}
+
+	return devices, nil
+}
+
+// Update updates the name and expected reporting interval of a device, keeping its credentials
+func (s *DeviceDatabase) Update(device *models.Device) error {
+	if device == nil {
+		return DeviceInvalidDataError("nil data")
+	}
+	if device.Interval < 0 {
+		return DeviceInvalidData
+	}
+
+	stored, err := s.Driver.ReadDevice(device.ID)
+	if err != nil {
+		return deviceError(err, DeviceInvalidID)
+	}
+	stored.Name = device.Name
+	stored.Interval = device.Interval
+	if err := deviceError(s.Driver.UpdateDevice(stored), DeviceInvalidID); err != nil {
+		return err
+	}
+	*device = *stored
+
+	return nil
+}
+
+// Delete deletes a device
+func (s *DeviceDatabase) Delete(id uint) error {
+	return deviceError(s.Driver.DeleteDevice(id), DeviceInvalidID)
+}
+
+// IssueCredentials replaces the credentials of a device, returning the new token
+func (s *DeviceDatabase) IssueCredentials(id uint) (string, error) {
+	device, err := s.Driver.ReadDevice(id)
+	if err != nil {
+		return "", deviceError(err, DeviceInvalidID)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", DeviceDatabaseDriverError(err.Error())
+	}
+	device.TokenHash = hashToken(token)
+	if err := deviceError(s.Driver.UpdateDevice(device), DeviceInvalidID); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Authenticate checks the credentials of a device
+func (s *DeviceDatabase) Authenticate(id uint, token string) error {
+	device, err := s.Driver.ReadDevice(id)
+	if _, ok := err.(database.DatabaseInvalidDataError); ok {
+		return DeviceUnauthorized
+	}
+	if err != nil {
+		return DeviceDatabaseDriverError(err.Error())
+	}
+
+	hash := hashToken(token)
+	if device.TokenHash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(device.TokenHash)) != 1 {
+		return DeviceUnauthorized
+	}
+
+	return nil
+}
+
+// Bind binds a device to a plant, closing its current binding.
+// Bindings start now when no start time is given.
+func (s *DeviceDatabase) Bind(binding *models.Binding) error {
+	if binding == nil {
+		return DeviceInvalidDataError("nil data")
+	}
+	if binding.From == 0 {
+		binding.From = s.now().Unix()
+	}
+	if binding.To != 0 && binding.To <= binding.From {
+		return DeviceInvalidData
+	}
+
+	exists, err := s.Plants.Exists(binding.PlantID)
+	if err != nil {
+		return DeviceDatabaseDriverError(err.Error())
+	}
+	if !exists {
+		return DeviceInvalidPlant
+	}
+
+	bindings, err := s.Driver.ReadBindings(binding.DeviceID)
+	if err != nil {
+		return deviceError(err, DeviceInvalidID)
+	}
+	if len(bindings) > 0 {
+		last := bindings[len(bindings)-1]
+		if binding.From <= last.From || (last.To != 0 && binding.From < last.To) {
+			return DeviceInvalidBinding
+		}
+		if last.To == 0 {
+			if err := deviceError(s.Driver.CloseBinding(binding.DeviceID, binding.From), DeviceInvalidBinding); err != nil {
+				return err
+			}
+		}
+	}
+
+	return deviceError(s.Driver.WriteBinding(binding), DeviceInvalidID)
+}
+
+// Unbind closes the current binding of a device at the given time, or now
+func (s *DeviceDatabase) Unbind(deviceID uint, at int64) error {
+	if at == 0 {
+		at = s.now().Unix()
+	}
+
+	bindings, err := s.Driver.ReadBindings(deviceID)
+	if err != nil {
+		return deviceError(err, DeviceInvalidID)
+	}
+	if len(bindings) == 0 {
+		return DeviceInvalidBinding
+	}
+	last := bindings[len(bindings)-1]
+	if last.To != 0 || at <= last.From {
+		return DeviceInvalidBinding
+	}
+
+	return deviceError(s.Driver.CloseBinding(deviceID, at), DeviceInvalidBinding)
+}
+
+// Bindings reads the binding history of a device
+func (s *DeviceDatabase) Bindings(deviceID uint) ([]*models.Binding, error) {
+	bindings, err := s.Driver.ReadBindings(deviceID)
+	if err != nil {
+		return nil, deviceError(err, DeviceInvalidID)
+	}
+
+	return bindings, nil
+}
+
+func (s *DeviceDatabase) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+
+	return s.Clock()
+}
+
+// resolvePlant finds the plant a device was bound to at the given time
+func resolvePlant(driver database.DeviceStore, deviceID uint, timestamp int64) (uint, error) {
+	bindings, err := driver.ReadBindings(deviceID)
+	if err != nil {
+		return 0, err
+	}
+	for _, binding := range bindings {
+		if binding.From <= timestamp && (binding.To == 0 || timestamp < binding.To) {
+			return binding.PlantID, nil
+		}
+	}
+
+	return 0, database.DatabaseInvalidDataError("no binding")
+}
+
+// newToken generates a random device token
+func newToken() (string, error) {
+	token := make([]byte, tokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// hashToken hashes a device token for storage
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// deviceError maps a driver error, using invalid for invalid data errors
+func deviceError(err error, invalid DeviceInvalidDataError) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case database.DatabaseInvalidDataError:
+		return invalid
+	default:
+		return DeviceDatabaseDriverError(err.Error())
+	}
+}
@@ -0,0 +1,238 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestDeviceInvalidDataError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.DeviceInvalidDataError // error
+		expected string                          // expected message
+	}{
+		"General test": {services.DeviceInvalidDataError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+func TestDeviceDatabaseDriverError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.DeviceDatabaseDriverError // error
+		expected string                             // expected message
+	}{
+		"General test": {services.DeviceDatabaseDriverError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+func newDeviceService() (*services.DeviceDatabase, *database.Memory) {
+	driver, _ := database.NewMemory(
+		map[uint][]*models.StatusData{
+			1: []*models.StatusData{},
+			2: []*models.StatusData{},
+		},
+	)
+
+	return &services.DeviceDatabase{
+		Driver: driver,
+		Plants: driver,
+		Clock:  func() time.Time { return time.Unix(1000, 0) },
+	}, driver
+}
+
+func TestDeviceProvision(t *testing.T) {
+	service, _ := newDeviceService()
+
+	device := &models.Device{Name: "probe"}
+	if err := service.Provision(device); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if device.ID != 1 || len(device.Token) != 64 {
+		t.Errorf("Expected ID 1 and a token, got %+v", device)
+	}
+
+	tests := map[string]struct {
+		id       uint   // input ID
+		token    string // input token
+		expected error  // expected error
+	}{
+		"Happy path":     {device.ID, device.Token, nil},
+		"Wrong token":    {device.ID, "guess", services.DeviceUnauthorized},
+		"Unknown device": {7, device.Token, services.DeviceUnauthorized},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Authenticate(testCase.id, testCase.token)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+
+	// Issuing new credentials revokes the old ones
+	token, err := service.IssueCredentials(device.ID)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	if err := service.Authenticate(device.ID, device.Token); err != services.DeviceUnauthorized {
+		t.Errorf("Expected %+v, got %+v", services.DeviceUnauthorized, err)
+	}
+	if err := service.Authenticate(device.ID, token); err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+
+	err = service.Provision(&models.Device{ID: device.ID})
+	if err != services.DeviceDuplicateID {
+		t.Errorf("Expected %+v, got %+v", services.DeviceDuplicateID, err)
+	}
+	if _, err := service.IssueCredentials(7); err != services.DeviceInvalidID {
+		t.Errorf("Expected %+v, got %+v", services.DeviceInvalidID, err)
+	}
+}
+
+func TestDeviceProvisionEUI(t *testing.T) {
+	service, _ := newDeviceService()
+
+	tests := []struct {
+		name           string         // step name
+		device         *models.Device // input device
+		expectedDevEUI string         // expected stored DevEUI
+		expected       error          // expected error
+	}{
+		{"Happy path", &models.Device{DevEUI: "70-b3-d5-7e-d0-05-a1-b2", Profile: "cayenne-lpp"}, "70B3D57ED005A1B2", nil},
+		{"Duplicate", &models.Device{DevEUI: "70b3d57ed005a1b2"}, "", services.DeviceDuplicateEUI},
+		{"Too short", &models.Device{DevEUI: "70b3d57e"}, "", services.DeviceInvalidData},
+		{"Not hex", &models.Device{DevEUI: "70b3d57ed005a1bz"}, "", services.DeviceInvalidData},
+	}
+	for _, step := range tests {
+		err := service.Provision(step.device)
+		if err != step.expected {
+			t.Fatalf("%s: expected %+v, got %+v", step.name, step.expected, err)
+		}
+		if err == nil && step.device.DevEUI != step.expectedDevEUI {
+			t.Errorf("%s: expected DevEUI %s, got %s", step.name, step.expectedDevEUI, step.device.DevEUI)
+		}
+	}
+}
+
+func TestDeviceBind(t *testing.T) {
+	service, driver := newDeviceService()
+	driver.CreateDevice(&models.Device{ID: 1})
+
+	steps := []struct {
+		name     string          // step name
+		binding  *models.Binding // input
+		expected error           // expected error
+	}{
+		{"First binding", &models.Binding{DeviceID: 1, PlantID: 1, From: 100}, nil},
+		{"Invalid plant", &models.Binding{DeviceID: 1, PlantID: 9, From: 200}, services.DeviceInvalidPlant},
+		{"Invalid device", &models.Binding{DeviceID: 9, PlantID: 1, From: 200}, services.DeviceInvalidID},
+		{"Overlapping binding", &models.Binding{DeviceID: 1, PlantID: 2, From: 50}, services.DeviceInvalidBinding},
+		{"Invalid range", &models.Binding{DeviceID: 1, PlantID: 2, From: 300, To: 200}, services.DeviceInvalidData},
+		{"Swap plant", &models.Binding{DeviceID: 1, PlantID: 2, From: 300}, nil},
+		{"Default start", &models.Binding{DeviceID: 1, PlantID: 1}, nil},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := service.Bind(step.binding)
+			if !reflect.DeepEqual(err, step.expected) {
+				t.Errorf("Expected %+v, got %+v", step.expected, err)
+			}
+		})
+	}
+
+	bindings, err := service.Bindings(1)
+	if err != nil {
+		t.Errorf("No error expected, got %+v", err)
+	}
+	expected := []*models.Binding{
+		&models.Binding{DeviceID: 1, PlantID: 1, From: 100, To: 300},
+		&models.Binding{DeviceID: 1, PlantID: 2, From: 300, To: 1000},
+		&models.Binding{DeviceID: 1, PlantID: 1, From: 1000},
+	}
+	if !reflect.DeepEqual(bindings, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, bindings)
+	}
+}
+
+func TestDeviceUnbind(t *testing.T) {
+	service, driver := newDeviceService()
+	driver.CreateDevice(&models.Device{ID: 1})
+	driver.CreateDevice(&models.Device{ID: 2})
+	service.Bind(&models.Binding{DeviceID: 1, PlantID: 1, From: 100})
+
+	steps := []struct {
+		name     string // step name
+		deviceID uint   // input device
+		at       int64  // input time
+		expected error  // expected error
+	}{
+		{"Before binding", 1, 50, services.DeviceInvalidBinding},
+		{"Happy path", 1, 200, nil},
+		{"Already unbound", 1, 300, services.DeviceInvalidBinding},
+		{"Never bound", 2, 300, services.DeviceInvalidBinding},
+		{"Invalid device", 9, 300, services.DeviceInvalidID},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := service.Unbind(step.deviceID, step.at)
+			if !reflect.DeepEqual(err, step.expected) {
+				t.Errorf("Expected %+v, got %+v", step.expected, err)
+			}
+		})
+	}
+}
+
+func TestStatusWriteDevice(t *testing.T) {
+	deviceService, driver := newDeviceService()
+	driver.CreateDevice(&models.Device{ID: 7})
+	deviceService.Bind(&models.Binding{DeviceID: 7, PlantID: 1, From: 100})
+	deviceService.Bind(&models.Binding{DeviceID: 7, PlantID: 2, From: 200})
+
+	service := services.StatusDatabase{
+		Driver:  driver,
+		Devices: driver,
+	}
+
+	tests := map[string]struct {
+		data            *models.StatusData // input
+		expected        error              // expected error
+		expectedPlantID uint               // expected resolved plant
+	}{
+		"First plant":      {&models.StatusData{DeviceID: 7, Timestamp: 150}, nil, 1},
+		"Second plant":     {&models.StatusData{DeviceID: 7, Timestamp: 200}, nil, 2},
+		"Before binding":   {&models.StatusData{DeviceID: 7, Timestamp: 50}, services.StatusInvalidID, 0},
+		"Unknown device":   {&models.StatusData{DeviceID: 8, Timestamp: 150}, services.StatusInvalidID, 0},
+		"Legacy plant IDs": {&models.StatusData{ID: 2, Timestamp: 150}, nil, 2},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Write(testCase.data)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if testCase.data.ID != testCase.expectedPlantID {
+				t.Errorf("Expected plant %d, got %d", testCase.expectedPlantID, testCase.data.ID)
+			}
+		})
+	}
+}
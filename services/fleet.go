@@ -0,0 +1,364 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"go.uber.org/zap"
+)
+
+// FleetInvalidDataError is an error type for invalid fleet queries
+type FleetInvalidDataError string
+
+// FleetDatabaseDriverError is an error type for fleet database driver errors
+type FleetDatabaseDriverError string
+
+func (e FleetInvalidDataError) Error() string    { return string(e) }
+func (e FleetDatabaseDriverError) Error() string { return string(e) }
+
+const (
+	// FleetInvalidState is the default error for unknown reporting states
+	FleetInvalidState = FleetInvalidDataError("invalid state")
+)
+
+const (
+	defaultFleetInterval     = 15 * time.Minute
+	defaultFleetOfflineAfter = 3
+)
+
+// FleetMonitor is a service tracking when devices and plants last reported.
+// Devices are stale once their expected reporting interval passes without an accepted reading,
+// and offline after OfflineAfter intervals; devices going offline and coming back raise
+// device.offline and device.online events. Plants are tracked likewise with the default interval.
+// Last seen times are kept in memory, so intervals are counted from startup until a first reading.
+type FleetMonitor struct {
+	Devices database.DeviceStore
+	Plants  database.PlantStore
+	// Interval is the expected reporting interval of plants and of devices without one, defaulting to 15 minutes
+	Interval time.Duration
+	// OfflineAfter is the number of missed intervals after which stale devices are offline, defaulting to 3
+	OfflineAfter int
+	// Events is notified of devices going offline and coming back, if set
+	Events Publisher
+	// Clock returns the current time, defaulting to time.Now
+	Clock func() time.Time
+
+	mu      sync.Mutex
+	started int64
+	checked int64
+	devices map[uint]*models.Heartbeat
+	plants  map[uint]*models.Heartbeat
+}
+
+// Seen records an accepted reading as a sign of life of its device and plant
+func (m *FleetMonitor) Seen(data *models.StatusData) {
+	seen := data.ReceivedAt
+	if seen == 0 {
+		seen = m.now().Unix()
+	}
+
+	// Devices seen before being checked start with their own interval
+	var interval int64
+	if data.DeviceID != 0 && !m.tracking(data.DeviceID) {
+		interval = m.deviceInterval(data.DeviceID)
+	}
+
+	var events []*models.Event
+	m.mu.Lock()
+	m.init()
+	if data.DeviceID != 0 {
+		_, tracked := m.devices[data.DeviceID]
+		heartbeat := m.heartbeat(m.devices, data.DeviceID, true)
+		if !tracked && interval != 0 {
+			heartbeat.Interval = interval
+		}
+		heartbeat.PlantID = data.ID
+		events = m.see(heartbeat, seen, events)
+	}
+	if data.ID != 0 {
+		events = m.see(m.heartbeat(m.plants, data.ID, false), seen, events)
+	}
+	m.mu.Unlock()
+
+	m.publish(events)
+}
+
+// Check updates the state of every registered device and plant, reporting transitions
+func (m *FleetMonitor) Check() error {
+	devices, err := m.Devices.ReadDevices()
+	if err != nil {
+		return FleetDatabaseDriverError(err.Error())
+	}
+	plants, err := m.Plants.ReadPlants()
+	if err != nil {
+		return FleetDatabaseDriverError(err.Error())
+	}
+	now := m.now().Unix()
+
+	var events []*models.Event
+	m.mu.Lock()
+	m.init()
+	registered := map[uint]bool{}
+	for _, device := range devices {
+		registered[device.ID] = true
+		heartbeat := m.heartbeat(m.devices, device.ID, true)
+		heartbeat.Interval = device.Interval
+		if heartbeat.Interval == 0 {
+			heartbeat.Interval = m.defaultInterval()
+		}
+		events = m.update(heartbeat, now, events)
+	}
+	prune(m.devices, registered)
+
+	registered = map[uint]bool{}
+	for _, plant := range plants {
+		registered[plant.ID] = true
+		events = m.update(m.heartbeat(m.plants, plant.ID, false), now, events)
+	}
+	prune(m.plants, registered)
+	m.checked = now
+	m.mu.Unlock()
+
+	m.publish(events)
+
+	return nil
+}
+
+// Fleet reads the reporting state of every device and plant as of the last check, ordered by ID,
+// with the readings accepted since. A non-empty state only lists the devices and plants in that
+// state; counts cover every device.
+func (m *FleetMonitor) Fleet(state string) (*models.Fleet, error) {
+	switch state {
+	case "", models.ReportingOnline, models.ReportingStale, models.ReportingOffline:
+	default:
+		return nil, FleetInvalidState
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fleet := &models.Fleet{
+		Devices: heartbeats(m.devices, state),
+		Plants:  heartbeats(m.plants, state),
+		Counts:  m.counts(),
+		Checked: m.checked,
+	}
+
+	return fleet, nil
+}
+
+// Counts returns the number of devices per state as of the last check
+func (m *FleetMonitor) Counts() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counts()
+}
+
+// Run checks the fleet at once and then every interval until stop is closed
+func (m *FleetMonitor) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(); err != nil {
+			zap.L().Error(err.Error(), zap.String("service", "fleet"))
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// init sets up the monitor on first use, starting the intervals of devices never seen
+func (m *FleetMonitor) init() {
+	if m.devices != nil {
+		return
+	}
+	m.started = m.now().Unix()
+	m.devices = map[uint]*models.Heartbeat{}
+	m.plants = map[uint]*models.Heartbeat{}
+}
+
+// tracking reports whether a device has a heartbeat
+func (m *FleetMonitor) tracking(id uint) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.devices[id]
+
+	return ok
+}
+
+// deviceInterval reads the expected reporting interval of a device, 0 if it has none or cannot be read
+func (m *FleetMonitor) deviceInterval(id uint) int64 {
+	device, err := m.Devices.ReadDevice(id)
+	if err != nil {
+		// Unregistered devices are left out by the next check
+		if _, ok := err.(database.DatabaseInvalidDataError); !ok {
+			zap.L().Error(err.Error(), zap.String("service", "fleet"), zap.Uint("device", id))
+		}
+
+		return 0
+	}
+
+	return device.Interval
+}
+
+// heartbeat returns the heartbeat of a device or plant, adding an online one if none is tracked
+func (m *FleetMonitor) heartbeat(tracked map[uint]*models.Heartbeat, id uint, device bool) *models.Heartbeat {
+	heartbeat, ok := tracked[id]
+	if !ok {
+		heartbeat = &models.Heartbeat{Interval: m.defaultInterval(), State: models.ReportingOnline}
+		if device {
+			heartbeat.DeviceID = id
+		} else {
+			heartbeat.PlantID = id
+		}
+		tracked[id] = heartbeat
+	}
+
+	return heartbeat
+}
+
+// see marks a heartbeat online at a receive time
+func (m *FleetMonitor) see(heartbeat *models.Heartbeat, seen int64, events []*models.Event) []*models.Event {
+	if seen > heartbeat.LastSeen {
+		heartbeat.LastSeen = seen
+	}
+
+	return m.transition(heartbeat, models.ReportingOnline, events)
+}
+
+// update sets the state of a heartbeat from the time since it was last seen
+func (m *FleetMonitor) update(heartbeat *models.Heartbeat, now int64, events []*models.Event) []*models.Event {
+	since := heartbeat.LastSeen
+	if since == 0 {
+		since = m.started
+	}
+	offlineAfter := m.OfflineAfter
+	if offlineAfter <= 0 {
+		offlineAfter = defaultFleetOfflineAfter
+	}
+
+	state := models.ReportingOnline
+	switch silence := now - since; {
+	case silence > heartbeat.Interval*int64(offlineAfter):
+		state = models.ReportingOffline
+	case silence > heartbeat.Interval:
+		state = models.ReportingStale
+	}
+
+	return m.transition(heartbeat, state, events)
+}
+
+// transition changes the state of a heartbeat, logging changes and queueing an event
+// when it goes offline or comes back
+func (m *FleetMonitor) transition(heartbeat *models.Heartbeat, state string, events []*models.Event) []*models.Event {
+	previous := heartbeat.State
+	if state == previous {
+		return events
+	}
+	heartbeat.State = state
+
+	fields := []zap.Field{
+		zap.String("service", "fleet"),
+		zap.Uint("device", heartbeat.DeviceID),
+		zap.Uint("plant", heartbeat.PlantID),
+		zap.Int64("lastSeen", heartbeat.LastSeen),
+	}
+	if state == models.ReportingOnline {
+		zap.L().Info("reporting "+state, fields...)
+	} else {
+		zap.L().Warn("reporting "+state, fields...)
+	}
+
+	var eventType string
+	switch {
+	case state == models.ReportingOffline:
+		eventType = models.EventDeviceOffline
+	case previous == models.ReportingOffline:
+		eventType = models.EventDeviceOnline
+	default:
+		return events
+	}
+	data := *heartbeat
+
+	return append(events, &models.Event{Type: eventType, PlantID: heartbeat.PlantID, Data: &data})
+}
+
+// publish notifies subscribers of transitions, once the monitor is unlocked
+func (m *FleetMonitor) publish(events []*models.Event) {
+	if m.Events == nil {
+		return
+	}
+	for _, event := range events {
+		if err := m.Events.Publish(event); err != nil {
+			zap.L().Error(err.Error(), zap.String("service", "events"), zap.Uint("plant", event.PlantID))
+		}
+	}
+}
+
+// counts returns the number of devices per state
+func (m *FleetMonitor) counts() map[string]int {
+	counts := map[string]int{
+		models.ReportingOnline:  0,
+		models.ReportingStale:   0,
+		models.ReportingOffline: 0,
+	}
+	for _, heartbeat := range m.devices {
+		counts[heartbeat.State]++
+	}
+
+	return counts
+}
+
+func (m *FleetMonitor) defaultInterval() int64 {
+	if m.Interval <= 0 {
+		return int64(defaultFleetInterval / time.Second)
+	}
+
+	return int64(m.Interval / time.Second)
+}
+
+func (m *FleetMonitor) now() time.Time {
+	if m.Clock == nil {
+		return time.Now()
+	}
+
+	return m.Clock()
+}
+
+// prune stops tracking the devices or plants no longer registered
+func prune(tracked map[uint]*models.Heartbeat, registered map[uint]bool) {
+	for id := range tracked {
+		if !registered[id] {
+			delete(tracked, id)
+		}
+	}
+}
+
+// heartbeats copies the heartbeats in a state, or every heartbeat, ordered by ID
+func heartbeats(tracked map[uint]*models.Heartbeat, state string) []*models.Heartbeat {
+	result := []*models.Heartbeat{}
+	for _, heartbeat := range tracked {
+		if state == "" || heartbeat.State == state {
+			copied := *heartbeat
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DeviceID != result[j].DeviceID {
+			return result[i].DeviceID < result[j].DeviceID
+		}
+
+		return result[i].PlantID < result[j].PlantID
+	})
+
+	return result
+}
@@ -0,0 +1,150 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestFleetMonitor(t *testing.T) {
+	// Setup
+	deviceService, driver := newDeviceService()
+	deviceService.Provision(&models.Device{ID: 7, Interval: 60})
+	deviceService.Provision(&models.Device{ID: 8})
+	deviceService.Bind(&models.Binding{DeviceID: 7, PlantID: 1, From: 100})
+
+	var now int64
+	clock := func() time.Time { return time.Unix(now, 0) }
+	events := &mockPublisher{}
+	monitor := &services.FleetMonitor{
+		Devices:  driver,
+		Plants:   driver,
+		Interval: 10 * time.Minute,
+		Events:   events,
+		Clock:    clock,
+	}
+	service := services.StatusDatabase{
+		Driver:     driver,
+		Devices:    driver,
+		Clock:      clock,
+		Heartbeats: monitor,
+	}
+
+	steps := []struct {
+		name     string         // step name
+		now      int64          // current time
+		deviceID uint           // device writing a reading, or 0 to check the fleet
+		expected map[string]int // expected device counts
+	}{
+		{"Startup", 1000, 0, map[string]int{"online": 2, "stale": 0, "offline": 0}},
+		{"Reading", 1000, 7, map[string]int{"online": 2, "stale": 0, "offline": 0}},
+		{"Interval missed", 1061, 0, map[string]int{"online": 1, "stale": 1, "offline": 0}},
+		{"Intervals missed", 1181, 0, map[string]int{"online": 1, "stale": 0, "offline": 1}},
+		{"Back", 1200, 7, map[string]int{"online": 2, "stale": 0, "offline": 0}},
+		{"Never seen", 2801, 0, map[string]int{"online": 0, "stale": 0, "offline": 2}},
+	}
+	for _, step := range steps {
+		now = step.now
+		if step.deviceID != 0 {
+			data := &models.StatusData{DeviceID: step.deviceID, Timestamp: step.now}
+			if err := service.Write(data); err != nil {
+				t.Fatalf("%s: no error expected, got %+v", step.name, err)
+			}
+		} else if err := monitor.Check(); err != nil {
+			t.Fatalf("%s: no error expected, got %+v", step.name, err)
+		}
+		if counts := monitor.Counts(); !reflect.DeepEqual(counts, step.expected) {
+			t.Errorf("%s: expected %+v, got %+v", step.name, step.expected, counts)
+		}
+	}
+
+	// Devices going offline and coming back are events
+	expected := []*models.Event{
+		{Type: models.EventDeviceOffline, PlantID: 1, Data: &models.Heartbeat{DeviceID: 7, PlantID: 1, LastSeen: 1000, Interval: 60, State: "offline"}},
+		{Type: models.EventDeviceOnline, PlantID: 1, Data: &models.Heartbeat{DeviceID: 7, PlantID: 1, LastSeen: 1200, Interval: 60, State: "online"}},
+		{Type: models.EventDeviceOffline, PlantID: 1, Data: &models.Heartbeat{DeviceID: 7, PlantID: 1, LastSeen: 1200, Interval: 60, State: "offline"}},
+		{Type: models.EventDeviceOffline, PlantID: 0, Data: &models.Heartbeat{DeviceID: 8, Interval: 600, State: "offline"}},
+		{Type: models.EventDeviceOffline, PlantID: 2, Data: &models.Heartbeat{PlantID: 2, Interval: 600, State: "offline"}},
+	}
+	if !reflect.DeepEqual(events.events, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, events.events)
+	}
+
+	tests := map[string]struct {
+		state           string              // input state
+		expectedDevices []*models.Heartbeat // expected devices
+		expectedPlants  []*models.Heartbeat // expected plants
+		expected        error               // expected error
+	}{
+		"Every state": {
+			"",
+			[]*models.Heartbeat{
+				{DeviceID: 7, PlantID: 1, LastSeen: 1200, Interval: 60, State: "offline"},
+				{DeviceID: 8, Interval: 600, State: "offline"},
+			},
+			[]*models.Heartbeat{
+				{PlantID: 1, LastSeen: 1200, Interval: 600, State: "stale"},
+				{PlantID: 2, Interval: 600, State: "offline"},
+			},
+			nil,
+		},
+		"Stale": {
+			"stale",
+			[]*models.Heartbeat{},
+			[]*models.Heartbeat{{PlantID: 1, LastSeen: 1200, Interval: 600, State: "stale"}},
+			nil,
+		},
+		"Invalid state": {"asleep", nil, nil, services.FleetInvalidState},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			fleet, err := monitor.Fleet(testCase.state)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(fleet.Devices, testCase.expectedDevices) {
+				t.Errorf("Expected devices %+v, got %+v", testCase.expectedDevices, fleet.Devices)
+			}
+			if !reflect.DeepEqual(fleet.Plants, testCase.expectedPlants) {
+				t.Errorf("Expected plants %+v, got %+v", testCase.expectedPlants, fleet.Plants)
+			}
+		})
+	}
+}
+
+func TestFleetMonitorSnapshot(t *testing.T) {
+	// Setup
+	deviceService, driver := newDeviceService()
+	deviceService.Provision(&models.Device{ID: 7, Interval: 60})
+
+	now := int64(1000)
+	monitor := &services.FleetMonitor{
+		Devices:  driver,
+		Plants:   driver,
+		Interval: 10 * time.Minute,
+		Clock:    func() time.Time { return time.Unix(now, 0) },
+	}
+
+	// Devices seen before the first check have their own interval
+	monitor.Seen(&models.StatusData{ID: 1, DeviceID: 7, ReceivedAt: 1000})
+	now = 1500
+	fleet, _ := monitor.Fleet("")
+	expected := []*models.Heartbeat{{DeviceID: 7, PlantID: 1, LastSeen: 1000, Interval: 60, State: "online"}}
+	if !reflect.DeepEqual(fleet.Devices, expected) || fleet.Checked != 0 {
+		t.Errorf("Expected %+v unchecked, got %+v checked at %d", expected, fleet.Devices, fleet.Checked)
+	}
+
+	// States change with checks
+	monitor.Check()
+	fleet, _ = monitor.Fleet("")
+	expected = []*models.Heartbeat{{DeviceID: 7, PlantID: 1, LastSeen: 1000, Interval: 60, State: "offline"}}
+	if !reflect.DeepEqual(fleet.Devices, expected) || fleet.Checked != 1500 {
+		t.Errorf("Expected %+v checked at 1500, got %+v checked at %d", expected, fleet.Devices, fleet.Checked)
+	}
+}
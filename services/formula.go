@@ -0,0 +1,291 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"unicode"
+)
+
+// formula is a parsed derived metric formula.
+// Formulas are arithmetic expressions (+, -, *, /, ^ and parentheses) over numbers,
+// metric names and the functions in formulaFunctions.
+type formula interface {
+	// eval evaluates the formula, reporting false when a metric it uses is absent
+	eval(metrics map[string]float64) (float64, bool)
+	// metrics appends the names of the metrics the formula uses
+	metrics(names []string) []string
+}
+
+type formulaNumber float64
+
+type formulaMetric string
+
+type formulaOperation struct {
+	operator    byte
+	left, right formula
+}
+
+type formulaCall struct {
+	function  func(args []float64) float64
+	arguments []formula
+}
+
+// formulaFunctions are the functions formulas may call, with their argument count
+var formulaFunctions = map[string]struct {
+	arguments int
+	function  func(args []float64) float64
+}{
+	"exp":   {1, func(args []float64) float64 { return math.Exp(args[0]) }},
+	"ln":    {1, func(args []float64) float64 { return math.Log(args[0]) }},
+	"log10": {1, func(args []float64) float64 { return math.Log10(args[0]) }},
+	"sqrt":  {1, func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"abs":   {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"pow":   {2, func(args []float64) float64 { return math.Pow(args[0], args[1]) }},
+	"min":   {2, func(args []float64) float64 { return math.Min(args[0], args[1]) }},
+	"max":   {2, func(args []float64) float64 { return math.Max(args[0], args[1]) }},
+}
+
+func (f formulaNumber) eval(map[string]float64) (float64, bool) { return float64(f), true }
+func (f formulaNumber) metrics(names []string) []string         { return names }
+
+func (f formulaMetric) eval(metrics map[string]float64) (float64, bool) {
+	value, ok := metrics[string(f)]
+
+	return value, ok
+}
+func (f formulaMetric) metrics(names []string) []string { return append(names, string(f)) }
+
+func (f *formulaOperation) eval(metrics map[string]float64) (float64, bool) {
+	left, ok := f.left.eval(metrics)
+	if !ok {
+		return 0, false
+	}
+	right, ok := f.right.eval(metrics)
+	if !ok {
+		return 0, false
+	}
+	switch f.operator {
+	case '+':
+		return left + right, true
+	case '-':
+		return left - right, true
+	case '*':
+		return left * right, true
+	case '/':
+		return left / right, true
+	default:
+		return math.Pow(left, right), true
+	}
+}
+func (f *formulaOperation) metrics(names []string) []string {
+	return f.right.metrics(f.left.metrics(names))
+}
+
+func (f *formulaCall) eval(metrics map[string]float64) (float64, bool) {
+	args := make([]float64, len(f.arguments))
+	for i, argument := range f.arguments {
+		value, ok := argument.eval(metrics)
+		if !ok {
+			return 0, false
+		}
+		args[i] = value
+	}
+
+	return f.function(args), true
+}
+func (f *formulaCall) metrics(names []string) []string {
+	for _, argument := range f.arguments {
+		names = argument.metrics(names)
+	}
+
+	return names
+}
+
+// formulas caches parsed formulas by source, as registries only hold their definitions
+var formulas sync.Map
+
+// parseFormula parses a formula, caching the result
+func parseFormula(source string) (formula, error) {
+	if cached, ok := formulas.Load(source); ok {
+		return cached.(formula), nil
+	}
+
+	p := &formulaParser{source: source}
+	result, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.position < len(p.source) {
+		return nil, p.fail()
+	}
+	formulas.Store(source, result)
+
+	return result, nil
+}
+
+// formulaParser is a recursive descent parser of formulas.
+// ^ binds tightest and is right associative; unary minus binds looser than ^.
+type formulaParser struct {
+	source   string
+	position int
+}
+
+func (p *formulaParser) fail() error {
+	return StatusInvalidDataError("invalid formula at position " + strconv.Itoa(p.position))
+}
+
+func (p *formulaParser) skipSpaces() {
+	for p.position < len(p.source) && p.source[p.position] == ' ' {
+		p.position++
+	}
+}
+
+// peek returns the next character, or 0 at the end
+func (p *formulaParser) peek() byte {
+	p.skipSpaces()
+	if p.position == len(p.source) {
+		return 0
+	}
+
+	return p.source[p.position]
+}
+
+// expression = term {("+" | "-") term}
+func (p *formulaParser) expression() (formula, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for operator := p.peek(); operator == '+' || operator == '-'; operator = p.peek() {
+		p.position++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &formulaOperation{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// term = unary {("*" | "/") unary}
+func (p *formulaParser) term() (formula, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for operator := p.peek(); operator == '*' || operator == '/'; operator = p.peek() {
+		p.position++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &formulaOperation{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// unary = "-" unary | power
+func (p *formulaParser) unary() (formula, error) {
+	if p.peek() == '-' {
+		p.position++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &formulaOperation{operator: '-', left: formulaNumber(0), right: operand}, nil
+	}
+
+	return p.power()
+}
+
+// power = primary ["^" unary]
+func (p *formulaParser) power() (formula, error) {
+	base, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.position++
+	exponent, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &formulaOperation{operator: '^', left: base, right: exponent}, nil
+}
+
+// primary = number | name | name "(" expression {"," expression} ")" | "(" expression ")"
+func (p *formulaParser) primary() (formula, error) {
+	next := p.peek()
+	switch {
+	case next == '(':
+		p.position++
+		inner, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.fail()
+		}
+		p.position++
+
+		return inner, nil
+	case next == '.' || unicode.IsDigit(rune(next)):
+		start := p.position
+		for p.position < len(p.source) && (p.source[p.position] == '.' || unicode.IsDigit(rune(p.source[p.position]))) {
+			p.position++
+		}
+		value, err := strconv.ParseFloat(p.source[start:p.position], 64)
+		if err != nil {
+			p.position = start
+
+			return nil, p.fail()
+		}
+
+		return formulaNumber(value), nil
+	case next == '_' || unicode.IsLetter(rune(next)):
+		start := p.position
+		for p.position < len(p.source) && (p.source[p.position] == '_' ||
+			unicode.IsLetter(rune(p.source[p.position])) || unicode.IsDigit(rune(p.source[p.position]))) {
+			p.position++
+		}
+		name := p.source[start:p.position]
+		if p.peek() != '(' {
+			return formulaMetric(name), nil
+		}
+
+		function, ok := formulaFunctions[name]
+		if !ok {
+			p.position = start
+
+			return nil, p.fail()
+		}
+		p.position++
+		call := &formulaCall{function: function.function}
+		for {
+			argument, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			call.arguments = append(call.arguments, argument)
+			if p.peek() != ',' {
+				break
+			}
+			p.position++
+		}
+		if p.peek() != ')' || len(call.arguments) != function.arguments {
+			return nil, p.fail()
+		}
+		p.position++
+
+		return call, nil
+	default:
+		return nil, p.fail()
+	}
+}
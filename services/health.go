@@ -0,0 +1,54 @@
+package services
+
+import (
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+const (
+	// HealthOK is the health status when every breaker is closed
+	HealthOK = "ok"
+	// HealthDegraded is the health status when a breaker is probing its backend
+	HealthDegraded = "degraded"
+	// HealthUnavailable is the health status when a breaker is open
+	HealthUnavailable = "unavailable"
+)
+
+// FleetCounter is an interface for services counting devices per reporting state
+type FleetCounter interface {
+	Counts() map[string]int
+}
+
+// HealthDatabase is a service for reporting database breaker states and fleet counts
+type HealthDatabase struct {
+	Breakers map[string]database.Breaker
+	// Fleet counts the devices per reporting state, if set
+	Fleet FleetCounter
+}
+
+// Check reports the state of every breaker, and the fleet counts.
+// Offline devices do not degrade the status, as the broker itself is healthy.
+func (s *HealthDatabase) Check() *models.Health {
+	health := &models.Health{
+		Status:   HealthOK,
+		Breakers: map[string]string{},
+	}
+	if s.Fleet != nil {
+		health.Fleet = s.Fleet.Counts()
+	}
+	for name, breaker := range s.Breakers {
+		state := breaker.State()
+		health.Breakers[name] = string(state)
+
+		switch state {
+		case database.BreakerOpen:
+			health.Status = HealthUnavailable
+		case database.BreakerHalfOpen:
+			if health.Status == HealthOK {
+				health.Status = HealthDegraded
+			}
+		}
+	}
+
+	return health
+}
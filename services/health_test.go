@@ -0,0 +1,64 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+type mockBreaker database.BreakerState
+
+func (b mockBreaker) State() database.BreakerState { return database.BreakerState(b) }
+
+type mockFleetCounter map[string]int
+
+func (c mockFleetCounter) Counts() map[string]int { return c }
+
+func TestHealthCheck(t *testing.T) {
+	tests := map[string]struct {
+		breakers map[string]database.Breaker // input
+		fleet    services.FleetCounter       // input fleet counts
+		expected *models.Health              // expected health
+	}{
+		"No breakers": {
+			breakers: nil,
+			expected: &models.Health{Status: services.HealthOK, Breakers: map[string]string{}},
+		},
+		"Closed": {
+			breakers: map[string]database.Breaker{"mysql": mockBreaker(database.BreakerClosed)},
+			expected: &models.Health{Status: services.HealthOK, Breakers: map[string]string{"mysql": "closed"}},
+		},
+		"Half-open": {
+			breakers: map[string]database.Breaker{"mysql": mockBreaker(database.BreakerHalfOpen)},
+			expected: &models.Health{Status: services.HealthDegraded, Breakers: map[string]string{"mysql": "half-open"}},
+		},
+		"Open": {
+			breakers: map[string]database.Breaker{
+				"mysql":  mockBreaker(database.BreakerOpen),
+				"memory": mockBreaker(database.BreakerHalfOpen),
+			},
+			expected: &models.Health{Status: services.HealthUnavailable, Breakers: map[string]string{"mysql": "open", "memory": "half-open"}},
+		},
+		"Offline devices": {
+			breakers: map[string]database.Breaker{"mysql": mockBreaker(database.BreakerClosed)},
+			fleet:    mockFleetCounter{"online": 1, "stale": 0, "offline": 2},
+			expected: &models.Health{
+				Status:   services.HealthOK,
+				Breakers: map[string]string{"mysql": "closed"},
+				Fleet:    map[string]int{"online": 1, "stale": 0, "offline": 2},
+			},
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			service := services.HealthDatabase{Breakers: testCase.breakers, Fleet: testCase.fleet}
+			health := service.Check()
+			if !reflect.DeepEqual(health, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, health)
+			}
+		})
+	}
+}
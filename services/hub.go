@@ -0,0 +1,141 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+)
+
+// hubSubscriptionBuffer is the number of live messages a subscriber may lag behind
+const hubSubscriptionBuffer = 64
+
+// HubMessage is a published event with its sequence ID
+type HubMessage struct {
+	ID    uint64
+	Event *models.Event
+}
+
+// HubSubscription is a subscription to hub events.
+// C is closed when the subscription is closed, or dropped for lagging behind.
+type HubSubscription struct {
+	C      <-chan *HubMessage
+	c      chan *HubMessage
+	filter func(*models.Event) bool
+	hub    *Hub
+}
+
+// Close unsubscribes from the hub
+func (s *HubSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.drop(s)
+}
+
+// Hub is an in-memory publish/subscribe hub for broker events.
+// The last published messages are kept in a ring buffer, so subscribers can resume.
+type Hub struct {
+	mu          sync.Mutex
+	ring        []*HubMessage
+	last        uint64
+	subscribers map[*HubSubscription]bool
+}
+
+var _ Publisher = (*Hub)(nil)
+var _ EventSource = (*Hub)(nil)
+
+// NewHub creates a hub keeping the last size messages
+func NewHub(size int) (*Hub, error) {
+	if size <= 0 {
+		return nil, StatusInvalidDataError("invalid buffer size")
+	}
+
+	return &Hub{
+		ring:        make([]*HubMessage, size),
+		subscribers: map[*HubSubscription]bool{},
+	}, nil
+}
+
+// Publish sends an event to every matching subscriber.
+// Events without a timestamp happen now.
+func (h *Hub) Publish(event *models.Event) error {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last++
+	message := &HubMessage{ID: h.last, Event: event}
+	h.ring[(h.last-1)%uint64(len(h.ring))] = message
+
+	for subscription := range h.subscribers {
+		if subscription.filter != nil && !subscription.filter(event) {
+			continue
+		}
+		select {
+		case subscription.c <- message:
+		default:
+			// Lagging subscribers resume from their last message
+			h.drop(subscription)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to the events matching filter, or every event for a nil filter.
+// Buffered messages published after a non-zero lastID are sent first.
+func (h *Hub) Subscribe(lastID uint64, filter func(*models.Event) bool) *HubSubscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var backlog []*HubMessage
+	if lastID != 0 && lastID < h.last {
+		first := lastID + 1
+		if size := uint64(len(h.ring)); h.last-first >= size {
+			first = h.last - size + 1
+		}
+		for id := first; id <= h.last; id++ {
+			message := h.ring[(id-1)%uint64(len(h.ring))]
+			if filter == nil || filter(message.Event) {
+				backlog = append(backlog, message)
+			}
+		}
+	}
+
+	c := make(chan *HubMessage, len(backlog)+hubSubscriptionBuffer)
+	for _, message := range backlog {
+		c <- message
+	}
+	subscription := &HubSubscription{C: c, c: c, filter: filter, hub: h}
+	h.subscribers[subscription] = true
+
+	return subscription
+}
+
+// drop removes a subscription, closing its channel. The hub must be locked.
+func (h *Hub) drop(subscription *HubSubscription) {
+	if !h.subscribers[subscription] {
+		return
+	}
+	delete(h.subscribers, subscription)
+	close(subscription.c)
+}
+
+// Publishers is a list of publishers notified of every event in order
+type Publishers []Publisher
+
+// Publish notifies every publisher, returning the first error
+func (p Publishers) Publish(event *models.Event) error {
+	var first error
+	for _, publisher := range p {
+		if err := publisher.Publish(event); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
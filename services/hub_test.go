@@ -0,0 +1,137 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// receive reads the buffered messages of a subscription, and whether it is still open
+func receive(subscription *services.HubSubscription) ([]uint64, bool) {
+	ids := []uint64{}
+	for {
+		select {
+		case message, ok := <-subscription.C:
+			if !ok {
+				return ids, false
+			}
+			ids = append(ids, message.ID)
+		default:
+			return ids, true
+		}
+	}
+}
+
+func TestNewHub(t *testing.T) {
+	if _, err := services.NewHub(0); err == nil {
+		t.Errorf("Error expected for an empty buffer")
+	}
+}
+
+func TestHubPublish(t *testing.T) {
+	// Setup
+	hub, _ := services.NewHub(4)
+	all := hub.Subscribe(0, nil)
+	plant2 := hub.Subscribe(0, func(event *models.Event) bool { return event.PlantID == 2 })
+
+	for _, plantID := range []uint{1, 2, 1} {
+		hub.Publish(&models.Event{Type: models.EventReadingAccepted, PlantID: plantID, Timestamp: 100})
+	}
+
+	tests := map[string]struct {
+		subscription *services.HubSubscription // input
+		expected     []uint64                  // expected message IDs
+	}{
+		"Every event": {all, []uint64{1, 2, 3}},
+		"Filtered":    {plant2, []uint64{2}},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ids, open := receive(testCase.subscription)
+			if !reflect.DeepEqual(ids, testCase.expected) || !open {
+				t.Errorf("Expected %+v, got %+v (open: %t)", testCase.expected, ids, open)
+			}
+		})
+	}
+
+	all.Close()
+	all.Close()
+	if _, open := receive(all); open {
+		t.Errorf("Expected a closed subscription")
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	// Setup
+	hub, _ := services.NewHub(4)
+	for i := 0; i < 6; i++ {
+		hub.Publish(&models.Event{Type: models.EventReadingAccepted, PlantID: uint(i%2 + 1)})
+	}
+
+	tests := map[string]struct {
+		lastID   uint64                   // input
+		filter   func(*models.Event) bool // input
+		expected []uint64                 // expected message IDs
+	}{
+		"Live only":      {0, nil, []uint64{}},
+		"Resume":         {4, nil, []uint64{5, 6}},
+		"Up to date":     {6, nil, []uint64{}},
+		"Past the ring":  {1, nil, []uint64{3, 4, 5, 6}},
+		"Filtered":       {2, func(event *models.Event) bool { return event.PlantID == 1 }, []uint64{3, 5}},
+		"Unknown future": {10, nil, []uint64{}},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			subscription := hub.Subscribe(testCase.lastID, testCase.filter)
+			defer subscription.Close()
+
+			ids, _ := receive(subscription)
+			if !reflect.DeepEqual(ids, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, ids)
+			}
+		})
+	}
+}
+
+func TestHubDropsLaggingSubscribers(t *testing.T) {
+	hub, _ := services.NewHub(1024)
+	subscription := hub.Subscribe(0, nil)
+
+	for i := 0; i < 200; i++ {
+		hub.Publish(&models.Event{Type: models.EventReadingAccepted})
+	}
+
+	ids, open := receive(subscription)
+	if open || len(ids) == 0 || len(ids) >= 200 {
+		t.Errorf("Expected a dropped subscription, got %d messages (open: %t)", len(ids), open)
+	}
+	// The dropped subscriber resumes from its last message
+	resumed := hub.Subscribe(ids[len(ids)-1], nil)
+	if ids, _ := receive(resumed); len(ids) == 0 || ids[len(ids)-1] != 200 {
+		t.Errorf("Expected to resume up to 200, got %+v", ids)
+	}
+}
+
+// Failing publisher mock
+type mockFailingPublisher struct{}
+
+func (p *mockFailingPublisher) Publish(event *models.Event) error {
+	return services.WebhookDatabaseDriverError("mocked error")
+}
+
+func TestPublishers(t *testing.T) {
+	first, last := &mockPublisher{}, &mockPublisher{}
+	publishers := services.Publishers{first, &mockFailingPublisher{}, last}
+
+	event := &models.Event{Type: models.EventAlertFiring}
+	err := publishers.Publish(event)
+	if err != services.WebhookDatabaseDriverError("mocked error") {
+		t.Errorf("Expected mocked error, got %+v", err)
+	}
+	expected := []*models.Event{event}
+	if !reflect.DeepEqual(first.events, expected) || !reflect.DeepEqual(last.events, expected) {
+		t.Errorf("Expected every publisher to get %+v", event)
+	}
+}
@@ -10,3 +10,97 @@ import "github.com/berry-house/http_broker/models"
 type Status interface {
 	Write(temp *models.StatusData) error
 }
+
+// StatusQuery is an interface for services reading stored status data
+type StatusQuery interface {
+	Query(plantID uint, from, to int64, fn func(data *models.StatusData) error) error
+}
+
+// StatusAggregation is an interface for services summarizing stored status data
+type StatusAggregation interface {
+	Aggregate(plantID uint, from, to, window int64, metrics []string) ([]*models.Aggregate, error)
+}
+
+// Plant is an interface for plant registry services
+type Plant interface {
+	Create(plant *models.Plant) error
+	Read(id uint) (*models.Plant, error)
+	ReadAll() ([]*models.Plant, error)
+	Update(plant *models.Plant) error
+	Delete(id uint) error
+}
+
+// Device is an interface for device provisioning services
+type Device interface {
+	Provision(device *models.Device) error
+	Read(id uint) (*models.Device, error)
+	ReadAll() ([]*models.Device, error)
+	Update(device *models.Device) error
+	Delete(id uint) error
+	IssueCredentials(id uint) (string, error)
+	Authenticate(id uint, token string) error
+	Bind(binding *models.Binding) error
+	Unbind(deviceID uint, at int64) error
+	Bindings(deviceID uint) ([]*models.Binding, error)
+	Calibrate(calibration *models.Calibration) error
+	Calibrations(deviceID uint) ([]*models.Calibration, error)
+}
+
+// Alert is an interface for alerting services
+type Alert interface {
+	CreateRule(rule *models.AlertRule) error
+	Rules(plantID uint) ([]*models.AlertRule, error)
+	DeleteRule(id uint) error
+	Alerts(state string) ([]*models.Alert, error)
+	Acknowledge(id uint) error
+}
+
+// AlertEvaluator is an interface for services evaluating accepted readings
+type AlertEvaluator interface {
+	Evaluate(data *models.StatusData) error
+}
+
+// QualityInspector is an interface for services flagging suspect metrics of readings.
+// Inspect flags a reading before storage; Record follows once it is stored.
+type QualityInspector interface {
+	Inspect(data *models.StatusData)
+	Record(data *models.StatusData)
+}
+
+// Publisher is an interface for services notifying subscribers of broker events
+type Publisher interface {
+	Publish(event *models.Event) error
+}
+
+// EventSource is an interface for services streaming broker events
+type EventSource interface {
+	Subscribe(lastID uint64, filter func(*models.Event) bool) *HubSubscription
+}
+
+// Webhook is an interface for webhook subscription services
+type Webhook interface {
+	Subscribe(subscription *models.Subscription) error
+	Subscriptions() ([]*models.Subscription, error)
+	Unsubscribe(id uint) error
+	Deliveries(subscriptionID uint) ([]*models.Delivery, error)
+}
+
+// LoRaWAN is an interface for services decoding LoRaWAN uplinks
+type LoRaWAN interface {
+	Decode(uplink *models.Uplink) (*models.StatusData, error)
+}
+
+// Fleet is an interface for services tracking the reporting state of devices and plants
+type Fleet interface {
+	Fleet(state string) (*models.Fleet, error)
+}
+
+// HeartbeatRecorder is an interface for services recording accepted readings as signs of life
+type HeartbeatRecorder interface {
+	Seen(data *models.StatusData)
+}
+
+// Health is an interface for health services
+type Health interface {
+	Check() *models.Health
+}
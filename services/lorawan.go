@@ -0,0 +1,268 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// LoRaWANInvalidDataError is an error type for invalid uplink errors
+type LoRaWANInvalidDataError string
+
+// LoRaWANDatabaseDriverError is an error type for LoRaWAN database driver errors
+type LoRaWANDatabaseDriverError string
+
+func (e LoRaWANInvalidDataError) Error() string    { return string(e) }
+func (e LoRaWANDatabaseDriverError) Error() string { return string(e) }
+
+const (
+	// LoRaWANInvalidData is the default error for undecodable uplinks
+	LoRaWANInvalidData = LoRaWANInvalidDataError("invalid data")
+	// LoRaWANInvalidID is the default error for uplinks of unknown DevEUIs
+	LoRaWANInvalidID = LoRaWANInvalidDataError("invalid ID")
+)
+
+// PayloadDecoder decodes the application payload of an uplink into metric values,
+// in the units of the default metrics
+type PayloadDecoder interface {
+	Decode(port int, payload []byte) (map[string]float64, error)
+}
+
+// PayloadDecoders is a payload decoder registry, keyed by device profile
+type PayloadDecoders map[string]PayloadDecoder
+
+// DefaultDecoders is the registry used when none is configured
+var DefaultDecoders = PayloadDecoders{
+	"cayenne-lpp":   CayenneLPPDecoder{},
+	"dragino-lse01": DraginoLSE01Decoder{},
+	"dragino-lht65": DraginoLHT65Decoder{},
+}
+
+// NewPayloadDecoders creates a registry of the default decoders and byte-layout decoders
+func NewPayloadDecoders(layouts []models.PayloadLayout) (PayloadDecoders, error) {
+	decoders := PayloadDecoders{}
+	for profile, decoder := range DefaultDecoders {
+		decoders[profile] = decoder
+	}
+	for _, layout := range layouts {
+		if layout.Profile == "" || len(layout.Fields) == 0 {
+			return nil, LoRaWANInvalidDataError("invalid payload layout")
+		}
+		if _, ok := decoders[layout.Profile]; ok {
+			return nil, LoRaWANInvalidDataError("duplicate payload layout")
+		}
+		for _, field := range layout.Fields {
+			if field.Metric == "" || field.Offset < 0 ||
+				(field.Size != 1 && field.Size != 2 && field.Size != 4) {
+				return nil, LoRaWANInvalidDataError("invalid payload layout")
+			}
+		}
+		decoders[layout.Profile] = LayoutDecoder{Layout: layout}
+	}
+
+	return decoders, nil
+}
+
+// LoRaWANDatabase is a service for decoding the uplinks of provisioned LoRaWAN devices
+type LoRaWANDatabase struct {
+	Devices database.DeviceStore
+	// Decoders decodes payloads by device profile, defaulting to DefaultDecoders
+	Decoders PayloadDecoders
+}
+
+// Decode maps an uplink to status data of the device with its DevEUI.
+// Payloads are decoded by the decoder of the device profile, or taken from the
+// network server decoded payload for devices without a profile.
+func (s *LoRaWANDatabase) Decode(uplink *models.Uplink) (*models.StatusData, error) {
+	if uplink == nil {
+		return nil, LoRaWANInvalidDataError("nil data")
+	}
+	devEUI, ok := normalizeEUI(uplink.DevEUI)
+	if !ok {
+		return nil, LoRaWANInvalidID
+	}
+	device, err := s.Devices.ReadDeviceByEUI(devEUI)
+	if _, ok := err.(database.DatabaseInvalidDataError); ok {
+		return nil, LoRaWANInvalidID
+	}
+	if err != nil {
+		return nil, LoRaWANDatabaseDriverError(err.Error())
+	}
+
+	var values map[string]float64
+	if device.Profile != "" {
+		decoders := s.Decoders
+		if decoders == nil {
+			decoders = DefaultDecoders
+		}
+		decoder, ok := decoders[device.Profile]
+		if !ok {
+			return nil, LoRaWANInvalidDataError("unknown profile")
+		}
+		if values, err = decoder.Decode(uplink.Port, uplink.Payload); err != nil {
+			return nil, LoRaWANInvalidData
+		}
+	} else {
+		values = map[string]float64{}
+		for name, value := range uplink.Decoded {
+			// Only numeric fields are metrics
+			if number, ok := value.(float64); ok {
+				values[name] = number
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, LoRaWANInvalidData
+	}
+
+	data := &models.StatusData{DeviceID: device.ID, Timestamp: uplink.ReceivedAt}
+	for name, value := range values {
+		data.SetValue(name, value)
+	}
+
+	return data, nil
+}
+
+// CayenneLPPDecoder decodes Cayenne Low Power Payload temperature, humidity and
+// illuminance channels, skipping other known data types
+type CayenneLPPDecoder struct{}
+
+// cayenneSizes are the data sizes of the Cayenne LPP data types
+var cayenneSizes = map[byte]int{
+	0x00: 1, // digital input
+	0x01: 1, // digital output
+	0x02: 2, // analog input
+	0x03: 2, // analog output
+	0x65: 2, // illuminance
+	0x66: 1, // presence
+	0x67: 2, // temperature
+	0x68: 1, // humidity
+	0x71: 6, // accelerometer
+	0x73: 2, // barometer
+	0x86: 6, // gyrometer
+	0x88: 9, // GPS location
+}
+
+func (CayenneLPPDecoder) Decode(port int, payload []byte) (map[string]float64, error) {
+	values := map[string]float64{}
+	for len(payload) > 0 {
+		if len(payload) < 2 {
+			return nil, errors.New("truncated payload")
+		}
+		dataType := payload[1]
+		size, ok := cayenneSizes[dataType]
+		if !ok {
+			return nil, fmt.Errorf("unknown data type %#x", dataType)
+		}
+		if len(payload) < 2+size {
+			return nil, errors.New("truncated payload")
+		}
+		data := payload[2 : 2+size]
+		switch dataType {
+		case 0x65:
+			values[models.MetricLight] = float64(binary.BigEndian.Uint16(data)) / 1000
+		case 0x67:
+			values[models.MetricTemperature] = float64(int16(binary.BigEndian.Uint16(data))) / 10
+		case 0x68:
+			values[models.MetricHumidity] = float64(data[0]) / 2
+		}
+		payload = payload[2+size:]
+	}
+
+	return values, nil
+}
+
+// DraginoLSE01Decoder decodes Dragino LSE01 soil moisture and EC sensor payloads
+type DraginoLSE01Decoder struct{}
+
+func (DraginoLSE01Decoder) Decode(port int, payload []byte) (map[string]float64, error) {
+	if len(payload) < 11 {
+		return nil, errors.New("truncated payload")
+	}
+
+	return map[string]float64{
+		"battery":          float64(binary.BigEndian.Uint16(payload[0:])&0x3fff) / 1000,
+		"soil_moisture":    float64(binary.BigEndian.Uint16(payload[4:])) / 100,
+		"soil_temperature": float64(int16(binary.BigEndian.Uint16(payload[6:]))) / 100,
+		// Conductivity is sent in µS/cm
+		"ec": float64(binary.BigEndian.Uint16(payload[8:])) / 1000,
+	}, nil
+}
+
+// DraginoLHT65Decoder decodes Dragino LHT65 temperature and humidity sensor payloads
+type DraginoLHT65Decoder struct{}
+
+func (DraginoLHT65Decoder) Decode(port int, payload []byte) (map[string]float64, error) {
+	if len(payload) < 6 {
+		return nil, errors.New("truncated payload")
+	}
+
+	return map[string]float64{
+		"battery":                float64(binary.BigEndian.Uint16(payload[0:])&0x3fff) / 1000,
+		models.MetricTemperature: float64(int16(binary.BigEndian.Uint16(payload[2:]))) / 100,
+		models.MetricHumidity:    float64(binary.BigEndian.Uint16(payload[4:])) / 10,
+	}, nil
+}
+
+// LayoutDecoder decodes payloads with a configured byte layout
+type LayoutDecoder struct {
+	Layout models.PayloadLayout
+}
+
+func (d LayoutDecoder) Decode(port int, payload []byte) (map[string]float64, error) {
+	if d.Layout.Port != 0 && port != d.Layout.Port {
+		return nil, fmt.Errorf("unexpected port %d", port)
+	}
+
+	values := map[string]float64{}
+	for _, field := range d.Layout.Fields {
+		if len(payload) < field.Offset+field.Size {
+			return nil, errors.New("truncated payload")
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if field.LittleEndian {
+			order = binary.LittleEndian
+		}
+		data := payload[field.Offset : field.Offset+field.Size]
+
+		var value float64
+		switch {
+		case field.Size == 1 && field.Signed:
+			value = float64(int8(data[0]))
+		case field.Size == 1:
+			value = float64(data[0])
+		case field.Size == 2 && field.Signed:
+			value = float64(int16(order.Uint16(data)))
+		case field.Size == 2:
+			value = float64(order.Uint16(data))
+		case field.Signed:
+			value = float64(int32(order.Uint32(data)))
+		default:
+			value = float64(order.Uint32(data))
+		}
+		if field.Scale != 0 {
+			value *= field.Scale
+		}
+		values[field.Metric] = value
+	}
+
+	return values, nil
+}
+
+// normalizeEUI formats a DevEUI as 16 upper case hex digits, ignoring separators
+func normalizeEUI(devEUI string) (string, bool) {
+	devEUI = strings.NewReplacer("-", "", ":", "").Replace(devEUI)
+	if len(devEUI) != 16 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(devEUI); err != nil {
+		return "", false
+	}
+
+	return strings.ToUpper(devEUI), true
+}
@@ -0,0 +1,132 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestPayloadDecoders(t *testing.T) {
+	decoders, err := services.NewPayloadDecoders([]models.PayloadLayout{
+		{Profile: "custom", Port: 2, Fields: []models.LayoutField{
+			{Metric: "temperature", Offset: 0, Size: 2, Signed: true, Scale: 0.01},
+			{Metric: "co2", Offset: 2, Size: 2, LittleEndian: true},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	tests := map[string]struct {
+		profile  string             // input profile
+		port     int                // input port
+		payload  []byte             // input payload
+		expected map[string]float64 // expected values, nil for an error
+	}{
+		"Cayenne LPP": {
+			"cayenne-lpp", 1,
+			[]byte{0x01, 0x67, 0x00, 0xd7, 0x02, 0x68, 0x50, 0x03, 0x65, 0x04, 0xb0, 0x04, 0x02, 0x01, 0x00},
+			map[string]float64{"temperature": 21.5, "humidity": 40, "light": 1.2},
+		},
+		"Cayenne LPP negative": {"cayenne-lpp", 1, []byte{0x01, 0x67, 0xff, 0xd7}, map[string]float64{"temperature": -4.1}},
+		"Cayenne LPP unknown":  {"cayenne-lpp", 1, []byte{0x01, 0x99, 0x00}, nil},
+		"Cayenne LPP short":    {"cayenne-lpp", 1, []byte{0x01, 0x67, 0x00}, nil},
+		"Dragino LSE01": {
+			"dragino-lse01", 2,
+			[]byte{0x0d, 0x10, 0x00, 0xf0, 0x0c, 0x80, 0x07, 0xd0, 0x02, 0x58, 0x00},
+			map[string]float64{"battery": 3.344, "soil_moisture": 32, "soil_temperature": 20, "ec": 0.6},
+		},
+		"Dragino LHT65": {
+			"dragino-lht65", 2,
+			[]byte{0xcb, 0xf6, 0x0b, 0x0d, 0x03, 0x76, 0x01, 0x0a, 0xdd, 0x7f, 0xff},
+			map[string]float64{"battery": 3.062, "temperature": 28.29, "humidity": 88.6},
+		},
+		"Dragino short": {"dragino-lht65", 2, []byte{0xcb, 0xf6}, nil},
+		"Layout":        {"custom", 2, []byte{0xf8, 0x30, 0x9a, 0x01}, map[string]float64{"temperature": -20, "co2": 410}},
+		"Layout port":   {"custom", 3, []byte{0xf8, 0x30, 0x9a, 0x01}, nil},
+		"Layout short":  {"custom", 2, []byte{0xf8, 0x30, 0x9a}, nil},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			values, err := decoders[testCase.profile].Decode(testCase.port, testCase.payload)
+			if testCase.expected == nil {
+				if err == nil {
+					t.Errorf("Error expected, got %+v", values)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			for name, value := range values {
+				// Scaled values are compared to the precision of the sensors
+				if diff := value - testCase.expected[name]; diff > 1e-9 || diff < -1e-9 {
+					t.Errorf("Expected %+v, got %+v", testCase.expected, values)
+				}
+			}
+			if len(values) != len(testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, values)
+			}
+		})
+	}
+
+	// Layouts must be valid and must not replace other decoders
+	invalid := map[string][]models.PayloadLayout{
+		"No profile": {{Fields: []models.LayoutField{{Metric: "ph", Size: 1}}}},
+		"No fields":  {{Profile: "empty"}},
+		"Bad size":   {{Profile: "odd", Fields: []models.LayoutField{{Metric: "ph", Size: 3}}}},
+		"Duplicate":  {{Profile: "cayenne-lpp", Fields: []models.LayoutField{{Metric: "ph", Size: 1}}}},
+	}
+	for name, layouts := range invalid {
+		if _, err := services.NewPayloadDecoders(layouts); err == nil {
+			t.Errorf("%s: error expected", name)
+		}
+	}
+}
+
+func TestLoRaWANDecode(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{})
+	driver.CreateDevice(&models.Device{ID: 1, DevEUI: "70B3D57ED005A1B2", Profile: "cayenne-lpp"})
+	driver.CreateDevice(&models.Device{ID: 2, DevEUI: "0004A30B001C0530"})
+	driver.CreateDevice(&models.Device{ID: 3, DevEUI: "0004A30B001C0531", Profile: "unknown"})
+	service := &services.LoRaWANDatabase{Devices: driver}
+
+	tests := map[string]struct {
+		uplink      *models.Uplink     // input
+		expected    *models.StatusData // expected data
+		expectedErr error              // expected error
+	}{
+		"Profile decoder": {
+			&models.Uplink{DevEUI: "70b3d57ed005a1b2", Port: 1, Payload: []byte{0x01, 0x68, 0x50}, ReceivedAt: 1516472722},
+			&models.StatusData{DeviceID: 1, Timestamp: 1516472722, Metrics: map[string]models.Metric{"humidity": {Value: 40}}},
+			nil,
+		},
+		"Network server decoder": {
+			&models.Uplink{DevEUI: "00-04-A3-0B-00-1C-05-30", Decoded: map[string]interface{}{"ph": 6.5, "label": "ok"}, ReceivedAt: 1516472722},
+			&models.StatusData{DeviceID: 2, Timestamp: 1516472722, Metrics: map[string]models.Metric{"ph": {Value: 6.5}}},
+			nil,
+		},
+		"Nothing decoded": {&models.Uplink{DevEUI: "0004A30B001C0530", Payload: []byte{0x01}}, nil, services.LoRaWANInvalidData},
+		"Bad payload":     {&models.Uplink{DevEUI: "70B3D57ED005A1B2", Payload: []byte{0x01}}, nil, services.LoRaWANInvalidData},
+		"Unknown profile": {&models.Uplink{DevEUI: "0004A30B001C0531", Payload: []byte{0x01}}, nil, services.LoRaWANInvalidDataError("unknown profile")},
+		"Unknown device":  {&models.Uplink{DevEUI: "0000000000000000"}, nil, services.LoRaWANInvalidID},
+		"Invalid DevEUI":  {&models.Uplink{DevEUI: "zz"}, nil, services.LoRaWANInvalidID},
+		"Nil data":        {nil, nil, services.LoRaWANInvalidDataError("nil data")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			data, err := service.Decode(testCase.uplink)
+			if !reflect.DeepEqual(err, testCase.expectedErr) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedErr, err)
+			}
+			if !reflect.DeepEqual(data, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, data)
+			}
+		})
+	}
+}
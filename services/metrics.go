@@ -0,0 +1,96 @@
+package services
+
+import (
+	"math"
+
+	"github.com/berry-house/http_broker/models"
+)
+
+// MetricRegistry describes the metrics accepted by the broker, keyed by name
+type MetricRegistry map[string]models.MetricDefinition
+
+// DefaultMetrics is the registry used when none is configured
+var DefaultMetrics = MetricRegistry{
+	models.MetricTemperature: {Name: models.MetricTemperature, Unit: "°C", Min: -30, Max: 50, MaxRate: 2, FlatCount: 60},
+	models.MetricHumidity:    {Name: models.MetricHumidity, Unit: "%", Min: 0, Max: 100, MaxRate: 10, FlatCount: 60},
+	models.MetricLight:       {Name: models.MetricLight, Unit: "klx", Min: 0, Max: 150},
+	"ph":                     {Name: "ph", Unit: "pH", Min: 0, Max: 14},
+	"ec":                     {Name: "ec", Unit: "mS/cm", Min: 0, Max: 20},
+	"co2":                    {Name: "co2", Unit: "ppm", Min: 0, Max: 10000},
+	"battery":                {Name: "battery", Unit: "V", Min: 0, Max: 5},
+	"soil_moisture":          {Name: "soil_moisture", Unit: "%", Min: 0, Max: 100},
+	"soil_temperature":       {Name: "soil_temperature", Unit: "°C", Min: -30, Max: 60},
+	"dew_point":              {Name: "dew_point", Unit: "°C", Min: -60, Max: 50, Formula: formulaDewPoint},
+	"vpd":                    {Name: "vpd", Unit: "kPa", Min: 0, Max: 15, Formula: formulaVPD},
+	"gdd":                    {Name: "gdd", Unit: "°C·d", Min: 0, Max: 500, Formula: formulaGDD, Window: 7 * 24 * 60 * 60},
+}
+
+const (
+	// formulaDewPoint is the Magnus approximation of the dew point
+	formulaDewPoint = "243.12 * (ln(humidity / 100) + 17.62 * temperature / (243.12 + temperature)) / " +
+		"(17.62 - ln(humidity / 100) - 17.62 * temperature / (243.12 + temperature))"
+	// formulaVPD is the vapour pressure deficit, from the Tetens saturation vapour pressure
+	formulaVPD = "0.6108 * exp(17.27 * temperature / (temperature + 237.3)) * (1 - humidity / 100)"
+	// formulaGDD is the rate of growing degree days over a base temperature of 10 °C
+	formulaGDD = "max(temperature - 10, 0)"
+)
+
+// NewMetricRegistry creates a registry from a list of definitions
+func NewMetricRegistry(definitions []models.MetricDefinition) (MetricRegistry, error) {
+	registry := MetricRegistry{}
+	for _, definition := range definitions {
+		if definition.Name == "" || definition.Min > definition.Max {
+			return nil, StatusInvalidDataError("invalid metric definition")
+		}
+		if _, ok := registry[definition.Name]; ok {
+			return nil, StatusInvalidDataError("duplicate metric definition")
+		}
+		registry[definition.Name] = definition
+	}
+
+	// Formulas use reported metrics only, so derived metrics do not depend on each other
+	for _, definition := range registry {
+		if definition.Formula == "" {
+			if definition.Window != 0 {
+				return nil, StatusInvalidDataError("invalid metric definition")
+			}
+
+			continue
+		}
+		parsed, err := parseFormula(definition.Formula)
+		if err != nil {
+			return nil, err
+		}
+		if definition.Window < 0 {
+			return nil, StatusInvalidDataError("invalid metric definition")
+		}
+		for _, name := range parsed.metrics(nil) {
+			if input, ok := registry[name]; !ok || input.Formula != "" {
+				return nil, StatusInvalidDataError("invalid formula metric " + name)
+			}
+		}
+	}
+
+	return registry, nil
+}
+
+// Validate checks every metric against the registry, filling in missing units
+func (r MetricRegistry) Validate(data *models.StatusData) error {
+	for name, metric := range data.Metrics {
+		definition, ok := r[name]
+		if !ok {
+			return StatusInvalidData
+		}
+		if metric.Unit == "" {
+			metric.Unit = definition.Unit
+			data.Metrics[name] = metric
+		}
+		if metric.Unit != definition.Unit ||
+			math.IsNaN(metric.Value) ||
+			metric.Value < definition.Min || metric.Value > definition.Max {
+			return StatusInvalidData
+		}
+	}
+
+	return nil
+}
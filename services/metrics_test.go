@@ -0,0 +1,133 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestNewMetricRegistry(t *testing.T) {
+	tests := map[string]struct {
+		definitions []models.MetricDefinition // input
+		expected    services.MetricRegistry   // expected registry
+		expectedErr error                     // expected error
+	}{
+		"Happy path": {
+			definitions: []models.MetricDefinition{{Name: "ph", Unit: "pH", Min: 0, Max: 14}},
+			expected:    services.MetricRegistry{"ph": {Name: "ph", Unit: "pH", Min: 0, Max: 14}},
+		},
+		"Missing name": {
+			definitions: []models.MetricDefinition{{Unit: "pH", Min: 0, Max: 14}},
+			expectedErr: services.StatusInvalidDataError("invalid metric definition"),
+		},
+		"Inverted range": {
+			definitions: []models.MetricDefinition{{Name: "ph", Unit: "pH", Min: 14, Max: 0}},
+			expectedErr: services.StatusInvalidDataError("invalid metric definition"),
+		},
+		"Duplicate name": {
+			definitions: []models.MetricDefinition{{Name: "ph", Max: 14}, {Name: "ph", Max: 14}},
+			expectedErr: services.StatusInvalidDataError("duplicate metric definition"),
+		},
+		"Derived": {
+			definitions: []models.MetricDefinition{{Name: "ph", Max: 14}, {Name: "acidity", Max: 14, Formula: "14 - ph"}},
+			expected:    services.MetricRegistry{"ph": {Name: "ph", Max: 14}, "acidity": {Name: "acidity", Max: 14, Formula: "14 - ph"}},
+		},
+		"Invalid formula": {
+			definitions: []models.MetricDefinition{{Name: "ph", Max: 14}, {Name: "acidity", Max: 14, Formula: "14 -* ph"}},
+			expectedErr: services.StatusInvalidDataError("invalid formula at position 4"),
+		},
+		"Unknown function": {
+			definitions: []models.MetricDefinition{{Name: "ph", Max: 14}, {Name: "acidity", Max: 14, Formula: "cbrt(ph)"}},
+			expectedErr: services.StatusInvalidDataError("invalid formula at position 0"),
+		},
+		"Unknown formula metric": {
+			definitions: []models.MetricDefinition{{Name: "acidity", Max: 14, Formula: "14 - ph"}},
+			expectedErr: services.StatusInvalidDataError("invalid formula metric ph"),
+		},
+		"Derived formula metric": {
+			definitions: []models.MetricDefinition{{Name: "ph", Max: 14}, {Name: "acidity", Max: 14, Formula: "14 - ph"}, {Name: "twice", Max: 28, Formula: "2 * acidity"}},
+			expectedErr: services.StatusInvalidDataError("invalid formula metric acidity"),
+		},
+		"Window without formula": {
+			definitions: []models.MetricDefinition{{Name: "ph", Max: 14, Window: 60}},
+			expectedErr: services.StatusInvalidDataError("invalid metric definition"),
+		},
+		"Default metrics": {
+			definitions: []models.MetricDefinition{
+				services.DefaultMetrics["temperature"], services.DefaultMetrics["humidity"],
+				services.DefaultMetrics["dew_point"], services.DefaultMetrics["vpd"], services.DefaultMetrics["gdd"],
+			},
+			expected: services.MetricRegistry{
+				"temperature": services.DefaultMetrics["temperature"],
+				"humidity":    services.DefaultMetrics["humidity"],
+				"dew_point":   services.DefaultMetrics["dew_point"],
+				"vpd":         services.DefaultMetrics["vpd"],
+				"gdd":         services.DefaultMetrics["gdd"],
+			},
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			registry, err := services.NewMetricRegistry(testCase.definitions)
+			if !reflect.DeepEqual(err, testCase.expectedErr) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedErr, err)
+			}
+			if !reflect.DeepEqual(registry, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, registry)
+			}
+		})
+	}
+}
+
+func TestMetricRegistryValidate(t *testing.T) {
+	registry := services.MetricRegistry{
+		"ph":      {Name: "ph", Unit: "pH", Min: 0, Max: 14},
+		"battery": {Name: "battery", Unit: "V", Min: 0, Max: 5},
+	}
+
+	tests := map[string]struct {
+		metrics  map[string]models.Metric // input
+		expected map[string]models.Metric // expected metrics after validation
+		err      error                    // expected error
+	}{
+		"Happy path": {
+			metrics:  map[string]models.Metric{"ph": {Value: 6.5, Unit: "pH"}},
+			expected: map[string]models.Metric{"ph": {Value: 6.5, Unit: "pH"}},
+		},
+		"Unit filled in": {
+			metrics:  map[string]models.Metric{"battery": {Value: 3.3}},
+			expected: map[string]models.Metric{"battery": {Value: 3.3, Unit: "V"}},
+		},
+		"No metrics": {},
+		"Unknown metric": {
+			metrics: map[string]models.Metric{"co2": {Value: 400}},
+			err:     services.StatusInvalidData,
+		},
+		"Wrong unit": {
+			metrics: map[string]models.Metric{"battery": {Value: 3300, Unit: "mV"}},
+			err:     services.StatusInvalidData,
+		},
+		"Below minimum": {
+			metrics: map[string]models.Metric{"ph": {Value: -1}},
+			err:     services.StatusInvalidData,
+		},
+		"Above maximum": {
+			metrics: map[string]models.Metric{"ph": {Value: 15}},
+			err:     services.StatusInvalidData,
+		},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			data := &models.StatusData{ID: 1, Metrics: testCase.metrics}
+			err := registry.Validate(data)
+			if !reflect.DeepEqual(err, testCase.err) {
+				t.Errorf("Expected %+v, got %+v", testCase.err, err)
+			}
+			if err == nil && !reflect.DeepEqual(data.Metrics, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, data.Metrics)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// Stages of the ingestion pipeline provided by StatusDatabase
+const (
+	// StageDecode checks timestamps against their receive time, applying the timestamp policy
+	StageDecode = "decode"
+	// StageEnrich resolves device IDs to the plants they are bound to
+	StageEnrich = "enrich"
+	// StageCalibrate corrects device readings with their calibration profiles
+	StageCalibrate = "calibrate"
+	// StageValidate checks metrics against their definitions
+	StageValidate = "validate"
+	// StageDerive computes derived metrics
+	StageDerive = "derive"
+	// StageDedupe acknowledges retries of the last reading stored for a plant without storing them
+	StageDedupe = "dedupe"
+	// StageInspect flags suspect metrics with quality codes
+	StageInspect = "inspect"
+	// StageStore writes readings to the database, which applies the duplicate policy, and adds
+	// stored readings to the quality statistics and to the windows of cumulative derived metrics
+	StageStore = "store"
+	// StagePublish evaluates alert rules and notifies subscribers of accepted readings
+	StagePublish = "publish"
+)
+
+// DefaultStages are the stages of the default pipeline, in order
+var DefaultStages = []string{
+	StageDecode,
+	StageEnrich,
+	StageCalibrate,
+	StageValidate,
+	StageDerive,
+	StageDedupe,
+	StageInspect,
+	StageStore,
+	StagePublish,
+}
+
+// Processor is an interface for stages of the ingestion pipeline.
+// A processor may change the reading; an error stops the pipeline, rejecting the reading
+// unless it is ErrIgnored.
+type Processor interface {
+	Process(data *models.StatusData) error
+}
+
+// ProcessorFunc adapts a function to a Processor
+type ProcessorFunc func(data *models.StatusData) error
+
+// Process calls f
+func (f ProcessorFunc) Process(data *models.StatusData) error { return f(data) }
+
+// Pipeline is a processor running its stages in order
+type Pipeline []Processor
+
+// Process runs the stages on a reading, stopping at the first error
+func (p Pipeline) Process(data *models.StatusData) error {
+	for _, stage := range p {
+		if err := stage.Process(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewPipeline builds the pipeline of the named stages, in order. Stages may appear once, and the
+// store stage is required, before the publish stage.
+func NewPipeline(names []string, stages map[string]Processor) (Pipeline, error) {
+	if len(names) == 0 {
+		return nil, StatusInvalidDataError("empty pipeline")
+	}
+
+	pipeline := make(Pipeline, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		stage, ok := stages[name]
+		if !ok {
+			return nil, StatusInvalidDataError("invalid stage " + name)
+		}
+		if seen[name] {
+			return nil, StatusInvalidDataError("duplicate stage " + name)
+		}
+		if name == StagePublish && !seen[StageStore] {
+			return nil, StatusInvalidDataError("publish stage before store stage")
+		}
+		seen[name] = true
+		pipeline = append(pipeline, stage)
+	}
+	if !seen[StageStore] {
+		return nil, StatusInvalidDataError("missing store stage")
+	}
+
+	return pipeline, nil
+}
+
+// Stages returns the stages provided by the service, by name.
+// The dedupe and store stages of a call share the readings stored last, so they
+// should come from the same call.
+func (s *StatusDatabase) Stages() map[string]Processor {
+	recent := &recentReadings{last: map[uint]*models.StatusData{}}
+
+	return map[string]Processor{
+		StageDecode:    ProcessorFunc(s.decode),
+		StageEnrich:    ProcessorFunc(s.enrich),
+		StageCalibrate: ProcessorFunc(s.calibrate),
+		StageValidate:  ProcessorFunc(s.validate),
+		StageDerive:    ProcessorFunc(s.deriveMetrics),
+		StageDedupe:    ProcessorFunc(recent.dedupe),
+		StageInspect:   ProcessorFunc(s.inspect),
+		StageStore: ProcessorFunc(func(data *models.StatusData) error {
+			if err := s.store(data); err != nil {
+				return err
+			}
+			recent.stored(data)
+			s.recordIntegrals(data, s.metrics())
+			if s.Quality != nil {
+				s.Quality.Record(data)
+			}
+
+			return nil
+		}),
+		StagePublish: ProcessorFunc(s.publishAccepted),
+	}
+}
+
+// pipeline returns the configured pipeline, or the default one
+func (s *StatusDatabase) pipeline() Pipeline {
+	if s.Pipeline != nil {
+		return s.Pipeline
+	}
+	s.defaultOnce.Do(func() {
+		s.defaultPipeline, _ = NewPipeline(DefaultStages, s.Stages())
+	})
+
+	return s.defaultPipeline
+}
+
+// prepare checks the idempotency key of a reading and sets the fields set by the broker,
+// whatever the stages of the pipeline
+func (s *StatusDatabase) prepare(data *models.StatusData) error {
+	if len(data.IdempotencyKey) > maxIdempotencyKey {
+		return StatusInvalidData
+	}
+
+	// Raw values and quality codes are set by the broker
+	data.Raw = nil
+	data.Quality = nil
+
+	clock := s.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	data.ReceivedAt = clock().Unix()
+
+	return nil
+}
+
+// decode checks the timestamp of a reading against its receive time, before calibration and
+// device resolution use it
+func (s *StatusDatabase) decode(data *models.StatusData) error {
+	return s.Timestamps.Check(data, data.ReceivedAt)
+}
+
+// enrich sets the plant of device readings from the binding effective at their timestamp
+func (s *StatusDatabase) enrich(data *models.StatusData) error {
+	if data.DeviceID == 0 {
+		return nil
+	}
+	if s.Devices == nil {
+		return StatusInvalidID
+	}
+
+	plantID, err := resolvePlant(s.Devices, data.DeviceID, data.Timestamp)
+	switch err.(type) {
+	case nil:
+		data.ID = plantID
+
+		return nil
+	case database.DatabaseInvalidDataError:
+		return StatusInvalidID
+	default:
+		return StatusDatabaseDriverError(err.Error())
+	}
+}
+
+// calibrate corrects device readings, so thresholds apply to the corrected values
+func (s *StatusDatabase) calibrate(data *models.StatusData) error {
+	if data.DeviceID == 0 || s.Devices == nil {
+		return nil
+	}
+
+	switch err := calibrate(s.Devices, data); err.(type) {
+	case nil:
+		return nil
+	case database.DatabaseInvalidDataError:
+		return StatusInvalidID
+	default:
+		return StatusDatabaseDriverError(err.Error())
+	}
+}
+
+// validate checks the metrics of a reading against their definitions
+func (s *StatusDatabase) validate(data *models.StatusData) error {
+	return s.metrics().Validate(data)
+}
+
+// deriveMetrics computes the derived metrics of a reading, once the plant of cumulative ones is known
+func (s *StatusDatabase) deriveMetrics(data *models.StatusData) error {
+	return s.derive(data, s.metrics())
+}
+
+// inspect flags the suspect metrics of a reading, if a quality inspector is set
+func (s *StatusDatabase) inspect(data *models.StatusData) error {
+	if s.Quality != nil {
+		s.Quality.Inspect(data)
+	}
+
+	return nil
+}
+
+// store writes a reading to the database
+func (s *StatusDatabase) store(data *models.StatusData) error {
+	switch err := s.Driver.WriteStatus(data); e := err.(type) {
+	case nil:
+		return nil
+	case database.DatabaseInvalidDataError:
+		return StatusInvalidID
+	case database.DatabaseUnavailableError:
+		return StatusUnavailableError{Message: e.Message, RetryAfter: e.RetryAfter}
+	case database.DatabaseDuplicateError:
+		if e.Ignored {
+			return ErrIgnored
+		}
+
+		return StatusDuplicate
+	default:
+		return StatusDatabaseDriverError(err.Error())
+	}
+}
+
+// publishAccepted runs the alert rules on a stored reading and notifies subscribers of it
+func (s *StatusDatabase) publishAccepted(data *models.StatusData) error {
+	s.evaluate(data)
+	s.publish(data, nil)
+
+	return nil
+}
+
+func (s *StatusDatabase) metrics() MetricRegistry {
+	if s.Metrics == nil {
+		return DefaultMetrics
+	}
+
+	return s.Metrics
+}
+
+// recentReadings holds the last reading stored for each plant, so immediate retries are
+// acknowledged without a database round trip. The database still detects other duplicates.
+type recentReadings struct {
+	mu   sync.Mutex
+	last map[uint]*models.StatusData
+}
+
+// dedupe ignores a reading repeating the last stored reading of its plant: same time and
+// either the same metrics or the same idempotency key
+func (r *recentReadings) dedupe(data *models.StatusData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, ok := r.last[data.ID]
+	if !ok || last.Timestamp != data.Timestamp {
+		return nil
+	}
+	if (data.IdempotencyKey != "" && data.IdempotencyKey == last.IdempotencyKey) ||
+		reflect.DeepEqual(data.Metrics, last.Metrics) {
+		return ErrIgnored
+	}
+
+	return nil
+}
+
+// stored records the last stored reading of a plant
+func (r *recentReadings) stored(data *models.StatusData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *data
+	if data.Metrics != nil {
+		stored.Metrics = make(map[string]models.Metric, len(data.Metrics))
+		for name, metric := range data.Metrics {
+			stored.Metrics[name] = metric
+		}
+	}
+	r.last[data.ID] = &stored
+}
@@ -0,0 +1,162 @@
+package services_test
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+// countingDriver counts the writes reaching the database
+type countingDriver struct {
+	*database.Memory
+	writes int
+}
+
+func (d *countingDriver) WriteStatus(data *models.StatusData) error {
+	d.writes++
+
+	return d.Memory.WriteStatus(data)
+}
+
+func TestNewPipeline(t *testing.T) {
+	service := services.StatusDatabase{}
+	stages := service.Stages()
+
+	tests := map[string]struct {
+		names    []string // input
+		expected error    // expected error
+	}{
+		"Default":           {services.DefaultStages, nil},
+		"Without alerts":    {[]string{"decode", "validate", "store"}, nil},
+		"Empty":             {nil, services.StatusInvalidDataError("empty pipeline")},
+		"Unknown stage":     {[]string{"decode", "tag", "store"}, services.StatusInvalidDataError("invalid stage tag")},
+		"Duplicate stage":   {[]string{"validate", "store", "store"}, services.StatusInvalidDataError("duplicate stage store")},
+		"Without store":     {[]string{"decode", "validate"}, services.StatusInvalidDataError("missing store stage")},
+		"Publish too early": {[]string{"validate", "publish", "store"}, services.StatusInvalidDataError("publish stage before store stage")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pipeline, err := services.NewPipeline(testCase.names, stages)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if err == nil && len(pipeline) != len(testCase.names) {
+				t.Errorf("Expected %d stages, got %d", len(testCase.names), len(pipeline))
+			}
+		})
+	}
+}
+
+func TestStatusWritePipeline(t *testing.T) {
+	// Setup
+	memory, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	driver := &countingDriver{Memory: memory}
+	events := &mockPublisher{}
+	service := &services.StatusDatabase{
+		Driver: driver,
+		Reader: memory,
+		Events: events,
+		Metrics: services.MetricRegistry{
+			"temperature": services.DefaultMetrics["temperature"],
+			"light":       services.DefaultMetrics["light"],
+		},
+	}
+
+	// A deployment stage converting units, and one dropping readings of a plant
+	stages := service.Stages()
+	stages[services.StageConvert] = &services.UnitConverter{Metrics: service.Metrics}
+	stages["mute"] = services.ProcessorFunc(func(data *models.StatusData) error {
+		if data.ID == 2 {
+			return services.ErrIgnored
+		}
+
+		return nil
+	})
+	pipeline, err := services.NewPipeline([]string{"mute", "decode", "convert", "validate", "dedupe", "store", "publish"}, stages)
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	service.Pipeline = pipeline
+
+	steps := []struct {
+		name           string             // step name
+		data           *models.StatusData // input
+		expected       error              // expected error
+		expectedWrites int                // expected database writes so far
+	}{
+		{"Converted", &models.StatusData{ID: 1, Timestamp: 100, Metrics: map[string]models.Metric{"temperature": {Value: 77, Unit: "°F"}, "light": {Value: 500, Unit: "lx"}}}, nil, 1},
+		{"Retry", &models.StatusData{ID: 1, Timestamp: 100, Metrics: map[string]models.Metric{"temperature": {Value: 77, Unit: "°F"}, "light": {Value: 500, Unit: "lx"}}}, nil, 1},
+		{"Keyed", &models.StatusData{ID: 1, Timestamp: 200, IdempotencyKey: "k", Metrics: map[string]models.Metric{"temperature": {Value: 20}}}, nil, 2},
+		{"Keyed retry", &models.StatusData{ID: 1, Timestamp: 200, IdempotencyKey: "k", Metrics: map[string]models.Metric{"temperature": {Value: 21}}}, nil, 2},
+		{"Overwrite", &models.StatusData{ID: 1, Timestamp: 200, Metrics: map[string]models.Metric{"temperature": {Value: 22}}}, nil, 3},
+		{"No conversion", &models.StatusData{ID: 1, Timestamp: 300, Metrics: map[string]models.Metric{"temperature": {Value: 20, Unit: "°R"}}}, services.StatusInvalidData, 3},
+		{"Muted", &models.StatusData{ID: 2, Timestamp: 300}, nil, 3},
+	}
+	for _, step := range steps {
+		if err := service.Write(step.data); !reflect.DeepEqual(err, step.expected) {
+			t.Errorf("%s: expected %+v, got %+v", step.name, step.expected, err)
+		}
+		if driver.writes != step.expectedWrites {
+			t.Errorf("%s: expected %d writes, got %d", step.name, step.expectedWrites, driver.writes)
+		}
+	}
+
+	// Values are stored in the units of their definitions
+	var stored []*models.StatusData
+	memory.ReadStatus(1, 0, 0, func(data *models.StatusData) error {
+		stored = append(stored, data)
+
+		return nil
+	})
+	if len(stored) != 2 {
+		t.Fatalf("Expected 2 readings, got %d", len(stored))
+	}
+	temperature, light := stored[0].Metrics["temperature"], stored[0].Metrics["light"]
+	if math.Abs(temperature.Value-25) > 1e-9 || temperature.Unit != "°C" || light != (models.Metric{Value: 0.5, Unit: "klx"}) {
+		t.Errorf("Expected 25 °C and 0.5 klx, got %+v and %+v", temperature, light)
+	}
+
+	// Accepted and rejected readings are published, retries and muted readings are not
+	types := []string{}
+	for _, event := range events.events {
+		types = append(types, event.Type)
+	}
+	expectedTypes := []string{models.EventReadingAccepted, models.EventReadingAccepted, models.EventReadingAccepted, models.EventReadingRejected}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Errorf("Expected %+v, got %+v", expectedTypes, types)
+	}
+}
+
+func TestStatusWriteMinimalPipeline(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	service := &services.StatusDatabase{
+		Driver: driver,
+		Clock:  func() time.Time { return time.Unix(10000, 0) },
+	}
+	pipeline, err := services.NewPipeline([]string{services.StageStore}, service.Stages())
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	service.Pipeline = pipeline
+
+	// Idempotency keys and the fields set by the broker do not depend on the stages
+	keyed := &models.StatusData{ID: 1, Timestamp: 100, IdempotencyKey: strings.Repeat("k", 65)}
+	if err := service.Write(keyed); err != services.StatusInvalidData {
+		t.Errorf("Expected %+v, got %+v", services.StatusInvalidData, err)
+	}
+	data := &models.StatusData{ID: 1, Timestamp: 100, Quality: map[string]string{"temperature": models.QualitySpike}}
+	if err := service.Write(data); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	expected := &models.StatusData{ID: 1, Timestamp: 100, ReceivedAt: 10000}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, data)
+	}
+}
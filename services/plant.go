@@ -0,0 +1,98 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+)
+
+// PlantInvalidDataError is an error type for invalid plant data errors
+type PlantInvalidDataError string
+
+// PlantDatabaseDriverError is an error type for plant database driver errors
+type PlantDatabaseDriverError string
+
+func (e PlantInvalidDataError) Error() string    { return string(e) }
+func (e PlantDatabaseDriverError) Error() string { return string(e) }
+
+const (
+	// PlantInvalidData is the default error for invalid plant data
+	PlantInvalidData = PlantInvalidDataError("invalid data")
+	// PlantInvalidID is the default error for non-existent plant IDs
+	PlantInvalidID = PlantInvalidDataError("invalid ID")
+	// PlantDuplicateID is the default error for already registered plant IDs
+	PlantDuplicateID = PlantInvalidDataError("duplicate ID")
+)
+
+// PlantDatabase is a service for managing the plant registry
+type PlantDatabase struct {
+	Driver database.PlantStore
+}
+
+// Create registers a new plant
+func (s *PlantDatabase) Create(plant *models.Plant) error {
+	if err := validatePlant(plant); err != nil {
+		return err
+	}
+
+	return plantError(s.Driver.CreatePlant(plant), PlantDuplicateID)
+}
+
+// Read reads a plant
+func (s *PlantDatabase) Read(id uint) (*models.Plant, error) {
+	plant, err := s.Driver.ReadPlant(id)
+	if err != nil {
+		return nil, plantError(err, PlantInvalidID)
+	}
+
+	return plant, nil
+}
+
+// ReadAll reads every plant
+func (s *PlantDatabase) ReadAll() ([]*models.Plant, error) {
+	plants, err := s.Driver.ReadPlants()
+	if err != nil {
+		return nil, plantError(err, PlantInvalidData)
+	}
+
+	return plants, nil
+}
+
+// Update updates a plant
+func (s *PlantDatabase) Update(plant *models.Plant) error {
+	if err := validatePlant(plant); err != nil {
+		return err
+	}
+
+	return plantError(s.Driver.UpdatePlant(plant), PlantInvalidID)
+}
+
+// Delete deletes a plant
+func (s *PlantDatabase) Delete(id uint) error {
+	return plantError(s.Driver.DeletePlant(id), PlantInvalidID)
+}
+
+// validatePlant checks the required plant fields
+func validatePlant(plant *models.Plant) error {
+	if plant == nil {
+		return PlantInvalidDataError("nil data")
+	}
+	if strings.TrimSpace(plant.Name) == "" {
+		return PlantInvalidData
+	}
+
+	return nil
+}
+
+// plantError maps a driver error, using invalid for invalid data errors
+func plantError(err error, invalid PlantInvalidDataError) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case database.DatabaseInvalidDataError:
+		return invalid
+	default:
+		return PlantDatabaseDriverError(err.Error())
+	}
+}
@@ -0,0 +1,190 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestPlantInvalidDataError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.PlantInvalidDataError // error
+		expected string                         // expected message
+	}{
+		"General test": {services.PlantInvalidDataError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+func TestPlantDatabaseDriverError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.PlantDatabaseDriverError // error
+		expected string                            // expected message
+	}{
+		"General test": {services.PlantDatabaseDriverError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+// Plant store mock: IDs 1 to 4 exist, ID 5 fails
+type mockPlantStore struct{}
+
+func (d *mockPlantStore) CreatePlant(plant *models.Plant) error {
+	switch {
+	case plant.ID > 0 && plant.ID < 5:
+		return database.DatabaseInvalidDataError("duplicate ID")
+	case plant.ID == 5:
+		return database.DatabaseUnexpectedError("mocked error")
+	case plant.ID == 0:
+		plant.ID = 6
+	}
+
+	return nil
+}
+
+func (d *mockPlantStore) ReadPlant(id uint) (*models.Plant, error) {
+	switch {
+	case id > 0 && id < 5:
+		return &models.Plant{ID: id, Name: "Basil"}, nil
+	case id == 5:
+		return nil, database.DatabaseUnexpectedError("mocked error")
+	}
+
+	return nil, database.DatabaseInvalidDataError("invalid ID")
+}
+
+func (d *mockPlantStore) ReadPlants() ([]*models.Plant, error) {
+	return []*models.Plant{&models.Plant{ID: 1, Name: "Basil"}}, nil
+}
+
+func (d *mockPlantStore) UpdatePlant(plant *models.Plant) error {
+	_, err := d.ReadPlant(plant.ID)
+
+	return err
+}
+
+func (d *mockPlantStore) DeletePlant(id uint) error {
+	_, err := d.ReadPlant(id)
+
+	return err
+}
+
+func TestPlantCreate(t *testing.T) {
+	// Setup
+	service := services.PlantDatabase{
+		Driver: &mockPlantStore{},
+	}
+
+	tests := map[string]struct {
+		plant    *models.Plant // input
+		expected error         // expected error
+	}{
+		"Happy path":     {&models.Plant{Name: "Basil"}, nil},
+		"nil data":       {nil, services.PlantInvalidDataError("nil data")},
+		"Missing name":   {&models.Plant{Name: "  "}, services.PlantInvalidData},
+		"Duplicate ID":   {&models.Plant{ID: 1, Name: "Basil"}, services.PlantDuplicateID},
+		"Database error": {&models.Plant{ID: 5, Name: "Basil"}, services.PlantDatabaseDriverError("mocked error")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Create(testCase.plant)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestPlantRead(t *testing.T) {
+	// Setup
+	service := services.PlantDatabase{
+		Driver: &mockPlantStore{},
+	}
+
+	tests := map[string]struct {
+		id            uint          // input
+		expected      *models.Plant // expected plant
+		expectedError error         // expected error
+	}{
+		"Happy path":     {1, &models.Plant{ID: 1, Name: "Basil"}, nil},
+		"Invalid ID":     {7, nil, services.PlantInvalidID},
+		"Database error": {5, nil, services.PlantDatabaseDriverError("mocked error")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			plant, err := service.Read(testCase.id)
+			if !reflect.DeepEqual(err, testCase.expectedError) {
+				t.Errorf("Expected %+v, got %+v", testCase.expectedError, err)
+			}
+			if !reflect.DeepEqual(plant, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, plant)
+			}
+		})
+	}
+}
+
+func TestPlantUpdate(t *testing.T) {
+	// Setup
+	service := services.PlantDatabase{
+		Driver: &mockPlantStore{},
+	}
+
+	tests := map[string]struct {
+		plant    *models.Plant // input
+		expected error         // expected error
+	}{
+		"Happy path":     {&models.Plant{ID: 1, Name: "Basil"}, nil},
+		"Missing name":   {&models.Plant{ID: 1}, services.PlantInvalidData},
+		"Invalid ID":     {&models.Plant{ID: 7, Name: "Basil"}, services.PlantInvalidID},
+		"Database error": {&models.Plant{ID: 5, Name: "Basil"}, services.PlantDatabaseDriverError("mocked error")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Update(testCase.plant)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestPlantDelete(t *testing.T) {
+	// Setup
+	service := services.PlantDatabase{
+		Driver: &mockPlantStore{},
+	}
+
+	tests := map[string]struct {
+		id       uint  // input
+		expected error // expected error
+	}{
+		"Happy path":     {1, nil},
+		"Invalid ID":     {7, services.PlantInvalidID},
+		"Database error": {5, services.PlantDatabaseDriverError("mocked error")},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Delete(testCase.id)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
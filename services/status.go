@@ -1,8 +1,14 @@
 package services
 
 import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
 	"github.com/berry-house/http_broker/drivers/database"
 	"github.com/berry-house/http_broker/models"
+	"go.uber.org/zap"
 )
 
 // StatusInvalidDataError is an error type for invalid data errors
@@ -11,40 +17,191 @@ type StatusInvalidDataError string
 // StatusDatabaseDriverError is an error type for database driver errors
 type StatusDatabaseDriverError string
 
+// StatusUnavailableError is an error type for temporarily unavailable databases
+type StatusUnavailableError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
 func (e StatusInvalidDataError) Error() string    { return string(e) }
 func (e StatusDatabaseDriverError) Error() string { return string(e) }
+func (e StatusUnavailableError) Error() string    { return e.Message }
 
 const (
 	// StatusInvalidData is the default error for invalid data
 	StatusInvalidData = StatusInvalidDataError("invalid data")
 	// StatusInvalidID is the default error for non-existent IDs
 	StatusInvalidID = StatusInvalidDataError("invalid ID")
+	// StatusDuplicate is the default error for readings rejected by the duplicate policy
+	StatusDuplicate = StatusInvalidDataError("duplicate reading")
+	// StatusInvalidTimestamp is the default error for readings rejected by the timestamp policy
+	StatusInvalidTimestamp = StatusInvalidDataError("invalid timestamp")
 )
 
+// maxIdempotencyKey is the length limit of idempotency keys
+const maxIdempotencyKey = 64
+
+// ErrIgnored marks writes acknowledged without being stored, such as retries.
+// Processors return it to stop the pipeline without rejecting a reading.
+var ErrIgnored = errors.New("ignored duplicate")
+
 // StatusDatabase is a service for writing status data to database
 type StatusDatabase struct {
 	Driver database.Database
+	// Devices resolves device IDs to plant IDs, if set
+	Devices database.DeviceStore
+	// Metrics describes the accepted metrics, defaulting to DefaultMetrics
+	Metrics MetricRegistry
+	// Alerts evaluates every accepted reading, if set
+	Alerts AlertEvaluator
+	// Events is notified of accepted and rejected readings, if set
+	Events Publisher
+	// Reader reads stored status data for queries, if set
+	Reader database.StatusReader
+	// Timestamps checks the timestamps of readings against their receive time
+	Timestamps TimestampPolicy
+	// Clock returns the receive time of readings, defaulting to time.Now
+	Clock func() time.Time
+	// Quality flags suspect metrics before storage and records stored readings, if set
+	Quality QualityInspector
+	// Heartbeats records accepted readings and acknowledged retries as signs of life, if set
+	Heartbeats HeartbeatRecorder
+	// Pipeline processes every reading, defaulting to the DefaultStages of the service
+	Pipeline Pipeline
+
+	defaultOnce     sync.Once
+	defaultPipeline Pipeline
+	integrals       integralWindows
 }
 
-// Write writes status data to the database
+// Write stamps status data with its receive time and runs it through the ingestion pipeline,
+// rejected readings being published
 func (s *StatusDatabase) Write(data *models.StatusData) error {
 	if data == nil {
 		return StatusInvalidDataError("nil data")
 	}
 
-	// Threshold values
-	if data.Temperature < -30 || data.Temperature > 50 ||
-		data.Light > 150 ||
-		data.Humidity > 100 {
+	err := s.prepare(data)
+	if err == nil {
+		err = s.pipeline().Process(data)
+	}
+	if (err == nil || err == ErrIgnored) && s.Heartbeats != nil {
+		s.Heartbeats.Seen(data)
+	}
+	if err == ErrIgnored {
+		// Nothing was stored, so subscribers are not notified again
+		return nil
+	}
+	if err != nil {
+		s.publish(data, err)
+	}
+
+	return err
+}
+
+// Query passes the status data of a plant from (inclusive) to (exclusive) to fn, in time order.
+// A zero to has no upper bound.
+func (s *StatusDatabase) Query(plantID uint, from, to int64, fn func(data *models.StatusData) error) error {
+	if to != 0 && to <= from {
 		return StatusInvalidData
 	}
+	if s.Reader == nil {
+		return StatusDatabaseDriverError("no status reader")
+	}
 
-	switch err := s.Driver.WriteStatus(data); err.(type) {
+	switch err := s.Reader.ReadStatus(plantID, from, to, fn); err.(type) {
 	case nil:
 		return nil
 	case database.DatabaseInvalidDataError:
 		return StatusInvalidID
-	default:
+	case database.DatabaseUnexpectedError, database.DatabaseQueryError:
 		return StatusDatabaseDriverError(err.Error())
+	default:
+		// Errors of fn are returned as is
+		return err
+	}
+}
+
+// Aggregate summarizes the metrics of the stored readings of a plant from a time (inclusive) to
+// another (exclusive, 0 for no limit) in windows of a number of seconds, aligned on multiples of
+// it. Windows without readings are left out. Derived metrics are summarized like reported ones,
+// and an empty list of metrics summarizes every metric.
+func (s *StatusDatabase) Aggregate(plantID uint, from, to, window int64, metrics []string) ([]*models.Aggregate, error) {
+	if window <= 0 {
+		return nil, StatusInvalidData
+	}
+	wanted := make(map[string]bool, len(metrics))
+	for _, name := range metrics {
+		wanted[name] = true
+	}
+
+	aggregates := []*models.Aggregate{}
+	var current *models.Aggregate
+	err := s.Query(plantID, from, to, func(data *models.StatusData) error {
+		start := data.Timestamp - data.Timestamp%window
+		if data.Timestamp%window < 0 {
+			start -= window
+		}
+		// Readings are read in time order
+		if current == nil || current.From != start {
+			current = &models.Aggregate{From: start, To: start + window, Metrics: map[string]*models.MetricSummary{}}
+			aggregates = append(aggregates, current)
+		}
+		for name, metric := range data.Metrics {
+			if len(wanted) > 0 && !wanted[name] {
+				continue
+			}
+			summary, ok := current.Metrics[name]
+			if !ok {
+				summary = &models.MetricSummary{Min: metric.Value, Max: metric.Value, Unit: metric.Unit}
+				current.Metrics[name] = summary
+			}
+			summary.Count++
+			summary.Min = math.Min(summary.Min, metric.Value)
+			summary.Max = math.Max(summary.Max, metric.Value)
+			// Running mean, which cannot overflow
+			summary.Mean += (metric.Value - summary.Mean) / float64(summary.Count)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregates, nil
+}
+
+// publish notifies subscribers of an accepted or rejected reading.
+// Unavailable and failing drivers reject nothing, so they are not notified.
+func (s *StatusDatabase) publish(data *models.StatusData, err error) {
+	if s.Events == nil {
+		return
+	}
+
+	event := &models.Event{PlantID: data.ID}
+	switch err.(type) {
+	case nil:
+		event.Type = models.EventReadingAccepted
+		event.Data = data
+	case StatusInvalidDataError:
+		event.Type = models.EventReadingRejected
+		event.Data = &models.RejectedReading{Reading: data, Error: err.Error()}
+	default:
+		return
+	}
+	if err := s.Events.Publish(event); err != nil {
+		zap.L().Error(err.Error(), zap.String("service", "events"), zap.Uint("plant", data.ID))
+	}
+}
+
+// evaluate runs the alert rules on an accepted reading.
+// The reading is already stored, so failures are only logged.
+func (s *StatusDatabase) evaluate(data *models.StatusData) {
+	if s.Alerts == nil {
+		return
+	}
+	if err := s.Alerts.Evaluate(data); err != nil {
+		zap.L().Error(err.Error(), zap.String("service", "alerts"), zap.Uint("plant", data.ID))
 	}
 }
@@ -2,7 +2,9 @@ package services_test
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/berry-house/http_broker/drivers/database"
 
@@ -44,6 +46,23 @@ func TestStatusDatabaseDriverError(t *testing.T) {
 	}
 }
 
+func TestStatusUnavailableError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.StatusUnavailableError // error
+		expected string                          // expected message
+	}{
+		"General test": {services.StatusUnavailableError{Message: "error message"}, "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
 type mockDatabaseDriver struct{}
 
 func (d *mockDatabaseDriver) Exists(id uint) (bool, error) {
@@ -69,6 +88,10 @@ func (d *mockDatabaseDriver) WriteStatus(data *models.StatusData) error {
 	if data.ID == 0 || data.ID == 5 {
 		return database.DatabaseUnexpectedError("mocked error")
 	}
+	// Mocked open breaker
+	if data.ID == 9 {
+		return database.DatabaseUnavailableError{Message: "mocked breaker", RetryAfter: time.Second}
+	}
 
 	return database.DatabaseInvalidDataError("invalid id")
 }
@@ -83,14 +106,22 @@ func TestStatusWrite(t *testing.T) {
 		data     *models.StatusData // input
 		expected error              // expected error
 	}{
-		"Happy path":           {&models.StatusData{ID: 1, Timestamp: 1516478286, Temperature: 23}, nil},
+		"Happy path":           {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: 23}}}, nil},
 		"nil data":             {nil, services.StatusInvalidDataError("nil data")},
 		"Invalid ID":           {&models.StatusData{ID: 6, Timestamp: 1516478286}, services.StatusInvalidID},
-		"Temperature too low":  {&models.StatusData{ID: 1, Timestamp: 1516478286, Temperature: -50}, services.StatusInvalidData},
-		"Temperature too high": {&models.StatusData{ID: 1, Timestamp: 1516478286, Temperature: 56}, services.StatusInvalidData},
-		"Light too high":       {&models.StatusData{ID: 1, Timestamp: 1516478286, Light: 153}, services.StatusInvalidData},
-		"Humidity too high":    {&models.StatusData{ID: 1, Timestamp: 1516478286, Humidity: 105}, services.StatusInvalidData},
-		"Database error":       {&models.StatusData{ID: 5, Timestamp: 1516478286, Temperature: 20}, services.StatusDatabaseDriverError("mocked error")},
+		"Temperature too low":  {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: -50}}}, services.StatusInvalidData},
+		"Temperature too high": {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: 56}}}, services.StatusInvalidData},
+		"Light too high":       {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"light": {Value: 153}}}, services.StatusInvalidData},
+		"Humidity too high":    {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"humidity": {Value: 105}}}, services.StatusInvalidData},
+		"Humidity negative":    {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"humidity": {Value: -0.5}}}, services.StatusInvalidData},
+		"Light negative":       {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"light": {Value: -1}}}, services.StatusInvalidData},
+		"Fractional values":    {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: 21.4}, "humidity": {Value: 99.9}}}, nil},
+		"Absent values":        {&models.StatusData{ID: 1, Timestamp: 1516478286}, nil},
+		"New metric":           {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"ph": {Value: 6.5, Unit: "pH"}}}, nil},
+		"Unknown metric":       {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"radiation": {Value: 1}}}, services.StatusInvalidData},
+		"Wrong unit":           {&models.StatusData{ID: 1, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: 70, Unit: "°F"}}}, services.StatusInvalidData},
+		"Database error":       {&models.StatusData{ID: 5, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: 20}}}, services.StatusDatabaseDriverError("mocked error")},
+		"Database unavailable": {&models.StatusData{ID: 9, Timestamp: 1516478286, Metrics: map[string]models.Metric{"temperature": {Value: 20}}}, services.StatusUnavailableError{Message: "mocked breaker", RetryAfter: time.Second}},
 	}
 	for testName, testCase := range tests {
 		t.Run(testName, func(t *testing.T) {
@@ -101,3 +132,177 @@ func TestStatusWrite(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusQuery(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{
+		1: []*models.StatusData{
+			&models.StatusData{ID: 1, Timestamp: 100},
+			&models.StatusData{ID: 1, Timestamp: 200},
+		},
+	})
+	service := services.StatusDatabase{
+		Driver: driver,
+		Reader: driver,
+	}
+	stop := services.StatusDatabaseDriverError("stop")
+
+	tests := map[string]struct {
+		plantID  uint    // input
+		from     int64   // input
+		to       int64   // input
+		fnErr    error   // error returned by fn
+		expected []int64 // expected timestamps
+		err      error   // expected error
+	}{
+		"Happy path":     {1, 0, 0, nil, []int64{100, 200}, nil},
+		"Range":          {1, 150, 250, nil, []int64{200}, nil},
+		"Invalid range":  {1, 200, 100, nil, []int64{}, services.StatusInvalidData},
+		"Invalid ID":     {2, 0, 0, nil, []int64{}, services.StatusInvalidID},
+		"Callback error": {1, 0, 0, stop, []int64{100}, stop},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			timestamps := []int64{}
+			err := service.Query(testCase.plantID, testCase.from, testCase.to, func(data *models.StatusData) error {
+				timestamps = append(timestamps, data.Timestamp)
+
+				return testCase.fnErr
+			})
+			if !reflect.DeepEqual(err, testCase.err) || !reflect.DeepEqual(timestamps, testCase.expected) {
+				t.Errorf("Expected %+v (%+v), got %+v (%+v)", testCase.expected, testCase.err, timestamps, err)
+			}
+		})
+	}
+}
+
+func TestStatusAggregate(t *testing.T) {
+	// Setup
+	reading := func(timestamp int64, humidity, dewPoint float64) *models.StatusData {
+		return &models.StatusData{ID: 1, Timestamp: timestamp, Metrics: map[string]models.Metric{
+			"humidity": {Value: humidity, Unit: "%"},
+			"dewPoint": {Value: dewPoint, Unit: "°C"},
+		}}
+	}
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{
+		1: []*models.StatusData{
+			reading(3500, 40, 8),
+			reading(3600, 30, 6),
+			reading(4000, 50, 10),
+			reading(7199, 40, 9),
+			&models.StatusData{ID: 1, Timestamp: 7200},
+			reading(14400, 20, 2),
+		},
+	})
+	service := services.StatusDatabase{
+		Driver: driver,
+		Reader: driver,
+	}
+
+	tests := map[string]struct {
+		from     int64               // input
+		to       int64               // input
+		window   int64               // input
+		metrics  []string            // input
+		expected []*models.Aggregate // expected aggregates
+		err      error               // expected error
+	}{
+		"Happy path": {3600, 10800, 3600, nil, []*models.Aggregate{
+			{From: 3600, To: 7200, Metrics: map[string]*models.MetricSummary{
+				"humidity": {Count: 3, Min: 30, Max: 50, Mean: 40, Unit: "%"},
+				"dewPoint": {Count: 3, Min: 6, Max: 10, Mean: 25.0 / 3, Unit: "°C"},
+			}},
+			{From: 7200, To: 10800, Metrics: map[string]*models.MetricSummary{}},
+		}, nil},
+		"Metrics": {0, 0, 7200, []string{"humidity"}, []*models.Aggregate{
+			{From: 0, To: 7200, Metrics: map[string]*models.MetricSummary{"humidity": {Count: 4, Min: 30, Max: 50, Mean: 40, Unit: "%"}}},
+			{From: 7200, To: 14400, Metrics: map[string]*models.MetricSummary{}},
+			{From: 14400, To: 21600, Metrics: map[string]*models.MetricSummary{"humidity": {Count: 1, Min: 20, Max: 20, Mean: 20, Unit: "%"}}},
+		}, nil},
+		"Empty":          {20000, 30000, 3600, nil, []*models.Aggregate{}, nil},
+		"Invalid window": {0, 0, 0, nil, nil, services.StatusInvalidData},
+		"Invalid range":  {200, 100, 60, nil, nil, services.StatusInvalidData},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			aggregates, err := service.Aggregate(1, testCase.from, testCase.to, testCase.window, testCase.metrics)
+			if !reflect.DeepEqual(err, testCase.err) || !reflect.DeepEqual(aggregates, testCase.expected) {
+				t.Errorf("Expected %+v (%+v), got %+v (%+v)", testCase.expected, testCase.err, aggregates, err)
+			}
+		})
+	}
+}
+
+func TestStatusWriteDuplicates(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	driver.Duplicates = database.DuplicateReject
+	events := &mockPublisher{}
+	service := services.StatusDatabase{
+		Driver: driver,
+		Events: events,
+	}
+
+	stored := &models.StatusData{ID: 1, Timestamp: 100, IdempotencyKey: "a"}
+	stored.SetValue("humidity", 40)
+	retry := &models.StatusData{ID: 1, Timestamp: 100, IdempotencyKey: "a"}
+	retry.SetValue("humidity", 40)
+	duplicate := &models.StatusData{ID: 1, Timestamp: 100}
+	duplicate.SetValue("humidity", 45)
+	longKey := &models.StatusData{ID: 1, Timestamp: 200, IdempotencyKey: strings.Repeat("k", 65)}
+
+	steps := []struct {
+		name     string             // step name
+		data     *models.StatusData // input
+		expected error              // expected error
+	}{
+		{"Stored", stored, nil},
+		{"Retry", retry, nil},
+		{"Duplicate", duplicate, services.StatusDuplicate},
+		{"Long key", longKey, services.StatusInvalidData},
+	}
+	for _, step := range steps {
+		if err := service.Write(step.data); !reflect.DeepEqual(err, step.expected) {
+			t.Errorf("%s: expected %+v, got %+v", step.name, step.expected, err)
+		}
+	}
+
+	// Retries are not notified again
+	expected := []*models.Event{
+		&models.Event{Type: models.EventReadingAccepted, PlantID: 1, Data: stored},
+		&models.Event{Type: models.EventReadingRejected, PlantID: 1, Data: &models.RejectedReading{Reading: duplicate, Error: "duplicate reading"}},
+		&models.Event{Type: models.EventReadingRejected, PlantID: 1, Data: &models.RejectedReading{Reading: longKey, Error: "invalid data"}},
+	}
+	if !reflect.DeepEqual(events.events, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, events.events)
+	}
+}
+
+func TestStatusWriteFanout(t *testing.T) {
+	duplicates, _ := database.NewMemory(map[uint][]*models.StatusData{7: []*models.StatusData{{ID: 7, Timestamp: 100, Metrics: map[string]models.Metric{"light": {Value: 10}}}}})
+	duplicates.Duplicates = database.DuplicateReject
+
+	tests := map[string]struct {
+		secondary database.Database  // input secondary
+		data      *models.StatusData // input
+		expected  error              // expected error
+	}{
+		"Stored":              {&mockDatabaseDriver{}, &models.StatusData{ID: 1, Timestamp: 100}, nil},
+		"Primary rejects ID":  {&mockDatabaseDriver{}, &models.StatusData{ID: 8, Timestamp: 100}, services.StatusInvalidID},
+		"Secondary rejects":   {&mockDatabaseDriver{}, &models.StatusData{ID: 7, Timestamp: 100}, services.StatusDatabaseDriverError("secondary 0: invalid id")},
+		"Secondary duplicate": {duplicates, &models.StatusData{ID: 7, Timestamp: 100}, services.StatusDatabaseDriverError("secondary 0: duplicate reading")},
+		"Secondary down":      {&mockDatabaseDriver{}, &models.StatusData{ID: 9, Timestamp: 100}, services.StatusUnavailableError{Message: "secondary 0: mocked breaker", RetryAfter: time.Second}},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			primary, _ := database.NewMemory(map[uint][]*models.StatusData{1: {}, 7: {}, 9: {}})
+			driver, _ := database.NewFanout(primary, []database.Database{testCase.secondary}, database.FanoutConfig{Mode: database.FanoutAll})
+			service := services.StatusDatabase{Driver: driver}
+
+			// Only the primary rejects readings
+			if err := service.Write(testCase.data); !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"time"
+
+	"github.com/berry-house/http_broker/models"
+)
+
+// TimestampPolicy checks the timestamps of readings against the time they were received.
+// The zero policy rejects readings without a timestamp and accepts any other.
+type TimestampPolicy struct {
+	// MaxPast is how far behind the receive time a timestamp may be, zero for no limit
+	MaxPast time.Duration
+	// MaxFuture is how far ahead of the receive time a timestamp may be, zero for no limit
+	MaxFuture time.Duration
+	// Correct replaces timestamps out of the window with the receive time, instead of rejecting them
+	Correct bool
+	// StampMissing sets the receive time as the timestamp of readings without one,
+	// for devices without a real-time clock
+	StampMissing bool
+}
+
+// Check checks the timestamp of a reading received at receivedAt, a Unix timestamp,
+// correcting it when configured. Timestamps before the epoch are missing.
+func (p TimestampPolicy) Check(data *models.StatusData, receivedAt int64) error {
+	if data.Timestamp <= 0 {
+		if !p.StampMissing {
+			return StatusInvalidTimestamp
+		}
+		data.Timestamp = receivedAt
+
+		return nil
+	}
+
+	// Compare in whole seconds, as the skew of a timestamp in milliseconds overflows a Duration
+	skew := data.Timestamp - receivedAt
+	if (p.MaxFuture > 0 && skew > int64(p.MaxFuture/time.Second)) ||
+		(p.MaxPast > 0 && -skew > int64(p.MaxPast/time.Second)) {
+		if !p.Correct {
+			return StatusInvalidTimestamp
+		}
+		data.Timestamp = receivedAt
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package services_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestTimestampPolicyCheck(t *testing.T) {
+	window := services.TimestampPolicy{MaxPast: time.Hour, MaxFuture: time.Minute}
+	correcting := window
+	correcting.Correct = true
+	stamping := services.TimestampPolicy{StampMissing: true}
+
+	tests := map[string]struct {
+		policy            services.TimestampPolicy // policy
+		timestamp         int64                    // input timestamp
+		expectedTimestamp int64                    // expected timestamp
+		expected          error                    // expected error
+	}{
+		"No limits":          {services.TimestampPolicy{}, 1, 1, nil},
+		"Missing":            {services.TimestampPolicy{}, 0, 0, services.StatusInvalidTimestamp},
+		"Before the epoch":   {window, -1, -1, services.StatusInvalidTimestamp},
+		"Missing stamped":    {stamping, 0, 10000, nil},
+		"In the window":      {window, 10060, 10060, nil},
+		"Oldest accepted":    {window, 6400, 6400, nil},
+		"Too far in future":  {window, 10061, 10061, services.StatusInvalidTimestamp},
+		"Too far in past":    {window, 6399, 6399, services.StatusInvalidTimestamp},
+		"Future corrected":   {correcting, 20000, 10000, nil},
+		"Past corrected":     {correcting, 1, 10000, nil},
+		"Stamping in window": {stamping, 1, 1, nil},
+		"In milliseconds":    {window, 1516472722000, 1516472722000, services.StatusInvalidTimestamp},
+		"Milliseconds fixed": {correcting, 1516472722000, 10000, nil},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			data := &models.StatusData{ID: 1, Timestamp: testCase.timestamp}
+			err := testCase.policy.Check(data, 10000)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if data.Timestamp != testCase.expectedTimestamp {
+				t.Errorf("Expected timestamp %d, got %d", testCase.expectedTimestamp, data.Timestamp)
+			}
+		})
+	}
+}
+
+func TestStatusWriteReceivedAt(t *testing.T) {
+	// Setup
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+	service := services.StatusDatabase{
+		Driver:     driver,
+		Reader:     driver,
+		Timestamps: services.TimestampPolicy{MaxFuture: time.Minute, Correct: true, StampMissing: true},
+		Clock:      func() time.Time { return time.Unix(10000, 0) },
+	}
+
+	for _, timestamp := range []int64{0, 9000, 20000} {
+		if err := service.Write(&models.StatusData{ID: 1, Timestamp: timestamp}); err != nil {
+			t.Fatalf("No error expected, got %+v", err)
+		}
+	}
+
+	// Both the device and the receive time are stored
+	expected := []*models.StatusData{
+		&models.StatusData{ID: 1, Timestamp: 9000, ReceivedAt: 10000},
+		&models.StatusData{ID: 1, Timestamp: 10000, ReceivedAt: 10000},
+	}
+	var readings []*models.StatusData
+	err := service.Query(1, 0, 0, func(data *models.StatusData) error {
+		readings = append(readings, data)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if !reflect.DeepEqual(readings, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, readings)
+	}
+}
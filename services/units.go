@@ -0,0 +1,59 @@
+package services
+
+import "github.com/berry-house/http_broker/models"
+
+// StageConvert is the name of the UnitConverter stage, which is not part of the default pipeline.
+// Converting after calibration lets profiles correct values in the unit devices report.
+const StageConvert = "convert"
+
+// UnitConversion is a linear conversion of values from a unit to another: value*Scale + Offset
+type UnitConversion struct {
+	From   string
+	To     string
+	Scale  float64
+	Offset float64
+}
+
+// DefaultConversions are the conversions to the units of the default metrics
+var DefaultConversions = []UnitConversion{
+	{From: "°F", To: "°C", Scale: 5.0 / 9, Offset: -160.0 / 9},
+	{From: "K", To: "°C", Scale: 1, Offset: -273.15},
+	{From: "lx", To: "klx", Scale: 0.001},
+}
+
+// UnitConverter is a processor converting metrics reported in other units to the unit of
+// their definition. Metrics without a conversion are left for validation to reject.
+type UnitConverter struct {
+	// Metrics gives the units of metrics, defaulting to DefaultMetrics
+	Metrics MetricRegistry
+	// Conversions are the known conversions, defaulting to DefaultConversions
+	Conversions []UnitConversion
+}
+
+// Process converts the metrics of a reading
+func (c *UnitConverter) Process(data *models.StatusData) error {
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
+	conversions := c.Conversions
+	if conversions == nil {
+		conversions = DefaultConversions
+	}
+
+	for name, metric := range data.Metrics {
+		definition, ok := metrics[name]
+		if !ok || metric.Unit == "" || metric.Unit == definition.Unit {
+			continue
+		}
+		for _, conversion := range conversions {
+			if conversion.From == metric.Unit && conversion.To == definition.Unit {
+				data.Metrics[name] = models.Metric{Value: metric.Value*conversion.Scale + conversion.Offset, Unit: definition.Unit}
+
+				break
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"go.uber.org/zap"
+)
+
+// WebhookInvalidDataError is an error type for invalid webhook data errors
+type WebhookInvalidDataError string
+
+// WebhookDatabaseDriverError is an error type for webhook database driver errors
+type WebhookDatabaseDriverError string
+
+func (e WebhookInvalidDataError) Error() string    { return string(e) }
+func (e WebhookDatabaseDriverError) Error() string { return string(e) }
+
+const (
+	// WebhookInvalidData is the default error for invalid subscriptions
+	WebhookInvalidData = WebhookInvalidDataError("invalid data")
+	// WebhookInvalidID is the default error for non-existent subscriptions
+	WebhookInvalidID = WebhookInvalidDataError("invalid ID")
+	// WebhookPrivateURL is the default error for subscriptions to internal addresses
+	WebhookPrivateURL = WebhookInvalidDataError("private URL")
+)
+
+// Webhook request headers
+const (
+	WebhookEventHeader     = "X-Broker-Event"
+	WebhookDeliveryHeader  = "X-Broker-Delivery"
+	WebhookTimestampHeader = "X-Broker-Timestamp"
+	WebhookSignatureHeader = "X-Broker-Signature"
+)
+
+// Webhook delivery defaults
+const (
+	defaultWebhookAttempts  = 8
+	defaultWebhookBaseDelay = 10 * time.Second
+	defaultWebhookMaxDelay  = time.Hour
+	defaultWebhookTimeout   = 10 * time.Second
+	webhookBatch            = 100
+	webhookMaxError         = 1024
+)
+
+// webhookEvents are the event types subscribers may register to
+var webhookEvents = map[string]bool{
+	models.EventReadingAccepted: true,
+	models.EventReadingRejected: true,
+	models.EventAlertFiring:     true,
+	models.EventDeviceHealth:    true,
+	models.EventDeviceOffline:   true,
+	models.EventDeviceOnline:    true,
+}
+
+// WebhookDatabase is a service for webhook subscriptions and their outbox.
+// Published events are queued for every subscriber and sent by Dispatch.
+type WebhookDatabase struct {
+	Driver database.WebhookStore
+	// Client sends deliveries, defaulting to a client with a 10 second timeout that refuses
+	// private addresses unless AllowPrivate is set
+	Client *http.Client
+	// AllowPrivate accepts subscribers at loopback, link-local and private addresses, which
+	// are refused by default so subscribers cannot reach internal services through the broker
+	AllowPrivate bool
+	// Clock returns the current time, defaulting to time.Now
+	Clock func() time.Time
+	// MaxAttempts is the number of attempts before a delivery fails, defaulting to 8
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled on every retry up to MaxDelay.
+	// They default to 10 seconds and an hour.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Subscribe registers a webhook subscriber, issuing its signing secret
+func (s *WebhookDatabase) Subscribe(subscription *models.Subscription) error {
+	if subscription == nil {
+		return WebhookInvalidDataError("nil data")
+	}
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return WebhookInvalidData
+	}
+	if !s.AllowPrivate && privateHost(target.Hostname()) {
+		return WebhookPrivateURL
+	}
+	if len(subscription.Events) == 0 {
+		return WebhookInvalidData
+	}
+	for _, event := range subscription.Events {
+		if !webhookEvents[event] {
+			return WebhookInvalidData
+		}
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		return WebhookDatabaseDriverError(err.Error())
+	}
+	subscription.Secret = secret
+
+	return webhookError(s.Driver.CreateSubscription(subscription), WebhookInvalidData)
+}
+
+// Subscriptions reads every webhook subscriber, without their secrets
+func (s *WebhookDatabase) Subscriptions() ([]*models.Subscription, error) {
+	subscriptions, err := s.Driver.ReadSubscriptions()
+	if err != nil {
+		return nil, webhookError(err, WebhookInvalidData)
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+
+	return subscriptions, nil
+}
+
+// Unsubscribe deletes a webhook subscriber and its deliveries
+func (s *WebhookDatabase) Unsubscribe(id uint) error {
+	return webhookError(s.Driver.DeleteSubscription(id), WebhookInvalidID)
+}
+
+// Deliveries reads the delivery log of a webhook subscriber
+func (s *WebhookDatabase) Deliveries(subscriptionID uint) ([]*models.Delivery, error) {
+	deliveries, err := s.Driver.ReadDeliveries(subscriptionID)
+	if err != nil {
+		return nil, webhookError(err, WebhookInvalidID)
+	}
+
+	return deliveries, nil
+}
+
+// Publish queues an event for every subscriber of its type.
+// Events without a timestamp happen now.
+func (s *WebhookDatabase) Publish(event *models.Event) error {
+	now := s.now().Unix()
+	if event.Timestamp == 0 {
+		event.Timestamp = now
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return WebhookInvalidDataError(err.Error())
+	}
+
+	subscriptions, err := s.Driver.ReadSubscriptions()
+	if err != nil {
+		return WebhookDatabaseDriverError(err.Error())
+	}
+	for _, subscription := range subscriptions {
+		if !subscribed(subscription, event.Type) {
+			continue
+		}
+		err := s.Driver.WriteDelivery(&models.Delivery{
+			SubscriptionID: subscription.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			State:          models.DeliveryPending,
+			NextAttempt:    now,
+			CreatedAt:      now,
+		})
+		switch err.(type) {
+		case nil:
+		case database.DatabaseInvalidDataError:
+			// Unsubscribed meanwhile
+		default:
+			return WebhookDatabaseDriverError(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Dispatch sends every due delivery, scheduling a retry for failed ones
+func (s *WebhookDatabase) Dispatch() error {
+	deliveries, err := s.Driver.ReadDueDeliveries(s.now().Unix(), webhookBatch)
+	if err != nil {
+		return WebhookDatabaseDriverError(err.Error())
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	subscriptions, err := s.Driver.ReadSubscriptions()
+	if err != nil {
+		return WebhookDatabaseDriverError(err.Error())
+	}
+	byID := make(map[uint]*models.Subscription, len(subscriptions))
+	for _, subscription := range subscriptions {
+		byID[subscription.ID] = subscription
+	}
+
+	for _, delivery := range deliveries {
+		subscription, ok := byID[delivery.SubscriptionID]
+		if !ok {
+			continue
+		}
+		s.deliver(subscription, delivery)
+		err := s.Driver.WriteDelivery(delivery)
+		if _, ok := err.(database.DatabaseInvalidDataError); err != nil && !ok {
+			return WebhookDatabaseDriverError(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Run dispatches deliveries every interval until stop is closed
+func (s *WebhookDatabase) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Dispatch(); err != nil {
+				zap.L().Error(err.Error(), zap.String("service", "webhooks"))
+			}
+		}
+	}
+}
+
+// deliver makes a delivery attempt, updating the delivery with its outcome
+func (s *WebhookDatabase) deliver(subscription *models.Subscription, delivery *models.Delivery) {
+	now := s.now().Unix()
+	delivery.Attempts++
+
+	status, err := s.send(subscription, delivery, now)
+	delivery.LastStatus = status
+	if err == nil {
+		delivery.State = models.DeliveryDelivered
+		delivery.NextAttempt = 0
+		delivery.LastError = ""
+		delivery.DeliveredAt = now
+
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > webhookMaxError {
+		delivery.LastError = delivery.LastError[:webhookMaxError]
+	}
+	if delivery.Attempts >= s.maxAttempts() {
+		delivery.State = models.DeliveryFailed
+		delivery.NextAttempt = 0
+
+		return
+	}
+	delivery.NextAttempt = now + int64(s.backoff(delivery.Attempts)/time.Second)
+}
+
+// send posts a signed delivery, returning the response status code
+func (s *WebhookDatabase) send(subscription *models.Subscription, delivery *models.Delivery, timestamp int64) (int, error) {
+	request, err := http.NewRequest("POST", subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	request.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, timestamp, delivery.Payload))
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+		if !s.AllowPrivate {
+			// Host names are checked once resolved, as they may resolve to private addresses
+			dialer := &net.Dialer{Timeout: defaultWebhookTimeout, Control: dialPublic}
+			client.Transport = &http.Transport{DialContext: dialer.DialContext}
+		}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+// privateHost reports whether a URL host is a loopback, link-local, private or unspecified
+// address. Other host names are checked by dialPublic once resolved.
+func privateHost(host string) bool {
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && privateIP(ip)
+}
+
+// privateIP reports whether ip is a loopback, link-local, private, unspecified or multicast address
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// dialPublic refuses connections to private addresses
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return fmt.Errorf("private address %s", host)
+	}
+
+	return nil
+}
+
+// backoff is the delay before the retry following an attempt
+func (s *WebhookDatabase) backoff(attempt int) time.Duration {
+	base, max := s.BaseDelay, s.MaxDelay
+	if base <= 0 {
+		base = defaultWebhookBaseDelay
+	}
+	if max <= 0 {
+		max = defaultWebhookMaxDelay
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+
+	return delay
+}
+
+func (s *WebhookDatabase) maxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return defaultWebhookAttempts
+	}
+
+	return s.MaxAttempts
+}
+
+func (s *WebhookDatabase) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+
+	return s.Clock()
+}
+
+// SignWebhook signs a webhook payload sent at a timestamp.
+// Receivers recompute it from the timestamp header and the raw body.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribed checks if a subscriber registered to an event type
+func subscribed(subscription *models.Subscription, eventType string) bool {
+	for _, event := range subscription.Events {
+		if event == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// webhookError maps a driver error, using invalid for invalid data errors
+func webhookError(err error, invalid WebhookInvalidDataError) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case database.DatabaseInvalidDataError:
+		return invalid
+	default:
+		return WebhookDatabaseDriverError(err.Error())
+	}
+}
@@ -0,0 +1,326 @@
+package services_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/berry-house/http_broker/drivers/database"
+	"github.com/berry-house/http_broker/models"
+	"github.com/berry-house/http_broker/services"
+)
+
+func TestWebhookInvalidDataError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.WebhookInvalidDataError // error
+		expected string                           // expected message
+	}{
+		"General test": {services.WebhookInvalidDataError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+func TestWebhookDatabaseDriverError(t *testing.T) {
+	tests := map[string]struct {
+		err      services.WebhookDatabaseDriverError // error
+		expected string                              // expected message
+	}{
+		"General test": {services.WebhookDatabaseDriverError("error message"), "error message"},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			errorMsg := testCase.err.Error()
+			if errorMsg != testCase.expected {
+				t.Errorf("Expected %s, got %s", testCase.expected, errorMsg)
+			}
+		})
+	}
+}
+
+// webhookReceiver is an httptest receiver answering with the queued status codes
+type webhookReceiver struct {
+	statusCodes []int
+	requests    []*http.Request
+	bodies      [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+
+	statusCode := http.StatusOK
+	if len(r.statusCodes) > 0 {
+		statusCode, r.statusCodes = r.statusCodes[0], r.statusCodes[1:]
+	}
+	w.WriteHeader(statusCode)
+}
+
+// newWebhookService creates a webhook service on a memory driver with a settable clock
+func newWebhookService(now *int64) *services.WebhookDatabase {
+	driver, _ := database.NewMemory(map[uint][]*models.StatusData{1: []*models.StatusData{}})
+
+	// Receivers listen on loopback
+	return &services.WebhookDatabase{
+		Driver:       driver,
+		AllowPrivate: true,
+		Clock:        func() time.Time { return time.Unix(*now, 0) },
+		MaxAttempts:  3,
+		BaseDelay:    10 * time.Second,
+		MaxDelay:     time.Minute,
+	}
+}
+
+func TestWebhookSubscribe(t *testing.T) {
+	now := int64(1000)
+	service := newWebhookService(&now)
+	service.AllowPrivate = false
+
+	tests := map[string]struct {
+		subscription *models.Subscription // input
+		expected     error                // expected error
+	}{
+		"Happy path":     {&models.Subscription{URL: "https://irrigation.local/hook", Events: []string{"reading.accepted", "alert.firing"}}, nil},
+		"nil data":       {nil, services.WebhookInvalidDataError("nil data")},
+		"Relative URL":   {&models.Subscription{URL: "/hook", Events: []string{"alert.firing"}}, services.WebhookInvalidData},
+		"Invalid scheme": {&models.Subscription{URL: "ftp://irrigation.local/hook", Events: []string{"alert.firing"}}, services.WebhookInvalidData},
+		"No events":      {&models.Subscription{URL: "https://irrigation.local/hook"}, services.WebhookInvalidData},
+		"Unknown event":  {&models.Subscription{URL: "https://irrigation.local/hook", Events: []string{"plant.watered"}}, services.WebhookInvalidData},
+		"Loopback":       {&models.Subscription{URL: "http://127.0.0.1:8000/hook", Events: []string{"alert.firing"}}, services.WebhookPrivateURL},
+		"IPv6 loopback":  {&models.Subscription{URL: "http://[::1]/hook", Events: []string{"alert.firing"}}, services.WebhookPrivateURL},
+		"Localhost":      {&models.Subscription{URL: "http://localhost/hook", Events: []string{"alert.firing"}}, services.WebhookPrivateURL},
+		"Link-local":     {&models.Subscription{URL: "http://169.254.169.254/latest/meta-data", Events: []string{"alert.firing"}}, services.WebhookPrivateURL},
+		"Private":        {&models.Subscription{URL: "https://10.0.0.5/hook", Events: []string{"alert.firing"}}, services.WebhookPrivateURL},
+		"Unspecified":    {&models.Subscription{URL: "http://0.0.0.0/hook", Events: []string{"alert.firing"}}, services.WebhookPrivateURL},
+	}
+	for testName, testCase := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := service.Subscribe(testCase.subscription)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, err)
+			}
+			if err == nil && len(testCase.subscription.Secret) != 64 {
+				t.Errorf("Expected a secret, got %q", testCase.subscription.Secret)
+			}
+		})
+	}
+
+	subscriptions, _ := service.Subscriptions()
+	if len(subscriptions) != 1 || subscriptions[0].Secret != "" {
+		t.Errorf("Expected one subscription without secret, got %+v", subscriptions)
+	}
+	if err := service.Unsubscribe(2); err != services.WebhookInvalidID {
+		t.Errorf("Expected %+v, got %+v", services.WebhookInvalidID, err)
+	}
+}
+
+func TestWebhookDispatch(t *testing.T) {
+	// Setup
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	now := int64(1000)
+	service := newWebhookService(&now)
+	subscription := &models.Subscription{URL: server.URL, Events: []string{models.EventAlertFiring}}
+	service.Subscribe(subscription)
+	other := &models.Subscription{URL: server.URL, Events: []string{models.EventReadingAccepted}}
+	service.Subscribe(other)
+
+	event := &models.Event{Type: models.EventAlertFiring, PlantID: 1, Data: map[string]string{"metric": "humidity"}}
+	if err := service.Publish(event); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+	if err := service.Dispatch(); err != nil {
+		t.Fatalf("No error expected, got %+v", err)
+	}
+
+	if len(receiver.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(receiver.requests))
+	}
+	request, body := receiver.requests[0], receiver.bodies[0]
+	expectedBody := `{"type":"alert.firing","plantId":1,"timestamp":1000,"data":{"metric":"humidity"}}`
+	if string(body) != expectedBody {
+		t.Errorf("Expected %s, got %s", expectedBody, body)
+	}
+	timestamp, _ := strconv.ParseInt(request.Header.Get(services.WebhookTimestampHeader), 10, 64)
+	signature := services.SignWebhook(subscription.Secret, timestamp, body)
+	if request.Header.Get(services.WebhookSignatureHeader) != signature ||
+		request.Header.Get(services.WebhookEventHeader) != models.EventAlertFiring ||
+		request.Header.Get(services.WebhookDeliveryHeader) != "1" {
+		t.Errorf("Unexpected headers %+v", request.Header)
+	}
+
+	deliveries, _ := service.Deliveries(subscription.ID)
+	expected := []*models.Delivery{&models.Delivery{
+		ID:             1,
+		SubscriptionID: 1,
+		EventType:      models.EventAlertFiring,
+		Payload:        json.RawMessage(expectedBody),
+		State:          models.DeliveryDelivered,
+		Attempts:       1,
+		LastStatus:     http.StatusOK,
+		CreatedAt:      1000,
+		DeliveredAt:    1000,
+	}}
+	if !reflect.DeepEqual(deliveries, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, deliveries)
+	}
+	if deliveries, _ := service.Deliveries(other.ID); len(deliveries) != 0 {
+		t.Errorf("Expected no deliveries, got %+v", deliveries)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	// Setup
+	receiver := &webhookReceiver{statusCodes: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	now := int64(1000)
+	service := newWebhookService(&now)
+	subscription := &models.Subscription{URL: server.URL, Events: []string{models.EventReadingAccepted}}
+	service.Subscribe(subscription)
+	service.Publish(&models.Event{Type: models.EventReadingAccepted, PlantID: 1})
+
+	steps := []struct {
+		name             string // step name
+		now              int64  // current time
+		expectedRequests int    // expected requests so far
+		expectedState    string // expected delivery state
+		expectedNext     int64  // expected next attempt
+	}{
+		{"First attempt fails", 1000, 1, models.DeliveryPending, 1010},
+		{"Not due yet", 1005, 1, models.DeliveryPending, 1010},
+		{"Second attempt fails", 1010, 2, models.DeliveryPending, 1030},
+		{"Third attempt succeeds", 1030, 3, models.DeliveryDelivered, 0},
+		{"Delivered once", 1100, 3, models.DeliveryDelivered, 0},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			now = step.now
+			if err := service.Dispatch(); err != nil {
+				t.Fatalf("No error expected, got %+v", err)
+			}
+			deliveries, _ := service.Deliveries(subscription.ID)
+			if len(receiver.requests) != step.expectedRequests ||
+				deliveries[0].State != step.expectedState ||
+				deliveries[0].NextAttempt != step.expectedNext {
+				t.Errorf("Expected %d requests, %s until %d, got %d requests, %+v",
+					step.expectedRequests, step.expectedState, step.expectedNext, len(receiver.requests), deliveries[0])
+			}
+		})
+	}
+}
+
+func TestWebhookFailed(t *testing.T) {
+	// Setup
+	now := int64(1000)
+	service := newWebhookService(&now)
+	subscription := &models.Subscription{URL: "http://127.0.0.1:1/hook", Events: []string{models.EventReadingRejected}}
+	service.Subscribe(subscription)
+	service.Publish(&models.Event{Type: models.EventReadingRejected})
+
+	for i := 0; i < 5; i++ {
+		service.Dispatch()
+		now += 3600
+	}
+
+	deliveries, _ := service.Deliveries(subscription.ID)
+	if deliveries[0].State != models.DeliveryFailed || deliveries[0].Attempts != 3 || deliveries[0].LastError == "" {
+		t.Errorf("Expected a failed delivery after 3 attempts, got %+v", deliveries[0])
+	}
+}
+
+func TestWebhookDispatchPrivate(t *testing.T) {
+	// Setup
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	now := int64(1000)
+	service := newWebhookService(&now)
+	subscription := &models.Subscription{URL: server.URL, Events: []string{models.EventAlertFiring}}
+	service.Subscribe(subscription)
+	service.Publish(&models.Event{Type: models.EventAlertFiring, PlantID: 1})
+
+	// Host names resolving to private addresses are refused when dialing
+	service.AllowPrivate = false
+	service.Dispatch()
+
+	if len(receiver.requests) != 0 {
+		t.Errorf("Expected no request, got %d", len(receiver.requests))
+	}
+	deliveries, _ := service.Deliveries(subscription.ID)
+	if deliveries[0].State != models.DeliveryPending || !strings.Contains(deliveries[0].LastError, "private address") {
+		t.Errorf("Expected a pending delivery refused for its private address, got %+v", deliveries[0])
+	}
+}
+
+// Publisher mock, recording published events
+type mockPublisher struct {
+	events []*models.Event
+}
+
+func (p *mockPublisher) Publish(event *models.Event) error {
+	p.events = append(p.events, event)
+
+	return nil
+}
+
+func TestStatusWritePublishes(t *testing.T) {
+	events := &mockPublisher{}
+	service := services.StatusDatabase{
+		Driver: &mockDatabaseDriver{},
+		Events: events,
+	}
+
+	accepted := &models.StatusData{ID: 1, Timestamp: 1516478286}
+	rejected := &models.StatusData{ID: 7, Timestamp: 1516478286}
+	service.Write(accepted)
+	service.Write(rejected)
+	service.Write(&models.StatusData{ID: 5, Timestamp: 1516478286})
+
+	expected := []*models.Event{
+		&models.Event{Type: models.EventReadingAccepted, PlantID: 1, Data: accepted},
+		&models.Event{Type: models.EventReadingRejected, PlantID: 7, Data: &models.RejectedReading{Reading: rejected, Error: "invalid ID"}},
+	}
+	if !reflect.DeepEqual(events.events, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, events.events)
+	}
+}
+
+func TestAlertEvaluatePublishes(t *testing.T) {
+	events := &mockPublisher{}
+	service, _ := newAlertService()
+	service.Events = events
+	service.CreateRule(&models.AlertRule{PlantID: 1, Metric: "humidity", Operator: "<", Threshold: 20, For: 600})
+
+	for _, timestamp := range []int64{0, 300, 600, 900} {
+		data := &models.StatusData{ID: 1, Timestamp: timestamp}
+		data.SetValue("humidity", 10)
+		service.Evaluate(data)
+	}
+
+	expected := []*models.Event{&models.Event{
+		Type:    models.EventAlertFiring,
+		PlantID: 1,
+		Data:    &models.Alert{ID: 1, RuleID: 1, PlantID: 1, Metric: "humidity", State: models.AlertFiring, Value: 10, Since: 0, FiredAt: 600},
+	}}
+	if !reflect.DeepEqual(events.events, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, events.events)
+	}
+}
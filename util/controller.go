@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"net/http"
 
 	"go.uber.org/zap"
@@ -8,7 +9,13 @@ import (
 
 // LogError extracts the Logger from the request's context and logs an error.
 func LogError(r *http.Request, err error) {
-	loggerValue := r.Context().Value("logger")
+	LogContextError(r.Context(), r.Method, r.URL.Path, err)
+}
+
+// LogContextError extracts the Logger from a context and logs an error of a call
+// to url, made with method.
+func LogContextError(ctx context.Context, method, url string, err error) {
+	loggerValue := ctx.Value("logger")
 	if loggerValue == nil {
 		return
 	}
@@ -20,8 +27,8 @@ func LogError(r *http.Request, err error) {
 
 	logger.Error(
 		err.Error(),
-		zap.String("method", r.Method),
-		zap.String("url", r.URL.Path),
+		zap.String("method", method),
+		zap.String("url", url),
 	)
 }
 
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"context"
+	"net/http"
+)
+
+type deviceKey struct{}
+
+// WithDevice returns a copy of the request carrying an authenticated device ID.
+func WithDevice(r *http.Request, id uint) *http.Request {
+	return r.WithContext(ContextWithDevice(r.Context(), id))
+}
+
+// Device extracts the authenticated device ID from the request's context.
+func Device(r *http.Request) (uint, bool) {
+	return ContextDevice(r.Context())
+}
+
+// ContextWithDevice returns a copy of the context carrying an authenticated device ID.
+func ContextWithDevice(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, deviceKey{}, id)
+}
+
+// ContextDevice extracts the authenticated device ID from a context.
+func ContextDevice(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(deviceKey{}).(uint)
+
+	return id, ok
+}